@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// isAdmin reports whether the request carries the admin token as a bearer
+// token. Admin features are disabled unless OCTREE_ADMIN_TOKEN is set.
+func isAdmin(r *http.Request) bool {
+	if config.AdminToken == "" {
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
+
+// requireAdmin rejects requests without the admin token before handing them to next
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeError(w, http.StatusForbidden, CodeForbidden, "Admin token required", nil)
+			return
+		}
+		next(w, r)
+	}
+}
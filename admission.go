@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// unlimitedMemoryEstimate is the memory committed to a program running
+	// without a memory limit, such as on /code/exec
+	unlimitedMemoryEstimate = defaultMemoryLimitMB << 20
+
+	// admissionRetryAfterSeconds is how long clients are asked to wait when
+	// the agent is out of memory to commit
+	admissionRetryAfterSeconds = 1
+)
+
+// memoryGuard keeps the memory committed to in-flight executions under a
+// threshold, so that load spikes are turned away rather than OOM-killing the
+// agent
+type memoryGuard struct {
+	mu        sync.Mutex
+	committed int64
+	limit     int64
+}
+
+// inFlight guards the executions running on this agent
+var inFlight = &memoryGuard{limit: config.MaxInFlightMemoryBytes}
+
+// admit commits bytes to a new execution, reporting false if that would go
+// over the threshold. A single execution is always admitted on an idle agent.
+func (g *memoryGuard) admit(bytes int64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.limit > 0 && g.committed > 0 && g.committed+bytes > g.limit {
+		return false
+	}
+	g.committed += bytes
+	return true
+}
+
+// release returns the memory committed to a finished execution
+func (g *memoryGuard) release(bytes int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.committed -= bytes
+}
+
+// idle reports whether no execution is running
+func (g *memoryGuard) idle() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.committed == 0
+}
+
+// admitExecution commits e.bytes for the request's execution, queueing it
+// while the agent is at capacity, and returns the context to run it in and the
+// function releasing them. If the agent is unhealthy, or the execution can't be
+// queued or waited too long, it writes a 503 with Retry-After and returns false.
+func admitExecution(w http.ResponseWriter, r *http.Request, e *queuedExecution) (context.Context, func(), bool) {
+	priority, ok := parsePriority(r.Header.Get(priorityHeader))
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Priority must be batch, normal or high",
+			map[string]string{"priority": r.Header.Get(priorityHeader)})
+		return nil, nil, false
+	}
+
+	if problem := watchdog.shedding(); problem != "" {
+		w.Header().Set("Retry-After", strconv.Itoa(admissionRetryAfterSeconds))
+		writeError(w, http.StatusServiceUnavailable, CodeOverloaded,
+			"The agent is unhealthy, retry later", map[string]string{"reason": problem})
+		return nil, nil, false
+	}
+
+	// The execution can be preempted once running
+	ctx, cancel := context.WithCancelCause(r.Context())
+	e.cancel = cancel
+	e.priority = priority
+	e.Tenant = r.Header.Get(tenantHeader)
+	e.User = r.Header.Get(userHeader)
+	e.client = queueClient(r)
+	if !queue.add(e) {
+		cancel(nil)
+		w.Header().Set("Retry-After", strconv.Itoa(admissionRetryAfterSeconds))
+		writeError(w, http.StatusServiceUnavailable, CodeOverloaded,
+			"The agent is at capacity, retry later", map[string]int64{"limitBytes": inFlight.limit})
+		return nil, nil, false
+	}
+	events.publish(ExecutionEvent{Type: EventAccepted, ExecutionID: e.ID, Kind: e.Kind, Language: e.Language, Tenant: e.Tenant})
+	select {
+	case <-e.admitted:
+	default:
+		announceQueued(w, e)
+	}
+	release := func() {
+		queue.finish(e)
+		cancel(nil)
+	}
+
+	timer := time.NewTimer(time.Duration(config.QueueTimeoutMs) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case <-e.admitted:
+		return ctx, release, true
+	case <-e.evicted:
+		cancel(nil)
+		w.Header().Set("Retry-After", strconv.Itoa(admissionRetryAfterSeconds))
+		writeError(w, http.StatusServiceUnavailable, CodeOverloaded, "The execution was evicted from the queue by an operator", nil)
+		return nil, nil, false
+	case <-r.Context().Done():
+	case <-timer.C:
+	}
+
+	// The execution may have been admitted just as it gave up
+	if !queue.remove(e) {
+		select {
+		case <-e.admitted:
+			queue.finish(e)
+		case <-e.evicted:
+		}
+	}
+	cancel(nil)
+	w.Header().Set("Retry-After", strconv.Itoa(admissionRetryAfterSeconds))
+	writeError(w, http.StatusServiceUnavailable, CodeOverloaded,
+		"The agent is at capacity, retry later", map[string]any{"limitBytes": inFlight.limit, "queuedMs": config.QueueTimeoutMs})
+	return nil, nil, false
+}
+
+// defaultInFlightMemory is three quarters of the machine's memory, or 0
+// (unlimited) if that can't be read
+func defaultInFlightMemory() int64 {
+	total, err := totalMemory()
+	if err != nil {
+		return 0
+	}
+	return total / 4 * 3
+}
+
+// totalMemory reads the machine's total memory from /proc/meminfo
+func totalMemory() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid MemTotal: %w", err)
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("MemTotal not found in /proc/meminfo")
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Debug sessions, snapshots and registered programs live on the agent that
+// created them, so the requests following up on them (connecting to a debug
+// session, restoring a snapshot, judging with a program) must reach that
+// agent. Every response carries the agent's routing token in the affinity
+// header, which the orchestrator records along with the IDs it hands out and
+// routes their follow-ups by.
+//
+// Before an agent is drained, POST /admin/state/export hands over its state:
+// the programs, the snapshots with their tarballs and the debug sessions not
+// connected to yet, which are removed from the agent (a session is only
+// connected to once). POST /admin/state/import on another agent takes the
+// exported state as is, keeping the IDs, after which the orchestrator routes
+// the old agent's token to the new one. Running debug sessions are bound to
+// their connection and can't be moved.
+
+// affinityHeader carries the routing token of the agent
+const affinityHeader = "X-Octree-Affinity"
+
+// AgentState is the state exported from an agent being drained
+type AgentState struct {
+	Affinity      string              `json:"affinity"`
+	Programs      []*Program          `json:"programs"`
+	Snapshots     []SnapshotState     `json:"snapshots"`
+	DebugSessions []DebugSessionState `json:"debugSessions"`
+}
+
+// SnapshotState is an exported snapshot along with its tarball
+type SnapshotState struct {
+	Snapshot
+	Tarball []byte `json:"tarball"`
+}
+
+// DebugSessionState is an exported debug session not connected to yet
+type DebugSessionState struct {
+	ID        string           `json:"id"`
+	Request   *CodeExecRequest `json:"request"`
+	ExpiresAt time.Time        `json:"expiresAt"`
+}
+
+// StateImportResponse is returned by the import endpoint
+type StateImportResponse struct {
+	Affinity      string         `json:"affinity"`
+	Programs      int            `json:"programs"`
+	Snapshots     int            `json:"snapshots"`
+	DebugSessions int            `json:"debugSessions"`
+	Skipped       []SkippedState `json:"skipped,omitempty"`
+}
+
+// SkippedState is an item of the imported state the agent couldn't take over
+type SkippedState struct {
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// hostname returns the hostname of the machine, or a random ID if it is unknown
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return uuid.New().String()
+	}
+	return name
+}
+
+// withAffinity advertises the routing token of the agent on every response
+func withAffinity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(affinityHeader, config.AgentID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// storedIDs returns the IDs of the JSON files in dir
+func storedIDs(dir string) []string {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+
+	var ids []string
+	for _, path := range paths {
+		ids = append(ids, strings.TrimSuffix(filepath.Base(path), ".json"))
+	}
+	return ids
+}
+
+// exportState collects the state of the agent, handing over the pending
+// debug sessions
+func exportState() (*AgentState, error) {
+	state := &AgentState{Affinity: config.AgentID}
+
+	for _, id := range storedIDs(programs.dir) {
+		if program, ok := programs.get(id); ok {
+			state.Programs = append(state.Programs, program)
+		}
+	}
+
+	for _, id := range storedIDs(config.SnapshotsDir) {
+		snapshot, ok := getSnapshot(id)
+		if !ok {
+			continue
+		}
+		tarball, err := os.ReadFile(snapshotPath(id, ".tar.gz"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s: %w", id, err)
+		}
+		state.Snapshots = append(state.Snapshots, SnapshotState{*snapshot, tarball})
+	}
+
+	debugSessionsMu.Lock()
+	for _, id := range slices.Sorted(maps.Keys(pendingDebugSessions)) {
+		session := pendingDebugSessions[id]
+		session.expiry.Stop()
+		delete(pendingDebugSessions, id)
+		state.DebugSessions = append(state.DebugSessions, DebugSessionState{session.id, session.req, session.expiresAt})
+	}
+	debugSessionsMu.Unlock()
+
+	return state, nil
+}
+
+// importSnapshot stores the exported snapshot
+func importSnapshot(snapshot SnapshotState) error {
+	if _, err := uuid.Parse(snapshot.ID); err != nil {
+		return fmt.Errorf("invalid ID")
+	}
+
+	err := os.MkdirAll(config.SnapshotsDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	path := snapshotPath(snapshot.ID, ".tar.gz")
+	err = os.WriteFile(path+".tmp", snapshot.Tarball, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	data, _ := json.Marshal(snapshot.Snapshot)
+	err = os.WriteFile(snapshotPath(snapshot.ID, ".json"), data, 0644)
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// importDebugSession adds the exported debug session to the pending ones
+func importDebugSession(state DebugSessionState) error {
+	if _, err := uuid.Parse(state.ID); err != nil || state.Request == nil {
+		return fmt.Errorf("invalid session")
+	}
+	if time.Now().After(state.ExpiresAt) {
+		return fmt.Errorf("session expired")
+	}
+
+	lang, ok := languages[state.Request.Language]
+	if !ok {
+		return fmt.Errorf("language %q not supported", state.Request.Language)
+	}
+	adapter, ok := debugAdapters[lang.Name]
+	if !ok {
+		return fmt.Errorf("debugging is not available for %q", lang.Name)
+	}
+	if state.Request.RestoreSnapshot != "" {
+		if _, ok := getSnapshot(state.Request.RestoreSnapshot); !ok {
+			return fmt.Errorf("snapshot %s not found", state.Request.RestoreSnapshot)
+		}
+	}
+
+	session := &debugSession{id: state.ID, lang: lang, adapter: adapter, req: state.Request}
+	if !addDebugSession(session, state.ExpiresAt) {
+		return fmt.Errorf("too many debug sessions")
+	}
+	return nil
+}
+
+// importState takes over the exported state, snapshots first since the
+// debug sessions may restore them
+func importState(state *AgentState) StateImportResponse {
+	response := StateImportResponse{Affinity: config.AgentID}
+	skip := func(kind string, id string, err error) {
+		log.Printf("Warning: failed to import %s %s from %s: %s", kind, id, state.Affinity, err)
+		response.Skipped = append(response.Skipped, SkippedState{kind, id, err.Error()})
+	}
+
+	for _, program := range state.Programs {
+		if _, err := uuid.Parse(program.ID); err != nil {
+			skip("program", program.ID, fmt.Errorf("invalid ID"))
+			continue
+		}
+		if err := programs.add(program); err != nil {
+			skip("program", program.ID, err)
+			continue
+		}
+		response.Programs++
+	}
+
+	for _, snapshot := range state.Snapshots {
+		if err := importSnapshot(snapshot); err != nil {
+			skip("snapshot", snapshot.ID, err)
+			continue
+		}
+		response.Snapshots++
+	}
+
+	for _, session := range state.DebugSessions {
+		if err := importDebugSession(session); err != nil {
+			skip("debugSession", session.ID, err)
+			continue
+		}
+		response.DebugSessions++
+	}
+
+	return response
+}
+
+// exportStateHandler hands over the state of the agent (POST)
+func exportStateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	state, err := exportState()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to export state: %v", err), nil)
+		return
+	}
+
+	jsonResponse, _ := json.Marshal(state)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
+
+// importStateHandler takes over the state exported from another agent (POST)
+func importStateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	var state AgentState
+	err := json.NewDecoder(r.Body).Decode(&state)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON format", err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	jsonResponse, _ := json.Marshal(importState(&state))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
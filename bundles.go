@@ -0,0 +1,674 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Test case bundles (problem packages) are uploaded once by admins and then
+// referenced from judge requests as "name" (the latest version) or
+// "name@version", instead of sending the same test data with every
+// submission. Every upload becomes a new numbered version, stored as
+// BundlesDir/<name>/<version>/tests/NNN.in and NNN.out next to the bundle's
+// metadata in bundle.json. Versions never change once stored, so agents
+// sharing BundlesDir (a network filesystem, say) can cache their metadata;
+// the test data is streamed from the files like test data fetched from URLs.
+
+// maxBundleBytes bounds the size of an uploaded bundle, and of its test data
+const maxBundleBytes = 1 << 30
+
+// bundleName matches the names of bundles
+var bundleName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// BundleSpec are the judging settings of a bundle, which judge requests
+// referencing it use unless they set their own
+type BundleSpec struct {
+	TimeLimitMs    int64 `json:"timeLimitMs,omitempty"`
+	CPUTimeLimitMs int64 `json:"cpuTimeLimitMs,omitempty"`
+	MemoryLimitMB  int64 `json:"memoryLimitMb,omitempty"`
+
+	Checker    *CodeExecRequest `json:"checker,omitempty"`
+	Interactor *CodeExecRequest `json:"interactor,omitempty"`
+	Comparison *ComparisonSpec  `json:"comparison,omitempty"`
+}
+
+// Bundle is a stored version of a test case bundle
+type Bundle struct {
+	// ID is how judge requests reference this version: "name@version"
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	Cases     int       `json:"cases"`
+	SizeBytes int64     `json:"sizeBytes"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	BundleSpec
+}
+
+// bundleUpload is a bundle uploaded as JSON, with its test data inline
+type bundleUpload struct {
+	BundleSpec
+	TestCases []TestCase `json:"testCases"`
+}
+
+// bundleRegistry stores the bundles in a directory, caching their metadata
+type bundleRegistry struct {
+	mu      sync.RWMutex
+	dir     string
+	bundles map[string]*Bundle
+}
+
+// bundles holds the test case bundles uploaded to this agent
+var bundles = &bundleRegistry{dir: config.BundlesDir, bundles: map[string]*Bundle{}}
+
+// path returns the directory of version of the bundle name
+func (r *bundleRegistry) path(name string, version int) string {
+	return filepath.Join(r.dir, name, strconv.Itoa(version))
+}
+
+// versions lists the stored version numbers of the bundle name, oldest first
+func (r *bundleRegistry) versions(name string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(r.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var versions []int
+	for _, entry := range entries {
+		version, err := strconv.Atoi(entry.Name())
+		if err == nil && version > 0 && entry.IsDir() {
+			versions = append(versions, version)
+		}
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// get returns version of the bundle name, loading it from disk if it isn't
+// cached. Another agent sharing the directory may have deleted it since.
+func (r *bundleRegistry) get(name string, version int) (*Bundle, bool) {
+	if !bundleName.MatchString(name) {
+		return nil, false
+	}
+	metadata := filepath.Join(r.path(name, version), "bundle.json")
+	id := fmt.Sprintf("%s@%d", name, version)
+
+	r.mu.RLock()
+	bundle, ok := r.bundles[id]
+	r.mu.RUnlock()
+	if ok {
+		if _, err := os.Stat(metadata); err == nil {
+			return bundle, true
+		}
+		r.mu.Lock()
+		delete(r.bundles, id)
+		r.mu.Unlock()
+		return nil, false
+	}
+
+	// The metadata is written last, so versions still being stored have none
+	data, err := os.ReadFile(metadata)
+	if err != nil {
+		return nil, false
+	}
+
+	bundle = &Bundle{}
+	err = json.Unmarshal(data, bundle)
+	if err != nil {
+		log.Printf("Warning: invalid bundle file for %s: %s", id, err)
+		return nil, false
+	}
+
+	r.mu.Lock()
+	r.bundles[id] = bundle
+	r.mu.Unlock()
+
+	return bundle, true
+}
+
+// resolve returns the bundle a judge request references, as "name" for its
+// latest version or "name@version"
+func (r *bundleRegistry) resolve(ref string) (*Bundle, bool) {
+	name, version, ok := strings.Cut(ref, "@")
+	if ok {
+		n, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, false
+		}
+		return r.get(name, n)
+	}
+
+	if !bundleName.MatchString(name) {
+		return nil, false
+	}
+	versions, err := r.versions(name)
+	if err != nil {
+		return nil, false
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if bundle, ok := r.get(name, versions[i]); ok {
+			return bundle, true
+		}
+	}
+	return nil, false
+}
+
+// add stores the test data prepared in tests (NNN.in and NNN.out files) as
+// the next version of bundle.Name, filling in the version
+func (r *bundleRegistry) add(bundle *Bundle, tests string) error {
+	versions, err := r.versions(bundle.Name)
+	if err != nil {
+		return err
+	}
+	bundle.Version = 1
+	if len(versions) > 0 {
+		bundle.Version = versions[len(versions)-1] + 1
+	}
+
+	// Creating the directory claims the version, even against other agents
+	// sharing the directory
+	for {
+		err = os.Mkdir(r.path(bundle.Name, bundle.Version), os.ModePerm)
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+		bundle.Version++
+	}
+	if err != nil {
+		return err
+	}
+	dir := r.path(bundle.Name, bundle.Version)
+	bundle.ID = fmt.Sprintf("%s@%d", bundle.Name, bundle.Version)
+
+	err = os.Rename(tests, filepath.Join(dir, "tests"))
+	if err == nil {
+		data, _ := json.Marshal(bundle)
+		err = os.WriteFile(filepath.Join(dir, "bundle.json.tmp"), data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(filepath.Join(dir, "bundle.json.tmp"), filepath.Join(dir, "bundle.json"))
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	r.mu.Lock()
+	r.bundles[bundle.ID] = bundle
+	r.mu.Unlock()
+
+	return nil
+}
+
+// remove deletes version of the bundle name, reporting whether it existed
+func (r *bundleRegistry) remove(name string, version int) bool {
+	bundle, ok := r.get(name, version)
+	if !ok {
+		return false
+	}
+
+	r.mu.Lock()
+	delete(r.bundles, bundle.ID)
+	r.mu.Unlock()
+
+	// Without its metadata the version is gone even if deleting the rest fails
+	dir := r.path(name, version)
+	err := os.Remove(filepath.Join(dir, "bundle.json"))
+	if err == nil {
+		err = os.RemoveAll(dir)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to delete bundle %s: %s", bundle.ID, err)
+	}
+	return true
+}
+
+// testCases returns the test cases of the bundle, which read their data from its files
+func (b *Bundle) testCases() []TestCase {
+	tests := filepath.Join(bundles.path(b.Name, b.Version), "tests")
+
+	cases := make([]TestCase, b.Cases)
+	for i := range cases {
+		base := filepath.Join(tests, fmt.Sprintf("%03d", i+1))
+		cases[i] = TestCase{inputFile: base + ".in", expectedOutputFile: base + ".out"}
+	}
+	return cases
+}
+
+// resolveBundle fills in the test cases of a judge request referencing a
+// bundle, along with the bundle's settings the request doesn't set itself.
+// If it is invalid it writes the error response and returns false.
+func resolveBundle(w http.ResponseWriter, req *JudgeRequest) bool {
+	if req.Bundle == "" {
+		return true
+	}
+	if len(req.TestCases) > 0 || req.Stress != nil || req.Function != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "A bundle can't be used with test cases, stress mode or function mode", nil)
+		return false
+	}
+
+	bundle, ok := bundles.resolve(req.Bundle)
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Bundle not found", map[string]string{"bundle": req.Bundle})
+		return false
+	}
+	req.TestCases = bundle.testCases()
+
+	if req.TimeLimitMs == 0 && req.WallTimeLimitMs == 0 {
+		req.TimeLimitMs = bundle.TimeLimitMs
+	}
+	if req.CPUTimeLimitMs == 0 {
+		req.CPUTimeLimitMs = bundle.CPUTimeLimitMs
+	}
+	if req.MemoryLimitMB == 0 {
+		req.MemoryLimitMB = bundle.MemoryLimitMB
+	}
+
+	// The bundle's way of judging outputs applies if the request has none;
+	// the programs are copied since resolving them fills them in
+	if req.Checker == nil && req.Interactor == nil && req.Comparison == nil {
+		if bundle.Checker != nil {
+			checker := *bundle.Checker
+			req.Checker = &checker
+		}
+		if bundle.Interactor != nil {
+			interactor := *bundle.Interactor
+			req.Interactor = &interactor
+		}
+		req.Comparison = bundle.Comparison
+	}
+	return true
+}
+
+// loadBundledData reads the data of a bundled test case into tc where req
+// needs it whole rather than streamed from the bundle's files, as with test
+// data from URLs
+func loadBundledData(req *JudgeRequest, tc TestCase) (TestCase, error) {
+	whole := req.Checker != nil || req.Interactor != nil || req.Function != nil || req.TimeLimitReruns != nil
+
+	if tc.inputFile != "" && whole {
+		data, err := os.ReadFile(tc.inputFile)
+		if err != nil {
+			return tc, fmt.Errorf("failed to read the input: %w", err)
+		}
+		tc.Input, tc.inputFile = string(data), ""
+	}
+	if tc.expectedOutputFile != "" && (whole || req.Comparison != nil) {
+		data, err := os.ReadFile(tc.expectedOutputFile)
+		if err != nil {
+			return tc, fmt.Errorf("failed to read the expected output: %w", err)
+		}
+		tc.ExpectedOutput, tc.expectedOutputFile = string(data), ""
+	}
+	return tc, nil
+}
+
+// checkBundleSpec validates the settings of an uploaded bundle. If they are
+// invalid it writes the error response and returns false.
+func checkBundleSpec(w http.ResponseWriter, spec *BundleSpec) bool {
+	if spec.TimeLimitMs < 0 || spec.TimeLimitMs > maxTimeLimitMs || spec.CPUTimeLimitMs < 0 || spec.CPUTimeLimitMs > maxTimeLimitMs ||
+		spec.MemoryLimitMB < 0 || spec.MemoryLimitMB > maxMemoryLimitMB {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Limits out of range", map[string]int64{
+			"maxTimeLimitMs":   maxTimeLimitMs,
+			"maxMemoryLimitMb": maxMemoryLimitMB,
+		})
+		return false
+	}
+
+	if spec.Checker != nil && spec.Interactor != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "A checker and an interactor can't be used together", nil)
+		return false
+	}
+	if spec.Checker != nil {
+		if _, ok := resolveJudgeProgram(w, "checker", spec.Checker); !ok {
+			return false
+		}
+	}
+	if spec.Interactor != nil {
+		if _, ok := resolveJudgeProgram(w, "interactor", spec.Interactor); !ok {
+			return false
+		}
+	}
+	return checkComparisonSpec(w, &JudgeRequest{Checker: spec.Checker, Interactor: spec.Interactor, Comparison: spec.Comparison})
+}
+
+// writeBundleTests writes the test cases of a bundle uploaded as JSON into
+// the directory tests, returning how many there are
+func writeBundleTests(tests string, cases []TestCase) (int, error) {
+	for i, tc := range cases {
+		if tc.InputURL != "" || tc.ExpectedOutputURL != "" || tc.Args != nil || tc.Expected != nil {
+			return 0, fmt.Errorf("test case %d: bundled test cases are given as an input and an expected output", i)
+		}
+
+		base := filepath.Join(tests, fmt.Sprintf("%03d", i+1))
+		err := os.WriteFile(base+".in", []byte(tc.Input), 0644)
+		if err == nil {
+			err = os.WriteFile(base+".out", []byte(tc.ExpectedOutput), 0644)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(cases), nil
+}
+
+// testFiles are the input and expected output files of a test case in an
+// unpacked archive
+type testFiles struct {
+	input  string
+	answer string
+}
+
+// collectBundleTests moves the test cases of an archive unpacked in pkg into
+// the directory tests, returning how many there are. Every X.in file under
+// dir is a test case, with its expected output in X.out or X.ans, taken in
+// the order of their names with numbers compared by value.
+func collectBundleTests(pkg string, dir string, tests string) (int, error) {
+	files, err := findTestFiles(pkg, dir)
+	if err != nil {
+		return 0, err
+	}
+	return moveTestFiles(tests, files)
+}
+
+// findTestFiles returns the X.in files under dir, each with its X.out or
+// X.ans file, sorted by name
+func findTestFiles(pkg string, dir string) ([]testFiles, error) {
+	var inputs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() && filepath.Ext(path) == ".in" {
+			inputs = append(inputs, strings.TrimSuffix(path, ".in"))
+		}
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(inputs, func(i, j int) bool { return naturalLess(inputs[i], inputs[j]) })
+
+	var files []testFiles
+	for _, stem := range inputs {
+		answer := stem + ".out"
+		if _, err := os.Stat(answer); err != nil {
+			answer = stem + ".ans"
+		}
+		if _, err := os.Stat(answer); err != nil {
+			rel, _ := filepath.Rel(pkg, stem)
+			return nil, fmt.Errorf("test %q has no .out or .ans file", filepath.ToSlash(rel))
+		}
+		files = append(files, testFiles{input: stem + ".in", answer: answer})
+	}
+	return files, nil
+}
+
+// moveTestFiles moves files into the directory tests as NNN.in and NNN.out,
+// returning how many test cases there are
+func moveTestFiles(tests string, files []testFiles) (int, error) {
+	for i, f := range files {
+		base := filepath.Join(tests, fmt.Sprintf("%03d", i+1))
+		err := os.Rename(f.input, base+".in")
+		if err == nil {
+			err = os.Rename(f.answer, base+".out")
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(files), nil
+}
+
+// naturalLess orders a before b with the numbers in them compared by value,
+// so that test 2 comes before test 10
+func naturalLess(a string, b string) bool {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da == "" || db == "" {
+			if a[0] != b[0] {
+				return a[0] < b[0]
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		}
+		if na != nb {
+			return na < nb
+		}
+		a, b = a[len(da):], b[len(db):]
+	}
+	return len(a) < len(b)
+}
+
+// leadingDigits returns the digits s starts with
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// readBundleUpload reads the bundle in the request body into the directory
+// tests: test data inline in JSON, or a zip or gzipped tar archive of .in and
+// .out (or .ans) files or of a problem package (see packages.go), with its
+// settings in an optional bundle.json at its root. It returns the settings
+// and the number of test cases.
+func readBundleUpload(r io.Reader, contentType string, tmp string, tests string) (BundleSpec, int, error) {
+	if strings.HasPrefix(contentType, "application/json") {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return BundleSpec{}, 0, err
+		}
+
+		var upload bundleUpload
+		err = json.Unmarshal(body, &upload)
+		if err != nil {
+			return BundleSpec{}, 0, fmt.Errorf("invalid JSON format: %w", err)
+		}
+		cases, err := writeBundleTests(tests, upload.TestCases)
+		return upload.BundleSpec, cases, err
+	}
+
+	// Gzipped tarballs are unpacked as they arrive, zip archives need all of it
+	pkg := filepath.Join(tmp, "package")
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	var err error
+	if string(magic) == "\x1f\x8b" {
+		err = extractTarball(br, pkg, maxBundleBytes)
+	} else {
+		var data []byte
+		data, err = io.ReadAll(br)
+		if err == nil {
+			err = extractArchive(data, pkg, maxBundleBytes)
+		}
+	}
+	if err != nil {
+		return BundleSpec{}, 0, err
+	}
+
+	pkg = packageRoot(pkg)
+	spec, unmapped, cases, err := importPackage(pkg, tests)
+	if err != nil {
+		return BundleSpec{}, 0, err
+	}
+
+	// The settings in bundle.json take precedence over those of a problem package
+	var override BundleSpec
+	data, err := os.ReadFile(filepath.Join(pkg, "bundle.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &override)
+		if err != nil {
+			return BundleSpec{}, 0, fmt.Errorf("invalid bundle.json: %w", err)
+		}
+	}
+	judged := override.Checker != nil || override.Interactor != nil || override.Comparison != nil
+	if unmapped != "" && !judged {
+		return BundleSpec{}, 0, fmt.Errorf("the package's %s can't be run by the agent; give a checker, interactor or comparison to use instead in bundle.json", unmapped)
+	}
+
+	if override.TimeLimitMs != 0 {
+		spec.TimeLimitMs = override.TimeLimitMs
+	}
+	if override.CPUTimeLimitMs != 0 {
+		spec.CPUTimeLimitMs = override.CPUTimeLimitMs
+	}
+	if override.MemoryLimitMB != 0 {
+		spec.MemoryLimitMB = override.MemoryLimitMB
+	}
+	if judged {
+		spec.Checker, spec.Interactor, spec.Comparison = override.Checker, override.Interactor, override.Comparison
+	}
+	return spec, cases, nil
+}
+
+// bundlesHandler lists the versions of the bundle /admin/bundles/{name} (GET)
+// or uploads a new one (POST), as JSON or as an archive
+func bundlesHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !bundleName.MatchString(name) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid bundle name", map[string]string{"pattern": bundleName.String()})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		versions, err := bundles.versions(name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to list bundles: %v", err), nil)
+			return
+		}
+
+		list := []*Bundle{}
+		for _, version := range versions {
+			if bundle, ok := bundles.get(name, version); ok {
+				list = append(list, bundle)
+			}
+		}
+
+		jsonResponse, _ := json.Marshal(map[string]any{"name": name, "versions": list})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+
+	case http.MethodPost:
+		// Step 1: Read the upload into a directory next to the versions
+		err := os.MkdirAll(filepath.Join(bundles.dir, name), os.ModePerm)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to store bundle: %v", err), nil)
+			return
+		}
+		tmp, err := os.MkdirTemp(filepath.Join(bundles.dir, name), ".upload-")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to store bundle: %v", err), nil)
+			return
+		}
+		defer os.RemoveAll(tmp)
+
+		tests := filepath.Join(tmp, "tests")
+		err = os.Mkdir(tests, os.ModePerm)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to store bundle: %v", err), nil)
+			return
+		}
+
+		spec, cases, err := readBundleUpload(http.MaxBytesReader(w, r.Body, maxBundleBytes), r.Header.Get("Content-Type"), tmp, tests)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeError(w, http.StatusRequestEntityTooLarge, CodeInvalidRequest,
+					fmt.Sprintf("Bundle exceeds the limit of %d bytes", maxBundleBytes),
+					map[string]int64{"limitBytes": maxBundleBytes})
+				return
+			}
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error(), nil)
+			return
+		}
+
+		if cases == 0 || cases > maxTestCases {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("Between 1 and %d test cases are required", maxTestCases), nil)
+			return
+		}
+		if !checkBundleSpec(w, &spec) {
+			return
+		}
+
+		// Step 2: Store it as the next version
+		bundle := &Bundle{
+			Name:       name,
+			Cases:      cases,
+			SizeBytes:  directorySize(tests),
+			CreatedAt:  time.Now().UTC(),
+			BundleSpec: spec,
+		}
+		err = bundles.add(bundle, tests)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to store bundle: %v", err), nil)
+			return
+		}
+		log.Printf("Stored bundle %s with %d test cases", bundle.ID, bundle.Cases)
+
+		jsonResponse, _ := json.Marshal(bundle)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+	}
+}
+
+// bundleVersionHandler returns (GET) or deletes (DELETE) the bundle version
+// /admin/bundles/{name}/{version}
+func bundleVersionHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	version, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Bundle not found", nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		bundle, ok := bundles.get(name, version)
+		if !ok {
+			writeError(w, http.StatusNotFound, CodeInvalidRequest, "Bundle not found", nil)
+			return
+		}
+
+		jsonResponse, _ := json.Marshal(bundle)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	case http.MethodDelete:
+		if !bundles.remove(name, version) {
+			writeError(w, http.StatusNotFound, CodeInvalidRequest, "Bundle not found", nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Fleets can run agents of different versions side by side. Every response
+// advertises the optional features of the agent in the capabilities header,
+// and a request can list the features it relies on in the requirements
+// header, in which case an agent lacking any of them rejects it with a 412
+// instead of ignoring the fields it doesn't know. GET /capabilities returns
+// the features along with the languages and limits.
+
+const (
+	// capabilitiesHeader lists the features of the agent, comma-separated
+	capabilitiesHeader = "X-Octree-Capabilities"
+
+	// requirementsHeader lists the features a request relies on, comma-separated
+	requirementsHeader = "X-Octree-Requires"
+)
+
+// features are the optional features of the agent, which are fixed once the
+// plugins and configs are loaded
+var features = sync.OnceValue(agentFeatures)
+
+// CapabilitiesResponse is returned by the capabilities endpoint
+type CapabilitiesResponse struct {
+	Features  []string                `json:"features"`
+	Languages map[string]LanguageInfo `json:"languages"`
+	Limits    map[string]int64        `json:"limits"`
+}
+
+// LanguageInfo describes what a language supports
+type LanguageInfo struct {
+	Runtimes  []string `json:"runtimes,omitempty"`
+	Function  bool     `json:"function"`
+	Profiling bool     `json:"profiling"`
+	Intel     bool     `json:"intel"`
+	Debug     bool     `json:"debug"`
+	Backend   string   `json:"backend"`
+}
+
+// withCapabilities advertises the agent's features on every response and
+// rejects requests requiring features the agent lacks
+func withCapabilities(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(capabilitiesHeader, strings.Join(features(), ","))
+
+		var missing []string
+		for _, feature := range strings.Split(r.Header.Get(requirementsHeader), ",") {
+			feature = strings.TrimSpace(feature)
+			if feature != "" && !slices.Contains(features(), feature) {
+				missing = append(missing, feature)
+			}
+		}
+		if len(missing) > 0 {
+			writeError(w, http.StatusPreconditionFailed, CodeUnsupportedFeature, "Required features not supported",
+				map[string][]string{"missing": missing})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// capabilitiesHandler returns the features, languages and limits of the agent
+func capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	response := CapabilitiesResponse{
+		Features:  features(),
+		Languages: map[string]LanguageInfo{},
+		Limits: map[string]int64{
+			"maxTimeLimitMs":           maxTimeLimitMs,
+			"maxMemoryLimitMb":         maxMemoryLimitMB,
+			"maxCodeBytes":             maxCodeBytes,
+			"maxRequestBodyBytes":      maxRequestBodyBytes,
+			"maxJudgeRequestBodyBytes": maxJudgeRequestBodyBytes,
+			"maxServicesPerRequest":    maxServicesPerRequest,
+			"maxStackSizeMb":           maxStackSizeMB,
+		},
+	}
+	for _, phase := range []string{PhaseSetup, PhaseCompile, PhaseRun, PhaseCleanup} {
+		if timeout := phaseTimeout(phase); timeout > 0 {
+			response.Limits[phase+"TimeoutMs"] = timeout.Milliseconds()
+		}
+	}
+	for name, lang := range languages {
+		response.Languages[name] = LanguageInfo{
+			Runtimes:  lang.Runtimes,
+			Function:  lang.Harness != nil,
+			Profiling: lang.Profiling,
+			Intel:     lang.Intel != nil,
+			Debug:     debugAdapters[name] != nil,
+			Backend:   lang.Backend,
+		}
+	}
+
+	jsonResponse, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// Every request goes through one chain of middleware for the concerns that
+// apply to all routes: panic recovery, the access log, the HTTP metrics, the
+// request deadline, CORS, rate limiting, capabilities and affinity. The
+// concerns specific to some routes (compression, admin auth, body limits,
+// replay recording and request validation) are interceptors further down the
+// chain, which a route selects with its options when it is registered (see
+// handle) and which leave the requests of the other routes alone. A route
+// can also opt out of the request deadline.
+
+// middleware wraps a handler with a concern shared by the routes
+type middleware func(http.Handler) http.Handler
+
+// interceptor wraps the handler of a route with a concern the route selected
+type interceptor func(http.HandlerFunc) http.HandlerFunc
+
+// chain wraps handler with middlewares, the first one being the outermost
+func chain(handler http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// route holds the interceptors a route selected, nil for those it didn't
+type route struct {
+	compression, auth, bodyLimit, replay, validation interceptor
+
+	// untimed exempts the route from the request deadline
+	untimed bool
+}
+
+// routeOption selects an interceptor for a route
+type routeOption func(*route)
+
+// compressed (de)compresses the bodies of the route (see withCompression)
+func compressed(rt *route) { rt.compression = withCompression }
+
+// adminOnly restricts the route to admins (see requireAdmin)
+func adminOnly(rt *route) { rt.auth = requireAdmin }
+
+// bodyLimit bounds the request bodies of the route to maxBytes (see limitRequestBody)
+func bodyLimit(maxBytes int64) routeOption {
+	return func(rt *route) {
+		rt.bodyLimit = func(next http.HandlerFunc) http.HandlerFunc { return limitRequestBody(maxBytes, next) }
+	}
+}
+
+// replayable keeps the executions started through the route for replay as
+// kind (see recordForReplay)
+func replayable(kind string) routeOption {
+	return func(rt *route) {
+		rt.replay = func(next http.HandlerFunc) http.HandlerFunc { return recordForReplay(kind, next) }
+	}
+}
+
+// untimed exempts the route from the request deadline (see
+// withRequestDeadline), for requests bounding their duration themselves
+func untimed(rt *route) { rt.untimed = true }
+
+// validated checks the requests of the route with validate before they reach its handler
+func validated(validate interceptor) routeOption {
+	return func(rt *route) { rt.validation = validate }
+}
+
+// routes are the options of the registered routes, by pattern
+var routes = map[string]*route{}
+
+// routeKey is the context key of the route of a request
+type routeKey struct{}
+
+// handle registers handler for pattern, with the interceptors selected by options
+func handle(pattern string, handler http.HandlerFunc, options ...routeOption) {
+	rt := &route{}
+	for _, option := range options {
+		option(rt)
+	}
+	routes[pattern] = rt
+	http.HandleFunc(pattern, handler)
+}
+
+// withRoute looks up the route of the request for the middleware after it
+func withRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := http.DefaultServeMux.Handler(r)
+		if rt, ok := routes[pattern]; ok {
+			r = r.WithContext(context.WithValue(r.Context(), routeKey{}, rt))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeOf returns the route of the request, looked up by withRoute, with no
+// options if it matches none
+func routeOf(r *http.Request) *route {
+	if rt, ok := r.Context().Value(routeKey{}).(*route); ok {
+		return rt
+	}
+	return &route{}
+}
+
+// intercept returns the middleware running the requests of the routes that
+// selected the interceptor returned by selected through it
+func intercept(selected func(*route) interceptor) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if wrap := selected(routeOf(r)); wrap != nil {
+				wrap(next.ServeHTTP)(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// The interceptors, in the order they run in
+var (
+	withRouteCompression = intercept(func(rt *route) interceptor { return rt.compression })
+	withRouteAuth        = intercept(func(rt *route) interceptor { return rt.auth })
+	withRouteBodyLimit   = intercept(func(rt *route) interceptor { return rt.bodyLimit })
+	withRouteReplay      = intercept(func(rt *route) interceptor { return rt.replay })
+	withRouteValidation  = intercept(func(rt *route) interceptor { return rt.validation })
+)
+
+// statusRecorder records the status and size of the response written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	// Informational responses, such as the early execution ID, come first
+	if sr.status == 0 && status >= 200 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to hijack it
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// httpMetrics counts the requests served
+var httpMetrics struct {
+	requests, inFlight, clientErrors, serverErrors, panics, rateLimited atomic.Int64
+}
+
+// withRecovery turns a panicking handler into a 500 response, if nothing
+// was written yet, instead of the connection being dropped
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// Handlers abort on purpose with ErrAbortHandler
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			httpMetrics.panics.Add(1)
+			log.Printf("Warning: panic serving %s %s: %v\n%s", r.Method, r.URL.Path, v, debug.Stack())
+			if rec.status == 0 {
+				writeError(rec, http.StatusInternalServerError, CodeInternal, "Internal error", nil)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// withRequestLog logs every request once served, if config.AccessLog is set
+func withRequestLog(next http.Handler) http.Handler {
+	if !config.AccessLog {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		log.Printf("%s %s %d %dB %s %s", r.Method, r.URL.Path, rec.status, rec.bytes, time.Since(start).Round(time.Millisecond), r.RemoteAddr)
+	})
+}
+
+// withHTTPMetrics counts the requests and their errors
+func withHTTPMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpMetrics.requests.Add(1)
+		httpMetrics.inFlight.Add(1)
+		defer httpMetrics.inFlight.Add(-1)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		switch {
+		case rec.status >= 500:
+			httpMetrics.serverErrors.Add(1)
+		case rec.status >= 400:
+			httpMetrics.clientErrors.Add(1)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// checkerTimeLimit bounds each run of a checker program
+const checkerTimeLimit = 10 * time.Second
+
+// checker is a special judge: a program, in any supported language, that
+// decides whether an output is correct. For every test case it runs in its
+// own workspace containing:
+//
+//   - input.txt:  the test case input
+//   - output.txt: the output of the submission
+//   - answer.txt: the expected (reference) output
+//
+// It exits with 0 to accept the output and 1 to reject it; anything else is a
+// checker failure. Whatever it prints is returned as the test case message.
+type checker struct {
+	lang *Language
+	job  *ExecJob
+}
+
+// check runs the checker against the output of a test case
+func (c *checker) check(ctx context.Context, tc TestCase, output string) (Verdict, string) {
+	// Step 1: Write the files the checker reads
+	err := writeTestCaseFiles(c.job.Dir, map[string]string{
+		"input.txt":  tc.Input,
+		"output.txt": output,
+		"answer.txt": tc.ExpectedOutput,
+	})
+	if err != nil {
+		return VerdictInternalError, fmt.Sprintf("checker failed: %s", err)
+	}
+
+	// Step 2: Run the checker and interpret its exit code
+	c.job.TimeLimit = checkerTimeLimit
+	result, err := c.lang.Run(ctx, c.job)
+	return judgeProgramVerdict("checker", result, err)
+}
+
+// writeTestCaseFiles writes each file name to its content inside dir. The
+// workspace is reused across test cases, so the files are confined to it in
+// case a previous run left symlinks in their place.
+func writeTestCaseFiles(dir string, files map[string]string) error {
+	for name, content := range files {
+		path, err := confinedPath(dir, name)
+		if err != nil {
+			return fmt.Errorf("unable to write %s: %w", name, err)
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			return fmt.Errorf("unable to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// judgeProgramVerdict interprets the outcome of a checker or interactor run:
+// exit code 0 accepts, 1 rejects and anything else means the judge program
+// itself failed. Whatever it printed is returned as the message.
+func judgeProgramVerdict(name string, result *ExecResult, err error) (Verdict, string) {
+	message := ""
+	if result != nil {
+		message = strings.TrimSpace(result.Stderr)
+		if message == "" {
+			message = strings.TrimSpace(result.Stdout)
+		}
+	}
+
+	var exitErr *programExitError
+	switch {
+	case err == nil:
+		return VerdictAccepted, message
+	case errors.As(err, &exitErr) && exitErr.code == 1:
+		return VerdictWrongAnswer, message
+	default:
+		return VerdictInternalError, fmt.Sprintf("%s failed: %s", name, err)
+	}
+}
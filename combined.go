@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// With combinedOutput, the program's stdout and stderr are also returned as
+// one stream of chunks in the order they were written, each labeled with its
+// stream and the time since the program started, since the separate stdout
+// and stderr lose the interleaving users see in a terminal.
+
+// OutputChunk is a piece of the program's output
+type OutputChunk struct {
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+	AtMs   int64  `json:"atMs"`
+
+	// Encoding is "base64" for binary output
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// combinedOutput records the chunks written to its writers
+type combinedOutput struct {
+	mu     sync.Mutex
+	start  time.Time
+	chunks []OutputChunk
+}
+
+// newCombinedOutput starts recording
+func newCombinedOutput() *combinedOutput {
+	return &combinedOutput{start: time.Now()}
+}
+
+// writer returns the writer recording the chunks of stream
+func (o *combinedOutput) writer(stream string) io.Writer {
+	return combinedWriter{o, stream}
+}
+
+// combinedWriter records what is written to it as chunks of one stream
+type combinedWriter struct {
+	output *combinedOutput
+	stream string
+}
+
+func (w combinedWriter) Write(p []byte) (int, error) {
+	o := w.output
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Consecutive writes to a stream within the same millisecond make up one chunk
+	at := time.Since(o.start).Milliseconds()
+	if n := len(o.chunks); n > 0 && o.chunks[n-1].Stream == w.stream && o.chunks[n-1].AtMs == at {
+		o.chunks[n-1].Data += string(p)
+		return len(p), nil
+	}
+
+	o.chunks = append(o.chunks, OutputChunk{Stream: w.stream, Data: string(p), AtMs: at})
+	return len(p), nil
+}
+
+// result returns the chunks recorded, encoding the binary ones
+func (o *combinedOutput) result() []OutputChunk {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	chunks := make([]OutputChunk, len(o.chunks))
+	for i, chunk := range o.chunks {
+		chunk.Data, chunk.Encoding = encodeOutput(chunk.Data)
+		chunks[i] = chunk
+	}
+	return chunks
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ComparisonSpec compares the outputs token by token instead of line by
+// line, with numbers matching if they are within AbsoluteError or
+// RelativeError (of the expected number) of each other, so floating-point
+// problems don't need a checker. Tokens are separated by any whitespace, so
+// the layout of the output doesn't matter. Numbers are read the same way in
+// every locale, except that a number with a decimal comma, as printed by a
+// program run under a locale like de_DE, matches the same number with a
+// decimal point.
+type ComparisonSpec struct {
+	AbsoluteError float64 `json:"absoluteError,omitempty"`
+	RelativeError float64 `json:"relativeError,omitempty"`
+}
+
+// checkComparisonSpec validates the comparison settings of req. If they are
+// invalid it writes the error response and returns false.
+func checkComparisonSpec(w http.ResponseWriter, req *JudgeRequest) bool {
+	spec := req.Comparison
+	if spec == nil {
+		return true
+	}
+	if req.Checker != nil || req.Interactor != nil || req.Function != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "A comparison can't be used with a checker, an interactor or function mode", nil)
+		return false
+	}
+	if !(spec.AbsoluteError >= 0) || !(spec.RelativeError >= 0) || math.IsInf(spec.AbsoluteError, 0) || math.IsInf(spec.RelativeError, 0) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Comparison errors must be finite and not negative", nil)
+		return false
+	}
+	return true
+}
+
+// outputToken is a whitespace-separated token of an output, at its 0-based
+// line and byte offset in that line
+type outputToken struct {
+	text   string
+	line   int
+	offset int
+}
+
+// tokenizeOutput splits output into its tokens
+func tokenizeOutput(output string) []outputToken {
+	var tokens []outputToken
+	for i, line := range strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n") {
+		start := -1
+		for j, r := range line + " " {
+			if !unicode.IsSpace(r) {
+				if start < 0 {
+					start = j
+				}
+				continue
+			}
+			if start >= 0 {
+				tokens = append(tokens, outputToken{line[start:j], i, start})
+				start = -1
+			}
+		}
+	}
+	return tokens
+}
+
+// compare returns where the tokens of expected and actual first differ, and
+// a message describing it, or nil if they match
+func (s *ComparisonSpec) compare(expected string, actual string) (*OutputDiff, string) {
+	want, got := tokenizeOutput(expected), tokenizeOutput(actual)
+
+	// Step 1: Find the first token that differs
+	i := 0
+	for i < len(want) && i < len(got) && s.tokensMatch(want[i].text, got[i].text) {
+		i++
+	}
+	if i == len(want) && i == len(got) {
+		return nil, ""
+	}
+
+	var message string
+	switch {
+	case i == len(got):
+		message = fmt.Sprintf("token %d missing: expected %q", i+1, want[i].text)
+	case i == len(want):
+		message = fmt.Sprintf("token %d unexpected: got %q", i+1, got[i].text)
+	default:
+		message = fmt.Sprintf("token %d differs: expected %q, got %q", i+1, want[i].text, got[i].text)
+	}
+
+	// Step 2: Show the lines around it in the actual output, or around the
+	// end of it if the token is missing
+	expectedLines := strings.Split(normalizeOutput(expected), "\n")
+	actualLines := strings.Split(normalizeOutput(actual), "\n")
+	line, column := len(actualLines)-1, 0
+	if i < len(got) {
+		line = got[i].line
+		column = utf8.RuneCountInString(actualLines[line][:got[i].offset])
+	} else {
+		column = utf8.RuneCountInString(actualLines[line])
+	}
+
+	from := max(line-diffContextLines, 0)
+	to := line + diffContextLines + 1
+
+	return &OutputDiff{
+		Line:     line + 1,
+		Column:   column + 1,
+		FromLine: from + 1,
+		Expected: excerpt(expectedLines, from, to),
+		Actual:   excerpt(actualLines, from, to),
+	}, message
+}
+
+// tokensMatch reports whether the actual token got matches the expected token want
+func (s *ComparisonSpec) tokensMatch(want string, got string) bool {
+	if want == got {
+		return true
+	}
+
+	a, ok := parseNumber(want)
+	if !ok {
+		return false
+	}
+	b, ok := parseNumber(got)
+	if !ok {
+		return false
+	}
+
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		return math.IsNaN(a) && math.IsNaN(b)
+	case math.IsInf(a, 0) || math.IsInf(b, 0):
+		return a == b
+	}
+	diff := math.Abs(a - b)
+	return diff <= s.AbsoluteError || diff <= s.RelativeError*math.Abs(a)
+}
+
+// parseNumber reads token as a decimal number, with a decimal point or a
+// single decimal comma
+func parseNumber(token string) (float64, bool) {
+	if strings.Count(token, ",") == 1 && !strings.Contains(token, ".") {
+		token = strings.Replace(token, ",", ".", 1)
+	}
+	// Only decimal numbers, not hexadecimal floats or Go's digit separators
+	if strings.ContainsAny(token, "xX_") {
+		return 0, false
+	}
+
+	n, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The compile cache keeps the outputs of successful compilations, keyed by
+// the toolchain version, the compiler command line and the submitted code, so
+// that resubmitting the same program (e.g. a reference solution graded against
+// every submission) skips compiling it. Entries are evicted least recently
+// used first once the cache outgrows its size limit.
+
+// compileCacheMeta is the name of the file holding the compiler output in a cache entry
+const compileCacheMeta = "result.json"
+
+// compileCacheEntry is what is stored besides the compiled files
+type compileCacheEntry struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+// toolchainVersionArgs are the arguments printing a compiler's version, for
+// compilers that don't take --version
+var toolchainVersionArgs = map[string][]string{
+	"zig":       {"version"},
+	"ocamlfind": {"ocamlopt", "-version"},
+}
+
+var (
+	// toolchainVersions memoizes the version output of each compiler
+	toolchainVersions sync.Map
+
+	// compileCacheMu serializes evictions
+	compileCacheMu sync.Mutex
+)
+
+// compileCached runs the compile command c for job, unless the same
+// compilation is cached, in which case its outputs (files relative to the
+// workspace) are restored instead
+func compileCached(ctx context.Context, job *ExecJob, c command, outputs ...string) (*commandResult, error) {
+	progress.advance(ctx, ProgressCompiling)
+	if config.CompileCacheBytes <= 0 {
+		return runCompiler(ctx, c)
+	}
+
+	key, err := compileCacheKey(ctx, job, c)
+	if err != nil {
+		log.Printf("Warning: not caching compilation: %s", err)
+		return runCompiler(ctx, c)
+	}
+	entryDir := filepath.Join(config.CompileCacheDir, key)
+
+	// Step 1: Restore a cached compilation
+	res, err := restoreCompilation(entryDir, job.Dir, outputs)
+	if err == nil {
+		return res, nil
+	}
+	if !os.IsNotExist(err) {
+		log.Printf("Warning: failed to restore cached compilation %s: %s", key, err)
+	}
+
+	// Step 2: Compile, caching the outputs if it succeeds
+	res, err = runCompiler(ctx, c)
+	if err != nil {
+		return res, err
+	}
+
+	err = storeCompilation(entryDir, job.Dir, outputs, res)
+	if err != nil {
+		log.Printf("Warning: failed to cache compilation %s: %s", key, err)
+	}
+
+	return res, nil
+}
+
+// compileCacheKey identifies the compilation of the job's code by c
+func compileCacheKey(ctx context.Context, job *ExecJob, c command) (string, error) {
+	version, err := toolchainVersion(ctx, c.Name)
+	if err != nil {
+		return "", err
+	}
+
+	source := sha256.Sum256([]byte(job.Request.Code))
+
+	h := sha256.New()
+	for _, part := range []string{job.Request.Language, version, c.Name, strings.Join(c.Args, "\x00"), strings.Join(c.Env, "\x00"), hex.EncodeToString(source[:])} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// toolchainVersion returns the version output of the compiler name
+func toolchainVersion(ctx context.Context, name string) (string, error) {
+	if version, ok := toolchainVersions.Load(name); ok {
+		return version.(string), nil
+	}
+
+	args, ok := toolchainVersionArgs[name]
+	if !ok {
+		args = []string{"--version"}
+	}
+
+	res, err := runCommand(ctx, command{Name: name, Args: args, Timeout: 10 * time.Second})
+	if err != nil {
+		return "", fmt.Errorf("failed to get the version of %s: %w", name, err)
+	}
+
+	version := res.Stdout + res.Stderr
+	toolchainVersions.Store(name, version)
+	return version, nil
+}
+
+// restoreCompilation copies the outputs of the cache entry into dir
+func restoreCompilation(entryDir string, dir string, outputs []string) (*commandResult, error) {
+	start := time.Now()
+
+	data, err := os.ReadFile(filepath.Join(entryDir, compileCacheMeta))
+	if err != nil {
+		return nil, err
+	}
+
+	var entry compileCacheEntry
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache entry: %w", err)
+	}
+
+	for _, output := range outputs {
+		err = copyFileMode(filepath.Join(entryDir, output), filepath.Join(dir, output))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The modification time orders the entries for eviction
+	now := time.Now()
+	os.Chtimes(entryDir, now, now)
+
+	return &commandResult{Stdout: entry.Stdout, Stderr: entry.Stderr, Duration: time.Since(start), Cached: true}, nil
+}
+
+// storeCompilation copies the outputs in dir into a new cache entry, then
+// evicts old entries if the cache is over its size limit
+func storeCompilation(entryDir string, dir string, outputs []string, res *commandResult) error {
+	// Step 1: Write the entry under a temporary name, so it only appears once complete
+	tmpDir := entryDir + ".tmp-" + uuid.New().String()
+
+	err := os.MkdirAll(tmpDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, output := range outputs {
+		err = copyFileMode(filepath.Join(dir, output), filepath.Join(tmpDir, output))
+		if err != nil {
+			return err
+		}
+	}
+
+	data, _ := json.Marshal(compileCacheEntry{Stdout: res.Stdout, Stderr: res.Stderr})
+	err = os.WriteFile(filepath.Join(tmpDir, compileCacheMeta), data, 0644)
+	if err != nil {
+		return err
+	}
+
+	// Another execution may have cached the same compilation in the meantime
+	err = os.Rename(tmpDir, entryDir)
+	if err != nil && !os.IsExist(err) {
+		if _, statErr := os.Stat(entryDir); statErr != nil {
+			return err
+		}
+	}
+
+	// Step 2: Evict the least recently used entries
+	return evictCompilations(config.CompileCacheDir, config.CompileCacheBytes)
+}
+
+// evictCompilations removes the least recently used entries of the cache in
+// dir until it is no larger than limit bytes
+func evictCompilations(dir string, limit int64) error {
+	compileCacheMu.Lock()
+	defer compileCacheMu.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type cacheEntry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	var cached []cacheEntry
+	var total int64
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.Contains(entry.Name(), ".tmp-") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		size := directorySize(path)
+		cached = append(cached, cacheEntry{path: path, size: size, modTime: info.ModTime()})
+		total += size
+	}
+
+	sort.Slice(cached, func(i, j int) bool {
+		return cached[i].modTime.Before(cached[j].modTime)
+	})
+
+	for _, entry := range cached {
+		if total <= limit {
+			break
+		}
+		err = os.RemoveAll(entry.path)
+		if err != nil {
+			return err
+		}
+		total -= entry.size
+	}
+
+	return nil
+}
+
+// directorySize returns the total size of the files under dir
+func directorySize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// copyFileMode copies a file from src to dest, keeping its permissions
+func copyFileMode(src string, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(dest), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = copyFile(src, dest)
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(dest, info.Mode().Perm())
+}
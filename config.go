@@ -0,0 +1,366 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config holds the agent settings that can be changed through the environment
+type Config struct {
+	// ClojureJVM makes JVM clojure available as a runtime alongside babashka (OCTREE_CLOJURE_JVM)
+	ClojureJVM bool
+
+	// PluginDir is scanned on startup for language plugins (OCTREE_PLUGIN_DIR)
+	PluginDir string
+
+	// RunnersConfig is a JSON file declaring external runners (OCTREE_RUNNERS_CONFIG)
+	RunnersConfig string
+
+	// HooksConfig is a JSON file declaring per-language setup and teardown hooks (OCTREE_HOOKS_CONFIG)
+	HooksConfig string
+
+	// MaxRecords is the number of execution records kept for export (OCTREE_MAX_RECORDS)
+	MaxRecords int
+
+	// AdminToken enables the admin-only features for requests bearing it (OCTREE_ADMIN_TOKEN)
+	AdminToken string
+
+	// CompileCacheDir holds the compile cache (OCTREE_COMPILE_CACHE_DIR)
+	CompileCacheDir string
+
+	// CompileCacheBytes bounds the size of the compile cache; 0 disables it (OCTREE_COMPILE_CACHE_BYTES)
+	CompileCacheBytes int64
+
+	// ProgramsDir stores the registered reference programs (OCTREE_PROGRAMS_DIR)
+	ProgramsDir string
+
+	// MaxInFlightMemoryBytes bounds the memory committed to running executions;
+	// 0 disables the bound (OCTREE_MAX_INFLIGHT_MEMORY_BYTES, default 3/4 of the machine's memory)
+	MaxInFlightMemoryBytes int64
+
+	// NodeCompileCacheDir holds the V8 code caches of the node templates; empty disables them (OCTREE_NODE_COMPILE_CACHE_DIR)
+	NodeCompileCacheDir string
+
+	// TemplatesDir holds the template projects uploaded through the admin API (OCTREE_TEMPLATES_DIR)
+	TemplatesDir string
+
+	// SnapshotsDir stores the workspace snapshots (OCTREE_SNAPSHOTS_DIR)
+	SnapshotsDir string
+
+	// GitHosts are the hosts code may be checked out from, comma-separated (OCTREE_GIT_HOSTS)
+	GitHosts []string
+
+	// SourceURLHosts are the hosts code and files may be fetched from, comma-separated,
+	// with ".example.com" allowing subdomains; none by default (OCTREE_SOURCE_URL_HOSTS)
+	SourceURLHosts []string
+
+	// ServicesConfig is a JSON file declaring the services requests can ask for (OCTREE_SERVICES_CONFIG)
+	ServicesConfig string
+
+	// ServerPorts is the range of ports handed to programs in server mode (OCTREE_SERVER_PORTS)
+	ServerPorts string
+
+	// PlaywrightBrowsersDir holds the browsers preinstalled for browser tests (OCTREE_PLAYWRIGHT_BROWSERS_DIR)
+	PlaywrightBrowsersDir string
+
+	// BrowserSandbox runs Chromium with its sandbox, which needs unprivileged user namespaces (OCTREE_BROWSER_SANDBOX)
+	BrowserSandbox bool
+
+	// Locales are the locales requests can set, comma-separated (OCTREE_LOCALES)
+	Locales []string
+
+	// Timezones are the timezones requests can set, comma-separated; any
+	// IANA timezone if empty (OCTREE_TIMEZONES)
+	Timezones []string
+
+	// MaxOpenFiles is the RLIMIT_NOFILE of the processes run for executions (OCTREE_MAX_OPEN_FILES)
+	MaxOpenFiles int
+
+	// MaxCoreFileBytes is the RLIMIT_CORE of the processes run for executions;
+	// 0 disables core dumps (OCTREE_MAX_CORE_FILE_BYTES)
+	MaxCoreFileBytes int64
+
+	// The watchdog turns executions away while the agent's heap is above
+	// WatchdogMaxHeapBytes (OCTREE_WATCHDOG_MAX_HEAP_BYTES), the free space
+	// of the workspace disk below WatchdogMinFreeDiskBytes
+	// (OCTREE_WATCHDOG_MIN_FREE_DISK_BYTES) or its goroutines more than
+	// WatchdogMaxGoroutines (OCTREE_WATCHDOG_MAX_GOROUTINES); 0 disables a
+	// check. WatchdogRestart restarts an agent that stays unhealthy (OCTREE_WATCHDOG_RESTART).
+	WatchdogMaxHeapBytes     int64
+	WatchdogMinFreeDiskBytes int64
+	WatchdogMaxGoroutines    int
+	WatchdogRestart          bool
+
+	// JournalDir holds the journal of running executions (OCTREE_JOURNAL_DIR)
+	JournalDir string
+
+	// MaxReplays is the number of recent executions that can be replayed (OCTREE_MAX_REPLAYS)
+	MaxReplays int
+
+	// Faults are the faults injected to test orchestrators against, see faults.go (OCTREE_FAULTS)
+	Faults []string
+
+	// Browsers may call the agent from CORSOrigins (OCTREE_CORS_ORIGINS, "*"
+	// for any, none by default) with the CORSMethods (OCTREE_CORS_METHODS) and
+	// CORSHeaders (OCTREE_CORS_HEADERS), caching preflight responses for
+	// CORSMaxAgeSeconds (OCTREE_CORS_MAX_AGE_SECONDS)
+	CORSOrigins       []string
+	CORSMethods       []string
+	CORSHeaders       []string
+	CORSMaxAgeSeconds int
+
+	// The HTTP server gives clients ReadHeaderTimeoutMs (OCTREE_READ_HEADER_TIMEOUT_MS)
+	// to send the headers and ReadTimeoutMs (OCTREE_READ_TIMEOUT_MS) to send
+	// the whole request, and closes keep-alive connections idle for
+	// IdleTimeoutMs (OCTREE_IDLE_TIMEOUT_MS). A request may take RequestTimeoutMs
+	// overall (OCTREE_REQUEST_TIMEOUT_MS, 0 for no deadline).
+	ReadHeaderTimeoutMs int
+	ReadTimeoutMs       int
+	IdleTimeoutMs       int
+	RequestTimeoutMs    int
+
+	// H2C enables HTTP/2 without TLS for clients with prior knowledge
+	// (OCTREE_H2C), with MaxConcurrentStreams per connection
+	// (OCTREE_MAX_CONCURRENT_STREAMS). At most MaxConnections are open at once
+	// (OCTREE_MAX_CONNECTIONS, 0 for no limit).
+	H2C                  bool
+	MaxConcurrentStreams int
+	MaxConnections       int
+
+	// The agent listens on the TCP address ListenAddr (OCTREE_LISTEN_ADDR,
+	// empty to disable) and, if set, the Unix socket UnixSocket
+	// (OCTREE_UNIX_SOCKET) with the octal permissions UnixSocketMode
+	// (OCTREE_UNIX_SOCKET_MODE)
+	ListenAddr     string
+	UnixSocket     string
+	UnixSocketMode string
+
+	// SandboxProfile is the sandbox-exec profile programs run under on macOS,
+	// instead of the default one (OCTREE_SANDBOX_PROFILE)
+	SandboxProfile string
+
+	// At most MaxQueuedExecutions (OCTREE_MAX_QUEUED_EXECUTIONS, none by
+	// default) wait for memory to run in, for at most QueueTimeoutMs
+	// (OCTREE_QUEUE_TIMEOUT_MS)
+	MaxQueuedExecutions int
+	QueueTimeoutMs      int
+
+	// PreemptAfterMs is how long a high-priority execution waits before the
+	// oldest running batch execution is preempted (OCTREE_PREEMPT_AFTER_MS, 0
+	// to never preempt)
+	PreemptAfterMs int
+
+	// LanguageConcurrency caps the running executions of some languages, e.g.
+	// "java=2,javascript=8" (OCTREE_LANGUAGE_CONCURRENCY)
+	LanguageConcurrency []string
+
+	// PrewarmLanguages are run once on boot to warm their caches
+	// (OCTREE_PREWARM_LANGUAGES, every language if unset, none if empty)
+	PrewarmLanguages []string
+
+	// DiskPressurePercent is the share of the workspace disk's space or
+	// inodes past which garbage is collected (OCTREE_DISK_PRESSURE_PERCENT, 0
+	// to disable)
+	DiskPressurePercent int
+
+	// The full output of the latest OutputLogExecutions executions is kept
+	// (OCTREE_OUTPUT_LOG_EXECUTIONS, 0 to disable), up to OutputLogBytes in
+	// all (OCTREE_OUTPUT_LOG_BYTES)
+	OutputLogExecutions int
+	OutputLogBytes      int64
+
+	// ScanRulesDir holds the semgrep rulesets of scan mode (OCTREE_SCAN_RULES_DIR)
+	ScanRulesDir string
+
+	// DebugAdaptersConfig is a JSON file declaring the debug adapters of the
+	// languages (OCTREE_DEBUG_ADAPTERS_CONFIG)
+	DebugAdaptersConfig string
+
+	// AuditLog is a file the lifecycle of the executions is appended to as
+	// JSON lines (OCTREE_AUDIT_LOG, disabled if empty)
+	AuditLog string
+
+	// EventWebhooks are URLs the lifecycle events of the executions are
+	// posted to (OCTREE_EVENT_WEBHOOKS)
+	EventWebhooks []string
+
+	// AccessLog logs every request served (OCTREE_ACCESS_LOG)
+	AccessLog bool
+
+	// Each client may send RateLimitBurst requests at once and
+	// RateLimitPerSecond on average (OCTREE_RATE_LIMIT_PER_SECOND, 0 to
+	// disable, and OCTREE_RATE_LIMIT_BURST)
+	RateLimitPerSecond int
+	RateLimitBurst     int
+
+	// BundlesDir stores the test case bundles judge requests reference, and
+	// may be storage shared by several agents (OCTREE_BUNDLES_DIR)
+	BundlesDir string
+
+	// TenantWeights are the shares of the queue of some tenants, e.g.
+	// "contest=4,practice=1", 1 for the others (OCTREE_TENANT_WEIGHTS)
+	TenantWeights []string
+
+	// Each phase of an execution has a timeout of its own: preparing the
+	// workspace and running its setup hooks SetupTimeoutMs
+	// (OCTREE_SETUP_TIMEOUT_MS), each compile step CompileTimeoutMs
+	// (OCTREE_COMPILE_TIMEOUT_MS), the program RunTimeoutMs
+	// (OCTREE_RUN_TIMEOUT_MS) and the teardown hooks CleanupTimeoutMs
+	// (OCTREE_CLEANUP_TIMEOUT_MS). 0 leaves a phase to the timeouts of its
+	// commands, set by the language or the hook.
+	SetupTimeoutMs   int
+	CompileTimeoutMs int
+	RunTimeoutMs     int
+	CleanupTimeoutMs int
+
+	// RetryInfraFailures retries an execution that failed because of the
+	// agent rather than the code once, on a fresh workspace, and a judged
+	// test case failing that way once, except for interactive ones
+	// (OCTREE_RETRY_INFRA_FAILURES)
+	RetryInfraFailures bool
+
+	// AgentID is the routing token the agent advertises on its responses,
+	// defaulting to the hostname (OCTREE_AGENT_ID)
+	AgentID string
+}
+
+// config is loaded before the language runners register themselves, so they can consult it
+var config = loadConfig()
+
+// loadConfig reads the agent configuration from the environment
+func loadConfig() Config {
+	return Config{
+		ClojureJVM:    envBool("OCTREE_CLOJURE_JVM", false),
+		PluginDir:     envString("OCTREE_PLUGIN_DIR", filepath.Join(defaultInstallDir(), "plugins")),
+		RunnersConfig: envString("OCTREE_RUNNERS_CONFIG", filepath.Join(defaultConfigDir(), "runners.json")),
+		HooksConfig:   envString("OCTREE_HOOKS_CONFIG", filepath.Join(defaultConfigDir(), "hooks.json")),
+		MaxRecords:    envInt("OCTREE_MAX_RECORDS", 10000),
+		AdminToken:    envString("OCTREE_ADMIN_TOKEN", ""),
+
+		CompileCacheDir:   envString("OCTREE_COMPILE_CACHE_DIR", filepath.Join(workspaceRoot, ".compile-cache")),
+		CompileCacheBytes: int64(envInt("OCTREE_COMPILE_CACHE_BYTES", 1<<30)),
+		ProgramsDir:       envString("OCTREE_PROGRAMS_DIR", filepath.Join(workspaceRoot, ".programs")),
+
+		MaxInFlightMemoryBytes: int64(envInt("OCTREE_MAX_INFLIGHT_MEMORY_BYTES", int(defaultInFlightMemory()))),
+		NodeCompileCacheDir:    envString("OCTREE_NODE_COMPILE_CACHE_DIR", filepath.Join(workspaceRoot, ".node-compile-cache")),
+		TemplatesDir:           envString("OCTREE_TEMPLATES_DIR", filepath.Join(workspaceRoot, ".templates")),
+		SnapshotsDir:           envString("OCTREE_SNAPSHOTS_DIR", filepath.Join(workspaceRoot, ".snapshots")),
+		GitHosts:               envList("OCTREE_GIT_HOSTS", []string{"github.com"}),
+		SourceURLHosts:         envList("OCTREE_SOURCE_URL_HOSTS", nil),
+		ServicesConfig:         envString("OCTREE_SERVICES_CONFIG", filepath.Join(defaultConfigDir(), "services.json")),
+		ServerPorts:            envString("OCTREE_SERVER_PORTS", defaultServerPorts),
+		PlaywrightBrowsersDir:  envString("OCTREE_PLAYWRIGHT_BROWSERS_DIR", filepath.Join(defaultInstallDir(), "ms-playwright")),
+		BrowserSandbox:         envBool("OCTREE_BROWSER_SANDBOX", true),
+		Locales:                envList("OCTREE_LOCALES", []string{"C.UTF-8", "en_US.UTF-8"}),
+		Timezones:              envList("OCTREE_TIMEZONES", nil),
+		MaxOpenFiles:           envInt("OCTREE_MAX_OPEN_FILES", 1024),
+		MaxCoreFileBytes:       int64(envInt("OCTREE_MAX_CORE_FILE_BYTES", 0)),
+
+		WatchdogMaxHeapBytes:     int64(envInt("OCTREE_WATCHDOG_MAX_HEAP_BYTES", 2<<30)),
+		WatchdogMinFreeDiskBytes: int64(envInt("OCTREE_WATCHDOG_MIN_FREE_DISK_BYTES", 1<<30)),
+		WatchdogMaxGoroutines:    envInt("OCTREE_WATCHDOG_MAX_GOROUTINES", 50000),
+		WatchdogRestart:          envBool("OCTREE_WATCHDOG_RESTART", false),
+		JournalDir:               envString("OCTREE_JOURNAL_DIR", filepath.Join(workspaceRoot, ".journal")),
+		MaxReplays:               envInt("OCTREE_MAX_REPLAYS", 100),
+		Faults:                   envList("OCTREE_FAULTS", nil),
+		CORSOrigins:              envList("OCTREE_CORS_ORIGINS", nil),
+		CORSMethods:              envList("OCTREE_CORS_METHODS", []string{"GET", "POST", "PUT", "DELETE"}),
+		CORSHeaders:              envList("OCTREE_CORS_HEADERS", []string{"Content-Type", "Content-Encoding", "Authorization", requirementsHeader}),
+		CORSMaxAgeSeconds:        envInt("OCTREE_CORS_MAX_AGE_SECONDS", 600),
+		ReadHeaderTimeoutMs:      envInt("OCTREE_READ_HEADER_TIMEOUT_MS", 10000),
+		ReadTimeoutMs:            envInt("OCTREE_READ_TIMEOUT_MS", 60000),
+		IdleTimeoutMs:            envInt("OCTREE_IDLE_TIMEOUT_MS", 120000),
+		RequestTimeoutMs:         envInt("OCTREE_REQUEST_TIMEOUT_MS", 600000),
+		H2C:                      envBool("OCTREE_H2C", false),
+		MaxConcurrentStreams:     envInt("OCTREE_MAX_CONCURRENT_STREAMS", 250),
+		MaxConnections:           envInt("OCTREE_MAX_CONNECTIONS", 4096),
+		ListenAddr:               envString("OCTREE_LISTEN_ADDR", ":8080"),
+		UnixSocket:               envString("OCTREE_UNIX_SOCKET", ""),
+		UnixSocketMode:           envString("OCTREE_UNIX_SOCKET_MODE", "0660"),
+		SandboxProfile:           envString("OCTREE_SANDBOX_PROFILE", ""),
+		MaxQueuedExecutions:      envInt("OCTREE_MAX_QUEUED_EXECUTIONS", 0),
+		QueueTimeoutMs:           envInt("OCTREE_QUEUE_TIMEOUT_MS", 30000),
+		PreemptAfterMs:           envInt("OCTREE_PREEMPT_AFTER_MS", 0),
+		LanguageConcurrency:      envList("OCTREE_LANGUAGE_CONCURRENCY", nil),
+		PrewarmLanguages:         envList("OCTREE_PREWARM_LANGUAGES", nil),
+		DiskPressurePercent:      envInt("OCTREE_DISK_PRESSURE_PERCENT", 85),
+		OutputLogExecutions:      envInt("OCTREE_OUTPUT_LOG_EXECUTIONS", 50),
+		OutputLogBytes:           int64(envInt("OCTREE_OUTPUT_LOG_BYTES", 64<<20)),
+		ScanRulesDir:             envString("OCTREE_SCAN_RULES_DIR", filepath.Join(defaultInstallDir(), "semgrep-rules")),
+		DebugAdaptersConfig:      envString("OCTREE_DEBUG_ADAPTERS_CONFIG", filepath.Join(defaultConfigDir(), "debug-adapters.json")),
+		AuditLog:                 envString("OCTREE_AUDIT_LOG", ""),
+		EventWebhooks:            envList("OCTREE_EVENT_WEBHOOKS", nil),
+		AccessLog:                envBool("OCTREE_ACCESS_LOG", false),
+		RateLimitPerSecond:       envInt("OCTREE_RATE_LIMIT_PER_SECOND", 0),
+		RateLimitBurst:           envInt("OCTREE_RATE_LIMIT_BURST", 20),
+		BundlesDir:               envString("OCTREE_BUNDLES_DIR", filepath.Join(workspaceRoot, ".bundles")),
+		TenantWeights:            envList("OCTREE_TENANT_WEIGHTS", nil),
+		SetupTimeoutMs:           envInt("OCTREE_SETUP_TIMEOUT_MS", 0),
+		CompileTimeoutMs:         envInt("OCTREE_COMPILE_TIMEOUT_MS", 0),
+		RunTimeoutMs:             envInt("OCTREE_RUN_TIMEOUT_MS", 0),
+		CleanupTimeoutMs:         envInt("OCTREE_CLEANUP_TIMEOUT_MS", 0),
+		RetryInfraFailures:       envBool("OCTREE_RETRY_INFRA_FAILURES", false),
+		AgentID:                  envString("OCTREE_AGENT_ID", hostname()),
+	}
+}
+
+// envString reads a string environment variable, falling back to def if it is unset
+func envString(key string, def string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	return value
+}
+
+// envList reads a comma-separated environment variable, falling back to def if it is unset
+func envList(key string, def []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envBool reads a boolean environment variable, falling back to def if it is unset or invalid
+func envBool(key string, def bool) bool {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: ignoring invalid value %q for %s", value, key)
+		return def
+	}
+
+	return parsed
+}
+
+// envInt reads an integer environment variable, falling back to def if it is unset or invalid
+func envInt(key string, def int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: ignoring invalid value %q for %s", value, key)
+		return def
+	}
+
+	return parsed
+}
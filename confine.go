@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The agent doesn't chroot its file operations, so paths taken from request
+// data (archive entries, entrypoints, the test files named by a problem
+// package, the artifact directories of a workspace) are confined to the
+// directory they belong in before they are used: they must be relative,
+// mustn't climb out of it with "..", and mustn't go through a symlink
+// pointing out of it, such as one the submitted code left in its workspace.
+// IDs naming stored files (snapshots, programs, journal entries) are UUIDs,
+// checked as such before they are used.
+//
+// Files the submitted code leaves in its workspace (artifacts, snapshots) are
+// read without following symlinks at all, and must be regular files with a
+// single link, so that a program can't have the agent return /etc/shadow by
+// linking output/result.txt to it. See openWorkspaceFile.
+
+// errPathEscapes is returned for a path that isn't confined to its directory
+var errPathEscapes = errors.New("path escapes its directory")
+
+// confinedPath returns the path of name, a slash-separated path taken from
+// request data, within dir, or errPathEscapes if it would land outside of it
+func confinedPath(dir string, name string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if !filepath.IsLocal(rel) || strings.ContainsRune(rel, 0) {
+		return "", fmt.Errorf("%w: %q", errPathEscapes, name)
+	}
+
+	err := checkSymlinks(dir, rel)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+// checkSymlinks checks that none of the existing parts of the path rel
+// within dir is a symlink pointing out of dir, or pointing nowhere (which
+// creating the file would follow)
+func checkSymlinks(dir string, rel string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	walked := ""
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		walked = filepath.Join(walked, part)
+		path := filepath.Join(dir, walked)
+		info, err := os.Lstat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil || !withinDir(root, target) {
+			return fmt.Errorf("%w: %q is a symlink out of it", errPathEscapes, filepath.ToSlash(walked))
+		}
+	}
+	return nil
+}
+
+// withinDir reports whether path is dir or inside of it
+func withinDir(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// readWorkspaceFile reads the file name (slash-separated) of the workspace
+// dir, left by the submitted code
+func readWorkspaceFile(dir string, name string) ([]byte, error) {
+	f, err := openWorkspaceFile(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// openConfinedFile opens the file name within dir once it is confined to it,
+// checking that what was opened is the regular file that was checked rather
+// than a symlink swapped in meanwhile
+func openConfinedFile(dir string, name string) (*os.File, error) {
+	path, err := confinedPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: %q is not a regular file", errPathEscapes, name)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	opened, err := f.Stat()
+	if err == nil && !os.SameFile(info, opened) {
+		err = fmt.Errorf("%w: %q was replaced while it was opened", errPathEscapes, name)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// checkWorkspaceFile checks that f, the file name opened from a workspace,
+// is a regular file with a single link, rather than e.g. a device or a hard
+// link to a file outside of the workspace
+func checkWorkspaceFile(f *os.File, name string) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %q is not a regular file", errPathEscapes, name)
+	}
+	if hardLinks(info) > 1 {
+		return fmt.Errorf("%w: %q has other hard links", errPathEscapes, name)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzConfinedPath(f *testing.F) {
+	for _, name := range []string{
+		"main.go", "src/main.go", "./a/../b", "..", "../etc/passwd", "a/../../b",
+		"/etc/passwd", "a//b/", "a\x00b", "", ".", "C:\\Windows", "\\\\host\\share",
+	} {
+		f.Add(name)
+	}
+	dir := f.TempDir()
+
+	f.Fuzz(func(t *testing.T, name string) {
+		path, err := confinedPath(dir, name)
+		if err != nil {
+			return
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			t.Fatalf("confinedPath(%q) = %q, not relative to %q: %v", name, path, dir, err)
+		}
+		if !filepath.IsLocal(rel) || filepath.IsAbs(rel) {
+			t.Errorf("confinedPath(%q) = %q, outside of %q", name, path, dir)
+		}
+		if strings.ContainsRune(path, 0) {
+			t.Errorf("confinedPath(%q) = %q, containing NUL", name, path)
+		}
+		for _, part := range strings.Split(rel, string(filepath.Separator)) {
+			if part == ".." {
+				t.Errorf("confinedPath(%q) = %q, containing ..", name, path)
+			}
+		}
+	})
+}
+
+func TestConfinedPathSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+
+	mustMkdir(t, filepath.Join(dir, "sub"))
+	mustSymlink(t, outside, filepath.Join(dir, "escape"))
+	mustSymlink(t, filepath.Join(outside, "passwd"), filepath.Join(dir, "escape.txt"))
+	mustSymlink(t, filepath.Join(dir, "missing"), filepath.Join(dir, "dangling"))
+	mustSymlink(t, filepath.Join(dir, "sub"), filepath.Join(dir, "inside"))
+	mustSymlink(t, "../escape", filepath.Join(dir, "sub", "up"))
+
+	tests := []struct {
+		name    string
+		escapes bool
+	}{
+		{"sub/main.go", false},
+		{"new/main.go", false},
+		{"inside/main.go", false},
+		{"escape", true},
+		{"escape/main.go", true},
+		{"escape.txt", true},
+		{"dangling", true},
+		{"dangling/main.go", true},
+		{"sub/up/main.go", true},
+		{"inside/up/main.go", true},
+	}
+	for _, test := range tests {
+		_, err := confinedPath(dir, test.name)
+		if escapes := errors.Is(err, errPathEscapes); escapes != test.escapes {
+			t.Errorf("confinedPath(%q) = %v, want escaping %v", test.name, err, test.escapes)
+		}
+	}
+}
+
+func mustMkdir(t *testing.T, path string) {
+	t.Helper()
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustSymlink(t *testing.T, target string, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Some deployments let the in-browser editor call agents directly, without a
+// proxy in between. Cross-origin requests are allowed from the origins in
+// config.CORSOrigins only ("*" allows any), and CORS is disabled when that is
+// empty.
+
+// corsExposedHeaders are the response headers browsers let the editor read
+var corsExposedHeaders = []string{executionIDHeader, capabilitiesHeader, "Retry-After"}
+
+// corsOriginAllowed reports whether cross-origin requests from origin are allowed
+func corsOriginAllowed(origin string) bool {
+	return slices.Contains(config.CORSOrigins, "*") || slices.Contains(config.CORSOrigins, origin)
+}
+
+// withCORS adds the CORS headers to the responses to allowed origins and
+// answers their preflight requests
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !corsOriginAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+
+		// Preflight requests are answered here, without reaching the handlers
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(config.CORSMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(config.CORSHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(config.CORSMaxAgeSeconds))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
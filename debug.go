@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The editor can debug the submitted code: POST /code/debug takes an exec
+// request and returns a session, and connecting to the session's WebSocket
+// at /code/debug/{id} prepares the workspace and starts the language's debug
+// adapter in it. Every WebSocket text message is then one Debug Adapter
+// Protocol message, relayed to and from the adapter as is, so the editor sets
+// breakpoints, steps and inspects variables as with a local adapter.
+//
+// The adapters are declared per language in a JSON file, e.g.
+//
+//	{"adapters": [{
+//	    "language": "javascript",
+//	    "command": "js-debug-adapter",
+//	    "args": ["{port}"],
+//	    "launch": {"type": "pwa-node"}
+//	}]}
+//
+// An adapter speaks DAP on its stdin and stdout, or on the loopback port
+// substituted for "{port}" in its arguments. The editor's launch request is
+// confined to the submitted program: its program and working directory are
+// replaced by the workspace's, only a few harmless arguments are kept, and
+// the launch defaults of the adapter are filled in. Attaching is refused.
+
+// debugPortPlaceholder is replaced by the port the adapter listens on
+const debugPortPlaceholder = "{port}"
+
+const (
+	// maxDebugSessions bounds the debug sessions pending or running at once
+	maxDebugSessions = 4
+
+	// debugConnectTimeout is how long a created session waits for the editor to connect
+	debugConnectTimeout = time.Minute
+
+	// debugSessionTimeout bounds a debug session, from the editor connecting
+	// on; its route is exempt from the request deadline
+	debugSessionTimeout = 30 * time.Minute
+
+	// debugAdapterReadyTimeout is how long an adapter may take to listen on its port
+	debugAdapterReadyTimeout = 10 * time.Second
+)
+
+// debugLaunchArguments are the launch arguments kept from the editor's request
+var debugLaunchArguments = []string{"args", "noDebug", "stopOnEntry"}
+
+// debugAdaptersConfigFile is the format of the debug adapters config file
+type debugAdaptersConfigFile struct {
+	Adapters []*debugAdapter `json:"adapters"`
+}
+
+// debugAdapter declares the debug adapter of a language
+type debugAdapter struct {
+	Language string            `json:"language"`
+	Command  string            `json:"command"`
+	Args     []string          `json:"args,omitempty"`
+	Env      map[string]string `json:"env,omitempty"`
+
+	// Launch are the default arguments of the launch request
+	Launch map[string]any `json:"launch,omitempty"`
+}
+
+// debugAdapters maps a language to its debug adapter
+var debugAdapters = map[string]*debugAdapter{}
+
+// loadDebugAdaptersConfig loads the debug adapters declared in the config file at path
+func loadDebugAdaptersConfig(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read debug adapters config %s: %s", path, err)
+		}
+		return
+	}
+
+	var file debugAdaptersConfigFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		log.Printf("Warning: invalid debug adapters config %s: %s", path, err)
+		return
+	}
+
+	for _, adapter := range file.Adapters {
+		if _, ok := languages[adapter.Language]; !ok || adapter.Command == "" {
+			log.Printf("Warning: skipping debug adapter of %q: a known language and a command are required", adapter.Language)
+			continue
+		}
+		debugAdapters[adapter.Language] = adapter
+		log.Printf("Loaded debug adapter for %s", adapter.Language)
+	}
+}
+
+// debugSession is a debug session waiting for the editor to connect
+type debugSession struct {
+	id      string
+	lang    *Language
+	adapter *debugAdapter
+	req     *CodeExecRequest
+
+	// expiry drops the session at expiresAt if the editor hasn't connected
+	expiry    *time.Timer
+	expiresAt time.Time
+}
+
+var (
+	debugSessionsMu sync.Mutex
+
+	// pendingDebugSessions are the sessions not connected to yet, by ID, and
+	// runningDebugSessions counts the connected ones
+	pendingDebugSessions = map[string]*debugSession{}
+	runningDebugSessions int
+)
+
+// DebugSessionResponse is returned by the debug endpoint
+type DebugSessionResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// createDebugSessionHandler creates a debug session for the submitted code
+func createDebugSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read request body", nil)
+		return
+	}
+	defer r.Body.Close()
+
+	var req CodeExecRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON format", err.Error())
+		return
+	}
+
+	lang, ok := resolveLanguage(w, &req)
+	if !ok {
+		return
+	}
+	if req.Mode != ModeRun {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Mode not supported", map[string]any{"mode": req.Mode})
+		return
+	}
+	adapter, ok := debugAdapters[lang.Name]
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeUnsupportedLanguage, "Debugging is not available for the language",
+			map[string]string{"language": lang.Name})
+		return
+	}
+
+	session := &debugSession{id: uuid.New().String(), lang: lang, adapter: adapter, req: &req}
+	expiresAt := time.Now().Add(debugConnectTimeout)
+	if !addDebugSession(session, expiresAt) {
+		w.Header().Set("Retry-After", "10")
+		writeError(w, http.StatusServiceUnavailable, CodeOverloaded, "Too many debug sessions", map[string]int{"limit": maxDebugSessions})
+		return
+	}
+
+	response := DebugSessionResponse{ID: session.id, URL: "/code/debug/" + session.id, ExpiresAt: expiresAt}
+	jsonResponse, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonResponse)
+}
+
+// addDebugSession adds session to the pending ones until expiresAt,
+// reporting false if there are too many sessions already
+func addDebugSession(session *debugSession, expiresAt time.Time) bool {
+	debugSessionsMu.Lock()
+	defer debugSessionsMu.Unlock()
+
+	if len(pendingDebugSessions)+runningDebugSessions >= maxDebugSessions {
+		return false
+	}
+	session.expiresAt = expiresAt
+	pendingDebugSessions[session.id] = session
+	session.expiry = time.AfterFunc(time.Until(expiresAt), func() {
+		debugSessionsMu.Lock()
+		delete(pendingDebugSessions, session.id)
+		debugSessionsMu.Unlock()
+	})
+	return true
+}
+
+// takeDebugSession moves the pending session id to the running ones,
+// returning nil if there is none
+func takeDebugSession(id string) *debugSession {
+	debugSessionsMu.Lock()
+	defer debugSessionsMu.Unlock()
+
+	session, ok := pendingDebugSessions[id]
+	if !ok {
+		return nil
+	}
+	delete(pendingDebugSessions, id)
+	session.expiry.Stop()
+	runningDebugSessions++
+	return session
+}
+
+// debugSessionHandler runs the debug session /code/debug/{id} over a WebSocket
+func debugSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	session := takeDebugSession(r.PathValue("id"))
+	if session == nil {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Debug session not found", nil)
+		return
+	}
+
+	defer func() {
+		debugSessionsMu.Lock()
+		runningDebugSessions--
+		debugSessionsMu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(r.Context(), debugSessionTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	// Step 1: Wait for admission like any other execution
+	record := newExecutionRecord("debug", session.req)
+	record.ID = session.id
+	records.add(record)
+
+	ctx, release, ok := admitExecution(w, r, &queuedExecution{ID: record.ID, Kind: record.Kind, Language: record.Language, bytes: unlimitedMemoryEstimate})
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx, done := executions.start(ctx, record)
+	defer done()
+
+	// Step 2: Prepare the workspace and take over the connection
+	job, err := prepareWorkspace(ctx, session.lang, session.req)
+	if err != nil {
+		status, code := classifyExecutionError(err)
+		writeError(w, status, code, fmt.Sprintf("Unable to prepare workspace: %v", err), timeoutDetails(err))
+		return
+	}
+	defer removeWorkspace(job)
+
+	ws := upgradeWebSocket(w, r)
+	if ws == nil {
+		return
+	}
+	defer ws.close()
+
+	// Step 3: Relay the messages until either side is done
+	err = bridgeDebugAdapter(ctx, ws, session.adapter, job)
+	if err != nil {
+		log.Printf("Warning: debug session %s failed: %s", session.id, err)
+	}
+}
+
+// bridgeDebugAdapter starts adapter in the workspace of job and relays the
+// DAP messages between it and ws
+func bridgeDebugAdapter(ctx context.Context, ws *webSocket, adapter *debugAdapter, job *ExecJob) error {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// Step 1: Start the adapter, on a port of the server range if it listens on one
+	args := slices.Clone(adapter.Args)
+	port := 0
+	if slices.Contains(args, debugPortPlaceholder) {
+		var err error
+		port, err = serverPorts.allocate(0)
+		if err != nil {
+			return err
+		}
+		defer serverPorts.release(port)
+		for i := range args {
+			args[i] = strings.ReplaceAll(args[i], debugPortPlaceholder, strconv.Itoa(port))
+		}
+	}
+
+	env := slices.Clone(job.Env)
+	for key, value := range adapter.Env {
+		env = append(env, key+"="+value)
+	}
+	c := command{Name: adapter.Command, Args: args, Dir: job.Dir, Env: env, Timeout: debugSessionTimeout}
+
+	// The adapter reads stdin from a file, so nothing is left copying to it
+	// once it exits
+	var toAdapter io.WriteCloser
+	var fromAdapter io.Reader
+	var stdin *os.File
+	var stdout *io.PipeWriter
+	if port == 0 {
+		var err error
+		stdin, toAdapter, err = os.Pipe()
+		if err != nil {
+			return fmt.Errorf("failed to create stdin pipe: %w", err)
+		}
+		fromAdapter, stdout = io.Pipe()
+		c.Stdin, c.Stdout = stdin, stdout
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		res, err := runCommand(ctx, c)
+		if err != nil && res.Stderr != "" {
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(res.Stderr))
+		}
+		// The relay to the editor ends with the adapter's output
+		if stdout != nil {
+			stdin.Close()
+			stdout.Close()
+		}
+		exited <- err
+	}()
+
+	if port != 0 {
+		conn, err := dialDebugAdapter(ctx, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), exited)
+		if err != nil {
+			stop()
+			return err
+		}
+		defer conn.Close()
+		toAdapter, fromAdapter = conn, conn
+	}
+
+	// Step 2: Relay the adapter's messages to the editor
+	go func() {
+		reader := bufio.NewReader(fromAdapter)
+		for {
+			message, err := readFramed(reader)
+			if err != nil || ws.write(wsText, message) != nil {
+				break
+			}
+		}
+		stop()
+	}()
+
+	// The session ends when the execution is cancelled or runs out of time
+	go func() {
+		<-ctx.Done()
+		ws.close()
+	}()
+
+	// Step 3: Relay the editor's messages to the adapter, confining its requests
+	for {
+		opcode, message, err := ws.read()
+		if err != nil {
+			break
+		}
+		if opcode != wsText {
+			continue
+		}
+
+		message, reply := confineDebugRequest(message, adapter, job)
+		if reply != nil {
+			ws.write(wsText, reply)
+			continue
+		}
+		_, err = fmt.Fprintf(toAdapter, "Content-Length: %d\r\n\r\n%s", len(message), message)
+		if err != nil {
+			break
+		}
+	}
+
+	stop()
+	toAdapter.Close()
+	// Adapters are stopped once the session is over
+	err := <-exited
+	if errors.Is(err, errCancelled) && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// dialDebugAdapter connects to the adapter at addr once it listens, giving up
+// if it exits first
+func dialDebugAdapter(ctx context.Context, addr string, exited chan error) (net.Conn, error) {
+	deadline := time.After(debugAdapterReadyTimeout)
+	ticker := time.NewTicker(serverReadyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		conn, err := net.DialTimeout("tcp", addr, serverReadyPollInterval)
+		if err == nil {
+			return conn, nil
+		}
+
+		select {
+		case err := <-exited:
+			if err == nil {
+				err = fmt.Errorf("debug adapter exited without listening on %s", addr)
+			}
+			return nil, err
+		case <-deadline:
+			return nil, fmt.Errorf("debug adapter didn't listen on %s within %s", addr, debugAdapterReadyTimeout)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// dapRequest is the part of a DAP message the agent looks at
+type dapRequest struct {
+	Seq       int            `json:"seq"`
+	Type      string         `json:"type"`
+	Command   string         `json:"command"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+// confineDebugRequest rewrites the editor's launch request to launch the
+// submitted program. Requests that aren't allowed get the reply the editor
+// is sent instead of relaying them.
+func confineDebugRequest(message []byte, adapter *debugAdapter, job *ExecJob) ([]byte, []byte) {
+	var req dapRequest
+	if json.Unmarshal(message, &req) != nil || req.Type != "request" {
+		return message, nil
+	}
+
+	switch req.Command {
+	case "launch":
+		arguments := maps.Clone(adapter.Launch)
+		if arguments == nil {
+			arguments = map[string]any{}
+		}
+		for _, key := range debugLaunchArguments {
+			if value, ok := req.Arguments[key]; ok {
+				arguments[key] = value
+			}
+		}
+		arguments["program"] = job.SourcePath
+		arguments["cwd"] = job.Dir
+
+		rewritten, _ := json.Marshal(map[string]any{"seq": req.Seq, "type": "request", "command": "launch", "arguments": arguments})
+		return rewritten, nil
+	case "attach":
+		reply, _ := json.Marshal(map[string]any{
+			"seq": 0, "type": "response", "request_seq": req.Seq, "command": req.Command, "success": false,
+			"message": "Attaching is not allowed, launch the submitted program instead",
+		})
+		return nil, reply
+	}
+	return message, nil
+}
+
+// readFramed reads one message framed as "Content-Length: n\r\n\r\n" and n
+// bytes, as language servers and debug adapters do
+func readFramed(reader *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		header = strings.TrimSpace(header)
+		if header == "" {
+			if length >= 0 {
+				break
+			}
+			continue
+		}
+
+		value, ok := strings.CutPrefix(header, "Content-Length:")
+		if ok {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid header %q", header)
+			}
+		}
+	}
+
+	body := make([]byte, length)
+	_, err := io.ReadFull(reader, body)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
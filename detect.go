@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// When a request omits its language, it is detected from the extension of
+// the request's filename, the interpreter named by the code's shebang line,
+// or failing both from telltale constructs in the code. The detected
+// language is returned in the response, so clients can show what ran.
+
+// extensionLanguages are the extensions recognised besides those of the
+// languages' source files
+var extensionLanguages = map[string]string{
+	".mjs":     "javascript",
+	".cjs":     "javascript",
+	".htm":     "html",
+	".ex":      "elixir",
+	".escript": "erlang",
+	".cljc":    "clojure",
+	".bb":      "clojure",
+	".fs":      "fsharp",
+	".s":       "asm",
+	".nasm":    "asm",
+	".pm":      "perl",
+	".r":       "r",
+}
+
+// interpreterLanguages maps the interpreters named in shebang lines to their language
+var interpreterLanguages = map[string]string{
+	"perl":       "perl",
+	"node":       "javascript",
+	"nodejs":     "javascript",
+	"ts-node":    "typescript",
+	"Rscript":    "r",
+	"lua":        "lua",
+	"luajit":     "lua",
+	"julia":      "julia",
+	"elixir":     "elixir",
+	"escript":    "erlang",
+	"runghc":     "haskell",
+	"runhaskell": "haskell",
+	"ocaml":      "ocaml",
+	"dart":       "dart",
+	"bb":         "clojure",
+	"clojure":    "clojure",
+	"scala":      "scala",
+}
+
+// contentHeuristic recognises the code of a language by a telltale construct
+type contentHeuristic struct {
+	language string
+	pattern  *regexp.Regexp
+}
+
+// contentHeuristics are tried in order, the more distinctive languages first
+var contentHeuristics = []contentHeuristic{
+	{"html", regexp.MustCompile(`(?i)^\s*<(!doctype html|html)`)},
+	{"wasm", regexp.MustCompile(`^\s*\(module\b`)},
+	{"zig", regexp.MustCompile(`@import\("std"\)`)},
+	{"elixir", regexp.MustCompile(`(?m)^\s*defmodule\s|\bIO\.puts\b`)},
+	{"erlang", regexp.MustCompile(`(?m)^-module\(|\bio:format\(`)},
+	{"haskell", regexp.MustCompile(`(?m)^main\s*::|^main\s*=|^import\s+qualified\s`)},
+	{"scala", regexp.MustCompile(`\bdef\s+main\(args:\s*Array\[String\]\)|(?m)^\s*object\s+\w+\s+extends\s+App\b|@main\s+def\b`)},
+	{"dart", regexp.MustCompile(`\bvoid\s+main\(\)`)},
+	{"clojure", regexp.MustCompile(`(?m)^\s*\((ns|defn|println)\s`)},
+	{"fsharp", regexp.MustCompile(`\bprintfn\b`)},
+	{"ocaml", regexp.MustCompile(`\bprint_endline\b|\bPrintf\.printf\b|(?m)^let\s+\(\)\s*=`)},
+	{"asm", regexp.MustCompile(`(?m)^\s*(section\s+\.text|global\s+_start)`)},
+	{"typescript", regexp.MustCompile(`\b(let|const|var)\s+\w+\s*:\s*(string|number|boolean)\b|(?m)^\s*interface\s+\w+\s*\{`)},
+	{"javascript", regexp.MustCompile(`\bconsole\.log\(|\brequire\(['"]`)},
+	{"perl", regexp.MustCompile(`(?m)^\s*use\s+(strict|warnings)\s*;|\bmy\s+[$@%]\w+`)},
+	{"r", regexp.MustCompile(`\w+\s*<-\s*|\bcat\(`)},
+	{"julia", regexp.MustCompile(`\bprintln\(`)},
+	{"lua", regexp.MustCompile(`\blocal\s+\w+\s*=|\bfunction\b[^\n]*\n(?s:.*)\bend\b`)},
+}
+
+// detectLanguage returns the language of code from filename, its shebang or
+// its content, or "" if it can't tell. Only registered languages are detected.
+func detectLanguage(filename string, code string) string {
+	if filename != "" {
+		ext := strings.ToLower(path.Ext(filename))
+		for _, name := range supportedLanguages() {
+			if strings.ToLower(path.Ext(languages[name].SourceFile)) == ext {
+				return name
+			}
+		}
+		if name, ok := extensionLanguages[ext]; ok && languages[name] != nil {
+			return name
+		}
+	}
+
+	if shebang, ok := strings.CutPrefix(code, "#!"); ok {
+		line, _, _ := strings.Cut(shebang, "\n")
+		fields := strings.Fields(line)
+		// #!/usr/bin/env interpreter names the interpreter as its argument
+		if len(fields) > 1 && path.Base(fields[0]) == "env" {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 {
+			if name, ok := interpreterLanguages[path.Base(fields[0])]; ok && languages[name] != nil {
+				return name
+			}
+		}
+	}
+
+	for _, heuristic := range contentHeuristics {
+		if languages[heuristic.language] != nil && heuristic.pattern.MatchString(code) {
+			return heuristic.language
+		}
+	}
+	return ""
+}
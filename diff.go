@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// diffContextLines is the number of lines shown on each side of a mismatch
+const diffContextLines = 2
+
+// endOfOutput stands in for the lines missing from the shorter output
+const endOfOutput = "(end of output)"
+
+// OutputDiff locates the first difference between an expected and an actual
+// output, once both have been normalized by normalizeOutput
+type OutputDiff struct {
+	// Line and Column (both 1-based) are where the outputs first differ
+	Line   int `json:"line"`
+	Column int `json:"column"`
+
+	// Expected and Actual are the lines around the mismatch, starting at line
+	// FromLine, with spaces shown as "·" and tabs as "→"
+	FromLine int      `json:"fromLine"`
+	Expected []string `json:"expected"`
+	Actual   []string `json:"actual"`
+}
+
+// diffOutputs returns where expected and actual first differ, or nil if they match
+func diffOutputs(expected string, actual string) *OutputDiff {
+	expectedLines := strings.Split(normalizeOutput(expected), "\n")
+	actualLines := strings.Split(normalizeOutput(actual), "\n")
+
+	// Step 1: Find the first line that differs
+	line := 0
+	for line < len(expectedLines) && line < len(actualLines) && expectedLines[line] == actualLines[line] {
+		line++
+	}
+	if line == len(expectedLines) && line == len(actualLines) {
+		return nil
+	}
+
+	// Step 2: Find the first character that differs on that line
+	column := 0
+	if line < len(expectedLines) && line < len(actualLines) {
+		column = diffColumn(expectedLines[line], actualLines[line])
+	}
+
+	// Step 3: Cut out the lines around it
+	from := max(line-diffContextLines, 0)
+	to := line + diffContextLines + 1
+
+	return &OutputDiff{
+		Line:     line + 1,
+		Column:   column + 1,
+		FromLine: from + 1,
+		Expected: excerpt(expectedLines, from, to),
+		Actual:   excerpt(actualLines, from, to),
+	}
+}
+
+// diffColumn returns the 0-based column of the first character where the
+// lines want and got differ
+func diffColumn(want string, got string) int {
+	i := 0
+	for i < len(want) && i < len(got) && want[i] == got[i] {
+		i++
+	}
+	return utf8.RuneCountInString(want[:i])
+}
+
+// summary describes the mismatch in one line
+func (d *OutputDiff) summary() string {
+	i := d.Line - d.FromLine
+	return fmt.Sprintf("line %d differs: expected %q, got %q", d.Line, d.Expected[i], d.Actual[i])
+}
+
+// excerpt returns lines[from:to] with whitespace made visible, marking the
+// end of the output if it comes before to
+func excerpt(lines []string, from int, to int) []string {
+	var result []string
+	for i := from; i < to; i++ {
+		if i >= len(lines) {
+			result = append(result, endOfOutput)
+			break
+		}
+		result = append(result, visualizeWhitespace(lines[i]))
+	}
+	return result
+}
+
+// visualizeWhitespace replaces spaces and tabs with visible characters
+func visualizeWhitespace(line string) string {
+	return strings.NewReplacer(" ", "·", "\t", "→").Replace(line)
+}
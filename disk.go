@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The disk monitor tracks the space and inodes of the workspace disk, exposed
+// on /metrics. Once either is used past config.DiskPressurePercent it logs a
+// warning and collects garbage: the workspaces left behind by failed cleanups
+// and half of the compile cache. The watchdog turns executions away only once
+// the disk is nearly full, which this tries to prevent.
+
+const (
+	// diskGCInterval is how often garbage is collected while the disk is under pressure
+	diskGCInterval = time.Minute
+
+	// staleWorkspaceAge is how old a workspace must be to be collected, well
+	// past the request deadline so no running execution loses its workspace
+	staleWorkspaceAge = time.Hour
+)
+
+// DiskUsage is the space and inodes of a disk. Inodes are 0 where the file
+// system has no limit on them.
+type DiskUsage struct {
+	TotalBytes  int64 `json:"totalBytes"`
+	FreeBytes   int64 `json:"freeBytes"`
+	TotalInodes int64 `json:"totalInodes"`
+	FreeInodes  int64 `json:"freeInodes"`
+}
+
+// usedPercent returns the share of the space or of the inodes used, whichever is larger
+func (u DiskUsage) usedPercent() float64 {
+	var used float64
+	if u.TotalBytes > 0 {
+		used = float64(u.TotalBytes-u.FreeBytes) / float64(u.TotalBytes) * 100
+	}
+	if u.TotalInodes > 0 {
+		used = max(used, float64(u.TotalInodes-u.FreeInodes)/float64(u.TotalInodes)*100)
+	}
+	return used
+}
+
+// diskMonitor holds the latest usage of the workspace disk and the garbage
+// collected from it
+type diskMonitor struct {
+	mu         sync.Mutex
+	usage      DiskUsage
+	pressure   bool
+	lastGC     time.Time
+	gcRuns     int64
+	gcRemoved  int64
+	checkError bool
+}
+
+// disk monitors the disk holding the workspaces
+var disk = &diskMonitor{}
+
+// check reads the usage of the workspace disk, collecting garbage if it is
+// under pressure
+func (m *diskMonitor) check() {
+	usage, err := diskUsage(workspaceRoot)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		if !m.checkError {
+			log.Printf("Warning: failed to read the usage of %s: %s", workspaceRoot, err)
+		}
+		m.checkError = true
+		return
+	}
+	m.checkError = false
+	m.usage = usage
+
+	pressure := config.DiskPressurePercent > 0 && usage.usedPercent() >= float64(config.DiskPressurePercent)
+	if pressure != m.pressure {
+		if pressure {
+			log.Printf("Warning: %.1f%% of the disk holding %s is used (%d bytes and %d inodes free), collecting garbage",
+				usage.usedPercent(), workspaceRoot, usage.FreeBytes, usage.FreeInodes)
+		} else {
+			log.Printf("Disk usage of %s is back under %d%%", workspaceRoot, config.DiskPressurePercent)
+		}
+	}
+	m.pressure = pressure
+
+	if pressure && time.Since(m.lastGC) >= diskGCInterval {
+		m.lastGC = time.Now()
+		m.gcRuns++
+		m.gcRemoved += collectDiskGarbage()
+	}
+}
+
+// snapshot returns the latest usage, whether the disk is under pressure and
+// how often garbage was collected and how many workspaces and cache entries
+// that removed
+func (m *diskMonitor) snapshot() (DiskUsage, bool, int64, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.usage, m.pressure, m.gcRuns, m.gcRemoved
+}
+
+// collectDiskGarbage removes the stale workspaces and halves the compile
+// cache, returning how many entries it removed
+func collectDiskGarbage() int64 {
+	var removed int64
+
+	entries, err := os.ReadDir(workspaceRoot)
+	if err != nil {
+		log.Printf("Warning: failed to list workspaces: %s", err)
+	}
+	for _, entry := range entries {
+		// Workspaces are named by UUID, unlike the agent's own directories
+		if _, err := uuid.Parse(entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < staleWorkspaceAge {
+			continue
+		}
+
+		path := filepath.Join(workspaceRoot, entry.Name())
+		err = os.RemoveAll(path)
+		if err != nil {
+			log.Printf("Warning: failed to remove stale workspace %s: %s", path, err)
+			continue
+		}
+		removed++
+	}
+
+	before := countEntries(config.CompileCacheDir)
+	err = evictCompilations(config.CompileCacheDir, config.CompileCacheBytes/2)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to evict compilations: %s", err)
+	}
+	removed += max(before-countEntries(config.CompileCacheDir), 0)
+
+	log.Printf("Collected %d stale workspaces and compile cache entries", removed)
+	return removed
+}
+
+// countEntries returns the number of entries in dir, 0 if it can't be read
+func countEntries(dir string) int64 {
+	entries, _ := os.ReadDir(dir)
+	return int64(len(entries))
+}
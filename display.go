@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Programs can display rich output, like a notebook cell would, by writing
+// files into the directory named by $OCTREE_DISPLAY_DIR: images (e.g. a
+// matplotlib figure saved as PNG), HTML fragments, JSON, Markdown or plain
+// text. They are returned in file name order as typed output items alongside
+// the program's stdout.
+
+// displayDir is the directory of the workspace display outputs are written to
+const displayDir = ".display"
+
+// displayContentTypes maps the extensions of display files to their content type
+var displayContentTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".svg":  "image/svg+xml",
+	".html": "text/html",
+	".json": "application/json",
+	".md":   "text/markdown",
+	".txt":  "text/plain",
+}
+
+// OutputItem is a rich output displayed by the program. Text outputs (HTML,
+// JSON, Markdown, text and SVG) are returned as Text, others as Data, which is
+// base64 encoded in JSON.
+type OutputItem struct {
+	// Type is "image", "html", "json", "markdown" or "text"
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Text        string `json:"text,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+// prepareDisplay creates the display directory of the job and passes it to the program
+func prepareDisplay(job *ExecJob) {
+	dir := filepath.Join(job.Dir, displayDir)
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		log.Printf("Warning: failed to create display directory %s: %s", dir, err)
+		return
+	}
+	job.Env = append(job.Env, "OCTREE_DISPLAY_DIR="+dir)
+}
+
+// displayOutputs returns the outputs the program displayed
+func displayOutputs(job *ExecJob) []OutputItem {
+	artifacts, err := collectArtifacts(job, displayDir, displayContentTypes)
+	if err != nil {
+		log.Printf("Warning: failed to collect display outputs: %s", err)
+	}
+
+	var outputs []OutputItem
+	for _, artifact := range artifacts {
+		item := OutputItem{Name: artifact.Name, ContentType: artifact.ContentType}
+
+		switch {
+		case strings.HasPrefix(artifact.ContentType, "image/"):
+			item.Type = "image"
+		case artifact.ContentType == "text/html":
+			item.Type = "html"
+		case artifact.ContentType == "application/json":
+			item.Type = "json"
+		case artifact.ContentType == "text/markdown":
+			item.Type = "markdown"
+		default:
+			item.Type = "text"
+		}
+
+		if strings.HasPrefix(artifact.ContentType, "text/") || artifact.ContentType == "application/json" || artifact.ContentType == "image/svg+xml" {
+			item.Text = string(artifact.Data)
+		} else {
+			item.Data = artifact.Data
+		}
+
+		outputs = append(outputs, item)
+	}
+	return outputs
+}
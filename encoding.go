@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode/utf8"
+)
+
+// Programs may print raw bytes that aren't valid UTF-8, which JSON can't
+// carry: the encoder would replace them. Such output is returned base64
+// encoded instead, with its encoding field set to "base64".
+
+// encodingBase64 marks output returned base64 encoded
+const encodingBase64 = "base64"
+
+// isBinaryOutput reports whether s can't be returned as JSON text as is
+func isBinaryOutput(s string) bool {
+	return !utf8.ValidString(s) || strings.IndexByte(s, 0) >= 0
+}
+
+// encodeOutput returns s as it should be returned, along with its encoding,
+// which is empty for text
+func encodeOutput(s string) (string, string) {
+	if !isBinaryOutput(s) {
+		return s, ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(s)), encodingBase64
+}
+
+// encodeOutputs encodes the binary outputs of the result and of its phases
+func (r *ExecResult) encodeOutputs() {
+	if isBinaryOutput(r.Stdout) || isBinaryOutput(r.StdoutPlain) {
+		r.Stdout = base64.StdEncoding.EncodeToString([]byte(r.Stdout))
+		if r.StdoutPlain != "" {
+			r.StdoutPlain = base64.StdEncoding.EncodeToString([]byte(r.StdoutPlain))
+		}
+		r.StdoutEncoding = encodingBase64
+	}
+	r.Stderr, r.StderrEncoding = encodeOutput(r.Stderr)
+
+	for i := range r.Phases {
+		p := &r.Phases[i]
+		p.Stdout, p.StdoutEncoding = encodeOutput(p.Stdout)
+		p.Stderr, p.StderrEncoding = encodeOutput(p.Stderr)
+	}
+}
+
+// encodeOutputs encodes the binary outputs of the test case
+func (c *TestCaseResult) encodeOutputs() {
+	c.Stdout, c.StdoutEncoding = encodeOutput(c.Stdout)
+	c.Stderr, c.StderrEncoding = encodeOutput(c.Stderr)
+}
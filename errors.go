@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"syscall"
+)
+
+// ErrorCode is a stable, machine-readable identifier for a class of failure.
+// Clients should switch on the code rather than on the message text.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest      ErrorCode = "INVALID_REQUEST"
+	CodeForbidden           ErrorCode = "FORBIDDEN"
+	CodeUnsupportedLanguage ErrorCode = "UNSUPPORTED_LANGUAGE"
+	CodeCompileError        ErrorCode = "COMPILE_ERROR"
+	CodeTimeout             ErrorCode = "TIMEOUT"
+	CodeMemoryLimit         ErrorCode = "MEMORY_LIMIT"
+	CodeCancelled           ErrorCode = "CANCELLED"
+	CodeOverloaded          ErrorCode = "OVERLOADED"
+	CodeSourceUnavailable   ErrorCode = "SOURCE_UNAVAILABLE"
+	CodeServiceUnavailable  ErrorCode = "SERVICE_UNAVAILABLE"
+	CodeUnsupportedFeature  ErrorCode = "UNSUPPORTED_FEATURE"
+	CodePreempted           ErrorCode = "PREEMPTED"
+	CodeRateLimited         ErrorCode = "RATE_LIMITED"
+	CodeInfrastructure      ErrorCode = "INFRASTRUCTURE"
+	CodeInternal            ErrorCode = "INTERNAL"
+)
+
+// Sentinel errors returned (wrapped) by the language runners so that the
+// handlers can classify a failure without looking at its text.
+var (
+	errExecutionTimeout   = errors.New("execution timed out")
+	errCompilation        = errors.New("compilation failed")
+	errMemoryLimit        = errors.New("memory limit exceeded")
+	errCancelled          = errors.New("execution cancelled")
+	errSourceUnavailable  = errors.New("source unavailable")
+	errServiceUnavailable = errors.New("service unavailable")
+
+	// errInfrastructure marks failures of the agent rather than of the
+	// submitted code, such as a workspace that couldn't be copied
+	errInfrastructure = errors.New("infrastructure failure")
+
+	// errCPUTimeLimit is the kind of timeout where the program used up its CPU time
+	errCPUTimeLimit = fmt.Errorf("%w: CPU time limit exceeded", errExecutionTimeout)
+)
+
+// programExitError is returned when a command runs to completion with a non-zero exit code
+type programExitError struct {
+	name string
+	code int
+}
+
+func (e *programExitError) Error() string {
+	return fmt.Sprintf("%s exited with code %d", e.name, e.code)
+}
+
+// APIError is the body of every error returned by the agent.
+type APIError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Details any       `json:"details,omitempty"`
+}
+
+type errorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// writeError writes an error response of the form {"error": {code, message, details}}
+func writeError(w http.ResponseWriter, status int, code ErrorCode, message string, details any) {
+	jsonResponse, _ := json.Marshal(errorResponse{
+		Error: APIError{Code: code, Message: message, Details: details},
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
+
+// classifyExecutionError maps an error returned by a language runner to the
+// HTTP status and error code reported to the client.
+func classifyExecutionError(err error) (int, ErrorCode) {
+	switch {
+	case isInfraFailure(err):
+		return http.StatusInternalServerError, CodeInfrastructure
+	case errors.Is(err, errCompilation):
+		return http.StatusUnprocessableEntity, CodeCompileError
+	case errors.Is(err, errExecutionTimeout):
+		return http.StatusRequestTimeout, CodeTimeout
+	case errors.Is(err, errMemoryLimit):
+		return http.StatusUnprocessableEntity, CodeMemoryLimit
+	case errors.Is(err, errCancelled):
+		return http.StatusConflict, CodeCancelled
+	case errors.Is(err, errSourceUnavailable):
+		return http.StatusUnprocessableEntity, CodeSourceUnavailable
+	case errors.Is(err, errServiceUnavailable):
+		return http.StatusServiceUnavailable, CodeServiceUnavailable
+	default:
+		return http.StatusInternalServerError, CodeInternal
+	}
+}
+
+// isInfraFailure reports whether err is a failure of the agent rather than of
+// the submitted code: one marked as such, a missing toolchain or a full disk
+func isInfraFailure(err error) bool {
+	return errors.Is(err, errInfrastructure) || errors.Is(err, exec.ErrNotFound) || errors.Is(err, syscall.ENOSPC)
+}
+
+// executionErrorMessage is the message of the error response for an
+// execution that failed with err, telling failures of the agent apart
+func executionErrorMessage(err error) string {
+	if isInfraFailure(err) {
+		return fmt.Sprintf("Agent error, not caused by the code: %s", err)
+	}
+	return fmt.Sprintf("Execution error: %s", err)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Executions publish their lifecycle on an event bus: accepted once they
+// are queued, started once they run, phase as they move on to compiling and
+// running (see progress.go), output as their program writes, and finished.
+// Cross-cutting features observe the bus rather than being wired into each
+// handler: the journal, the metrics, the audit log (OCTREE_AUDIT_LOG),
+// webhooks (OCTREE_EVENT_WEBHOOKS) and operators watching GET /events over
+// a WebSocket.
+//
+// Observers are called synchronously, in the order they subscribed, so they
+// must not block: the ones doing I/O over the network queue the events and
+// drop them when they fall behind.
+
+// Types of execution events
+const (
+	EventAccepted = "accepted"
+	EventStarted  = "started"
+	EventPhase    = "phase"
+	EventOutput   = "output"
+	EventFinished = "finished"
+)
+
+// eventQueueSize bounds the events queued for a slow observer before they are dropped
+const eventQueueSize = 256
+
+// webhookTimeout bounds the delivery of an event to a webhook
+const webhookTimeout = 5 * time.Second
+
+// ExecutionEvent is a step in the lifecycle of an execution
+type ExecutionEvent struct {
+	Type        string    `json:"type"`
+	ExecutionID string    `json:"executionId"`
+	Kind        string    `json:"kind,omitempty"`
+	Language    string    `json:"language,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Time        time.Time `json:"time"`
+
+	// Phase ("compiling" or "running") is set for phase events
+	Phase string `json:"phase,omitempty"`
+
+	// Stream ("stdout" or "stderr") and Data are set for output events
+	Stream string `json:"stream,omitempty"`
+	Data   string `json:"data,omitempty"`
+
+	// DurationMs and Cancelled are set for finished events
+	DurationMs int64 `json:"durationMs,omitempty"`
+	Cancelled  bool  `json:"cancelled,omitempty"`
+}
+
+// eventObserver is called with every event published
+type eventObserver func(ExecutionEvent)
+
+// subscription is an observer of the event bus
+type subscription struct {
+	id       int
+	observer eventObserver
+}
+
+// eventBus delivers the events of the executions to the observers
+type eventBus struct {
+	mu            sync.RWMutex
+	subscriptions []subscription
+	next          int
+}
+
+// events is the event bus of this agent
+var events = &eventBus{}
+
+// subscribe adds observer, returning the function that removes it
+func (b *eventBus) subscribe(observer eventObserver) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.next
+	b.next++
+	b.subscriptions = append(b.subscriptions, subscription{id, observer})
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		// Publishers may be going through the old slice
+		b.subscriptions = slices.DeleteFunc(slices.Clone(b.subscriptions), func(s subscription) bool { return s.id == id })
+	}
+}
+
+// publish delivers event to the observers, in the order they subscribed
+func (b *eventBus) publish(event ExecutionEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	b.mu.RLock()
+	subscriptions := b.subscriptions
+	b.mu.RUnlock()
+
+	for _, s := range subscriptions {
+		s.observer(event)
+	}
+}
+
+// eventWriter publishes what is written to it as output events of the
+// execution running under ctx
+type eventWriter struct {
+	ctx    context.Context
+	stream string
+}
+
+func (w eventWriter) Write(p []byte) (int, error) {
+	events.publish(ExecutionEvent{Type: EventOutput, ExecutionID: executionID(w.ctx), Stream: w.stream, Data: string(p)})
+	return len(p), nil
+}
+
+// publishedWriter returns a writer passing what is written to it on to w, if
+// set, and publishing it as output events of the execution running under
+// ctx, if any
+func publishedWriter(ctx context.Context, stream string, w io.Writer) io.Writer {
+	if executionID(ctx) == "" {
+		return w
+	}
+	if w == nil {
+		return eventWriter{ctx, stream}
+	}
+	return io.MultiWriter(w, eventWriter{ctx, stream})
+}
+
+// eventMetrics counts the events published
+var eventMetrics struct {
+	accepted, started, finished, cancelled, outputBytes atomic.Int64
+}
+
+// countEvent is the observer counting the events for the metrics
+func countEvent(event ExecutionEvent) {
+	switch event.Type {
+	case EventAccepted:
+		eventMetrics.accepted.Add(1)
+	case EventStarted:
+		eventMetrics.started.Add(1)
+	case EventOutput:
+		eventMetrics.outputBytes.Add(int64(len(event.Data)))
+	case EventFinished:
+		eventMetrics.finished.Add(1)
+		if event.Cancelled {
+			eventMetrics.cancelled.Add(1)
+		}
+	}
+}
+
+// startEventObservers subscribes the built-in observers
+func startEventObservers() {
+	events.subscribe(journalEvent)
+	events.subscribe(countEvent)
+	events.subscribe(progress.observe)
+
+	if config.AuditLog != "" {
+		file, err := os.OpenFile(config.AuditLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			log.Printf("Warning: failed to open audit log %s: %s", config.AuditLog, err)
+		} else {
+			events.subscribe(auditEvent(file))
+		}
+	}
+
+	for _, url := range config.EventWebhooks {
+		events.subscribe(queueEvents(func(event ExecutionEvent) { deliverWebhook(url, event) }))
+	}
+}
+
+// auditEvent returns the observer appending the lifecycle of the executions,
+// without their output, to the audit log as JSON lines
+func auditEvent(file *os.File) eventObserver {
+	var mu sync.Mutex
+	return func(event ExecutionEvent) {
+		if event.Type == EventOutput {
+			return
+		}
+
+		line, _ := json.Marshal(event)
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := file.Write(append(line, '\n'))
+		if err != nil {
+			log.Printf("Warning: failed to write audit log: %s", err)
+		}
+	}
+}
+
+// queueEvents returns an observer handing the events other than output to
+// deliver in the background, dropping them when it falls behind
+func queueEvents(deliver func(ExecutionEvent)) eventObserver {
+	queued := make(chan ExecutionEvent, eventQueueSize)
+	go func() {
+		for event := range queued {
+			deliver(event)
+		}
+	}()
+
+	return func(event ExecutionEvent) {
+		if event.Type == EventOutput {
+			return
+		}
+		select {
+		case queued <- event:
+		default:
+			log.Printf("Warning: dropping %s event of execution %s, the observer fell behind", event.Type, event.ExecutionID)
+		}
+	}
+}
+
+// deliverWebhook posts event to the webhook at url
+func deliverWebhook(url string, event ExecutionEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(event)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Warning: invalid event webhook %s: %s", url, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Warning: failed to deliver %s event to %s: %s", event.Type, url, err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		log.Printf("Warning: event webhook %s answered %s", url, res.Status)
+	}
+}
+
+// eventsHandler streams the events to an operator over a WebSocket, as JSON
+// text messages. ?types= restricts them to some types, e.g.
+// "accepted,finished"; output events are only sent when asked for.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	types := []string{EventAccepted, EventStarted, EventFinished}
+	if param := r.URL.Query().Get("types"); param != "" {
+		types = strings.Split(param, ",")
+	}
+
+	ws := upgradeWebSocket(w, r)
+	if ws == nil {
+		return
+	}
+	defer ws.close()
+
+	queued := make(chan ExecutionEvent, eventQueueSize)
+	unsubscribe := events.subscribe(func(event ExecutionEvent) {
+		if !slices.Contains(types, event.Type) {
+			return
+		}
+		select {
+		case queued <- event:
+		default:
+			// A watcher too slow to keep up misses events
+		}
+	})
+	defer unsubscribe()
+
+	// The watcher only ever closes the connection
+	closed := make(chan struct{})
+	go func() {
+		for {
+			if _, _, err := ws.read(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event := <-queued:
+			message, _ := json.Marshal(event)
+			if ws.write(wsText, message) != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
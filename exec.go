@@ -0,0 +1,690 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// workspaceRoot is where the per-execution workspaces are created (OCTREE_WORKSPACE_ROOT)
+var workspaceRoot = envString("OCTREE_WORKSPACE_ROOT", defaultWorkspaceRoot())
+
+// defaultTimeout is applied to any command that doesn't specify its own
+const defaultTimeout = 30 * time.Second
+
+// waitDelay bounds how long we wait for a killed command's output pipes to
+// close, in case it left children behind that still hold them open
+const waitDelay = time.Second
+
+// ExecJob describes a single execution of submitted code
+type ExecJob struct {
+	Request *CodeExecRequest
+
+	// Dir is the workspace directory the code runs in
+	Dir string
+
+	// SourcePath is the path of the file the submitted code was written to
+	SourcePath string
+
+	// Template is the template directory the workspace was copied from, if any
+	Template string
+
+	// Stdin is fed to the program
+	Stdin string
+
+	// Input and Output, if set, connect the program to another process for
+	// interactive runs, or to test data streamed from a URL: Input replaces
+	// Stdin and Output receives the program's stdout as it is written
+	Input  io.Reader
+	Output io.Writer
+
+	// CaptureLimit, if set, bounds the program's stdout and stderr kept in
+	// the result, for outputs only checked through Output
+	CaptureLimit int
+
+	// Started, if set, is called once the program's process has started
+	Started func(*os.Process)
+
+	// Combined, if set, records the program's output interleaved
+	Combined *combinedOutput
+
+	// TimeLimit (wall-clock), CPUTimeLimit and MemoryLimit (in bytes), if
+	// set, bound the program but not its compilation
+	TimeLimit    time.Duration
+	CPUTimeLimit time.Duration
+	MemoryLimit  int64
+
+	// Usage is recorded by runProgram once the program has run
+	Usage *ProgramUsage
+
+	// Env is added to the environment of the program and the hooks, and
+	// Services are the services acquired for it
+	Env      []string
+	Services []*serviceLease
+}
+
+// ProgramUsage is the resources used by the program step of a job
+type ProgramUsage struct {
+	TimeMs    int64 `json:"timeMs"`
+	CPUTimeMs int64 `json:"cpuTimeMs,omitempty"`
+	MemoryKB  int64 `json:"memoryKb"`
+
+	// Timeline is set if the request asked for its usage to be sampled
+	Timeline *UsageTimeline `json:"timeline,omitempty"`
+}
+
+// ExecResult is the outcome of running a job
+type ExecResult struct {
+	Stdout      string       `json:"stdout"`
+	Stderr      string       `json:"stderr"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+
+	// StdoutPlain is stdout without its ANSI escape codes, for programs run under a pseudo-terminal
+	StdoutPlain string `json:"stdoutPlain,omitempty"`
+
+	// StdoutEncoding (which also applies to StdoutPlain) and StderrEncoding
+	// are "base64" if the output isn't text and is returned base64 encoded
+	StdoutEncoding string `json:"stdoutEncoding,omitempty"`
+	StderrEncoding string `json:"stderrEncoding,omitempty"`
+
+	// Phases is set by runners that compile and run in separate steps
+	Phases []PhaseResult `json:"phases,omitempty"`
+
+	// Artifacts are files produced by the program, such as plots
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+
+	// Outputs are the rich outputs the program displayed
+	Outputs []OutputItem `json:"outputs,omitempty"`
+
+	// Timing is set by runners that can tell compilation time apart from the total
+	Timing *ExecTiming `json:"timing,omitempty"`
+
+	// Timeline is the program's sampled usage, if requested
+	Timeline *UsageTimeline `json:"timeline,omitempty"`
+
+	// Profile summarizes the profile of runs in "profile" mode; the full
+	// profile is returned as an artifact
+	Profile *ProfileSummary `json:"profile,omitempty"`
+
+	// Probes are the server's responses to the probes of a request in server mode
+	Probes []ProbeResult `json:"probes,omitempty"`
+
+	// SnapshotID identifies the snapshot of the workspace taken after the run, if requested
+	SnapshotID string `json:"snapshotId,omitempty"`
+
+	// Metrics are the static metrics of the code, if requested
+	Metrics *CodeMetrics `json:"metrics,omitempty"`
+
+	// Findings are the issues found by a scan, in scan mode
+	Findings []ScanFinding `json:"findings,omitempty"`
+
+	// Combined is the program's output interleaved, if requested
+	Combined []OutputChunk `json:"combined,omitempty"`
+
+	// Failure summarizes the error the execution failed with, if it can be told
+	Failure *FailureSummary `json:"failure,omitempty"`
+
+	// Retried is set if the execution was retried on a fresh workspace after
+	// failing because of the agent
+	Retried bool `json:"retried,omitempty"`
+}
+
+// ExecTiming splits the time spent running a program into JIT/compile time and wall time
+type ExecTiming struct {
+	CompileMs int64 `json:"compileMs"`
+	WallMs    int64 `json:"wallMs"`
+}
+
+// Artifact is a file produced by an execution. Data is base64 encoded in JSON.
+type Artifact struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"`
+}
+
+// PhaseResult is the outcome of one step (e.g. compile or run) of a job
+type PhaseResult struct {
+	Name     string `json:"name"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+	TimeMs   int64  `json:"timeMs"`
+	Cached   bool   `json:"cached,omitempty"`
+
+	// StdoutEncoding and StderrEncoding are "base64" for binary output
+	StdoutEncoding string `json:"stdoutEncoding,omitempty"`
+	StderrEncoding string `json:"stderrEncoding,omitempty"`
+}
+
+// newPhaseResult records the output of a command as the named phase
+func newPhaseResult(name string, res *commandResult) PhaseResult {
+	return PhaseResult{
+		Name:     name,
+		Stdout:   res.Stdout,
+		Stderr:   res.Stderr,
+		ExitCode: res.ExitCode,
+		TimeMs:   res.Duration.Milliseconds(),
+		Cached:   res.Cached,
+	}
+}
+
+// Diagnostic is a single compiler or interpreter message tied to a source location
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// command is an external process to be run by runCommand
+type command struct {
+	Name    string
+	Args    []string
+	Dir     string
+	Env     []string
+	Stdin   io.Reader
+	Timeout time.Duration
+
+	// MemoryLimit, if set, kills the command once its resident memory exceeds this many bytes
+	MemoryLimit int64
+
+	// CPULimit, if set, kills the command once it has used this much CPU time
+	CPULimit time.Duration
+
+	// Stdout and Stderr, if set, also receive the command's output as it is written
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Started, if set, is called once the command's process has started
+	Started func(*os.Process)
+
+	// SampleUsage records a usage timeline of the command
+	SampleUsage bool
+
+	// PTY runs the command under a pseudo-terminal; its stderr is then part of its stdout
+	PTY bool
+
+	// CaptureLimit, if set, bounds the stdout and stderr captured in the
+	// result; Stdout and Stderr still receive all of it
+	CaptureLimit int
+
+	// DiscardStdout leaves stdout out of the result, for commands whose
+	// stdout is only consumed through Stdout
+	DiscardStdout bool
+}
+
+// cappedBuffer keeps what is written to it up to limit bytes, if set,
+// dropping the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 {
+		b.Buffer.Write(p[:min(len(p), max(b.limit-b.Len(), 0))])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// commandResult holds the captured output of a finished command
+type commandResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	CPUTime  time.Duration
+	MaxRSSKB int64
+	Timeline *UsageTimeline
+
+	// Cached is set when a compilation was restored from the compile cache
+	Cached bool
+}
+
+// runCommand runs c to completion, capturing its output. A non-zero exit code
+// is returned as an error alongside the captured output.
+func runCommand(ctx context.Context, c command) (*commandResult, error) {
+	timeout := c.timeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	name, args := limitedCommand(c)
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = c.Dir
+	cmd.WaitDelay = waitDelay
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+
+	stdoutBuf := &cappedBuffer{limit: c.CaptureLimit}
+	stderrBuf := &cappedBuffer{limit: c.CaptureLimit}
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = stdoutBuf
+	cmd.Stderr = stderrBuf
+	if c.Stdout != nil {
+		cmd.Stdout = io.MultiWriter(stdoutBuf, c.Stdout)
+	}
+	if c.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderrBuf, c.Stderr)
+	}
+	if c.DiscardStdout {
+		cmd.Stdout = c.Stdout
+	}
+
+	// Under a pseudo-terminal, the output is read from the terminal instead
+	var pty *ptySession
+	stdout := cmd.Stdout
+	if c.PTY {
+		var err error
+		pty, err = attachPTY(cmd)
+		if err != nil {
+			return &commandResult{}, fmt.Errorf("%w: %w", errInfrastructure, err)
+		}
+	}
+
+	tree := newCommandTree(cmd)
+	defer tree.close()
+	tree.limit(c.MemoryLimit, c.CPULimit)
+
+	injectDelay(ctx, faults.delayedStart)
+	start := time.Now()
+
+	err := cmd.Start()
+	if err != nil {
+		if pty != nil {
+			pty.close()
+		}
+		// A command whose context ended before it started isn't the agent's failure
+		if ctx.Err() != nil {
+			return &commandResult{}, fmt.Errorf("failed to run %s: %w", c.Name, err)
+		}
+		return &commandResult{}, fmt.Errorf("%w: failed to run %s: %w", errInfrastructure, c.Name, err)
+	}
+	tree.attach(cmd.Process)
+	if pty != nil {
+		pty.start(c.Stdin, stdout)
+	}
+
+	if c.Started != nil {
+		c.Started(cmd.Process)
+	}
+
+	var watch *processWatch
+	if c.MemoryLimit > 0 || c.CPULimit > 0 || c.SampleUsage {
+		watch = watchProcess(cmd.Process, c.MemoryLimit, c.CPULimit, c.SampleUsage)
+	}
+
+	err = cmd.Wait()
+	if watch != nil {
+		watch.stop()
+	}
+	if pty != nil {
+		pty.close()
+	}
+
+	treeKB, treeCPU, memoryCapped := tree.usage()
+	result := &commandResult{
+		Stdout:   stdoutBuf.String(),
+		Stderr:   stderrBuf.String(),
+		Duration: time.Since(start),
+		CPUTime:  max(cpuTime(cmd.ProcessState), treeCPU),
+		MaxRSSKB: max(maxRSSKB(cmd.ProcessState), treeKB),
+	}
+	if watch != nil {
+		result.Timeline = watch.timeline()
+	}
+	outputs.add(executionID(ctx), CapturedCommand{
+		Command:    c.Name,
+		Args:       c.Args,
+		StartedAt:  start,
+		DurationMs: result.Duration.Milliseconds(),
+		ExitCode:   cmd.ProcessState.ExitCode(),
+		Stdout:     result.Stdout,
+		Stderr:     result.Stderr,
+	})
+
+	// The CPU time limit is checked first, since a program that spins until
+	// it is killed may also run past its wall-clock limit
+	if c.CPULimit > 0 && (watch.cpuExceeded() || result.CPUTime > c.CPULimit) {
+		return result, fmt.Errorf("%w: %s used more than %s", errCPUTimeLimit, c.Name, c.CPULimit)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return result, fmt.Errorf("%w after %s", errExecutionTimeout, timeout)
+	}
+	if ctx.Err() == context.Canceled {
+		return result, fmt.Errorf("%s: %w", c.Name, errCancelled)
+	}
+
+	if c.MemoryLimit > 0 && (watch.exceeded() || memoryCapped || result.MaxRSSKB*1024 > c.MemoryLimit) {
+		return result, fmt.Errorf("%w: %s used more than %d bytes", errMemoryLimit, c.Name, c.MemoryLimit)
+	}
+
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+			return result, &programExitError{name: c.Name, code: result.ExitCode}
+		}
+		return result, fmt.Errorf("%w: failed to run %s: %w", errInfrastructure, c.Name, err)
+	}
+
+	return result, nil
+}
+
+// timeout returns how long c may run
+func (c command) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return defaultTimeout
+	}
+	return c.Timeout
+}
+
+// runProgram runs the step of a job that executes the submitted program,
+// applying the job's stdin, streams and limits and recording its resource usage
+func runProgram(ctx context.Context, job *ExecJob, c command) (*commandResult, error) {
+	progress.advance(ctx, ProgressRunning)
+	c.Stdin = strings.NewReader(job.Stdin)
+	if job.Input != nil {
+		c.Stdin = job.Input
+	}
+	c.Stdout = job.Output
+	if job.Combined != nil {
+		c.Stdout = job.Combined.writer("stdout")
+		if job.Output != nil {
+			c.Stdout = io.MultiWriter(job.Output, c.Stdout)
+		}
+		c.Stderr = job.Combined.writer("stderr")
+	}
+	c.Stdout = publishedWriter(ctx, "stdout", c.Stdout)
+	c.Stderr = publishedWriter(ctx, "stderr", c.Stderr)
+	c.Started = job.Started
+	c.SampleUsage = job.Request != nil && job.Request.SampleUsage
+	c.Name, c.Args = stackCommand(job, c.Name, c.Args)
+	c.Name, c.Args = tracedCommand(job, c.Name, c.Args)
+	c.Name, c.Args = sandboxedCommand(job, c.Name, c.Args)
+	if job.TimeLimit > 0 {
+		c.Timeout = job.TimeLimit
+	} else if timeout := phaseTimeout(PhaseRun); timeout > 0 {
+		c.Timeout = timeout
+	}
+	c.MemoryLimit = job.MemoryLimit
+	c.CPULimit = job.CPUTimeLimit
+	c.CaptureLimit = job.CaptureLimit
+	c.Env = append(c.Env[:len(c.Env):len(c.Env)], job.Env...)
+	if job.Request != nil && job.Request.PTY {
+		c.PTY = true
+		c.Env = append(c.Env, "TERM=xterm-256color")
+	}
+
+	res, err := runCommand(ctx, c)
+	job.Usage = &ProgramUsage{TimeMs: res.Duration.Milliseconds(), CPUTimeMs: res.CPUTime.Milliseconds(), MemoryKB: res.MaxRSSKB, Timeline: res.Timeline}
+
+	return res, inPhase(PhaseRun, c.timeout(), err)
+}
+
+// runJob runs the job in the mode its request asks for, collecting the
+// outputs the program displayed
+func runJob(ctx context.Context, lang *Language, job *ExecJob) (*ExecResult, error) {
+	prepareDisplay(job)
+	if job.Request.CombinedOutput {
+		job.Combined = newCombinedOutput()
+	}
+
+	var result *ExecResult
+	var err error
+	switch job.Request.Mode {
+	case ModeServer:
+		result, err = runServer(ctx, lang, job)
+	case ModeScan:
+		result, err = runScan(ctx, job)
+	default:
+		result, err = lang.Run(ctx, job)
+	}
+
+	result.Outputs = displayOutputs(job)
+	if job.Combined != nil {
+		result.Combined = job.Combined.result()
+	}
+	if job.Request.PTY {
+		result.StdoutPlain = stripANSI(result.Stdout)
+	}
+	if err != nil {
+		result.Failure = summarizeFailure(lang, job, result, err)
+	}
+	result.encodeOutputs()
+	return result, err
+}
+
+// prepareAndRun prepares a workspace for req and runs the job in it. If either
+// fails because of the agent rather than the code, it is retried once on a
+// fresh workspace when config.RetryInfraFailures is set. The job is nil if
+// its workspace couldn't be prepared; otherwise the caller removes it.
+func prepareAndRun(ctx context.Context, lang *Language, req *CodeExecRequest) (*ExecJob, *ExecResult, error) {
+	retried := false
+	for {
+		job, err := prepareWorkspace(ctx, lang, req)
+		if err != nil && (retried || !config.RetryInfraFailures || !isInfraFailure(err)) {
+			return nil, nil, err
+		}
+
+		var result *ExecResult
+		if err == nil {
+			result, err = runJob(ctx, lang, job)
+			result.Retried = retried
+			if retried || !config.RetryInfraFailures || !isInfraFailure(err) || ctx.Err() != nil {
+				return job, result, err
+			}
+			removeWorkspace(job)
+		}
+
+		log.Printf("Warning: retrying execution %s on a fresh workspace: %s", executionID(ctx), err)
+		retried = true
+	}
+}
+
+// prepareWorkspace creates a fresh workspace for lang, copies in the language
+// template (if any), or restores the requested snapshot, writes the
+// submitted code into it, acquires the requested services and runs the
+// language's setup hooks, within the setup timeout. It stops early once ctx
+// is done, e.g. when the client disconnects.
+func prepareWorkspace(ctx context.Context, lang *Language, req *CodeExecRequest) (*ExecJob, error) {
+	ctx, cancel := withPhaseTimeout(ctx, PhaseSetup)
+	defer cancel()
+	injectDelay(ctx, faults.slowSetup)
+
+	// Step 1: Create a new folder with a random UUID
+	dir := filepath.Join(workspaceRoot, uuid.New().String())
+
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create folder %s: %w", errInfrastructure, dir, err)
+	}
+
+	// Step 2: Copy the language template into the new folder
+	template, ok := namedTemplate(lang, req.Template)
+	if !ok {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("template %q not found", req.Template)
+	}
+	if req.RestoreSnapshot != "" {
+		err = restoreSnapshot(req.RestoreSnapshot, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("failed to restore snapshot %s to %s: %w", req.RestoreSnapshot, dir, err)
+		}
+	} else if template != "" {
+		err = copyDirectory(template, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("%w: failed to copy template %s to %s: %w", errInfrastructure, template, dir, err)
+		}
+	}
+
+	if req.Git != nil {
+		err = cloneGitSource(ctx, req.Git, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+	if req.FilesURL != "" {
+		err = fetchFiles(req.FilesURL, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+
+	// Step 3: Write the submitted code, unless it comes from the repository
+	sourcePath := filepath.Join(dir, lang.SourceFile)
+
+	if req.Git != nil && req.Code == "" {
+		_, err = os.Stat(sourcePath)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("%w: repository has no %s", errSourceUnavailable, lang.SourceFile)
+		}
+	} else {
+		err = os.MkdirAll(filepath.Dir(sourcePath), os.ModePerm)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("%w: failed to create folder for %s: %w", errInfrastructure, lang.SourceFile, err)
+		}
+
+		err = os.WriteFile(sourcePath, []byte(req.Code), 0644)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("%w: unable to write file: %w", errInfrastructure, err)
+		}
+	}
+
+	// Step 4: Acquire the requested services and run the language's setup hooks
+	job := &ExecJob{Request: req, Dir: dir, SourcePath: sourcePath, Template: template, Env: localeEnv(req)}
+
+	err = acquireServices(job)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	err = runSetupHooks(ctx, job)
+	if err != nil {
+		releaseServices(job)
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// maxArtifactBytes bounds the total size of the artifacts returned for a job
+const maxArtifactBytes = 8 << 20
+
+// collectArtifacts reads the files directly inside the directory name of the
+// job's workspace whose extension is a key of contentTypes. Files past the
+// total size limit are skipped.
+func collectArtifacts(job *ExecJob, name string, contentTypes map[string]string) ([]Artifact, error) {
+	dir, err := confinedPath(job.Dir, name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read artifact directory %s: %w", dir, err)
+	}
+
+	var artifacts []Artifact
+	var total int64
+
+	for _, entry := range entries {
+		contentType, ok := contentTypes[strings.ToLower(filepath.Ext(entry.Name()))]
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if total+info.Size() > maxArtifactBytes {
+			log.Printf("Warning: skipping artifact %s, artifact size limit reached", entry.Name())
+			continue
+		}
+
+		// The program may have swapped the file for a link since it was listed
+		data, err := readWorkspaceFile(job.Dir, filepath.ToSlash(filepath.Join(name, entry.Name())))
+		if errors.Is(err, errPathEscapes) {
+			log.Printf("Warning: skipping artifact %s: %s", entry.Name(), err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read artifact %s: %w", entry.Name(), err)
+		}
+		total += int64(len(data))
+
+		artifacts = append(artifacts, Artifact{Name: entry.Name(), ContentType: contentType, Data: data})
+	}
+
+	return artifacts, nil
+}
+
+// copyDirectory copies the contents of srcDir to destDir
+func copyDirectory(srcDir string, destDir string) error {
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+
+		destPath := filepath.Join(destDir, relPath)
+
+		if info.IsDir() {
+			return os.MkdirAll(destPath, os.ModePerm)
+		}
+
+		return copyFile(path, destPath)
+	})
+	return err
+}
+
+// copyFile copies a file from src to dest
+func copyFile(src, dest string) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destFile, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	_, err = io.Copy(destFile, sourceFile)
+	if err != nil {
+		return err
+	}
+
+	return destFile.Sync()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// executionIDHeader carries the ID of the execution a request started
+const executionIDHeader = "X-Execution-Id"
+
+// executionRegistry tracks the running executions so they can be cancelled
+type executionRegistry struct {
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+// executions holds the executions running on this agent
+var executions = &executionRegistry{running: map[string]context.CancelFunc{}}
+
+// executionIDKey is the context key of the ID of the running execution
+type executionIDKey struct{}
+
+// executionID returns the ID of the execution running under ctx, or "" if none is
+func executionID(ctx context.Context) string {
+	id, _ := ctx.Value(executionIDKey{}).(string)
+	return id
+}
+
+// start registers the execution of record and publishes its start,
+// returning its context and a function to call once it has finished
+func (e *executionRegistry) start(ctx context.Context, record *ExecutionRecord) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, executionIDKey{}, record.ID))
+	id := record.ID
+	started := time.Now()
+
+	e.mu.Lock()
+	e.running[id] = cancel
+	e.mu.Unlock()
+	events.publish(ExecutionEvent{Type: EventStarted, ExecutionID: id, Kind: record.Kind, Language: record.Language})
+
+	return ctx, func() {
+		e.mu.Lock()
+		delete(e.running, id)
+		e.mu.Unlock()
+
+		cancelled := ctx.Err() != nil
+		cancel()
+		events.publish(ExecutionEvent{
+			Type:        EventFinished,
+			ExecutionID: id,
+			Kind:        record.Kind,
+			Language:    record.Language,
+			DurationMs:  time.Since(started).Milliseconds(),
+			Cancelled:   cancelled,
+		})
+	}
+}
+
+// cancel stops the execution id, reporting whether it was running
+func (e *executionRegistry) cancel(id string) bool {
+	e.mu.Lock()
+	cancel, ok := e.running[id]
+	e.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// announceExecution sends the execution ID to the client right away, in a
+// 103 Early Hints response, so that it can cancel the execution before the
+// final response arrives. The final response carries the header as well.
+func announceExecution(w http.ResponseWriter, id string) {
+	w.Header().Set(executionIDHeader, id)
+	w.WriteHeader(http.StatusEarlyHints)
+}
+
+// cancelExecutionHandler cancels the execution /executions/{id}/cancel
+func cancelExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	id := r.PathValue("id")
+	if !executions.cancel(id) {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Execution not found or already finished", nil)
+		return
+	}
+
+	jsonResponse, _ := json.Marshal(map[string]any{"id": id, "cancelled": true})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
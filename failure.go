@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// When an execution fails, its error is summarized so the frontend can
+// highlight the offending line: compile errors from the first error
+// diagnostic, runtime errors from what the program left on stderr, using
+// the patterns of its language. Locations are only reported in files of the
+// workspace, not in the runtime's own sources.
+
+// FailureSummary is the error an execution failed with
+type FailureSummary struct {
+	// Type is e.g. "TypeError" or "CompileError", if the language names its errors
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message"`
+
+	// File is relative to the workspace
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+// failurePatterns pick the runtime error out of the stderr of a language's programs
+type failurePatterns struct {
+	// Errors match the error a program died with, with the named groups
+	// "message" and optionally "type", "file", "line" and "column". The
+	// first one matching is used, at its last match.
+	Errors []*regexp.Regexp
+
+	// Frames match the locations of a stack trace, with the named groups
+	// "file", "line" and optionally "column". The first frame in a file of
+	// the workspace is the location of the error if it has none.
+	Frames []*regexp.Regexp
+}
+
+// summarizeFailure returns the summary of the error the job failed with,
+// or nil if it can't tell
+func summarizeFailure(lang *Language, job *ExecJob, result *ExecResult, err error) *FailureSummary {
+	for _, d := range result.Diagnostics {
+		if d.Severity == "error" {
+			return &FailureSummary{Type: "CompileError", Message: d.Message, File: d.File, Line: d.Line, Column: d.Column}
+		}
+	}
+
+	stderr := stripANSI(result.Stderr)
+	if errors.Is(err, errCompilation) {
+		message, _, _ := strings.Cut(strings.TrimSpace(stderr), "\n")
+		if message == "" {
+			return nil
+		}
+		return &FailureSummary{Type: "CompileError", Message: message}
+	}
+	if lang.Failure == nil {
+		return nil
+	}
+
+	for _, pattern := range lang.Failure.Errors {
+		matches := pattern.FindAllStringSubmatchIndex(stderr, -1)
+		if matches == nil {
+			continue
+		}
+		match := matches[len(matches)-1]
+
+		summary := &FailureSummary{
+			Type:    strings.TrimSpace(submatch(pattern, stderr, match, "type")),
+			Message: strings.TrimSpace(submatch(pattern, stderr, match, "message")),
+		}
+		if summary.Message == "" {
+			summary.Message = summary.Type
+		}
+		// Stack traces mostly follow the error, but some come before it
+		if !summary.locate(job.Dir, pattern, stderr, match) &&
+			!summary.locateFrame(job.Dir, lang.Failure.Frames, stderr[match[0]:]) {
+			summary.locateFrame(job.Dir, lang.Failure.Frames, stderr)
+		}
+		return summary
+	}
+	return nil
+}
+
+// locateFrame sets the location of the error to the first of frames in a
+// file of the workspace dir, reporting whether there was one
+func (s *FailureSummary) locateFrame(dir string, frames []*regexp.Regexp, output string) bool {
+	for _, frame := range frames {
+		for _, match := range frame.FindAllStringSubmatchIndex(output, -1) {
+			if s.locate(dir, frame, output, match) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// locate sets the location of the error to the one in match, reporting
+// whether it is in a file of the workspace dir
+func (s *FailureSummary) locate(dir string, pattern *regexp.Regexp, output string, match []int) bool {
+	file, ok := workspaceFile(dir, submatch(pattern, output, match, "file"))
+	if !ok {
+		return false
+	}
+	line, err := strconv.Atoi(submatch(pattern, output, match, "line"))
+	if err != nil {
+		return false
+	}
+
+	s.File, s.Line = file, line
+	s.Column, _ = strconv.Atoi(submatch(pattern, output, match, "column"))
+	return true
+}
+
+// submatch returns the named group of match, or "" if pattern has no such group
+func submatch(pattern *regexp.Regexp, output string, match []int, name string) string {
+	i := pattern.SubexpIndex(name)
+	if i < 0 || match[2*i] < 0 {
+		return ""
+	}
+	return output[match[2*i]:match[2*i+1]]
+}
+
+// workspaceFile returns file, as named in a stack trace, relative to the
+// workspace dir, or false if it isn't an existing file of the workspace
+func workspaceFile(dir string, file string) (string, bool) {
+	file = strings.TrimPrefix(file, "file://")
+	if file == "" {
+		return "", false
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+
+	rel, err := filepath.Rel(dir, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
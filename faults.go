@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fault injection lets orchestrators test their retry and failover logic
+// against a real agent. It is disabled unless OCTREE_FAULTS lists faults,
+// e.g. "slow-setup=2s,failed-cleanup,delayed-start=500ms,rate=0.1":
+//
+//   - slow-setup=<duration> delays the preparation of every workspace
+//   - failed-cleanup leaves workspaces behind as if removing them failed
+//   - delayed-start=<duration> delays starting every process
+//   - rate=<probability> injects each fault only that often (default 1)
+
+// faultSettings are the faults injected into this agent
+type faultSettings struct {
+	slowSetup     time.Duration
+	failedCleanup bool
+	delayedStart  time.Duration
+	rate          float64
+}
+
+// faults holds the faults injected into this agent, none by default
+var faults = faultSettings{rate: 1}
+
+// loadFaults parses the faults to inject, ignoring the invalid ones
+func loadFaults(specs []string) {
+	for _, spec := range specs {
+		name, value, _ := strings.Cut(spec, "=")
+
+		var err error
+		switch name {
+		case "slow-setup":
+			faults.slowSetup, err = time.ParseDuration(value)
+		case "failed-cleanup":
+			faults.failedCleanup = true
+		case "delayed-start":
+			faults.delayedStart, err = time.ParseDuration(value)
+		case "rate":
+			faults.rate, err = strconv.ParseFloat(value, 64)
+		default:
+			log.Printf("Warning: ignoring unknown fault %q", spec)
+			continue
+		}
+		if err != nil {
+			log.Printf("Warning: ignoring invalid fault %q: %s", spec, err)
+			continue
+		}
+		log.Printf("Warning: injecting fault %s", spec)
+	}
+}
+
+// injectFault reports whether an enabled fault should be injected this time
+func injectFault(enabled bool) bool {
+	return enabled && rand.Float64() < faults.rate
+}
+
+// injectDelay waits for d if the fault should be injected, or until ctx is done
+func injectDelay(ctx context.Context, d time.Duration) {
+	if !injectFault(d > 0) {
+		return
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
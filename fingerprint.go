@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode"
+)
+
+// Submissions are fingerprinted with winnowing (Schleimer et al., "Winnowing:
+// Local Algorithms for Document Fingerprinting"), the scheme used by MOSS.
+// The code is reduced to a stream of normalized tokens so that renaming
+// identifiers, changing literals or reformatting doesn't change it, every
+// k-gram of tokens is hashed, and the smallest hash of each window of
+// consecutive k-grams is kept.
+
+const (
+	// fingerprintK is the number of tokens hashed together
+	fingerprintK = 5
+
+	// fingerprintWindow is the number of consecutive k-gram hashes a fingerprint is picked from
+	fingerprintWindow = 4
+)
+
+// commentSyntax is how comments are written in a language
+type commentSyntax struct {
+	line       string
+	blockStart string
+	blockEnd   string
+}
+
+// languageComments is the comment syntax of the languages whose comments
+// are stripped before fingerprinting
+var languageComments = map[string]commentSyntax{
+	"javascript": {"//", "/*", "*/"},
+	"typescript": {"//", "/*", "*/"},
+	"scala":      {"//", "/*", "*/"},
+	"dart":       {"//", "/*", "*/"},
+	"zig":        {"//", "", ""},
+	"fsharp":     {"//", "(*", "*)"},
+	"ocaml":      {"", "(*", "*)"},
+	"haskell":    {"--", "{-", "-}"},
+	"lua":        {"--", "--[[", "]]"},
+	"elixir":     {"#", "", ""},
+	"perl":       {"#", "", ""},
+	"r":          {"#", "", ""},
+	"julia":      {"#", "#=", "=#"},
+	"erlang":     {"%", "", ""},
+	"clojure":    {";", "", ""},
+	"asm":        {";", "", ""},
+}
+
+// fingerprintKeywords are kept as they are; every other identifier is
+// normalized to the same token
+var fingerprintKeywords = map[string]bool{
+	"if": true, "else": true, "elif": true, "elsif": true, "then": true, "case": true, "when": true,
+	"match": true, "switch": true, "for": true, "foreach": true, "while": true, "do": true, "loop": true,
+	"break": true, "continue": true, "return": true, "function": true, "fn": true, "fun": true,
+	"def": true, "defn": true, "let": true, "const": true, "var": true, "val": true, "class": true,
+	"struct": true, "new": true, "end": true, "in": true, "of": true, "try": true, "catch": true,
+	"throw": true, "and": true, "or": true, "not": true, "true": true, "false": true, "null": true,
+	"nil": true,
+}
+
+// fingerprint returns the winnowing fingerprints of code, as hex strings
+func fingerprint(language string, code string) []string {
+	// Step 1: Tokenize
+	tokens := fingerprintTokens(stripComments(code, languageComments[language]))
+	if len(tokens) < fingerprintK {
+		return []string{}
+	}
+
+	// Step 2: Hash every k-gram
+	hashes := make([]uint64, len(tokens)-fingerprintK+1)
+	for i := range hashes {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(tokens[i:i+fingerprintK], " ")))
+		hashes[i] = h.Sum64()
+	}
+
+	// Step 3: Keep the rightmost smallest hash of every window, once
+	fingerprints := []string{}
+	selected := -1
+	for start := 0; start+fingerprintWindow <= len(hashes) || start == 0; start++ {
+		end := min(start+fingerprintWindow, len(hashes))
+
+		smallest := start
+		for i := start; i < end; i++ {
+			if hashes[i] <= hashes[smallest] {
+				smallest = i
+			}
+		}
+
+		if smallest != selected {
+			selected = smallest
+			fingerprints = append(fingerprints, fmt.Sprintf("%016x", hashes[smallest]))
+		}
+	}
+
+	return fingerprints
+}
+
+// stripComments removes the comments of the given syntax from code. String
+// literals aren't recognized, so a comment marker inside a string cuts it short.
+func stripComments(code string, syntax commentSyntax) string {
+	var out strings.Builder
+	for len(code) > 0 {
+		switch {
+		case syntax.blockStart != "" && strings.HasPrefix(code, syntax.blockStart):
+			end := strings.Index(code[len(syntax.blockStart):], syntax.blockEnd)
+			if end < 0 {
+				return out.String()
+			}
+			code = code[len(syntax.blockStart)+end+len(syntax.blockEnd):]
+		case syntax.line != "" && strings.HasPrefix(code, syntax.line):
+			end := strings.IndexByte(code, '\n')
+			if end < 0 {
+				return out.String()
+			}
+			code = code[end:]
+		default:
+			out.WriteByte(code[0])
+			code = code[1:]
+		}
+	}
+	return out.String()
+}
+
+// fingerprintTokens splits code into normalized tokens: keywords, "id" for any
+// other identifier, "num" for numbers, "str" for string literals and single
+// punctuation characters. Whitespace is dropped.
+func fingerprintTokens(code string) []string {
+	var tokens []string
+	runes := []rune(code)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			word := strings.ToLower(string(runes[start:i]))
+			if fingerprintKeywords[word] {
+				tokens = append(tokens, word)
+			} else {
+				tokens = append(tokens, "id")
+			}
+		case unicode.IsDigit(r):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || unicode.IsLetter(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, "num")
+		case r == '"' || r == '\'' || r == '`':
+			i++
+			for i < len(runes) && runes[i] != r && runes[i] != '\n' {
+				if runes[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+			tokens = append(tokens, "str")
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+
+	return tokens
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// gitCloneTimeout bounds fetching a repository
+	gitCloneTimeout = 30 * time.Second
+
+	// maxGitSourceBytes bounds the size of a checked out repository, and
+	// of the objects fetched for it
+	maxGitSourceBytes = 64 << 20
+
+	// gitSizePollInterval is how often the size of a repository being fetched is checked
+	gitSizePollInterval = 100 * time.Millisecond
+)
+
+// errGitSourceTooLarge is returned for a repository past maxGitSourceBytes
+var errGitSourceTooLarge = fmt.Errorf("%w: repository exceeds the limit of %d bytes", errSourceUnavailable, maxGitSourceBytes)
+
+// GitSource references the code of a request in a git repository, in place of
+// inline code. The repository is checked out at the root of the workspace and
+// must contain the language's source file, unless code is given as well.
+type GitSource struct {
+	URL string `json:"url"`
+
+	// Ref is a branch, tag or commit, HEAD if empty
+	Ref string `json:"ref,omitempty"`
+}
+
+// checkGitSource validates the git source of a request against the allowed
+// hosts. If it is invalid it writes the error response and returns false.
+func checkGitSource(w http.ResponseWriter, req *CodeExecRequest) bool {
+	if req.Git == nil {
+		return true
+	}
+
+	u, err := url.Parse(req.Git.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Git URL must be an https URL", nil)
+		return false
+	}
+	if !slices.Contains(config.GitHosts, u.Hostname()) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Git host not allowed", map[string]any{"allowedHosts": config.GitHosts})
+		return false
+	}
+	if len(req.Git.Ref) > 0 && req.Git.Ref[0] == '-' {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid git ref", nil)
+		return false
+	}
+
+	return true
+}
+
+// cloneGitSource checks out src into dir, which may already hold the
+// template. Only the requested commit is fetched, which is abandoned as soon
+// as the fetched objects grow past the size limit, and checked out only if
+// its files fit within it. The repository's history is removed afterwards.
+func cloneGitSource(ctx context.Context, src *GitSource, dir string) error {
+	ctx, cancel := context.WithTimeout(ctx, gitCloneTimeout)
+	defer cancel()
+
+	ref := src.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	// Step 1: Fetch the commit, watching the size of the repository
+	_, err := runGit(ctx, dir, "init", "--quiet")
+	if err != nil {
+		return err
+	}
+
+	fetchCtx, stopFetch := context.WithCancel(ctx)
+	defer stopFetch()
+	var exceeded atomic.Bool
+	go func() {
+		ticker := time.NewTicker(gitSizePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-fetchCtx.Done():
+				return
+			case <-ticker.C:
+				if directorySize(filepath.Join(dir, ".git")) > maxGitSourceBytes {
+					exceeded.Store(true)
+					stopFetch()
+					return
+				}
+			}
+		}
+	}()
+
+	_, err = runGit(fetchCtx, dir, "fetch", "--quiet", "--depth", "1", "--no-tags", "--", src.URL, ref)
+	stopFetch()
+	if exceeded.Load() {
+		return errGitSourceTooLarge
+	}
+	if err != nil {
+		return err
+	}
+
+	// Step 2: Check the size of its files before writing them
+	res, err := runGit(ctx, dir, "ls-tree", "-r", "-l", "-z", "FETCH_HEAD")
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, entry := range strings.Split(res.Stdout, "\x00") {
+		fields := strings.Fields(strings.SplitN(entry, "\t", 2)[0])
+		if len(fields) == 4 {
+			blobSize, _ := strconv.ParseInt(fields[3], 10, 64)
+			size += blobSize
+		}
+	}
+	if size > maxGitSourceBytes {
+		return errGitSourceTooLarge
+	}
+
+	// Step 3: Check it out
+	_, err = runGit(ctx, dir, "checkout", "--quiet", "--force", "FETCH_HEAD")
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(filepath.Join(dir, ".git"))
+	if err != nil {
+		return fmt.Errorf("failed to remove repository metadata: %w", err)
+	}
+
+	return nil
+}
+
+// runGit runs git with args in dir, allowing only https remotes
+func runGit(ctx context.Context, dir string, args ...string) (*commandResult, error) {
+	res, err := runCommand(ctx, command{
+		Name:    "git",
+		Args:    append([]string{"-c", "protocol.allow=never", "-c", "protocol.https.allow=always"}, args...),
+		Dir:     dir,
+		Env:     []string{"GIT_TERMINAL_PROMPT=0", "GIT_CONFIG_NOSYSTEM=1"},
+		Timeout: gitCloneTimeout,
+	})
+	if err != nil {
+		return res, fmt.Errorf("%w: git %s failed: %s %s", errSourceUnavailable, args[0], err, res.Stderr)
+	}
+	return res, nil
+}
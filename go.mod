@@ -1,5 +1,5 @@
 module octree.io-agent
 
-go 1.23.2
+go 1.24
 
 require github.com/google/uuid v1.3.0
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// In function mode a judge request names a function instead of providing a
+// whole program. Each test case gives the function's arguments as a JSON array
+// and its expected return value as JSON. The language's harness, appended to
+// the submitted code, reads the arguments from stdin, calls the function and
+// prints the return value as JSON on the last line of stdout.
+
+// FunctionSpec is the function called in function mode. The parameter and
+// return types are only needed for data structures (see harnessStructures);
+// anything else is passed as plain JSON.
+type FunctionSpec struct {
+	Name       string   `json:"name"`
+	ParamTypes []string `json:"paramTypes,omitempty"`
+	ReturnType string   `json:"returnType,omitempty"`
+}
+
+// harnessStructures are the data structures that can be passed to and returned
+// from a function, with their canonical JSON encodings:
+//
+//   - ListNode:  [1,2,3]
+//   - TreeNode:  [1,2,null,3], level order with null for missing children
+//   - GraphNode: [[2,4],[1,3],[2,4],[1,3]], the neighbors of nodes 1..n, starting from node 1
+//   - Interval:  [1,3]
+//
+// A "[]" suffix makes an array of any type, e.g. "ListNode[]".
+var harnessStructures = []string{"ListNode", "TreeNode", "GraphNode", "Interval"}
+
+// structureDefinition is how a language declares one of the harnessStructures
+type structureDefinition struct {
+	// ClassName is the name the structure has in the language
+	ClassName string
+
+	// Source declares the class, for submissions that don't declare it themselves
+	Source string
+}
+
+// structures returns the harnessStructures that fn's signature uses
+func (fn *FunctionSpec) structures() []string {
+	var used []string
+	for _, structure := range harnessStructures {
+		for _, t := range append([]string{fn.ReturnType}, fn.ParamTypes...) {
+			if strings.TrimSuffix(t, "[]") == structure {
+				used = append(used, structure)
+				break
+			}
+		}
+	}
+	return used
+}
+
+// missingDefinitions returns the source of the structures used by fn that the
+// code doesn't declare itself
+func missingDefinitions(code string, fn *FunctionSpec, definitions map[string]structureDefinition) string {
+	var sources strings.Builder
+	for _, structure := range fn.structures() {
+		def := definitions[structure]
+		declared := regexp.MustCompile(`\b(class|function|interface|type|const|let|var)\s+` + regexp.QuoteMeta(def.ClassName) + `\b`)
+		if !declared.MatchString(code) {
+			sources.WriteString("\n" + def.Source + "\n")
+		}
+	}
+	return sources.String()
+}
+
+// functionName matches the function names a harness can call
+var functionName = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// prepareHarness rewrites req for function mode: the code gets the language's
+// harness and every test case's arguments and expected value become its input
+// and expected output
+func prepareHarness(lang *Language, req *JudgeRequest) error {
+	if lang.Harness == nil {
+		return fmt.Errorf("function mode is not supported for %s", lang.Name)
+	}
+	if !functionName.MatchString(req.Function.Name) {
+		return fmt.Errorf("invalid function name %q", req.Function.Name)
+	}
+
+	for i := range req.TestCases {
+		tc := &req.TestCases[i]
+
+		var args []json.RawMessage
+		err := json.Unmarshal(tc.Args, &args)
+		if err != nil {
+			return fmt.Errorf("test case %d: args must be a JSON array", i)
+		}
+		if !json.Valid(tc.Expected) {
+			return fmt.Errorf("test case %d: expected must be a JSON value", i)
+		}
+
+		var input, expected bytes.Buffer
+		json.Compact(&input, tc.Args)
+		json.Compact(&expected, tc.Expected)
+		tc.Input = input.String()
+		tc.ExpectedOutput = expected.String()
+	}
+
+	req.Code = lang.Harness(req.Code, req.Function)
+	return nil
+}
+
+// javaScriptCodec returns JavaScript (that is also valid TypeScript) defining
+// __octreeCodec, which converts values between their JSON encoding and the
+// harnessStructures, along with fn's __octreeParamTypes and __octreeReturnType
+func javaScriptCodec(fn *FunctionSpec, definitions map[string]structureDefinition) string {
+	var classes []string
+	for _, structure := range fn.structures() {
+		classes = append(classes, structure+": "+definitions[structure].ClassName)
+	}
+
+	codec, _ := json.Marshal(javaScriptCodecSource)
+	paramTypes, _ := json.Marshal(fn.ParamTypes)
+	types, _ := json.Marshal(string(paramTypes))
+	returnType, _ := json.Marshal(fn.ReturnType)
+
+	return fmt.Sprintf(`const __octreeCodec = new Function("classes", %s)({ %s });
+const __octreeParamTypes = JSON.parse(%s) || [];
+const __octreeReturnType = %s;`, codec, strings.Join(classes, ", "), types, returnType)
+}
+
+// javaScriptCodecSource is the body of the function that builds __octreeCodec
+const javaScriptCodecSource = `
+function decode(type, value) {
+  if (value === null) return null;
+  if (type.endsWith("[]")) return value.map((v) => decode(type.slice(0, -2), v));
+  switch (type) {
+    case "ListNode": {
+      let head = null;
+      for (let i = value.length - 1; i >= 0; i--) {
+        const node = new classes.ListNode();
+        node.val = value[i];
+        node.next = head;
+        head = node;
+      }
+      return head;
+    }
+    case "TreeNode": {
+      if (value.length === 0 || value[0] === null) return null;
+      const make = (val) => {
+        const node = new classes.TreeNode();
+        node.val = val;
+        node.left = null;
+        node.right = null;
+        return node;
+      };
+      const root = make(value[0]);
+      const queue = [root];
+      let i = 1;
+      for (let q = 0; q < queue.length && i < value.length; q++) {
+        for (const side of ["left", "right"]) {
+          if (i < value.length && value[i] !== null) {
+            queue[q][side] = make(value[i]);
+            queue.push(queue[q][side]);
+          }
+          i++;
+        }
+      }
+      return root;
+    }
+    case "GraphNode": {
+      if (value.length === 0) return null;
+      const nodes = value.map((_, i) => {
+        const node = new classes.GraphNode();
+        node.val = i + 1;
+        node.neighbors = [];
+        return node;
+      });
+      value.forEach((neighbors, i) => {
+        nodes[i].neighbors = neighbors.map((val) => nodes[val - 1]);
+      });
+      return nodes[0];
+    }
+    case "Interval": {
+      const interval = new classes.Interval();
+      interval.start = value[0];
+      interval.end = value[1];
+      return interval;
+    }
+    default:
+      return value;
+  }
+}
+
+function encode(type, value) {
+  if (value === undefined || value === null) {
+    // An empty list, tree or graph is represented by null
+    return ["ListNode", "TreeNode", "GraphNode"].includes(type) ? [] : null;
+  }
+  if (type.endsWith("[]")) return value.map((v) => encode(type.slice(0, -2), v));
+  switch (type) {
+    case "ListNode": {
+      const values = [];
+      const seen = new Set();
+      for (let node = value; node && !seen.has(node); node = node.next) {
+        seen.add(node);
+        values.push(node.val);
+      }
+      return values;
+    }
+    case "TreeNode": {
+      const values = [];
+      const queue = [value];
+      for (let q = 0; q < queue.length; q++) {
+        const node = queue[q];
+        if (!node) {
+          values.push(null);
+          continue;
+        }
+        values.push(node.val);
+        queue.push(node.left || null, node.right || null);
+      }
+      while (values.length > 0 && values[values.length - 1] === null) values.pop();
+      return values;
+    }
+    case "GraphNode": {
+      const nodes = new Map([[value.val, value]]);
+      const queue = [value];
+      for (let q = 0; q < queue.length; q++) {
+        for (const neighbor of queue[q].neighbors) {
+          if (!nodes.has(neighbor.val)) {
+            nodes.set(neighbor.val, neighbor);
+            queue.push(neighbor);
+          }
+        }
+      }
+      const adjacency = [];
+      for (let val = 1; val <= Math.max(...nodes.keys()); val++) {
+        adjacency.push(nodes.has(val) ? nodes.get(val).neighbors.map((n) => n.val) : []);
+      }
+      return adjacency;
+    }
+    case "Interval":
+      return [value.start, value.end];
+    default:
+      return value;
+  }
+}
+
+return { decode, encode };
+`
+
+// harnessOutputsMatch compares the return value printed by a harness with the
+// expected one as JSON values, ignoring anything the function printed itself
+func harnessOutputsMatch(expected string, actual string) bool {
+	lines := strings.Split(strings.TrimRight(actual, " \t\r\n"), "\n")
+
+	var want, got any
+	if json.Unmarshal([]byte(expected), &want) != nil {
+		return false
+	}
+	if json.Unmarshal([]byte(lines[len(lines)-1]), &got) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(want, got)
+}
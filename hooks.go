@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+)
+
+// Hooks are shell commands declared per language in the hooks config file and
+// run inside the workspace: setup hooks once the workspace is prepared (e.g.
+// `npm ci --prefer-offline` or seeding a database), teardown hooks before it
+// is deleted (e.g. collecting artifacts). For example:
+//
+//	{"languages": {"typescript": {
+//	    "setup": [{"command": "npm ci --prefer-offline", "timeoutMs": 60000}],
+//	    "teardown": [{"command": "/opt/octree/collect.sh"}]
+//	}}}
+
+// hooksConfigFile is the format of the hooks config file
+type hooksConfigFile struct {
+	Languages map[string]*languageHooks `json:"languages"`
+}
+
+// languageHooks are the hooks of one language, run in order
+type languageHooks struct {
+	Setup    []hook `json:"setup,omitempty"`
+	Teardown []hook `json:"teardown,omitempty"`
+}
+
+// hook is a shell command run in the workspace
+type hook struct {
+	Command   string            `json:"command"`
+	Env       map[string]string `json:"env,omitempty"`
+	TimeoutMs int64             `json:"timeoutMs,omitempty"`
+}
+
+// hooks maps a language to its hooks
+var hooks = map[string]*languageHooks{}
+
+// loadHooksConfig loads the hooks declared in the config file at path, for
+// the languages that are registered
+func loadHooksConfig(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read hooks config %s: %s", path, err)
+		}
+		return
+	}
+
+	var file hooksConfigFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		log.Printf("Warning: invalid hooks config %s: %s", path, err)
+		return
+	}
+
+	for name, lh := range file.Languages {
+		if _, ok := languages[name]; !ok {
+			log.Printf("Warning: skipping hooks for unknown language %s", name)
+			continue
+		}
+		hooks[name] = lh
+		log.Printf("Loaded %d setup and %d teardown hooks for language %s", len(lh.Setup), len(lh.Teardown), name)
+	}
+}
+
+// run runs the hook in the job's workspace, within ctx. A hook without a
+// timeout of its own may take what is left of the phase it runs in.
+func (h hook) run(ctx context.Context, job *ExecJob) (*commandResult, error) {
+	name, args := shellCommand(h.Command)
+	c := command{
+		Name:    name,
+		Args:    args,
+		Dir:     job.Dir,
+		Env:     slices.Clone(job.Env),
+		Timeout: time.Duration(h.TimeoutMs) * time.Millisecond,
+	}
+	if deadline, ok := ctx.Deadline(); ok && c.Timeout == 0 {
+		c.Timeout = time.Until(deadline)
+	}
+	for key, value := range h.Env {
+		c.Env = append(c.Env, key+"="+value)
+	}
+
+	return runCommand(ctx, c)
+}
+
+// timeout returns how long the hook ran for before timing out in phase,
+// whose own timeout (bounding ctx) comes first if it has passed
+func (h hook) timeout(ctx context.Context, phase string) time.Duration {
+	switch {
+	case ctx.Err() != nil:
+		return phaseTimeout(phase)
+	case h.TimeoutMs > 0:
+		return time.Duration(h.TimeoutMs) * time.Millisecond
+	default:
+		return defaultTimeout
+	}
+}
+
+// runSetupHooks runs the setup hooks of the job's language within ctx,
+// stopping at the first one that fails
+func runSetupHooks(ctx context.Context, job *ExecJob) error {
+	lh, ok := hooks[job.Request.Language]
+	if !ok {
+		return nil
+	}
+
+	for _, h := range lh.Setup {
+		if ctx.Err() != nil {
+			err := fmt.Errorf("%w before setup hook %q", errExecutionTimeout, h.Command)
+			return inPhase(PhaseSetup, phaseTimeout(PhaseSetup), err)
+		}
+		res, err := h.run(ctx, job)
+		if err != nil {
+			err = fmt.Errorf("setup hook %q failed: %w: %s", h.Command, err, res.Stderr)
+			return inPhase(PhaseSetup, h.timeout(ctx, PhaseSetup), err)
+		}
+	}
+
+	return nil
+}
+
+// runTeardownHooks runs all the teardown hooks of the job's language within
+// the cleanup timeout, logging the ones that fail
+func runTeardownHooks(job *ExecJob) {
+	lh, ok := hooks[job.Request.Language]
+	if !ok {
+		return
+	}
+
+	ctx, cancel := withPhaseTimeout(context.Background(), PhaseCleanup)
+	defer cancel()
+
+	for _, h := range lh.Teardown {
+		if ctx.Err() != nil {
+			log.Printf("Warning: cleanup phase timed out after %s in %s, skipping the remaining teardown hooks", phaseTimeout(PhaseCleanup), job.Dir)
+			return
+		}
+		res, err := h.run(ctx, job)
+		if err != nil {
+			err = inPhase(PhaseCleanup, h.timeout(ctx, PhaseCleanup), err)
+			log.Printf("Warning: teardown hook %q failed in %s: %s %s", h.Command, job.Dir, err, res.Stderr)
+		}
+	}
+}
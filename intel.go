@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// The editor asks POST /code/intel about the code being edited: its errors,
+// the type of the symbol at a position and the completions there. Languages
+// answer from a persistent language server per template (see Language.Intel),
+// so the template's dependencies are known to it. Languages without one are
+// rejected.
+
+// The kinds of code intelligence a request can ask for
+const (
+	intelHover       = "hover"
+	intelDiagnostics = "diagnostics"
+	intelCompletions = "completions"
+)
+
+// maxintelCompletions bounds the completions returned, best first
+const maxintelCompletions = 100
+
+// IntelRequest is the body of the code intel endpoint
+type IntelRequest struct {
+	Language string `json:"language"`
+	Template string `json:"template"`
+	Filename string `json:"filename"`
+	Code     string `json:"code"`
+	IntelQuery
+}
+
+// IntelQuery is what is asked about the code. Line and Column are 1-based.
+// Kinds defaults to all of them.
+type IntelQuery struct {
+	Line   int      `json:"line"`
+	Column int      `json:"column"`
+	Kinds  []string `json:"kinds,omitempty"`
+}
+
+// wants reports whether the query asks for kind
+func (q IntelQuery) wants(kind string) bool {
+	return len(q.Kinds) == 0 || slices.Contains(q.Kinds, kind)
+}
+
+// IntelResponse is returned by the code intel endpoint. Only the requested
+// kinds are set; Hover is also nil when there is nothing at the position.
+type IntelResponse struct {
+	Language    string            `json:"language"`
+	Hover       *IntelHover       `json:"hover,omitempty"`
+	Diagnostics []Diagnostic      `json:"diagnostics,omitempty"`
+	Completions []IntelCompletion `json:"completions,omitempty"`
+}
+
+// IntelHover describes the symbol at a position
+type IntelHover struct {
+	Kind          string `json:"kind"`
+	Signature     string `json:"signature"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// IntelCompletion is a completion at a position
+type IntelCompletion struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// intelHandler answers questions about code from the language's server
+func intelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read request body", nil)
+		return
+	}
+	defer r.Body.Close()
+
+	var req IntelRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON format", err.Error())
+		return
+	}
+
+	// Step 1: Resolve the language and template
+	if req.Language == "" {
+		req.Language = detectLanguage(req.Filename, req.Code)
+	}
+	lang, ok := languages[req.Language]
+	if !ok || lang.Intel == nil {
+		writeError(w, http.StatusBadRequest, CodeUnsupportedLanguage, "Code intelligence is not available for the language",
+			map[string]string{"language": req.Language})
+		return
+	}
+
+	template, ok := namedTemplate(lang, req.Template)
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Template not found", map[string]any{"templates": templateNames(lang.Name)})
+		return
+	}
+
+	// Step 2: Ask the language's server
+	response, err := lang.Intel(r.Context(), req.Template, template, req.Code, req.IntelQuery)
+	if errors.Is(err, errTypeCheckUnavailable) {
+		writeError(w, http.StatusServiceUnavailable, CodeServiceUnavailable, "Language server unavailable", err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Code intelligence failed", err.Error())
+		return
+	}
+	response.Language = lang.Name
+
+	jsonResponse, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// interactIdleTimeout is how long the program and the interactor may both
+	// sit idle, exchanging nothing and using no CPU, before the run is treated
+	// as deadlocked
+	interactIdleTimeout = time.Second
+
+	// interactPollInterval is how often the idle watch samples CPU usage
+	interactPollInterval = 50 * time.Millisecond
+)
+
+// interactor is a program that talks to the submission over its stdin and
+// stdout, for interactive problems. Like a checker it runs in its own
+// workspace, where input.txt and answer.txt hold the test case, and it exits
+// with 0 to accept the submission or 1 to reject it. Whatever it prints to
+// stderr is returned as the test case message.
+type interactor struct {
+	lang *Language
+	job  *ExecJob
+}
+
+// interaction is the outcome of running a test case against an interactor
+type interaction struct {
+	// result and err are the submission's
+	result *ExecResult
+	err    error
+
+	// verdict and message are the interactor's
+	verdict Verdict
+	message string
+
+	deadlocked bool
+}
+
+// interact runs job and the interactor side by side, each one's stdout piped
+// into the other's stdin
+func (in *interactor) interact(ctx context.Context, lang *Language, job *ExecJob, tc TestCase) *interaction {
+	// Step 1: Write the test case for the interactor
+	err := writeTestCaseFiles(in.job.Dir, map[string]string{
+		"input.txt":  tc.Input,
+		"answer.txt": tc.ExpectedOutput,
+	})
+	if err != nil {
+		return &interaction{result: &ExecResult{}, err: fmt.Errorf("interactor failed: %w", err)}
+	}
+
+	// Step 2: Connect the program and the interactor
+	programIn, interactorOut, err := os.Pipe()
+	if err != nil {
+		return &interaction{result: &ExecResult{}, err: fmt.Errorf("failed to create pipe: %w", err)}
+	}
+	interactorIn, programOut, err := os.Pipe()
+	if err != nil {
+		programIn.Close()
+		interactorOut.Close()
+		return &interaction{result: &ExecResult{}, err: fmt.Errorf("failed to create pipe: %w", err)}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	watch := &idleWatch{lastActivity: time.Now()}
+
+	job.Input, job.Output, job.Started = programIn, watch.writer(programOut), watch.add
+	in.job.Input, in.job.Output, in.job.Started = interactorIn, watch.writer(interactorOut), watch.add
+	defer func() {
+		job.Input, job.Output, job.Started = nil, nil, nil
+		in.job.Input, in.job.Output, in.job.Started = nil, nil, nil
+	}()
+
+	// Step 3: Run both sides. When one exits, its ends of the pipes are closed
+	// so that the other sees end of file.
+	run := &interaction{}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		run.result, run.err = lang.Run(ctx, job)
+		programIn.Close()
+		programOut.Close()
+	}()
+
+	go func() {
+		defer wg.Done()
+		result, err := in.lang.Run(ctx, in.job)
+		if result != nil {
+			// The interactor's stdout is the conversation, not a message
+			result.Stdout = ""
+		}
+		run.verdict, run.message = judgeProgramVerdict("interactor", result, err)
+		interactorIn.Close()
+		interactorOut.Close()
+	}()
+
+	go watch.run(ctx, cancel)
+
+	wg.Wait()
+	cancel()
+
+	run.deadlocked = watch.deadlocked.Load()
+	return run
+}
+
+// judgeInteraction combines the outcome of the program and the interactor into
+// the verdict for one test case
+func judgeInteraction(index int, job *ExecJob, run *interaction) TestCaseResult {
+	caseResult := judgeTestCase(index, job, run.result, run.err)
+
+	switch {
+	case run.deadlocked:
+		caseResult.Verdict = VerdictIdlenessLimit
+		caseResult.Message = "program and interactor were both waiting for each other"
+	case caseResult.Verdict == VerdictCompileError:
+	case run.verdict == VerdictWrongAnswer:
+		// A program usually fails once the interactor hangs up on it, so a
+		// rejection by the interactor takes precedence
+		caseResult.Verdict, caseResult.Message = run.verdict, run.message
+	case caseResult.Verdict == VerdictAccepted:
+		caseResult.Verdict, caseResult.Message = run.verdict, run.message
+	}
+
+	return caseResult
+}
+
+// idleWatch detects a deadlocked interaction: both sides running, but neither
+// writing anything nor using any CPU
+type idleWatch struct {
+	mu           sync.Mutex
+	lastActivity time.Time
+	processes    []*os.Process
+
+	deadlocked atomic.Bool
+}
+
+// add starts watching a process of the interaction
+func (w *idleWatch) add(process *os.Process) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.processes = append(w.processes, process)
+	w.lastActivity = time.Now()
+}
+
+// touch records that something happened in the interaction
+func (w *idleWatch) touch() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.lastActivity = time.Now()
+}
+
+// writer wraps one side's output pipe so that writes count as activity. Once
+// the other side has exited, further output is dropped.
+func (w *idleWatch) writer(pipe io.Writer) io.Writer {
+	return &activityWriter{pipe: pipe, watch: w}
+}
+
+// run polls the processes until ctx is done, calling cancel if they deadlock
+func (w *idleWatch) run(ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(interactPollInterval)
+	defer ticker.Stop()
+
+	ticks := make(map[int]int64)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		processes := w.processes
+		w.mu.Unlock()
+
+		// Until both sides are running (e.g. while one is still compiling) nothing can deadlock
+		if len(processes) < 2 {
+			continue
+		}
+
+		running := 0
+		for _, process := range processes {
+			cpu, ok := cpuTicks(process.Pid)
+			if !ok {
+				continue
+			}
+			running++
+			if cpu != ticks[process.Pid] {
+				ticks[process.Pid] = cpu
+				w.touch()
+			}
+		}
+		if running < len(processes) {
+			continue
+		}
+
+		w.mu.Lock()
+		idle := time.Since(w.lastActivity)
+		w.mu.Unlock()
+
+		if idle > interactIdleTimeout {
+			w.deadlocked.Store(true)
+			cancel()
+			return
+		}
+	}
+}
+
+// activityWriter forwards writes to a pipe, recording them with its idleWatch
+type activityWriter struct {
+	pipe  io.Writer
+	watch *idleWatch
+}
+
+func (w *activityWriter) Write(p []byte) (int, error) {
+	w.watch.touch()
+	w.pipe.Write(p)
+	return len(p), nil
+}
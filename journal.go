@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Every execution is journaled to JournalDir while it runs, so that an agent
+// that crashes or is killed doesn't lose track of it: on startup the entries
+// left behind are marked as interrupted. Clients that lost their connection
+// can then look up what became of an execution by its ID instead of it
+// silently vanishing.
+
+// journalRetention is how long the entries of interrupted executions are kept
+const journalRetention = 24 * time.Hour
+
+// Statuses of a journaled execution
+const (
+	ExecutionQueued      = "QUEUED"
+	ExecutionRunning     = "RUNNING"
+	ExecutionInterrupted = "INTERRUPTED"
+)
+
+// JournalEntry describes an execution that is running, or was interrupted by
+// the agent going down. The status of queued executions, which aren't
+// journaled yet, is described the same way.
+type JournalEntry struct {
+	ID            string     `json:"id"`
+	Kind          string     `json:"kind"`
+	Language      string     `json:"language"`
+	Status        string     `json:"status"`
+	StartedAt     time.Time  `json:"startedAt,omitzero"`
+	InterruptedAt *time.Time `json:"interruptedAt,omitempty"`
+
+	// QueuePosition and EstimatedStartAt are set for queued executions
+	QueuePosition    *int       `json:"queuePosition,omitempty"`
+	EstimatedStartAt *time.Time `json:"estimatedStartAt,omitempty"`
+}
+
+// journalPath returns the path of the journal entry of the execution id
+func journalPath(id string) string {
+	return filepath.Join(config.JournalDir, id+".json")
+}
+
+// writeJournalEntry writes entry to the journal, only renaming it into place once complete
+func writeJournalEntry(entry *JournalEntry) error {
+	data, _ := json.Marshal(entry)
+	path := journalPath(entry.ID)
+
+	err := os.WriteFile(path+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// journalEvent is the observer journaling the executions: they are
+// recorded as running once started and removed once finished
+func journalEvent(event ExecutionEvent) {
+	switch event.Type {
+	case EventStarted:
+		entry := &JournalEntry{
+			ID:        event.ExecutionID,
+			Kind:      event.Kind,
+			Language:  event.Language,
+			Status:    ExecutionRunning,
+			StartedAt: event.Time,
+		}
+		err := writeJournalEntry(entry)
+		if err != nil {
+			log.Printf("Warning: failed to journal execution %s: %s", event.ExecutionID, err)
+		}
+	case EventFinished:
+		err := os.Remove(journalPath(event.ExecutionID))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to remove journal entry of execution %s: %s", event.ExecutionID, err)
+		}
+	}
+}
+
+// recoverJournal marks the executions that were running when the agent went
+// down as interrupted, and drops the entries past their retention
+func recoverJournal() {
+	err := os.MkdirAll(config.JournalDir, os.ModePerm)
+	if err != nil {
+		log.Printf("Warning: failed to create journal directory %s: %s", config.JournalDir, err)
+		return
+	}
+
+	entries, err := os.ReadDir(config.JournalDir)
+	if err != nil {
+		log.Printf("Warning: failed to read journal directory %s: %s", config.JournalDir, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, e := range entries {
+		path := filepath.Join(config.JournalDir, e.Name())
+		if filepath.Ext(path) != ".json" {
+			os.Remove(path)
+			continue
+		}
+
+		entry, ok := readJournalEntry(path)
+		if !ok {
+			log.Printf("Warning: removing invalid journal entry %s", path)
+			os.Remove(path)
+			continue
+		}
+
+		switch {
+		case entry.Status == ExecutionRunning:
+			entry.Status = ExecutionInterrupted
+			entry.InterruptedAt = &now
+			err = writeJournalEntry(entry)
+			if err != nil {
+				log.Printf("Warning: failed to mark execution %s as interrupted: %s", entry.ID, err)
+			}
+			log.Printf("Execution %s (%s) was interrupted by the agent going down", entry.ID, entry.Kind)
+		case entry.InterruptedAt != nil && now.Sub(*entry.InterruptedAt) > journalRetention:
+			os.Remove(path)
+		}
+	}
+}
+
+// readJournalEntry reads the journal entry at path
+func readJournalEntry(path string) (*JournalEntry, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	entry := &JournalEntry{}
+	err = json.Unmarshal(data, entry)
+	if err != nil || entry.ID == "" {
+		return nil, false
+	}
+	return entry, true
+}
+
+// executionStatusHandler returns the journal entry of the execution /executions/{id},
+// if it is queued, running or was interrupted
+func executionStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Execution not found or already finished", nil)
+		return
+	}
+
+	// Queued executions aren't journaled until they start
+	var entry *JournalEntry
+	queued, ok := queue.lookup(id)
+	if ok {
+		entry = &JournalEntry{
+			ID:               id,
+			Kind:             queued.Kind,
+			Language:         queued.Language,
+			Status:           ExecutionQueued,
+			QueuePosition:    queued.Position,
+			EstimatedStartAt: queued.EstimatedStartAt,
+		}
+	} else {
+		entry, ok = readJournalEntry(journalPath(id))
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Execution not found or already finished", nil)
+		return
+	}
+
+	jsonResponse, _ := json.Marshal(entry)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
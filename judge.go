@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// maxJudgeRequestBodyBytes is larger than for plain executions to leave room for test data
+	maxJudgeRequestBodyBytes = 16 << 20
+
+	// maxTestCases bounds the number of test cases in a judge request
+	maxTestCases = 200
+
+	defaultTimeLimitMs   = 2000
+	maxTimeLimitMs       = 30000
+	defaultMemoryLimitMB = 256
+	maxMemoryLimitMB     = 2048
+)
+
+// TimeLimitKind is the kind of time limit a program exceeded
+type TimeLimitKind string
+
+const (
+	TimeLimitWall TimeLimitKind = "wall"
+	TimeLimitCPU  TimeLimitKind = "cpu"
+
+	// TimeLimitCompile is the compile timeout, which the compilation ran past
+	TimeLimitCompile TimeLimitKind = "compile"
+)
+
+// TestCase is an input and the output expected for it. In function mode the
+// arguments and expected return value are given instead, as JSON. Large
+// inputs and outputs can be fetched from URLs instead, see testdata.go.
+type TestCase struct {
+	Input          string `json:"input"`
+	ExpectedOutput string `json:"expectedOutput"`
+
+	InputURL          string `json:"inputUrl,omitempty"`
+	ExpectedOutputURL string `json:"expectedOutputUrl,omitempty"`
+
+	// inputFile and expectedOutputFile hold the data of the cases of a bundle
+	inputFile          string
+	expectedOutputFile string
+
+	Args     json.RawMessage `json:"args,omitempty"`
+	Expected json.RawMessage `json:"expected,omitempty"`
+}
+
+// JudgeRequest runs a submission against a set of test cases. The limits apply
+// to every test case separately.
+type JudgeRequest struct {
+	CodeExecRequest
+
+	TestCases     []TestCase `json:"testCases"`
+	MemoryLimitMB int64      `json:"memoryLimitMb,omitempty"`
+
+	// Bundle, if set, takes the test cases from a stored bundle, referenced
+	// as "name" or "name@version". See bundles.go.
+	Bundle string `json:"bundle,omitempty"`
+
+	// TimeLimitMs (or WallTimeLimitMs, which takes precedence) bounds the
+	// wall-clock time of a test case, and CPUTimeLimitMs its CPU time, so
+	// that a program that sleeps can be told apart from one that spins
+	TimeLimitMs     int64 `json:"timeLimitMs,omitempty"`
+	WallTimeLimitMs int64 `json:"wallTimeLimitMs,omitempty"`
+	CPUTimeLimitMs  int64 `json:"cpuTimeLimitMs,omitempty"`
+
+	// RunAllCases keeps judging after the first failing case instead of skipping the rest
+	RunAllCases bool `json:"runAllCases,omitempty"`
+
+	// Checker, if set, is a program that decides whether an output is correct
+	// instead of comparing it with the expected output. See checker. Like the
+	// interactor, it can be given as the programId of a registered program.
+	Checker *CodeExecRequest `json:"checker,omitempty"`
+
+	// Comparison, if set, compares the outputs token by token, with a
+	// tolerance for numbers. See ComparisonSpec.
+	Comparison *ComparisonSpec `json:"comparison,omitempty"`
+
+	// Interactor, if set, makes the problem interactive: the submission talks
+	// to this program instead of reading its input. See interactor.
+	Interactor *CodeExecRequest `json:"interactor,omitempty"`
+
+	// Function, if set, selects function mode. See FunctionSpec.
+	Function *FunctionSpec `json:"function,omitempty"`
+
+	// Stress, if set, selects stress mode, which generates the test cases
+	// instead of taking them from TestCases. See StressSpec.
+	Stress *StressSpec `json:"stress,omitempty"`
+
+	// TimeLimitReruns, if set, reruns the cases that ran only a little past
+	// their time limit. See RerunSpec.
+	TimeLimitReruns *RerunSpec `json:"timeLimitReruns,omitempty"`
+}
+
+// TestCaseResult is the verdict for a single test case
+type TestCaseResult struct {
+	Index       int     `json:"index"`
+	Verdict     Verdict `json:"verdict"`
+	VerdictCode int     `json:"verdictCode"`
+	TimeMs      int64   `json:"timeMs"`
+	MemoryKB    int64   `json:"memoryKb"`
+
+	// CPUTimeMs is the CPU time the program used, where it is known
+	CPUTimeMs int64 `json:"cpuTimeMs,omitempty"`
+
+	// TimeLimit tells which limit a TIME_LIMIT verdict exceeded
+	TimeLimit TimeLimitKind `json:"timeLimit,omitempty"`
+	Stdout    string        `json:"stdout,omitempty"`
+	Stderr    string        `json:"stderr,omitempty"`
+	Message   string        `json:"message,omitempty"`
+
+	// StdoutEncoding and StderrEncoding are "base64" for binary output
+	StdoutEncoding string `json:"stdoutEncoding,omitempty"`
+	StderrEncoding string `json:"stderrEncoding,omitempty"`
+
+	// Diff shows where the output went wrong on a WRONG_ANSWER
+	Diff *OutputDiff `json:"diff,omitempty"`
+
+	// Timeline is the program's sampled usage, if requested
+	Timeline *UsageTimeline `json:"timeline,omitempty"`
+
+	// RunTimesMs are the times of every run of a case that was rerun
+	RunTimesMs []int64 `json:"runTimesMs,omitempty"`
+
+	// Infrastructure is set if the case failed because of the agent rather than the code
+	Infrastructure bool `json:"infrastructure,omitempty"`
+
+	// Retried is set if the case was run again after failing because of the agent
+	Retried bool `json:"retried,omitempty"`
+}
+
+// JudgeResponse is the overall verdict along with the result of every test case
+type JudgeResponse struct {
+	Verdict     Verdict          `json:"verdict"`
+	VerdictCode int              `json:"verdictCode"`
+	Cases       []TestCaseResult `json:"cases"`
+	Diagnostics []Diagnostic     `json:"diagnostics,omitempty"`
+	ExecTime    string           `json:"execTime"`
+
+	// Stress is set in stress mode
+	Stress *StressResult `json:"stress,omitempty"`
+
+	// DetectedLanguage is the language detected for a request without one
+	DetectedLanguage string `json:"detectedLanguage,omitempty"`
+
+	// Metrics are the static metrics of the code, if requested
+	Metrics *CodeMetrics `json:"metrics,omitempty"`
+}
+
+func judgeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read request body", nil)
+		return
+	}
+	defer r.Body.Close()
+
+	var req JudgeRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON format", err.Error())
+		return
+	}
+
+	if !resolveBundle(w, &req) || !checkJudgeRequest(w, &req) {
+		return
+	}
+
+	lang, ok := resolveLanguage(w, &req.CodeExecRequest)
+	if !ok {
+		return
+	}
+	record := newExecutionRecord("judge", &req.CodeExecRequest)
+
+	if req.Trace != "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Tracing is only available when executing code", nil)
+		return
+	}
+	if req.Snapshot {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Snapshots are only available when executing code", nil)
+		return
+	}
+	if req.Mode == ModeServer || req.Mode == ModeScan {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Server and scan modes are only available when executing code", nil)
+		return
+	}
+
+	if req.Checker != nil && req.Interactor != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "A checker and an interactor can't be used together", nil)
+		return
+	}
+
+	if req.Function != nil {
+		if req.Interactor != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Function mode can't be used with an interactor", nil)
+			return
+		}
+		if req.Code == "" {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Function mode needs the code in the request", nil)
+			return
+		}
+		err = prepareHarness(lang, &req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error(), nil)
+			return
+		}
+	}
+
+	var checkerLang *Language
+	if req.Checker != nil {
+		checkerLang, ok = resolveJudgeProgram(w, "checker", req.Checker)
+		if !ok {
+			return
+		}
+	}
+
+	var interactorLang *Language
+	if req.Interactor != nil {
+		interactorLang, ok = resolveJudgeProgram(w, "interactor", req.Interactor)
+		if !ok {
+			return
+		}
+	}
+
+	var generatorLang, referenceLang *Language
+	if req.Stress != nil {
+		generatorLang, ok = resolveJudgeProgram(w, "stress.generator", req.Stress.Generator)
+		if !ok {
+			return
+		}
+		referenceLang, ok = resolveJudgeProgram(w, "stress.reference", req.Stress.Reference)
+		if !ok {
+			return
+		}
+	}
+
+	// Only valid requests are recorded
+	records.add(record)
+
+	// Helper programs run without a memory limit of their own
+	committed := req.MemoryLimitMB << 20
+	for _, helper := range []*Language{checkerLang, interactorLang, generatorLang, referenceLang} {
+		if helper != nil {
+			committed += unlimitedMemoryEstimate
+		}
+	}
+	ctx, release, ok := admitExecution(w, r, &queuedExecution{ID: record.ID, Kind: record.Kind, Language: record.Language, bytes: committed})
+	if !ok {
+		return
+	}
+	defer release()
+
+	// The execution stops if the client disconnects or cancels it
+	ctx, done := executions.start(ctx, record)
+	defer done()
+	announceExecution(w, record.ID)
+
+	start := time.Now()
+
+	job, err := prepareWorkspace(ctx, lang, &req.CodeExecRequest)
+	if err != nil {
+		status, code := classifyExecutionError(err)
+		writeError(w, status, code, fmt.Sprintf("Unable to prepare workspace: %v", err), timeoutDetails(err))
+		return
+	}
+	defer removeWorkspace(job)
+
+	var chk *checker
+	if checkerLang != nil {
+		checkerJob, ok := prepareJudgeWorkspace(ctx, w, "checker", checkerLang, req.Checker)
+		if !ok {
+			return
+		}
+		defer removeWorkspace(checkerJob)
+		chk = &checker{lang: checkerLang, job: checkerJob}
+	}
+
+	var inter *interactor
+	if interactorLang != nil {
+		interactorJob, ok := prepareJudgeWorkspace(ctx, w, "interactor", interactorLang, req.Interactor)
+		if !ok {
+			return
+		}
+		defer removeWorkspace(interactorJob)
+		inter = &interactor{lang: interactorLang, job: interactorJob}
+	}
+
+	var response *JudgeResponse
+	if req.Stress != nil {
+		generatorJob, ok := prepareJudgeWorkspace(ctx, w, "generator", generatorLang, req.Stress.Generator)
+		if !ok {
+			return
+		}
+		defer removeWorkspace(generatorJob)
+
+		referenceJob, ok := prepareJudgeWorkspace(ctx, w, "reference", referenceLang, req.Stress.Reference)
+		if !ok {
+			return
+		}
+		defer removeWorkspace(referenceJob)
+
+		gen := &stressProgram{name: "generator", lang: generatorLang, job: generatorJob}
+		ref := &stressProgram{name: "reference", lang: referenceLang, job: referenceJob}
+		response = stressTest(ctx, lang, job, &req, chk, gen, ref)
+	} else {
+		response = judge(ctx, lang, job, &req, chk, inter)
+	}
+	if writePreempted(w, ctx) {
+		return
+	}
+	response.setVerdictCodes()
+	if req.detected {
+		response.DetectedLanguage = req.Language
+	}
+	if req.Metrics {
+		response.Metrics = codeMetrics(req.Language, req.Code)
+	}
+	response.ExecTime = fmt.Sprintf("%d", time.Since(start).Milliseconds())
+
+	jsonResponse, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
+
+// checkJudgeRequest validates the test cases or stress settings of req and
+// fills in its default limits. If the request is invalid it writes the error
+// response and returns false.
+func checkJudgeRequest(w http.ResponseWriter, req *JudgeRequest) bool {
+	if req.Stress != nil {
+		if len(req.TestCases) > 0 || req.Interactor != nil || req.Function != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Stress mode can't be used with test cases, an interactor or function mode", nil)
+			return false
+		}
+		if req.Stress.Generator == nil || req.Stress.Reference == nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Missing required fields", map[string][]string{"fields": {"stress.generator", "stress.reference"}})
+			return false
+		}
+		if req.Stress.SeedTo < req.Stress.SeedFrom || req.Stress.SeedTo-req.Stress.SeedFrom >= maxStressSeeds {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("Between 1 and %d seeds are required", maxStressSeeds), nil)
+			return false
+		}
+	} else if len(req.TestCases) == 0 || len(req.TestCases) > maxTestCases {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("Between 1 and %d test cases are required", maxTestCases), nil)
+		return false
+	}
+
+	// Limits nothing would enforce are refused rather than silently ignored
+	if !resourceLimitsEnforced && (req.MemoryLimitMB != 0 || req.CPUTimeLimitMs != 0) {
+		writeError(w, http.StatusBadRequest, CodeUnsupportedFeature, "Memory and CPU time limits not supported on this platform",
+			map[string]string{"os": runtime.GOOS})
+		return false
+	}
+
+	// Without a wall-clock limit of its own, a CPU-bound program gets to use up its CPU time
+	if req.WallTimeLimitMs != 0 {
+		req.TimeLimitMs = req.WallTimeLimitMs
+	}
+	if req.TimeLimitMs == 0 {
+		req.TimeLimitMs = min(max(defaultTimeLimitMs, 2*req.CPUTimeLimitMs), maxTimeLimitMs)
+	}
+	if req.MemoryLimitMB == 0 {
+		req.MemoryLimitMB = defaultMemoryLimitMB
+	}
+	if req.TimeLimitMs < 0 || req.TimeLimitMs > maxTimeLimitMs || req.CPUTimeLimitMs < 0 || req.CPUTimeLimitMs > maxTimeLimitMs ||
+		req.MemoryLimitMB < 0 || req.MemoryLimitMB > maxMemoryLimitMB {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Limits out of range", map[string]int64{
+			"maxTimeLimitMs":   maxTimeLimitMs,
+			"maxMemoryLimitMb": maxMemoryLimitMB,
+		})
+		return false
+	}
+
+	return checkRerunSpec(w, req) && checkComparisonSpec(w, req) && checkTestCaseURLs(w, req)
+}
+
+// prepareJudgeWorkspace prepares the workspace of a program helping to judge
+// a submission, such as its checker. If that fails it writes the error
+// response and returns false.
+func prepareJudgeWorkspace(ctx context.Context, w http.ResponseWriter, name string, lang *Language, req *CodeExecRequest) (*ExecJob, bool) {
+	job, err := prepareWorkspace(ctx, lang, req)
+	if err != nil {
+		status, code := classifyExecutionError(err)
+		writeError(w, status, code, fmt.Sprintf("Unable to prepare %s workspace: %v", name, err), timeoutDetails(err))
+		return nil, false
+	}
+	return job, true
+}
+
+// removeWorkspace runs the teardown hooks of a finished job, releases its
+// services and deletes its workspace
+func removeWorkspace(job *ExecJob) {
+	runTeardownHooks(job)
+	releaseServices(job)
+
+	if injectFault(faults.failedCleanup) {
+		log.Printf("Warning: Unable to delete workspace %s: injected fault", job.Dir)
+		return
+	}
+
+	err := os.RemoveAll(job.Dir)
+	if err != nil {
+		log.Printf("Warning: Unable to delete workspace %s: %v", job.Dir, err)
+	}
+}
+
+// judge runs the job against every test case of req, stopping at the first
+// failure unless all cases were requested. Interactive problems are run
+// against inter; otherwise outputs are checked by chk if it is set, or
+// compared with the expected outputs.
+func judge(ctx context.Context, lang *Language, job *ExecJob, req *JudgeRequest, chk *checker, inter *interactor) *JudgeResponse {
+	response := &JudgeResponse{Verdict: VerdictAccepted}
+
+	job.TimeLimit = req.TimeLimitReruns.extend(time.Duration(req.TimeLimitMs) * time.Millisecond)
+	job.CPUTimeLimit = req.TimeLimitReruns.extend(time.Duration(req.CPUTimeLimitMs) * time.Millisecond)
+	job.MemoryLimit = req.MemoryLimitMB << 20
+
+	for i, tc := range req.TestCases {
+		// Once a case has failed, the rest are skipped unless all cases were requested
+		if response.Verdict != VerdictAccepted && !req.RunAllCases {
+			response.Cases = append(response.Cases, TestCaseResult{Index: i, Verdict: VerdictSkipped})
+			continue
+		}
+
+		caseResult, result := runTestCase(ctx, lang, job, req, i, tc, chk, inter)
+		caseResult.encodeOutputs()
+		response.Cases = append(response.Cases, caseResult)
+
+		// A compile error fails every case the same way
+		if caseResult.Verdict == VerdictCompileError {
+			response.Verdict = VerdictCompileError
+			if result != nil {
+				response.Diagnostics = result.Diagnostics
+			}
+			for j := i + 1; j < len(req.TestCases); j++ {
+				response.Cases = append(response.Cases, TestCaseResult{Index: j, Verdict: VerdictSkipped})
+			}
+			break
+		}
+
+		if response.Verdict == VerdictAccepted && caseResult.Verdict != VerdictAccepted {
+			response.Verdict = caseResult.Verdict
+		}
+	}
+
+	return response
+}
+
+// runTestCase runs the job against one test case and judges it
+func runTestCase(ctx context.Context, lang *Language, job *ExecJob, req *JudgeRequest, index int, tc TestCase, chk *checker, inter *interactor) (TestCaseResult, *ExecResult) {
+	tc, err := loadBundledData(req, tc)
+	if err != nil {
+		return TestCaseResult{Index: index, Verdict: VerdictInternalError, Message: err.Error()}, nil
+	}
+	job.Stdin = tc.Input
+	job.Usage = nil
+
+	if inter != nil {
+		run := inter.interact(ctx, lang, job, tc)
+		return judgeInteraction(index, job, run), run.result
+	}
+
+	// A case failing because of the agent rather than the code is run again
+	// once when config.RetryInfraFailures is set
+	var streams *testStreams
+	var result *ExecResult
+	retried := false
+	for {
+		streams, err = openTestStreams(ctx, job, tc)
+		if err != nil {
+			return TestCaseResult{Index: index, Verdict: VerdictInternalError, Message: err.Error()}, nil
+		}
+
+		result, err = lang.Run(ctx, job)
+		if retried || !config.RetryInfraFailures || !isInfraFailure(err) || ctx.Err() != nil {
+			break
+		}
+		streams.close()
+		job.Usage = nil
+
+		log.Printf("Warning: retrying test case %d of execution %s: %s", index, executionID(ctx), err)
+		retried = true
+	}
+	defer streams.close()
+
+	caseResult := judgeTestCase(index, job, result, err)
+	caseResult.Retried = retried
+	if err := streams.disconnect(); err != nil && caseResult.Verdict != VerdictInternalError {
+		caseResult.Verdict = VerdictInternalError
+		caseResult.Message = fmt.Sprintf("failed to fetch the input: %s", err)
+	}
+	if req.TimeLimitReruns != nil {
+		caseResult, result = rerunBorderline(ctx, lang, job, req, index, caseResult, result)
+	}
+
+	if caseResult.Verdict == VerdictAccepted {
+		if chk != nil {
+			caseResult.Verdict, caseResult.Message = chk.check(ctx, tc, caseResult.Stdout)
+		} else if req.Function != nil {
+			if !harnessOutputsMatch(tc.ExpectedOutput, caseResult.Stdout) {
+				caseResult.Verdict = VerdictWrongAnswer
+			}
+		} else if streams != nil && streams.expected != nil {
+			caseResult.Verdict, caseResult.Message, caseResult.Diff = streams.expected.verdict()
+		} else if req.Comparison != nil {
+			if diff, message := req.Comparison.compare(tc.ExpectedOutput, caseResult.Stdout); diff != nil {
+				caseResult.Verdict = VerdictWrongAnswer
+				caseResult.Message = message
+				caseResult.Diff = diff
+			}
+		} else if diff := diffOutputs(tc.ExpectedOutput, caseResult.Stdout); diff != nil {
+			caseResult.Verdict = VerdictWrongAnswer
+			caseResult.Message = diff.summary()
+			caseResult.Diff = diff
+		}
+	}
+
+	return caseResult, result
+}
+
+// judgeTestCase turns the outcome of running one test case into its verdict.
+// A program that ran successfully is ACCEPTED; its output is checked separately.
+func judgeTestCase(index int, job *ExecJob, result *ExecResult, err error) TestCaseResult {
+	caseResult := TestCaseResult{Index: index}
+	if job.Usage != nil {
+		caseResult.TimeMs = job.Usage.TimeMs
+		caseResult.CPUTimeMs = job.Usage.CPUTimeMs
+		caseResult.MemoryKB = job.Usage.MemoryKB
+		caseResult.Timeline = job.Usage.Timeline
+	}
+	if result != nil {
+		caseResult.Stdout = result.Stdout
+		caseResult.Stderr = result.Stderr
+	}
+
+	caseResult.Verdict = verdictOf(err)
+	switch timeout := timedOutPhase(err); {
+	case timeout != nil && timeout.Phase == PhaseCompile:
+		caseResult.TimeLimit = TimeLimitCompile
+	case errors.Is(err, errCPUTimeLimit):
+		caseResult.TimeLimit = TimeLimitCPU
+	case errors.Is(err, errExecutionTimeout):
+		caseResult.TimeLimit = TimeLimitWall
+	}
+
+	if err != nil {
+		caseResult.Message = err.Error()
+		caseResult.Infrastructure = isInfraFailure(err)
+	}
+
+	return caseResult
+}
+
+// normalizeOutput drops trailing whitespace on each line and trailing blank
+// lines, which are ignored when comparing outputs
+func normalizeOutput(output string) string {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
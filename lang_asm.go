@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "asm",
+		SourceFile: "main.asm",
+		Run:        runAsm,
+	})
+}
+
+// runAsm assembles main.asm with nasm, links it with ld and runs the binary
+func runAsm(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	// Step 1: Assemble
+	res, err := runCompiler(ctx, command{
+		Name: "nasm",
+		Args: []string{"-f", "elf64", "-g", "-o", "main.o", "main.asm"},
+		Dir:  job.Dir,
+	})
+	assemble := newPhaseResult("assemble", res)
+	diagnostics := parseNasmDiagnostics(res.Stderr)
+	if err != nil {
+		result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Diagnostics: diagnostics, Phases: []PhaseResult{assemble}}
+		if res.ExitCode != 0 {
+			return result, fmt.Errorf("%w: %s", errCompilation, err)
+		}
+		return result, err
+	}
+
+	// Step 2: Link
+	res, err = runCompiler(ctx, command{
+		Name: "ld",
+		Args: []string{"-o", "main", "main.o"},
+		Dir:  job.Dir,
+	})
+	link := newPhaseResult("link", res)
+	if err != nil {
+		result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Diagnostics: diagnostics, Phases: []PhaseResult{assemble, link}}
+		if res.ExitCode != 0 {
+			return result, fmt.Errorf("%w: %s", errCompilation, err)
+		}
+		return result, err
+	}
+
+	// Step 3: Run the binary
+	res, err = runProgram(ctx, job, command{
+		Name: filepath.Join(job.Dir, "main"),
+		Dir:  job.Dir,
+	})
+	run := newPhaseResult("run", res)
+
+	return &ExecResult{
+		Stdout:      res.Stdout,
+		Stderr:      res.Stderr,
+		Diagnostics: diagnostics,
+		Phases:      []PhaseResult{assemble, link, run},
+	}, err
+}
+
+// nasmDiagnostic matches e.g. "main.asm:3: error: parser: instruction expected"
+var nasmDiagnostic = regexp.MustCompile(`(?m)^(\S+?):(\d+): (error|warning): (.*)$`)
+
+// parseNasmDiagnostics extracts the errors and warnings from nasm output
+func parseNasmDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, match := range nasmDiagnostic.FindAllStringSubmatch(output, -1) {
+		lineNum, _ := strconv.Atoi(match[2])
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Severity: match[3],
+			Message:  match[4],
+		})
+	}
+	return diagnostics
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	runtimes := []string{"babashka"}
+	if config.ClojureJVM {
+		runtimes = append(runtimes, "jvm")
+	}
+
+	registerLanguage(&Language{
+		Name:       "clojure",
+		SourceFile: "main.clj",
+		Runtimes:   runtimes,
+		Run:        runClojure,
+		Failure:    clojureFailure,
+	})
+}
+
+// clojureFailure picks the exception out of babashka's error report
+// ("Type:", "Message:" and "Location:" lines) or clojure's
+// ("Execution error (ArithmeticException) at ..." followed by the message)
+var clojureFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^Type:\s+(?P<type>\S+)\s*\nMessage:\s+(?P<message>.*)$`),
+		regexp.MustCompile(`(?m)^Execution error \((?P<type>\w+)\) at .*?(?:\((?P<file>[^\s():]+):(?P<line>\d+)\))?\.\n(?P<message>.*)$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^Location:\s+(?P<file>[^\s:]+):(?P<line>\d+):(?P<column>\d+)`),
+	},
+}
+
+// runClojure runs main.clj with babashka, or with JVM clojure when selected
+func runClojure(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	if job.Request.Runtime == "jvm" {
+		return runClojureJVM(ctx, job)
+	}
+
+	res, err := runProgram(ctx, job, command{
+		Name: "bb",
+		Args: []string{"main.clj"},
+		Dir:  job.Dir,
+	})
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr}
+
+	if err != nil && babashkaCompilePhase.MatchString(res.Stderr) {
+		result.Diagnostics = parseBabashkaDiagnostics(res.Stderr)
+		return result, fmt.Errorf("%w: %s", errCompilation, err)
+	}
+
+	return result, err
+}
+
+// runClojureJVM runs main.clj with the clojure CLI
+func runClojureJVM(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	res, err := runProgram(ctx, job, command{
+		Name:    "clojure",
+		Args:    []string{"-M", "main.clj"},
+		Dir:     job.Dir,
+		Timeout: 60 * time.Second,
+	})
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr}
+
+	if err != nil {
+		diagnostics := parseClojureDiagnostics(res.Stderr)
+		if len(diagnostics) > 0 {
+			result.Diagnostics = diagnostics
+			return result, fmt.Errorf("%w: %s", errCompilation, err)
+		}
+	}
+
+	return result, err
+}
+
+var (
+	// babashkaCompilePhase matches the phase babashka reports for reader and analysis errors
+	babashkaCompilePhase = regexp.MustCompile(`(?m)^Phase:\s+(parse|analysis|compile)\s*$`)
+
+	// babashkaMessage and babashkaLocation match the fields of babashka's error report
+	babashkaMessage  = regexp.MustCompile(`(?m)^Message:\s+(.*)$`)
+	babashkaLocation = regexp.MustCompile(`(?m)^Location:\s+(\S+?):(\d+):(\d+)\s*$`)
+
+	// clojureSyntaxError matches e.g. "Syntax error compiling at (main.clj:3:1).\nUnable to resolve symbol: foo in this context"
+	clojureSyntaxError = regexp.MustCompile(`Syntax error (?:\(\w+\) )?(?:reading source|compiling|macroexpanding)(?: \S+)? at \((\S+?):(\d+):(\d+)\)\.\n(.*)`)
+)
+
+// parseBabashkaDiagnostics extracts the reader or analysis error from babashka's error report
+func parseBabashkaDiagnostics(output string) []Diagnostic {
+	location := babashkaLocation.FindStringSubmatch(output)
+	if location == nil {
+		return nil
+	}
+
+	lineNum, _ := strconv.Atoi(location[2])
+	colNum, _ := strconv.Atoi(location[3])
+
+	message := "syntax error"
+	if match := babashkaMessage.FindStringSubmatch(output); match != nil {
+		message = strings.TrimSpace(match[1])
+	}
+
+	return []Diagnostic{{
+		File:     location[1],
+		Line:     lineNum,
+		Column:   colNum,
+		Severity: "error",
+		Message:  message,
+	}}
+}
+
+// parseClojureDiagnostics extracts the reader and compiler errors from clojure output
+func parseClojureDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, match := range clojureSyntaxError.FindAllStringSubmatch(output, -1) {
+		lineNum, _ := strconv.Atoi(match[2])
+		colNum, _ := strconv.Atoi(match[3])
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Column:   colNum,
+			Severity: "error",
+			Message:  strings.TrimSpace(match[4]),
+		})
+	}
+	return diagnostics
+}
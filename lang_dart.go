@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// dartCompileErrorExitCode is the exit code dart uses when the program fails to compile
+const dartCompileErrorExitCode = 254
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "dart",
+		SourceFile: "bin/main.dart",
+		Template:   filepath.Join(os.TempDir(), "dummy-pkg-dart"),
+		Run:        runDart,
+		Failure:    dartFailure,
+	})
+}
+
+// dartFailure picks the unhandled exception out of dart's stderr, e.g.
+// "Unhandled exception:\nException: boom" and its stack trace
+var dartFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^Unhandled exception:\n(?:(?P<type>[A-Z]\w*): )?(?P<message>.*)$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(`\((?:file://)?(?P<file>[^\s()]+\.dart):(?P<line>\d+)(?::(?P<column>\d+))?\)`),
+	},
+}
+
+// runDart runs bin/main.dart inside a copy of the Dart template project, whose
+// dependencies have already been resolved so no pub get is needed
+func runDart(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	res, err := runProgram(ctx, job, command{
+		Name: "dart",
+		Args: []string{"run", "--no-pub", "bin/main.dart"},
+		Dir:  job.Dir,
+	})
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr}
+
+	if err != nil && res.ExitCode == dartCompileErrorExitCode {
+		result.Diagnostics = parseDartDiagnostics(res.Stdout + res.Stderr)
+		return result, fmt.Errorf("%w: %s", errCompilation, err)
+	}
+
+	return result, err
+}
+
+// dartDiagnostic matches e.g. "bin/main.dart:3:5: Error: Expected ';' after this."
+var dartDiagnostic = regexp.MustCompile(`(?m)^(\S+?\.dart):(\d+):(\d+): (Error|Warning|Context): (.*)$`)
+
+// parseDartDiagnostics extracts the compiler messages from dart output
+func parseDartDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, match := range dartDiagnostic.FindAllStringSubmatch(output, -1) {
+		if match[4] == "Context" {
+			continue
+		}
+		lineNum, _ := strconv.Atoi(match[2])
+		colNum, _ := strconv.Atoi(match[3])
+		severity := "error"
+		if match[4] == "Warning" {
+			severity = "warning"
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Column:   colNum,
+			Severity: severity,
+			Message:  match[5],
+		})
+	}
+	return diagnostics
+}
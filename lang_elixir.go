@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// elixirPool runs scripts in prewarmed BEAM instances: each one reads its
+// workspace directory from stdin, changes into it and requires main.exs
+var elixirPool = newWarmPool("elixir", "-e",
+	`File.cd!(String.trim(IO.read(:stdio, :line))); Code.require_file("main.exs")`)
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "elixir",
+		SourceFile: "main.exs",
+		Run:        runElixir,
+		Warm:       true,
+		Failure:    elixirFailure,
+	})
+}
+
+// elixirFailure picks the exception out of elixir's stderr, e.g.
+// "** (ArithmeticError) bad argument in arithmetic expression" and its stack trace
+var elixirFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^\*\* \((?P<type>[\w.]+)\) (?:(?P<file>[^\s:]+\.exs?):(?P<line>\d+):(?:(?P<column>\d+):)? )?(?P<message>.*)$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^\s+(?:\([\w .-]+\) )?(?P<file>[^\s:()]+\.exs?):(?P<line>\d+)(?::(?P<column>\d+))?`),
+	},
+}
+
+// runElixir runs main.exs in a prewarmed elixir process
+func runElixir(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	res, err := elixirPool.run(ctx, job)
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Diagnostics: parseElixirDiagnostics(res.Stderr)}
+
+	if err != nil && elixirCompileError.MatchString(res.Stderr) {
+		return result, fmt.Errorf("%w: %s", errCompilation, err)
+	}
+
+	return result, err
+}
+
+// elixirCompileError matches the exceptions raised when a script fails to compile
+var elixirCompileError = regexp.MustCompile(`\*\* \((CompileError|SyntaxError|TokenMissingError|MismatchedDelimiterError)\)`)
+
+var (
+	// elixirDiagnosticHeader matches "error: ..." or "warning: ..." at the start of a message
+	elixirDiagnosticHeader = regexp.MustCompile(`^\s*(error|warning): (.*)$`)
+
+	// elixirDiagnosticLocation matches the location trailer of a message, e.g. "└─ main.exs:3:5"
+	elixirDiagnosticLocation = regexp.MustCompile(`└─ (\S+?):(\d+)(?::(\d+))?`)
+
+	// elixirExceptionLocation matches e.g. "** (SyntaxError) main.exs:3:5: syntax error before: ')'"
+	elixirExceptionLocation = regexp.MustCompile(`^\*\* \((\w+)\) (?:invalid syntax found on )?(\S+?):(\d+)(?::(\d+))?:\s*(.*)$`)
+)
+
+// parseElixirDiagnostics extracts the errors and warnings from elixir output
+func parseElixirDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	var pending *Diagnostic
+
+	for _, line := range strings.Split(output, "\n") {
+		if match := elixirDiagnosticHeader.FindStringSubmatch(line); match != nil {
+			pending = &Diagnostic{Severity: match[1], Message: strings.TrimSpace(match[2])}
+			continue
+		}
+
+		if match := elixirDiagnosticLocation.FindStringSubmatch(line); match != nil && pending != nil {
+			pending.File = match[1]
+			pending.Line, _ = strconv.Atoi(match[2])
+			pending.Column, _ = strconv.Atoi(match[3])
+			diagnostics = append(diagnostics, *pending)
+			pending = nil
+			continue
+		}
+
+		if match := elixirExceptionLocation.FindStringSubmatch(line); match != nil {
+			lineNum, _ := strconv.Atoi(match[3])
+			colNum, _ := strconv.Atoi(match[4])
+			message := strings.TrimSpace(match[5])
+			if message == "" {
+				message = match[1]
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     match[2],
+				Line:     lineNum,
+				Column:   colNum,
+				Severity: "error",
+				Message:  message,
+			})
+		}
+	}
+
+	return diagnostics
+}
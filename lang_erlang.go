@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// erlangCompileFailedExitCode is the exit code the erlang worker uses when main.erl fails to compile
+const erlangCompileFailedExitCode = 2
+
+// erlangPool runs escript-style programs in prewarmed BEAM instances: each one
+// reads its workspace directory from stdin, compiles main.erl in memory and
+// calls main:main([])
+var erlangPool = newWarmPool("erl", "-noshell", "-eval", `
+	{ok, [Dir]} = io:fread("", "~s"),
+	ok = file:set_cwd(Dir),
+	case compile:file("main.erl", [binary, report]) of
+		{ok, Mod, Bin} ->
+			{module, Mod} = code:load_binary(Mod, "main.erl", Bin),
+			try Mod:main([]) of
+				_ -> halt(0)
+			catch Class:Reason:Stack ->
+				io:format(standard_error, "~p: ~p~n~p~n", [Class, Reason, Stack]),
+				halt(1)
+			end;
+		error ->
+			halt(2)
+	end.`)
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "erlang",
+		SourceFile: "main.erl",
+		Run:        runErlang,
+		Warm:       true,
+		Failure:    erlangFailure,
+	})
+}
+
+// erlangFailure picks the exception the erlang worker prints out of its
+// stderr, e.g. "error: badarith" followed by the stack trace
+var erlangFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^(?P<type>error|throw|exit): (?P<message>.*)$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(`\{file,"(?P<file>[^"]+)"\},\s*\{line,(?P<line>\d+)\}`),
+	},
+}
+
+// runErlang runs main.erl, written like an escript, in a prewarmed erl process
+func runErlang(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	err := prepareErlangSource(job.SourcePath)
+	if err != nil {
+		return &ExecResult{}, err
+	}
+
+	res, err := erlangPool.run(ctx, job)
+	output := res.Stdout + res.Stderr
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Diagnostics: parseErlangDiagnostics(output)}
+
+	if err != nil && res.ExitCode == erlangCompileFailedExitCode && hasErrorDiagnostic(result.Diagnostics) {
+		return result, fmt.Errorf("%w: %s", errCompilation, err)
+	}
+
+	return result, err
+}
+
+// prepareErlangSource turns an escript into a compilable module: the shebang
+// is commented out and, if missing, the module and export attributes are added
+// without shifting the line numbers reported by the compiler
+func prepareErlangSource(path string) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	code := string(source)
+	if strings.HasPrefix(code, "#!") {
+		code = "%" + code
+	}
+
+	if !strings.Contains(code, "-module(") {
+		code = "-module(main).\n-export([main/1]).\n-file(\"main.erl\", 1).\n" + code
+	}
+
+	return os.WriteFile(path, []byte(code), 0644)
+}
+
+// erlangDiagnostic matches a compiler message, e.g. "main.erl:3:5: syntax error before: ')'"
+var erlangDiagnostic = regexp.MustCompile(`(?m)^(\S+?\.erl):(\d+)(?::(\d+))?: (Warning: )?(.*)$`)
+
+// parseErlangDiagnostics extracts the errors and warnings from erlc-style output
+func parseErlangDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, match := range erlangDiagnostic.FindAllStringSubmatch(output, -1) {
+		lineNum, _ := strconv.Atoi(match[2])
+		colNum, _ := strconv.Atoi(match[3])
+		severity := "error"
+		if match[4] != "" {
+			severity = "warning"
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Column:   colNum,
+			Severity: severity,
+			Message:  strings.TrimSpace(match[5]),
+		})
+	}
+	return diagnostics
+}
+
+// hasErrorDiagnostic reports whether any of diagnostics is an error
+func hasErrorDiagnostic(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == "error" {
+			return true
+		}
+	}
+	return false
+}
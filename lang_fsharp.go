@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// dotnetEnv keeps the dotnet CLI from doing first-run work or phoning home on every execution
+var dotnetEnv = []string{
+	"DOTNET_CLI_TELEMETRY_OPTOUT=1",
+	"DOTNET_NOLOGO=1",
+	"DOTNET_SKIP_FIRST_TIME_EXPERIENCE=1",
+}
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "fsharp",
+		SourceFile: "main.fsx",
+		Run:        runFSharp,
+		Failure:    fsharpFailure,
+	})
+}
+
+// fsharpFailure picks the exception out of dotnet fsi's stderr, e.g.
+// "System.DivideByZeroException: Attempted to divide by zero." and its stack trace
+var fsharpFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^(?:Unhandled exception\. )?(?P<type>System\.[\w.]+|[\w.]+Exception): (?P<message>.*)$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(` in (?P<file>\S+\.fsx?):line (?P<line>\d+)`),
+	},
+}
+
+// runFSharp runs main.fsx as a script with dotnet fsi
+func runFSharp(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	res, err := runProgram(ctx, job, command{
+		Name:    "dotnet",
+		Args:    []string{"fsi", "--quiet", "--exec", "main.fsx"},
+		Dir:     job.Dir,
+		Env:     dotnetEnv,
+		Timeout: 60 * time.Second,
+	})
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Diagnostics: parseFSharpDiagnostics(res.Stdout + res.Stderr)}
+
+	if err != nil && hasErrorDiagnostic(result.Diagnostics) {
+		return result, fmt.Errorf("%w: %s", errCompilation, err)
+	}
+
+	return result, err
+}
+
+// fsharpDiagnostic matches e.g. "main.fsx(3,5): error FS0039: The value or constructor 'foo' is not defined."
+var fsharpDiagnostic = regexp.MustCompile(`(?m)^(\S+?)\((\d+),(\d+)\): (error|warning) (FS\d+: .*)$`)
+
+// parseFSharpDiagnostics extracts the compiler messages from fsi output
+func parseFSharpDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, match := range fsharpDiagnostic.FindAllStringSubmatch(output, -1) {
+		lineNum, _ := strconv.Atoi(match[2])
+		colNum, _ := strconv.Atoi(match[3])
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Column:   colNum,
+			Severity: match[4],
+			Message:  match[5],
+		})
+	}
+	return diagnostics
+}
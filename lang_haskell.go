@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "haskell",
+		SourceFile: "Main.hs",
+		Run:        runHaskell,
+		Failure:    haskellFailure,
+	})
+}
+
+// haskellFailure picks the exception out of the program's stderr, e.g.
+// "main: Prelude.head: empty list" and its call stack
+var haskellFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^main: (?P<message>.*)$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(`(?P<file>[^\s:()]+\.hs):\(?(?P<line>\d+)[,:](?P<column>\d+)`),
+	},
+}
+
+// runHaskell compiles Main.hs with ghc and runs the resulting binary
+func runHaskell(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	// Step 1: Compile, keeping the intermediate files out of the way
+	res, err := compileCached(ctx, job, command{
+		Name: "ghc",
+		Args: []string{"-v0", "-O0", "-outputdir", "build", "-o", "main", "Main.hs"},
+		Dir:  job.Dir,
+	}, "main")
+	if err != nil {
+		result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Diagnostics: parseGHCDiagnostics(res.Stderr)}
+		if res.ExitCode != 0 {
+			return result, fmt.Errorf("%w: %s", errCompilation, err)
+		}
+		return result, err
+	}
+	warnings := parseGHCDiagnostics(res.Stderr)
+
+	// Step 2: Run the binary
+	res, err = runProgram(ctx, job, command{
+		Name: filepath.Join(job.Dir, "main"),
+		Dir:  job.Dir,
+	})
+	return &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Diagnostics: warnings}, err
+}
+
+// ghcDiagnosticHeader matches the first line of a GHC message, e.g.
+// "Main.hs:3:5: error:" or "Main.hs:(3,5)-(4,10): warning: [-Wunused-binds]"
+var ghcDiagnosticHeader = regexp.MustCompile(`^(\S+?):(?:(\d+):(\d+)(?:-\d+)?|\((\d+),(\d+)\)-\(\d+,\d+\)): (error|warning)(?::|\s|$)(.*)$`)
+
+// ghcSourceExcerpt matches the source excerpt lines ghc prints under a message, e.g. "3 | main = foo"
+var ghcSourceExcerpt = regexp.MustCompile(`^\d*\s*\|`)
+
+// parseGHCDiagnostics extracts the errors and warnings from ghc output
+func parseGHCDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+
+	for _, line := range strings.Split(output, "\n") {
+		match := ghcDiagnosticHeader.FindStringSubmatch(line)
+		if match != nil {
+			lineStr, colStr := match[2], match[3]
+			if lineStr == "" {
+				lineStr, colStr = match[4], match[5]
+			}
+			lineNum, _ := strconv.Atoi(lineStr)
+			colNum, _ := strconv.Atoi(colStr)
+
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     match[1],
+				Line:     lineNum,
+				Column:   colNum,
+				Severity: match[6],
+				Message:  strings.TrimSpace(match[7]),
+			})
+			continue
+		}
+
+		// The body of a message is indented below its header
+		if len(diagnostics) > 0 && strings.HasPrefix(line, " ") {
+			last := &diagnostics[len(diagnostics)-1]
+			text := strings.TrimSpace(line)
+			if text == "" || ghcSourceExcerpt.MatchString(text) {
+				continue
+			}
+			if last.Message == "" {
+				last.Message = text
+			} else {
+				last.Message += "\n" + text
+			}
+		}
+	}
+
+	return diagnostics
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Frontend exercises submit an HTML page (with its scripts inline or as
+// workspace files) that is tested in a headless Chromium with Playwright. The
+// template has Playwright and the exercise's DOM tests installed, and its
+// playwright.config reads the browser settings passed in the environment:
+//
+//   - PLAYWRIGHT_BROWSERS_PATH points at the preinstalled browsers, which are
+//     never downloaded per request
+//   - OCTREE_CHROMIUM_SANDBOX is "1" if Chromium should run with its sandbox
+//     (chromiumSandbox), which needs unprivileged user namespaces on the host
+//
+// Exercises with their own tests use named templates.
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "html",
+		SourceFile: "index.html",
+		Template:   filepath.Join(os.TempDir(), "dummy-pkg-browser"),
+		Run:        runBrowserTests,
+	})
+}
+
+// browserTestTimeout bounds a browser test run, which includes starting Chromium
+const browserTestTimeout = 120 * time.Second
+
+// runBrowserTests runs the template's Playwright tests against index.html. The
+// JSON report is returned as an artifact.
+func runBrowserTests(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	reportDir := filepath.Join(job.Dir, "report")
+
+	sandbox := "0"
+	if config.BrowserSandbox {
+		sandbox = "1"
+	}
+
+	res, err := runProgram(ctx, job, command{
+		Name: "npx",
+		Args: []string{"--no-install", "playwright", "test", "--reporter=line,json"},
+		Dir:  job.Dir,
+		Env: []string{
+			"PLAYWRIGHT_BROWSERS_PATH=" + config.PlaywrightBrowsersDir,
+			"PLAYWRIGHT_SKIP_BROWSER_DOWNLOAD=1",
+			"PLAYWRIGHT_JSON_OUTPUT_NAME=" + filepath.Join(reportDir, "report.json"),
+			"OCTREE_CHROMIUM_SANDBOX=" + sandbox,
+			"CI=1",
+		},
+		Timeout: browserTestTimeout,
+	})
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr}
+
+	artifacts, artifactErr := collectArtifacts(job, "report", map[string]string{".json": "application/json"})
+	if artifactErr != nil {
+		log.Printf("Warning: failed to collect browser test report: %s", artifactErr)
+	}
+	result.Artifacts = artifacts
+
+	return result, err
+}
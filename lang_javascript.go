@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "javascript",
+		SourceFile: "main.js",
+		Run:        runJavaScript,
+		Failure:    nodeFailure,
+		Harness:    javaScriptHarness,
+		Profiling:  true,
+	})
+}
+
+// nodeFailure picks the uncaught error out of node's stderr, e.g.
+// "TypeError: f is not a function" and its stack trace, or the source line
+// printed above a syntax error
+var nodeFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^(?P<type>(?:[A-Z][\w.]*)?(?:Error|Exception))(?: \[\w+\])?: (?P<message>.*)$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^\s+at (?:.* \()?(?:file://)?(?P<file>[^\s()]+):(?P<line>\d+):(?P<column>\d+)\)?$`),
+		regexp.MustCompile(`(?m)^(?P<file>\S+\.[cm]?[jt]s):(?P<line>\d+)$`),
+	},
+}
+
+// runJavaScript runs the submitted file with node. In profile mode node writes
+// a CPU profile, which is returned as an artifact along with its summary.
+func runJavaScript(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	profileDir := filepath.Join(job.Dir, "profile")
+
+	args := []string{job.SourcePath}
+	if job.Request.Mode == ModeProfile {
+		args = append([]string{"--cpu-prof", "--cpu-prof-dir=" + profileDir}, args...)
+	}
+	args = append(nodeStackSizeArgs(job), args...)
+
+	res, err := runProgram(ctx, job, command{
+		Name:    "node",
+		Args:    args,
+		Dir:     job.Dir,
+		Timeout: 60 * time.Second,
+	})
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr}
+
+	if job.Request.Mode == ModeProfile {
+		artifacts, artifactErr := collectArtifacts(job, "profile", map[string]string{".cpuprofile": "application/json"})
+		if artifactErr != nil {
+			log.Printf("Warning: failed to collect profile: %s", artifactErr)
+		}
+		result.Artifacts = artifacts
+
+		if len(artifacts) > 0 {
+			result.Profile, artifactErr = summarizeCPUProfile(artifacts[0].Data, job.Dir)
+			if artifactErr != nil {
+				log.Printf("Warning: failed to summarize profile: %s", artifactErr)
+			}
+		}
+	}
+
+	return result, err
+}
+
+// javaScriptStructures declares the harness data structures the way LeetCode-style problems expect them
+var javaScriptStructures = map[string]structureDefinition{
+	"ListNode": {"ListNode", `function ListNode(val, next) {
+  this.val = val === undefined ? 0 : val;
+  this.next = next === undefined ? null : next;
+}`},
+	"TreeNode": {"TreeNode", `function TreeNode(val, left, right) {
+  this.val = val === undefined ? 0 : val;
+  this.left = left === undefined ? null : left;
+  this.right = right === undefined ? null : right;
+}`},
+	"GraphNode": {"Node", `function Node(val, neighbors) {
+  this.val = val === undefined ? 0 : val;
+  this.neighbors = neighbors === undefined ? [] : neighbors;
+}`},
+	"Interval": {"Interval", `function Interval(start, end) {
+  this.start = start;
+  this.end = end;
+}`},
+}
+
+// javaScriptHarness calls fn with the arguments read from stdin and prints its
+// return value, awaited if it is a promise
+func javaScriptHarness(code string, fn *FunctionSpec) string {
+	return code + missingDefinitions(code, fn, javaScriptStructures) + fmt.Sprintf(`
+;(() => {
+  %s
+  let __octreeInput = "";
+  process.stdin.on("data", (chunk) => { __octreeInput += chunk; });
+  process.stdin.on("end", () => {
+    const __octreePrint = (value) => process.stdout.write("\n" + JSON.stringify(__octreeCodec.encode(__octreeReturnType, value)) + "\n");
+    const __octreeArgs = JSON.parse(__octreeInput).map((arg, i) => __octreeCodec.decode(__octreeParamTypes[i] || "", arg));
+    const __octreeResult = %s(...__octreeArgs);
+    if (__octreeResult && typeof __octreeResult.then === "function") {
+      __octreeResult.then(__octreePrint);
+    } else {
+      __octreePrint(__octreeResult);
+    }
+  });
+})();
+`, javaScriptCodec(fn, javaScriptStructures), fn.Name)
+}
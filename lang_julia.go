@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// juliaTimingFile is where the julia worker records compile and wall time, in milliseconds
+const juliaTimingFile = ".timing"
+
+// juliaPool keeps julia processes started ahead of time, hiding julia's
+// startup latency. Each one reads its workspace directory from stdin,
+// includes main.jl and records how much of the run was spent compiling.
+var juliaPool = newWarmPool("julia", "--startup-file=no", "--history-file=no", "-e", `
+	dir = strip(readline())
+	cd(dir)
+	Base.cumulative_compile_timing(true)
+	t0 = time_ns()
+	try
+		include(joinpath(dir, "main.jl"))
+	finally
+		wall = (time_ns() - t0) ÷ 1_000_000
+		compile = first(Base.cumulative_compile_time_ns()) ÷ 1_000_000
+		write(joinpath(dir, "`+juliaTimingFile+`"), string(compile, " ", wall))
+	end`)
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "julia",
+		SourceFile: "main.jl",
+		Run:        runJulia,
+		Warm:       true,
+		Failure:    juliaFailure,
+	})
+}
+
+// juliaFailure picks the error out of julia's stderr, e.g.
+// "ERROR: LoadError: DivideError: integer division error" and its stack trace
+var juliaFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^ERROR: (?:LoadError: )?(?:(?P<type>[A-Z]\w*): )?(?P<message>.*)$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^\s+@ (?:\S+ )?(?P<file>\S+\.jl):(?P<line>\d+)`),
+		regexp.MustCompile(`(?m)^in expression starting at (?P<file>\S+):(?P<line>\d+)$`),
+	},
+}
+
+// runJulia runs main.jl in a prewarmed julia process
+func runJulia(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	res, err := juliaPool.run(ctx, job)
+	result := &ExecResult{
+		Stdout: res.Stdout,
+		Stderr: res.Stderr,
+		Timing: readJuliaTiming(job.Dir),
+	}
+
+	if err != nil && juliaParseError.MatchString(res.Stderr) {
+		result.Diagnostics = parseJuliaDiagnostics(res.Stderr)
+		return result, fmt.Errorf("%w: %s", errCompilation, err)
+	}
+
+	return result, err
+}
+
+// readJuliaTiming reads the timing file written by the worker, if it got that far
+func readJuliaTiming(dir string) *ExecTiming {
+	data, err := readWorkspaceFile(dir, juliaTimingFile)
+	if err != nil {
+		return nil
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return nil
+	}
+
+	compile, _ := strconv.ParseInt(fields[0], 10, 64)
+	wall, _ := strconv.ParseInt(fields[1], 10, 64)
+	return &ExecTiming{CompileMs: compile, WallMs: wall}
+}
+
+var (
+	// juliaParseError matches the errors julia reports when main.jl can't be parsed
+	juliaParseError = regexp.MustCompile(`(ParseError|syntax:)`)
+
+	// juliaErrorLocation matches "# Error @ /path/main.jl:3:5" or "in expression starting at /path/main.jl:3"
+	juliaErrorLocation = regexp.MustCompile(`(?:# Error @ |in expression starting at )(\S+?):(\d+)(?::(\d+))?`)
+
+	// juliaErrorMessage matches the first line of an uncaught error
+	juliaErrorMessage = regexp.MustCompile(`(?m)^ERROR: (?:LoadError: )?(.*)$`)
+)
+
+// parseJuliaDiagnostics extracts the location of a parse error from julia output
+func parseJuliaDiagnostics(output string) []Diagnostic {
+	location := juliaErrorLocation.FindStringSubmatch(output)
+	if location == nil {
+		return nil
+	}
+
+	lineNum, _ := strconv.Atoi(location[2])
+	colNum, _ := strconv.Atoi(location[3])
+
+	message := "syntax error"
+	if match := juliaErrorMessage.FindStringSubmatch(output); match != nil {
+		message = strings.TrimSpace(match[1])
+	}
+
+	return []Diagnostic{{
+		File:     filepath.Base(location[1]),
+		Line:     lineNum,
+		Column:   colNum,
+		Severity: "error",
+		Message:  message,
+	}}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// luaRuntime is an installed Lua implementation
+type luaRuntime struct {
+	// interpreter runs a script
+	interpreter string
+
+	// check is the command that only parses the script, reporting syntax errors
+	check []string
+}
+
+var luaRuntimes = map[string]luaRuntime{
+	"5.4":    {interpreter: "lua5.4", check: []string{"luac5.4", "-p", "main.lua"}},
+	"luajit": {interpreter: "luajit", check: []string{"luajit", "-b", "main.lua", os.DevNull}},
+}
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "lua",
+		SourceFile: "main.lua",
+		Runtimes:   []string{"5.4", "luajit"},
+		Run:        runLua,
+		Failure:    luaFailure,
+	})
+}
+
+// luaFailure picks the error out of the interpreter's stderr, e.g.
+// "lua: main.lua:3: attempt to call a nil value (global 'f')"
+var luaFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^(?:lua|luajit)[\d.]*: (?:(?P<file>[^\s:]+):(?P<line>\d+): )?(?P<message>.*)$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^\s+(?P<file>[^\s:\[]+):(?P<line>\d+): in `),
+	},
+}
+
+// runLua syntax-checks main.lua and then runs it with the selected runtime
+func runLua(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	runtime := luaRuntimes[job.Request.Runtime]
+
+	// Step 1: Parse only, so that syntax errors are reported as compile errors
+	res, err := runCompiler(ctx, command{
+		Name: runtime.check[0],
+		Args: runtime.check[1:],
+		Dir:  job.Dir,
+	})
+	if err != nil {
+		result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Diagnostics: parseLuaDiagnostics(res.Stderr)}
+		if res.ExitCode != 0 {
+			return result, fmt.Errorf("%w: %s", errCompilation, err)
+		}
+		return result, err
+	}
+
+	// Step 2: Run
+	res, err = runProgram(ctx, job, command{
+		Name: runtime.interpreter,
+		Args: []string{"main.lua"},
+		Dir:  job.Dir,
+	})
+	return &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr}, err
+}
+
+// luaDiagnostic matches an error such as "luac5.4: main.lua:3: unexpected symbol near 'x'"
+var luaDiagnostic = regexp.MustCompile(`(?m)^(?:\S+: )?(\S+?\.lua):(\d+): (.*)$`)
+
+// parseLuaDiagnostics extracts the syntax errors from luac or luajit output
+func parseLuaDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, match := range luaDiagnostic.FindAllStringSubmatch(output, -1) {
+		lineNum, _ := strconv.Atoi(match[2])
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Severity: "error",
+			Message:  strings.TrimSpace(match[3]),
+		})
+	}
+	return diagnostics
+}
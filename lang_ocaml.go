@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "ocaml",
+		SourceFile: "main.ml",
+		Run:        runOCaml,
+		Failure:    ocamlFailure,
+	})
+}
+
+// ocamlFailure picks the uncaught exception out of the program's stderr, e.g.
+// "Fatal error: exception Failure("boom")" and its backtrace, if recorded
+var ocamlFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^Fatal error: exception (?P<type>[\w.]+)[( ]?(?P<message>.*?)\)?$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(`file "(?P<file>[^"]+)", line (?P<line>\d+)`),
+	},
+}
+
+// runOCaml compiles main.ml to a native binary with ocamlopt and runs it
+func runOCaml(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	// Step 1: Compile
+	res, err := compileCached(ctx, job, command{
+		Name: "ocamlfind",
+		Args: []string{"ocamlopt", "-package", "str,unix", "-linkpkg", "-o", "main", "main.ml"},
+		Dir:  job.Dir,
+	}, "main")
+	compile := newPhaseResult("compile", res)
+	diagnostics := parseOCamlDiagnostics(res.Stderr)
+	if err != nil {
+		result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Diagnostics: diagnostics, Phases: []PhaseResult{compile}}
+		if res.ExitCode != 0 {
+			return result, fmt.Errorf("%w: %s", errCompilation, err)
+		}
+		return result, err
+	}
+
+	// Step 2: Run the binary
+	res, err = runProgram(ctx, job, command{
+		Name: filepath.Join(job.Dir, "main"),
+		Dir:  job.Dir,
+	})
+	run := newPhaseResult("run", res)
+
+	return &ExecResult{
+		Stdout:      res.Stdout,
+		Stderr:      res.Stderr,
+		Diagnostics: diagnostics,
+		Phases:      []PhaseResult{compile, run},
+	}, err
+}
+
+var (
+	// ocamlDiagnosticLocation matches e.g. `File "main.ml", line 3, characters 4-9:`
+	ocamlDiagnosticLocation = regexp.MustCompile(`^File "([^"]+)", lines? (\d+)(?:-\d+)?, characters (\d+)-\d+:`)
+
+	// ocamlDiagnosticMessage matches e.g. "Error: Unbound value foo" or "Warning 26 [unused-var]: unused variable x."
+	ocamlDiagnosticMessage = regexp.MustCompile(`^(Error|Warning)(?: \d+(?: \[[\w-]+\])?)?: ?(.*)$`)
+)
+
+// parseOCamlDiagnostics extracts the errors and warnings from ocaml compiler output
+func parseOCamlDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	var pending *Diagnostic
+	var current *Diagnostic
+
+	for _, line := range strings.Split(output, "\n") {
+		if match := ocamlDiagnosticLocation.FindStringSubmatch(line); match != nil {
+			lineNum, _ := strconv.Atoi(match[2])
+			colNum, _ := strconv.Atoi(match[3])
+			// ocaml counts characters from 0
+			pending = &Diagnostic{File: match[1], Line: lineNum, Column: colNum + 1}
+			current = nil
+			continue
+		}
+
+		if match := ocamlDiagnosticMessage.FindStringSubmatch(line); match != nil && pending != nil {
+			pending.Severity = strings.ToLower(match[1])
+			pending.Message = strings.TrimSpace(match[2])
+			diagnostics = append(diagnostics, *pending)
+			current = &diagnostics[len(diagnostics)-1]
+			pending = nil
+			continue
+		}
+
+		// Long messages continue on indented lines
+		if current != nil && strings.HasPrefix(line, " ") {
+			text := strings.TrimSpace(line)
+			if current.Message == "" {
+				current.Message = text
+			} else {
+				current.Message += "\n" + text
+			}
+		} else {
+			current = nil
+		}
+	}
+
+	return diagnostics
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "perl",
+		SourceFile: "main.pl",
+		Run:        runPerl,
+		Failure:    perlFailure,
+	})
+}
+
+// perlFailure picks the error perl died with out of its stderr, e.g.
+// "Illegal division by zero at main.pl line 3."
+var perlFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^(?P<message>.+?) at (?P<file>\S+) line (?P<line>\d+)(?:[.,].*)?$`),
+	},
+}
+
+// runPerl runs main.pl with perl. It isn't syntax-checked with `perl -c`
+// first, since that runs its BEGIN blocks and imports outside of the run's
+// limits; compile errors are told apart by how perl reports them instead.
+func runPerl(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	res, err := runProgram(ctx, job, command{
+		Name: "perl",
+		Args: []string{"main.pl"},
+		Dir:  job.Dir,
+	})
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr}
+
+	if err != nil && perlCompileError.MatchString(res.Stderr) {
+		result.Diagnostics = parsePerlDiagnostics(res.Stderr)
+		return result, fmt.Errorf("%w: %s", errCompilation, err)
+	}
+
+	return result, err
+}
+
+// perlCompileError matches the line perl ends its messages with when main.pl
+// fails to compile, e.g. "Execution of main.pl aborted due to compilation
+// errors." or, for a failing import, "BEGIN failed--compilation aborted at
+// main.pl line 1."
+var perlCompileError = regexp.MustCompile(`(?m)^(?:Execution of \S+ aborted due to compilation errors\.|\S+ had compilation errors\.|BEGIN failed--compilation aborted)`)
+
+// perlDiagnostic matches e.g. `syntax error at main.pl line 3, near "foo bar"`
+var perlDiagnostic = regexp.MustCompile(`(?m)^(.*?) at (\S+?) line (\d+)(?:, (.*))?\.?$`)
+
+// parsePerlDiagnostics extracts the compile errors from perl's stderr
+func parsePerlDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, match := range perlDiagnostic.FindAllStringSubmatch(output, -1) {
+		lineNum, _ := strconv.Atoi(match[3])
+		message := match[1]
+		if match[4] != "" {
+			message += ", " + strings.TrimSuffix(match[4], ".")
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[2],
+			Line:     lineNum,
+			Severity: "error",
+			Message:  message,
+		})
+	}
+	return diagnostics
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// rLibraryDir holds the preinstalled package set made available to every R execution
+var rLibraryDir = filepath.Join(defaultInstallDir(), "r-library")
+
+// rRunScript sources main.R with the default graphics device writing numbered
+// PNGs into the output directory, so plots come back as artifacts
+const rRunScript = `options(device = function(...) grDevices::png(file.path("output", "plot%03d.png"), ...))
+source("main.R")
+invisible(grDevices::graphics.off())`
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "r",
+		SourceFile: "main.R",
+		Run:        runR,
+		Failure:    rFailure,
+	})
+}
+
+// rFailure picks the error out of Rscript's stderr, e.g.
+// "Error in log(-1) : non-numeric argument to mathematical function"
+var rFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^(?P<type>Error)(?: in .+?)? ?: *(?P<message>.*)$`),
+	},
+}
+
+// runR parses and then runs main.R with Rscript, returning any PNGs written to output/
+func runR(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	env := []string{"R_LIBS_SITE=" + rLibraryDir}
+
+	// Step 1: Parse only, so that syntax errors are reported as compile errors
+	res, err := runCompiler(ctx, command{
+		Name: "Rscript",
+		Args: []string{"-e", `invisible(parse("main.R"))`},
+		Dir:  job.Dir,
+		Env:  env,
+	})
+	if err != nil {
+		result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Diagnostics: parseRDiagnostics(res.Stderr)}
+		if res.ExitCode != 0 {
+			return result, fmt.Errorf("%w: %s", errCompilation, err)
+		}
+		return result, err
+	}
+
+	// Step 2: Run
+	outputDir := filepath.Join(job.Dir, "output")
+	err = os.Mkdir(outputDir, os.ModePerm)
+	if err != nil {
+		return &ExecResult{}, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	res, err = runProgram(ctx, job, command{
+		Name: "Rscript",
+		Args: []string{"-e", rRunScript},
+		Dir:  job.Dir,
+		Env:  env,
+	})
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr}
+
+	// Step 3: Collect the plots
+	artifacts, artifactErr := collectArtifacts(job, "output", map[string]string{".png": "image/png"})
+	if artifactErr != nil {
+		return result, artifactErr
+	}
+	result.Artifacts = artifacts
+
+	return result, err
+}
+
+// rDiagnostic matches a parse error location, e.g. "main.R:3:5: unexpected symbol"
+var rDiagnostic = regexp.MustCompile(`(?m)(\S+?\.R):(\d+):(\d+): (.*)$`)
+
+// parseRDiagnostics extracts the parse errors from Rscript output
+func parseRDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, match := range rDiagnostic.FindAllStringSubmatch(output, -1) {
+		lineNum, _ := strconv.Atoi(match[2])
+		colNum, _ := strconv.Atoi(match[3])
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Column:   colNum,
+			Severity: "error",
+			Message:  match[4],
+		})
+	}
+	return diagnostics
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scalaCompileTimeout is generous because the first compile also starts the Bloop server
+const scalaCompileTimeout = 90 * time.Second
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "scala",
+		SourceFile: "Main.scala",
+		Run:        runScala,
+		Failure:    scalaFailure,
+	})
+}
+
+// scalaFailure picks the uncaught exception out of the JVM's stderr, e.g.
+// "Exception in thread "main" java.lang.ArithmeticException: / by zero"
+var scalaFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^Exception in thread "[^"]*" (?P<type>[\w.$]+)(?:: (?P<message>.*))?$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(`\((?P<file>[\w$.-]+\.scala):(?P<line>\d+)\)`),
+	},
+}
+
+// runScala compiles and then runs Main.scala with scala-cli. scala-cli keeps
+// a Bloop compile server running between executions, so only the first
+// compile pays the JVM startup cost.
+func runScala(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	// Step 1: Compile
+	res, err := runCompiler(ctx, command{
+		Name:    "scala-cli",
+		Args:    []string{"compile", "--server=true", "Main.scala"},
+		Dir:     job.Dir,
+		Timeout: scalaCompileTimeout,
+	})
+	compile := newPhaseResult("compile", res)
+	diagnostics := parseScalaDiagnostics(res.Stdout + res.Stderr)
+	if err != nil {
+		result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Diagnostics: diagnostics, Phases: []PhaseResult{compile}}
+		if res.ExitCode != 0 {
+			return result, fmt.Errorf("%w: %s", errCompilation, err)
+		}
+		return result, err
+	}
+
+	// Step 2: Run, reusing the compiled classes
+	res, err = runProgram(ctx, job, command{
+		Name: "scala-cli",
+		Args: []string{"run", "--server=true", "Main.scala"},
+		Dir:  job.Dir,
+	})
+	run := newPhaseResult("run", res)
+
+	return &ExecResult{
+		Stdout:      res.Stdout,
+		Stderr:      res.Stderr,
+		Diagnostics: diagnostics,
+		Phases:      []PhaseResult{compile, run},
+	}, err
+}
+
+var (
+	// scalaDiagnosticHeader matches the location line of a message, e.g. "[error] ./Main.scala:3:5"
+	scalaDiagnosticHeader = regexp.MustCompile(`^\[(error|warn)\] (?:\./)?(\S+?\.scala):(\d+):(\d+)\s*$`)
+
+	// scalaDiagnosticBody matches the lines following the location
+	scalaDiagnosticBody = regexp.MustCompile(`^\[(?:error|warn)\] (.*)$`)
+)
+
+// parseScalaDiagnostics extracts the errors and warnings from scala-cli output
+func parseScalaDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	var current *Diagnostic
+
+	for _, line := range strings.Split(output, "\n") {
+		if match := scalaDiagnosticHeader.FindStringSubmatch(line); match != nil {
+			lineNum, _ := strconv.Atoi(match[3])
+			colNum, _ := strconv.Atoi(match[4])
+			severity := match[1]
+			if severity == "warn" {
+				severity = "warning"
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     match[2],
+				Line:     lineNum,
+				Column:   colNum,
+				Severity: severity,
+			})
+			current = &diagnostics[len(diagnostics)-1]
+			continue
+		}
+
+		match := scalaDiagnosticBody.FindStringSubmatch(line)
+		if match == nil || current == nil {
+			current = nil
+			continue
+		}
+
+		// The message is followed by an excerpt of the offending source, which we skip
+		if current.Message == "" {
+			current.Message = strings.TrimSpace(match[1])
+		} else {
+			current = nil
+		}
+	}
+
+	return diagnostics
+}
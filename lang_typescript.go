@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "typescript",
+		SourceFile: "index.ts",
+		Template:   filepath.Join(os.TempDir(), "dummy-pkg-ts"),
+		Run:        runTypeScript,
+		Failure:    nodeFailure,
+		Harness:    typeScriptHarness,
+		Intel:      typeScriptIntel,
+	})
+}
+
+var (
+	typeScriptCheckersMu sync.Mutex
+
+	// typeScriptCheckers type-check submissions, one per template name
+	typeScriptCheckers = map[string]*tsServer{}
+)
+
+// typeScriptChecker returns the tsserver of the named template, or of the
+// default template if name is empty
+func typeScriptChecker(name string) *tsServer {
+	typeScriptCheckersMu.Lock()
+	defer typeScriptCheckersMu.Unlock()
+
+	checker, ok := typeScriptCheckers[name]
+	if !ok {
+		checker = newTSServer("index.ts")
+		typeScriptCheckers[name] = checker
+	}
+	return checker
+}
+
+// typeScriptIntel answers query from the tsserver of the template name
+func typeScriptIntel(ctx context.Context, name string, template string, code string, query IntelQuery) (*IntelResponse, error) {
+	return typeScriptChecker(name).intel(ctx, template, code, query)
+}
+
+// runTypeScript runs index.ts with ts-node inside a copy of the TypeScript template project
+func runTypeScript(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	// Step 1: Type-check with the warm tsserver. If it can't be used, ts-node
+	// type-checks the program itself.
+	args := []string{"index.ts"}
+
+	code, err := os.ReadFile(job.SourcePath)
+	if err != nil {
+		return &ExecResult{}, fmt.Errorf("failed to read source: %w", err)
+	}
+
+	name := job.Request.Template
+	diagnostics, err := typeScriptChecker(name).check(ctx, job.Template, string(code))
+	if err == nil {
+		if len(diagnostics) > 0 {
+			return &ExecResult{Diagnostics: diagnostics}, fmt.Errorf("%w: %d type errors", errCompilation, len(diagnostics))
+		}
+		args = []string{"--transpile-only", "index.ts"}
+	}
+
+	// V8 flags such as --stack-size can't be passed through NODE_OPTIONS, so
+	// node is run with ts-node as its script to bound its stack
+	tsNode := "ts-node"
+	if stack := nodeStackSizeArgs(job); stack != nil {
+		path, err := exec.LookPath("ts-node")
+		if err == nil {
+			tsNode = "node"
+			args = append(append(stack, path), args...)
+		}
+	}
+
+	// Step 2: Run, with the template's dependencies loaded from the code cache
+	res, err := runProgram(ctx, job, command{
+		Name: tsNode,
+		Args: args,
+		Dir:  job.Dir,
+		Env:  nodeCompileCacheEnv(ctx, strings.TrimSuffix("typescript@"+name, "@"), job.Template),
+	})
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr}
+
+	// ts-node reports type errors as a TSError before running anything
+	if err != nil && strings.Contains(res.Stderr, "TSError") {
+		result.Diagnostics = parseTSCDiagnostics(res.Stderr)
+		return result, fmt.Errorf("%w: %s", errCompilation, err)
+	}
+
+	return result, err
+}
+
+// typeScriptStructures declares the harness data structures the way LeetCode-style problems expect them
+var typeScriptStructures = map[string]structureDefinition{
+	"ListNode": {"ListNode", `class ListNode {
+  val: number;
+  next: ListNode | null;
+  constructor(val?: number, next?: ListNode | null) {
+    this.val = val === undefined ? 0 : val;
+    this.next = next === undefined ? null : next;
+  }
+}`},
+	"TreeNode": {"TreeNode", `class TreeNode {
+  val: number;
+  left: TreeNode | null;
+  right: TreeNode | null;
+  constructor(val?: number, left?: TreeNode | null, right?: TreeNode | null) {
+    this.val = val === undefined ? 0 : val;
+    this.left = left === undefined ? null : left;
+    this.right = right === undefined ? null : right;
+  }
+}`},
+	// Node would clash with the DOM type of the same name
+	"GraphNode": {"_Node", `class _Node {
+  val: number;
+  neighbors: _Node[];
+  constructor(val?: number, neighbors?: _Node[]) {
+    this.val = val === undefined ? 0 : val;
+    this.neighbors = neighbors === undefined ? [] : neighbors;
+  }
+}`},
+	"Interval": {"Interval", `class Interval {
+  start: number;
+  end: number;
+  constructor(start: number, end: number) {
+    this.start = start;
+    this.end = end;
+  }
+}`},
+}
+
+// typeScriptHarness is javaScriptHarness with enough annotations to type-check.
+// process is reached through globalThis so it works without @types/node.
+func typeScriptHarness(code string, fn *FunctionSpec) string {
+	return code + missingDefinitions(code, fn, typeScriptStructures) + fmt.Sprintf(`
+;(() => {
+  %s
+  const __octreeProcess = (globalThis as any).process;
+  let __octreeInput = "";
+  __octreeProcess.stdin.on("data", (chunk: any) => { __octreeInput += chunk; });
+  __octreeProcess.stdin.on("end", () => {
+    const __octreePrint = (value: any) => __octreeProcess.stdout.write("\n" + JSON.stringify(__octreeCodec.encode(__octreeReturnType, value)) + "\n");
+    const __octreeArgs = JSON.parse(__octreeInput).map((arg: any, i: number) => __octreeCodec.decode(__octreeParamTypes[i] || "", arg));
+    const __octreeResult: any = (%s as any)(...__octreeArgs);
+    if (__octreeResult && typeof __octreeResult.then === "function") {
+      __octreeResult.then(__octreePrint);
+    } else {
+      __octreePrint(__octreeResult);
+    }
+  });
+})();
+`, javaScriptCodec(fn, typeScriptStructures), fn.Name)
+}
+
+// tscDiagnostic matches a TypeScript compiler message, e.g.
+// "index.ts(3,5): error TS2304: Cannot find name 'foo'."
+var tscDiagnostic = regexp.MustCompile(`(?m)^(\S+?)\((\d+),(\d+)\): (error|warning) (TS\d+: .*)$`)
+
+// parseTSCDiagnostics extracts the compiler messages from tsc or ts-node output
+func parseTSCDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, match := range tscDiagnostic.FindAllStringSubmatch(output, -1) {
+		lineNum, _ := strconv.Atoi(match[2])
+		colNum, _ := strconv.Atoi(match[3])
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Column:   colNum,
+			Severity: match[4],
+			Message:  match[5],
+		})
+	}
+	return diagnostics
+}
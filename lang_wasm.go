@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// wasmFuel bounds the number of instructions a module may execute
+	wasmFuel = 10_000_000_000
+
+	// wasmEpochTimeout interrupts modules through epoch interruption, independently of fuel
+	wasmEpochTimeout = "20s"
+)
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "wasm",
+		SourceFile: "main.wat",
+		Run:        runWasm,
+		Failure:    wasmFailure,
+	})
+}
+
+// wasmFailure picks the trap out of wasmtime's stderr, e.g.
+// "wasm trap: integer divide by zero"
+var wasmFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)(?P<type>wasm trap): (?P<message>.*)$`),
+	},
+}
+
+// runWasm runs a WASI module under wasmtime. The code is either WAT text or a
+// base64 encoded binary module.
+func runWasm(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	module := "main.wat"
+
+	// Step 1: Decode binary modules
+	code := strings.TrimSpace(job.Request.Code)
+	if !strings.HasPrefix(code, "(") && !strings.HasPrefix(code, ";;") {
+		binary, err := base64.StdEncoding.DecodeString(code)
+		if err != nil {
+			return &ExecResult{}, fmt.Errorf("%w: code is neither WAT text nor a base64 encoded module: %s", errCompilation, err)
+		}
+
+		module = "main.wasm"
+		err = os.WriteFile(filepath.Join(job.Dir, module), binary, 0644)
+		if err != nil {
+			return &ExecResult{}, fmt.Errorf("unable to write file: %w", err)
+		}
+	}
+
+	// Step 2: Run with fuel and epoch based interruption
+	res, err := runProgram(ctx, job, command{
+		Name: "wasmtime",
+		Args: []string{
+			"run",
+			"-W", fmt.Sprintf("fuel=%d", wasmFuel),
+			"-W", "timeout=" + wasmEpochTimeout,
+			"--dir", ".",
+			module,
+		},
+		Dir: job.Dir,
+	})
+	result := &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr}
+
+	if err != nil {
+		switch {
+		case wasmCompileError.MatchString(res.Stderr):
+			result.Diagnostics = parseWasmDiagnostics(res.Stderr)
+			return result, fmt.Errorf("%w: %s", errCompilation, err)
+		case wasmInterrupted.MatchString(res.Stderr):
+			return result, fmt.Errorf("%w: %s", errExecutionTimeout, strings.TrimSpace(wasmInterrupted.FindString(res.Stderr)))
+		}
+	}
+
+	return result, err
+}
+
+var (
+	// wasmCompileError matches wasmtime's errors for modules that can't be loaded
+	wasmCompileError = regexp.MustCompile(`failed to (parse|compile|validate)`)
+
+	// wasmInterrupted matches the traps raised when fuel runs out or the epoch deadline passes
+	wasmInterrupted = regexp.MustCompile(`all fuel consumed by WebAssembly|wasm trap: interrupt`)
+
+	// wasmDiagnosticLocation matches the location of a WAT parse error, e.g. "--> main.wat:3:5"
+	wasmDiagnosticLocation = regexp.MustCompile(`--> (\S+?):(\d+):(\d+)`)
+)
+
+// parseWasmDiagnostics extracts the location of a WAT parse error from wasmtime output
+func parseWasmDiagnostics(output string) []Diagnostic {
+	location := wasmDiagnosticLocation.FindStringSubmatch(output)
+	if location == nil {
+		return nil
+	}
+
+	lineNum, _ := strconv.Atoi(location[2])
+	colNum, _ := strconv.Atoi(location[3])
+
+	// The message is the last line before the location
+	message := "failed to parse module"
+	for _, line := range strings.Split(output[:strings.Index(output, location[0])], "\n") {
+		if text := strings.TrimSpace(line); text != "" && !strings.HasPrefix(text, "Caused by") && !strings.HasPrefix(text, "Error:") {
+			message = strings.TrimLeft(text, "0123456789: ")
+		}
+	}
+
+	return []Diagnostic{{
+		File:     location[1],
+		Line:     lineNum,
+		Column:   colNum,
+		Severity: "error",
+		Message:  message,
+	}}
+}
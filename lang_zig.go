@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// zigGlobalCacheDir is shared by every zig execution so the standard library
+// and compiler_rt are only built once per agent
+var zigGlobalCacheDir = filepath.Join(workspaceRoot, ".zig-global-cache")
+
+func init() {
+	registerLanguage(&Language{
+		Name:       "zig",
+		SourceFile: "main.zig",
+		Run:        runZig,
+		Failure:    zigFailure,
+	})
+}
+
+// zigFailure picks the panic, or the error returned from main, out of the
+// program's stderr, e.g. "thread 7 panic: integer overflow" and its stack trace
+var zigFailure = &failurePatterns{
+	Errors: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^(?:thread \d+ )?(?P<type>panic): (?P<message>.*)$`),
+		regexp.MustCompile(`(?m)^(?P<type>error): (?P<message>\w+)$`),
+	},
+	Frames: []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^(?P<file>\S+\.zig):(?P<line>\d+):(?P<column>\d+): 0x`),
+	},
+}
+
+// runZig compiles main.zig and runs the resulting binary
+func runZig(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	// Step 1: Compile
+	res, err := compileCached(ctx, job, command{
+		Name: "zig",
+		Args: []string{
+			"build-exe", "main.zig",
+			"-femit-bin=main",
+			"--cache-dir", ".zig-cache",
+			"--global-cache-dir", zigGlobalCacheDir,
+		},
+		Dir: job.Dir,
+	}, "main")
+	compile := newPhaseResult("compile", res)
+	if err != nil {
+		result := &ExecResult{
+			Stdout:      res.Stdout,
+			Stderr:      res.Stderr,
+			Diagnostics: parseZigDiagnostics(res.Stderr),
+			Phases:      []PhaseResult{compile},
+		}
+		if res.ExitCode != 0 {
+			return result, fmt.Errorf("%w: %s", errCompilation, err)
+		}
+		return result, err
+	}
+
+	// Step 2: Run the binary
+	res, err = runProgram(ctx, job, command{
+		Name: filepath.Join(job.Dir, "main"),
+		Dir:  job.Dir,
+	})
+	run := newPhaseResult("run", res)
+
+	return &ExecResult{Stdout: res.Stdout, Stderr: res.Stderr, Phases: []PhaseResult{compile, run}}, err
+}
+
+// zigDiagnostic matches e.g. "main.zig:3:5: error: use of undeclared identifier 'foo'"
+var zigDiagnostic = regexp.MustCompile(`(?m)^(\S+?\.zig):(\d+):(\d+): (error|warning): (.*)$`)
+
+// parseZigDiagnostics extracts the compiler errors from zig output
+func parseZigDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, match := range zigDiagnostic.FindAllStringSubmatch(output, -1) {
+		lineNum, _ := strconv.Atoi(match[2])
+		colNum, _ := strconv.Atoi(match[3])
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Column:   colNum,
+			Severity: match[4],
+			Message:  match[5],
+		})
+	}
+	return diagnostics
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// Language describes how code in a supported language is executed
+type Language struct {
+	Name string
+
+	// SourceFile is the name the submitted code is saved under in the workspace
+	SourceFile string
+
+	// Template is an optional directory copied into the workspace before the code is written
+	Template string
+
+	// Runtimes lists the selectable runtimes for the language; the first is the default
+	Runtimes []string
+
+	// Run executes the job and returns its output
+	Run func(ctx context.Context, job *ExecJob) (*ExecResult, error)
+
+	// Harness, if set, appends to code a harness that calls fn for function mode
+	Harness func(code string, fn *FunctionSpec) string
+
+	// Intel, if set, answers code intelligence queries from the language
+	// server of the template name, whose active version is in template
+	Intel func(ctx context.Context, name string, template string, code string, query IntelQuery) (*IntelResponse, error)
+
+	// Failure, if set, picks the runtime error out of the stderr of the
+	// language's programs for the summary of failed executions
+	Failure *failurePatterns
+
+	// Profiling reports whether Run supports the "profile" mode
+	Profiling bool
+
+	// Backend is what runs the language: "builtin" (the default) or "external"
+	Backend string
+
+	// Warm reports whether Run hands the job to a warm pool (see warmPool)
+	Warm bool
+}
+
+// languages is the registry of supported languages, keyed by name
+var languages = map[string]*Language{}
+
+// registerLanguage adds lang to the registry; runners call it from init
+func registerLanguage(lang *Language) {
+	if lang.Backend == "" {
+		lang.Backend = "builtin"
+	}
+	languages[lang.Name] = lang
+}
+
+// supportedLanguages returns the names of all registered languages, sorted
+func supportedLanguages() []string {
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveRuntime returns the runtime to use for a request, or false if the
+// requested runtime isn't available for lang
+func (lang *Language) resolveRuntime(requested string) (string, bool) {
+	if len(lang.Runtimes) == 0 {
+		return "", requested == ""
+	}
+	if requested == "" {
+		return lang.Runtimes[0], true
+	}
+	for _, runtime := range lang.Runtimes {
+		if runtime == requested {
+			return runtime, true
+		}
+	}
+	return "", false
+}
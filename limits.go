@@ -0,0 +1,224 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// memoryPollInterval is how often a process's resident memory is checked against its limit
+	memoryPollInterval = 10 * time.Millisecond
+
+	// usageSampleInterval is how often a process's usage is recorded for its timeline
+	usageSampleInterval = 100 * time.Millisecond
+
+	// clockTicksPerSecond is the unit of the CPU times in /proc (USER_HZ, 100 on every Linux platform)
+	clockTicksPerSecond = 100
+)
+
+// UsageTimeline is a program's CPU time and resident memory sampled every
+// IntervalMs; entry i of each series was taken (i+1)*IntervalMs after the
+// program started
+type UsageTimeline struct {
+	IntervalMs int64   `json:"intervalMs"`
+	CPUMs      []int64 `json:"cpuMs"`
+	RSSKB      []int64 `json:"rssKb"`
+}
+
+// processWatch polls a running process, killing it once its resident memory
+// or the CPU time it used since the watch started exceeds a limit, and
+// optionally sampling its usage into a timeline. A process leading its own
+// process group (see commandTree) is accounted for along with the processes
+// it forked into the group, so a program can't escape its limits by
+// splitting its work across children.
+type processWatch struct {
+	done      chan struct{}
+	stopOnce  sync.Once
+	killed    atomic.Bool
+	cpuKilled atomic.Bool
+
+	mu      sync.Mutex
+	samples *UsageTimeline
+}
+
+// watchProcess starts polling process. A limit of 0 bytes means no memory
+// limit, and a cpuLimit of 0 no CPU time limit; sample records a usage timeline.
+func watchProcess(process *os.Process, limit int64, cpuLimit time.Duration, sample bool) *processWatch {
+	w := &processWatch{done: make(chan struct{})}
+	if sample {
+		w.samples = &UsageTimeline{IntervalMs: usageSampleInterval.Milliseconds()}
+	}
+
+	interval := usageSampleInterval
+	if limit > 0 || cpuLimit > 0 {
+		interval = memoryPollInterval
+	}
+
+	// A warm process has used CPU time before it was handed the job
+	_, baseTicks, _ := groupUsage(process.Pid)
+	cpuLimitTicks := int64(cpuLimit / (time.Second / clockTicksPerSecond))
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		start := time.Now()
+		nextSample := start.Add(usageSampleInterval)
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case now := <-ticker.C:
+				rss, cpu, running := groupUsage(process.Pid)
+
+				if sample && !now.Before(nextSample) {
+					nextSample = nextSample.Add(usageSampleInterval)
+
+					w.mu.Lock()
+					w.samples.CPUMs = append(w.samples.CPUMs, cpu*1000/clockTicksPerSecond)
+					w.samples.RSSKB = append(w.samples.RSSKB, rss/1024)
+					w.mu.Unlock()
+				}
+
+				if limit > 0 && rss > limit {
+					w.killed.Store(true)
+					killProcessGroup(process)
+					return
+				}
+
+				if cpuLimit > 0 && running && cpu-baseTicks > cpuLimitTicks {
+					w.cpuKilled.Store(true)
+					killProcessGroup(process)
+					return
+				}
+			}
+		}
+	}()
+
+	return w
+}
+
+// stop ends the polling; it is safe to call more than once
+func (w *processWatch) stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+}
+
+// exceeded reports whether the process was killed for exceeding its memory limit
+func (w *processWatch) exceeded() bool {
+	return w.killed.Load()
+}
+
+// cpuExceeded reports whether the process was killed for exceeding its CPU time limit
+func (w *processWatch) cpuExceeded() bool {
+	return w != nil && w.cpuKilled.Load()
+}
+
+// timeline returns the usage sampled so far, or nil if sampling wasn't requested
+func (w *processWatch) timeline() *UsageTimeline {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.samples == nil {
+		return nil
+	}
+	return &UsageTimeline{
+		IntervalMs: w.samples.IntervalMs,
+		CPUMs:      append([]int64{}, w.samples.CPUMs...),
+		RSSKB:      append([]int64{}, w.samples.RSSKB...),
+	}
+}
+
+// groupUsage returns the resident memory (in bytes) and the CPU time (user
+// and system, in clock ticks) used so far by the process with the given pid
+// and the processes of the process group it leads, if it does, and whether
+// that process is still running. The CPU time includes that of the children
+// they waited for; the resident memory of processes sharing pages is counted
+// once for each.
+func groupUsage(pid int) (int64, int64, bool) {
+	rss, ticks, pgrp, running := procStat(strconv.Itoa(pid))
+	if !running {
+		return 0, 0, false
+	}
+	if pgrp != pid {
+		return rss, ticks, true
+	}
+
+	entries, _ := os.ReadDir("/proc")
+	for _, entry := range entries {
+		member, err := strconv.Atoi(entry.Name())
+		if err != nil || member == pid {
+			continue
+		}
+		memberRSS, memberTicks, memberPgrp, ok := procStat(entry.Name())
+		if ok && memberPgrp == pid {
+			rss += memberRSS
+			ticks += memberTicks
+		}
+	}
+	return rss, ticks, true
+}
+
+// procStat returns the resident memory (in bytes), the CPU time (in clock
+// ticks, including that of the children it waited for) and the process group
+// of the process with the given pid, and whether that process is still running
+func procStat(pid string) (int64, int64, int, bool) {
+	data, err := os.ReadFile("/proc/" + pid + "/stat")
+	if err != nil {
+		return 0, 0, 0, false
+	}
+
+	// The command name may contain spaces, so the fields are counted from its closing parenthesis
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return 0, 0, 0, false
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 22 || fields[0] == "Z" || fields[0] == "X" {
+		return 0, 0, 0, false
+	}
+
+	pgrp, _ := strconv.Atoi(fields[2])
+	var ticks int64
+	for _, field := range fields[11:15] {
+		n, _ := strconv.ParseInt(field, 10, 64)
+		ticks += n
+	}
+	pages, _ := strconv.ParseInt(fields[21], 10, 64)
+	return pages * int64(os.Getpagesize()), ticks, pgrp, true
+}
+
+// cpuTicks returns the CPU time (user and system, in clock ticks) used so far by
+// the process with the given pid, and whether that process is still running
+func cpuTicks(pid int) (int64, bool) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, false
+	}
+
+	// The command name may contain spaces, so the fields are counted from its closing parenthesis
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return 0, false
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 13 || fields[0] == "Z" || fields[0] == "X" {
+		return 0, false
+	}
+
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	return utime + stime, true
+}
+
+// cpuTime returns the CPU time (user and system) used by a finished process
+func cpuTime(state *os.ProcessState) time.Duration {
+	if state == nil {
+		return 0
+	}
+	return state.UserTime() + state.SystemTime()
+}
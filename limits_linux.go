@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// resourceLimitsEnforced reports whether the memory and CPU time limits of a
+// program are enforced on this platform, which the watch does on Linux
+const resourceLimitsEnforced = true
+
+// limitedCommand wraps a command so that it runs with the configured open
+// file and core file limits, so a program can't exhaust the host's
+// descriptors or fill the disk with core dumps, and with an RLIMIT_CPU
+// matching its CPU time limit, if set. The limits are set by a shell the command is
+// exec'd from, so they apply from its start and are inherited by its
+// children; the shell counts core file sizes in 512-byte blocks. A command
+// that can't be found is left for exec to fail on.
+//
+// RLIMIT_CPU is a backstop for the watch, which enforces the limit more
+// precisely: the kernel only counts whole seconds, and kills the process
+// with SIGXCPU past the soft limit.
+func limitedCommand(c command) (string, []string) {
+	name, args := c.Name, c.Args
+	if !strings.ContainsRune(name, '/') {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return c.Name, c.Args
+		}
+		name = path
+	} else if _, err := os.Stat(inDir(c.Dir, name)); err != nil {
+		return c.Name, c.Args
+	}
+
+	openFiles := strconv.FormatUint(belowHardLimit(syscall.RLIMIT_NOFILE, uint64(config.MaxOpenFiles)), 10)
+	coreBlocks := strconv.FormatUint(belowHardLimit(syscall.RLIMIT_CORE, uint64(config.MaxCoreFileBytes))/512, 10)
+	script := `ulimit -n "$0" && ulimit -c "$1"`
+	limits := []string{openFiles, coreBlocks}
+	if c.CPULimit > 0 {
+		// The hard limit is a second past the soft one, itself a second past the limit
+		script += ` && ulimit -t $(($2 + 1)) && ulimit -S -t "$2" && shift`
+		limits = append(limits, strconv.FormatInt(int64((c.CPULimit+time.Second-1)/time.Second)+1, 10))
+	}
+	script += ` && shift && exec "$@"`
+	return "sh", append(append([]string{"-c", script}, limits...), append([]string{name}, args...)...)
+}
+
+// inDir returns the path of the file name relative to dir, if it isn't absolute
+func inDir(dir string, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// belowHardLimit returns value, lowered to the agent's hard limit of
+// resource, which the limits of its children can't be raised above
+func belowHardLimit(resource int, value uint64) uint64 {
+	var rlimit syscall.Rlimit
+	err := syscall.Getrlimit(resource, &rlimit)
+	if err != nil {
+		return value
+	}
+	return min(value, rlimit.Max)
+}
+
+// maxRSSKB returns the peak resident memory of a finished process, in kilobytes
+func maxRSSKB(state *os.ProcessState) int64 {
+	if state == nil {
+		return 0
+	}
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return usage.Maxrss
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"runtime"
+)
+
+// resourceLimitsEnforced reports whether the memory and CPU time limits of a
+// program are enforced on this platform: on Windows its job object caps
+// them, while elsewhere nothing does, the watch reading /proc
+const resourceLimitsEnforced = runtime.GOOS == "windows"
+
+// limitedCommand returns the command unchanged, since the open file, core
+// file and CPU time rlimits are only applied on Linux
+func limitedCommand(c command) (string, []string) {
+	return c.Name, c.Args
+}
+
+// maxRSSKB returns the peak resident memory of a finished process, in
+// kilobytes, which isn't reported on this platform (see commandTree.usage)
+func maxRSSKB(state *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// tcpKeepAlive is how often idle TCP connections are probed, so that the
+// connections of vanished clients are closed
+const tcpKeepAlive = 30 * time.Second
+
+// newHTTPServer returns the agent's HTTP server. Its timeouts keep slow
+// clients from holding connections open indefinitely: the headers and then
+// the whole request must arrive in time, and idle keep-alive connections are
+// closed. The write timeout only has to outlast the per-request deadline.
+//
+// With config.H2C, clients with prior knowledge can also speak HTTP/2 without
+// TLS, which internal clients like the orchestrator use to multiplex their
+// requests over a few connections.
+func newHTTPServer(handler http.Handler) *http.Server {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeoutMs) * time.Millisecond,
+		ReadTimeout:       time.Duration(config.ReadTimeoutMs) * time.Millisecond,
+		IdleTimeout:       time.Duration(config.IdleTimeoutMs) * time.Millisecond,
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: config.MaxConcurrentStreams,
+		},
+	}
+	if config.RequestTimeoutMs > 0 {
+		server.WriteTimeout = time.Duration(config.RequestTimeoutMs+requestDeadlineGrace) * time.Millisecond
+	}
+	if config.H2C {
+		server.Protocols = &http.Protocols{}
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return server
+}
+
+// openListeners listens on the TCP address config.ListenAddr and the Unix
+// socket config.UnixSocket, whichever are set. A sidecar agent that must not
+// be reachable over the network only listens on the socket.
+func openListeners() ([]net.Listener, error) {
+	var listeners []net.Listener
+	if config.ListenAddr != "" {
+		listener, err := listen("tcp", config.ListenAddr)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if config.UnixSocket != "" {
+		listener, err := listenUnix(config.UnixSocket)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("neither OCTREE_LISTEN_ADDR nor OCTREE_UNIX_SOCKET is set")
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on the Unix socket at path, replacing the socket a
+// previous agent left behind, with the permissions config.UnixSocketMode
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := strconv.ParseUint(config.UnixSocketMode, 8, 32)
+	if err == nil {
+		err = os.Chmod(path, os.FileMode(mode))
+	}
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set the mode of socket %s: %w", path, err)
+	}
+	return listener, nil
+}
+
+// serve serves HTTP on every listener, returning once any of them fails
+func serve(server *http.Server, listeners []net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		log.Printf("Server is listening on %s %s", listener.Addr().Network(), listener.Addr())
+		go func() {
+			errs <- server.Serve(listener)
+		}()
+	}
+	return <-errs
+}
+
+// listen listens on addr, accepting at most config.MaxConnections at once
+// (0 for no limit) so that clients can't exhaust the agent's file descriptors
+func listen(network string, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: tcpKeepAlive}
+	listener, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.MaxConnections > 0 {
+		listener = &limitListener{Listener: listener, slots: make(chan struct{}, config.MaxConnections)}
+	}
+	return listener, nil
+}
+
+// limitListener only accepts a connection once one of its slots is free
+type limitListener struct {
+	net.Listener
+	slots chan struct{}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.slots <- struct{}{}
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitConn{Conn: conn, release: sync.OnceFunc(func() { <-l.slots })}, nil
+}
+
+// limitConn frees its slot of the listener once closed
+type limitConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+// requestDeadlineGrace is how long after its deadline a request may still
+// write its response, in milliseconds
+const requestDeadlineGrace = 10000
+
+// withRequestDeadline bounds how long a request may take overall. Executions
+// run past the deadline are stopped and reported as timed out. Untimed
+// routes, such as debug sessions, bound their requests themselves.
+func withRequestDeadline(next http.Handler) http.Handler {
+	if config.RequestTimeoutMs <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if routeOf(r).untimed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(config.RequestTimeoutMs)*time.Millisecond)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// shutdownTimeout bounds how long a stopping agent waits for the running requests
+const shutdownTimeout = 2 * time.Minute
+
+// shutdownDone is closed once the agent has shut down
+var shutdownDone = make(chan struct{})
+
+// shutdownOnSignal stops the server on SIGTERM or SIGINT, letting the running
+// requests finish first, so restarts don't fail in-flight executions
+func shutdownOnSignal(server *http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+
+	log.Printf("Received %s, shutting down", sig)
+	sdNotify("STOPPING=1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Warning: failed to shut down gracefully: %s", err)
+	}
+	close(shutdownDone)
+}
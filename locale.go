@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+// checkLocale validates the locale and timezone of a request against the
+// allowed ones. If either isn't allowed it writes the error response and
+// returns false.
+func checkLocale(w http.ResponseWriter, req *CodeExecRequest) bool {
+	if req.Locale != "" && !slices.Contains(config.Locales, req.Locale) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Locale not supported", map[string]any{"locales": config.Locales})
+		return false
+	}
+
+	if req.Timezone != "" {
+		_, err := time.LoadLocation(req.Timezone)
+		allowed := len(config.Timezones) == 0 || slices.Contains(config.Timezones, req.Timezone)
+		if err != nil || !allowed || req.Timezone == "Local" {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Timezone not supported", map[string]any{"timezone": req.Timezone})
+			return false
+		}
+	}
+
+	return true
+}
+
+// localeEnv returns the environment setting the locale and timezone of req
+func localeEnv(req *CodeExecRequest) []string {
+	var env []string
+	if req.Locale != "" {
+		env = append(env, "LANG="+req.Locale, "LC_ALL="+req.Locale)
+	}
+	if req.Timezone != "" {
+		env = append(env, "TZ="+req.Timezone)
+	}
+	return env
+}
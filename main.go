@@ -1,272 +1,170 @@
 package main
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"os/exec"
-	"path/filepath"
+	"strings"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 type CodeExecRequest struct {
 	Language string `json:"language"`
 	Code     string `json:"code"`
-}
 
-var LANGUAGE_EXTENSIONS = map[string]string{
-	"javascript": ".js",
-	"typescript": ".ts",
-}
+	// Filename optionally names the file the code came from. Without a
+	// language, it is detected from the filename or the code.
+	Filename string `json:"filename,omitempty"`
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	response := map[string]string{"status": "Health check OK"}
-	jsonResponse, _ := json.Marshal(response)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonResponse)
-}
+	// Runtime optionally selects one of the language's runtimes, e.g. "luajit"
+	Runtime string `json:"runtime,omitempty"`
 
-func handleTypeScriptExecution(filePath string) (string, string, error) {
-	stdout, stderr, err := executeTypescript(filePath)
-	return string(stdout), string(stderr), err
-}
-
-// executeTypescript runs a TypeScript file by creating a unique folder and copying the necessary files
-func executeTypescript(filePath string) (string, string, error) {
-	// Step 1: Generate a UUID folder name
-	uuidFolder := uuid.New().String()
-
-	// Step 2: Save the current working directory
-	originalDir, err := os.Getwd()
-	if err != nil {
-		return "", "", fmt.Errorf("failed to get current directory: %w", err)
-	}
-
-	// Step 3: Change directory to /mnt/persistent
-	err = os.Chdir("/mnt/persistent")
-	if err != nil {
-		return "", "", fmt.Errorf("failed to change directory to /mnt/persistent: %w", err)
-	}
+	// Template optionally selects one of the language's named templates, e.g.
+	// "react-testing" or "typescript/react-testing"
+	Template string `json:"template,omitempty"`
 
-	// Step 4: Create a new folder with the UUID
-	err = os.Mkdir(uuidFolder, os.ModePerm)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to create folder %s: %w", uuidFolder, err)
-	}
-
-	// Step 5: Copy everything from /tmp/dummy-pkg-ts into the new UUID folder
-	err = copyDirectory("/tmp/dummy-pkg-ts", filepath.Join("/mnt/persistent", uuidFolder))
-	if err != nil {
-		return "", "", fmt.Errorf("failed to copy files to %s: %w", uuidFolder, err)
-	}
-
-	// Step 6: Change directory to the new UUID folder
-	err = os.Chdir(filepath.Join("/mnt/persistent", uuidFolder))
-	if err != nil {
-		return "", "", fmt.Errorf("failed to change directory to %s: %w", uuidFolder, err)
-	}
-
-	// Step 7: Copy the input TypeScript file to the UUID folder as index.ts
-	err = copyFile(filePath, "index.ts")
-	if err != nil {
-		return "", "", fmt.Errorf("failed to copy file %s to index.ts: %w", filePath, err)
-	}
-
-	// Step 8: Set a 30s timeout using context.WithTimeout and execute `ts-node index.ts`
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "ts-node", "index.ts")
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
-
-	// Start the command
-	err = cmd.Start()
-	if err != nil {
-		return "", "", fmt.Errorf("failed to start ts-node: %w", err)
-	}
+	// PTY runs the program under a pseudo-terminal, for programs whose output
+	// depends on being run in a terminal
+	PTY bool `json:"pty,omitempty"`
 
-	// Wait for the command to finish or timeout
-	err = cmd.Wait()
-	if ctx.Err() == context.DeadlineExceeded {
-		return "", "", fmt.Errorf("execution timeout after 30 seconds")
-	}
-	if err != nil {
-		return stdoutBuf.String(), stderrBuf.String(), fmt.Errorf("failed to run ts-node: %w", err)
-	}
+	// Locale (e.g. "de_DE.UTF-8") and Timezone (e.g. "Europe/Berlin") set the
+	// program's locale and timezone instead of the agent's
+	Locale   string `json:"locale,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
 
-	// Step 9: Change back to the original directory
-	err = os.Chdir(originalDir)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to change back to original directory: %w", err)
-	}
+	// StackSizeMB sets the program's stack size instead of the platform's
+	// default, for deep recursion
+	StackSizeMB int64 `json:"stackSizeMb,omitempty"`
 
-	// Step 10: Delete the UUID folder
-	err = os.RemoveAll(filepath.Join("/mnt/persistent", uuidFolder))
-	if err != nil {
-		log.Printf("Warning: failed to delete folder %s: %s", uuidFolder, err)
-	}
+	// SampleUsage returns a timeline of the program's CPU and memory usage with the result
+	SampleUsage bool `json:"sampleUsage,omitempty"`
 
-	// Return the stdout and stderr of the TypeScript execution
-	return stdoutBuf.String(), stderrBuf.String(), nil
-}
+	// Metrics returns static metrics of the code with the result, see CodeMetrics
+	Metrics bool `json:"metrics,omitempty"`
 
-// copyDirectory copies the contents of srcDir to destDir
-func copyDirectory(srcDir string, destDir string) error {
-	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	// CombinedOutput also returns the program's stdout and stderr
+	// interleaved as they were written, see OutputChunk
+	CombinedOutput bool `json:"combinedOutput,omitempty"`
 
-		relPath, err := filepath.Rel(srcDir, path)
-		if err != nil {
-			return err
-		}
+	// Mode is empty to simply run the program, "profile" to run it under a
+	// profiler for languages that support it, "server" to run it as a
+	// server and probe it as described by Server, or "scan" to scan it for
+	// security issues as described by Scan instead of running it
+	Mode   string      `json:"mode,omitempty"`
+	Server *ServerSpec `json:"server,omitempty"`
+	Scan   *ScanSpec   `json:"scan,omitempty"`
 
-		destPath := filepath.Join(destDir, relPath)
+	// Trace runs the program under "strace" or "ltrace" (admin only)
+	Trace string `json:"trace,omitempty"`
 
-		if info.IsDir() {
-			return os.MkdirAll(destPath, os.ModePerm)
-		}
+	// ProgramID references a registered program in place of the language and
+	// code, where a judge request accepts one
+	ProgramID string `json:"programId,omitempty"`
 
-		return copyFile(path, destPath)
-	})
-	return err
-}
+	// CodeURL fetches the code from a URL in place of Code, and FilesURL a zip
+	// or gzipped tar archive unpacked into the workspace
+	CodeURL  string `json:"codeUrl,omitempty"`
+	FilesURL string `json:"filesUrl,omitempty"`
 
-// copyFile copies a file from src to dest
-func copyFile(src, dest string) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer sourceFile.Close()
+	// Git checks out a repository into the workspace, in place of or in
+	// addition to the code
+	Git *GitSource `json:"git,omitempty"`
 
-	destFile, err := os.Create(dest)
-	if err != nil {
-		return err
-	}
-	defer destFile.Close()
+	// Snapshot stores the workspace once the program has run, so that a later
+	// request can continue from it by passing the returned ID as RestoreSnapshot
+	Snapshot        bool   `json:"snapshot,omitempty"`
+	RestoreSnapshot string `json:"restoreSnapshot,omitempty"`
 
-	_, err = io.Copy(destFile, sourceFile)
-	if err != nil {
-		return err
-	}
+	// Services names ephemeral backing services, e.g. "postgres", whose
+	// connection strings are passed to the program in the environment
+	Services []string `json:"services,omitempty"`
 
-	return destFile.Sync()
+	// detected is set once the language was detected rather than given
+	detected bool
 }
 
-func handleJavaScriptExecution(filePath string) (string, string, error) {
-	// Create a context with a 10-second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel() // Ensure resources are cleaned up after the command finishes
-
-	// Create the command using the context
-	cmd := exec.CommandContext(ctx, "node", filePath)
-
-	// Get stdout and stderr pipes
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", "", fmt.Errorf("error while obtaining stdout pipe: %s", err)
-	}
-
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		return "", "", fmt.Errorf("error while obtaining stderr pipe: %s", err)
-	}
-
-	// Start the command
-	err = cmd.Start()
-	if err != nil {
-		return "", "", fmt.Errorf("error while starting command: %s", err)
-	}
-
-	// Read stdout and stderr
-	stdout, err := io.ReadAll(stdoutPipe)
-	if err != nil {
-		return "", "", fmt.Errorf("error while reading stdout: %s", err)
-	}
-
-	stderr, err := io.ReadAll(stderrPipe)
-	if err != nil {
-		return "", "", fmt.Errorf("error while reading stderr: %s", err)
-	}
-
-	// Wait for the command to finish or timeout
-	err = cmd.Wait()
-	if ctx.Err() == context.DeadlineExceeded {
-		return "", "", fmt.Errorf("command timed out after 10 seconds")
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if problem := watchdog.shedding(); problem != "" {
+		writeError(w, http.StatusServiceUnavailable, CodeOverloaded, "The agent is unhealthy", map[string]string{"reason": problem})
+		return
 	}
-	if err != nil {
-		return "", "", fmt.Errorf("command execution error: %s", err)
+	if writePrewarming(w) {
+		return
 	}
 
-	return string(stdout), string(stderr), nil
+	response := map[string]string{"status": "Health check OK"}
+	jsonResponse, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
 }
 
 // Run arbitrary Linux commands, mostly for debugging purposes
 func cmdExecHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, `{"error": "Invalid request method"}`, http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, `{"error": "Unable to read request body"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read request body", nil)
 		return
 	}
 	defer r.Body.Close()
 
-	cmd := exec.Command("sh", "-c", string(body))
+	name, args := shellCommand(string(body))
+	cmd := exec.Command(name, args...)
 
 	stdoutPipe, _ := cmd.StdoutPipe()
 	stderrPipe, _ := cmd.StderrPipe()
 
 	err = cmd.Start()
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to start command: %s"}`, err), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to start command: %s", err), nil)
 		return
 	}
 
 	stdout, _ := io.ReadAll(stdoutPipe)
 	stderr, _ := io.ReadAll(stderrPipe)
-	cmd.Wait()
+	err = cmd.Wait()
 
-	response := map[string]string{
+	response := map[string]any{
 		"stdout": string(stdout),
 		"stderr": string(stderr),
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	if err != nil {
-		response["error"] = err.Error()
+		response["error"] = APIError{Code: CodeInternal, Message: err.Error()}
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 
 	jsonResponse, _ := json.Marshal(response)
-	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonResponse)
 }
 
-func codeExecHandler(w http.ResponseWriter, r *http.Request) {
-	supportedLanguages := []string{"javascript", "typescript"}
+// CodeExecResponse is returned by the code execution endpoint on success
+type CodeExecResponse struct {
+	*ExecResult
+	ExecVerdict
+	ExecTime string `json:"execTime"`
+
+	// DetectedLanguage is the language detected for a request without one
+	DetectedLanguage string `json:"detectedLanguage,omitempty"`
+}
 
+func codeExecHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, `{"error": "Invalid request method"}`, http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, `{"error": "Unable to read request body"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read request body", nil)
 		return
 	}
 	defer r.Body.Close()
@@ -274,81 +172,213 @@ func codeExecHandler(w http.ResponseWriter, r *http.Request) {
 	var req CodeExecRequest
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid JSON format"}`, http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON format", err.Error())
 		return
 	}
 
-	language := req.Language
-	code := req.Code
-
-	fmt.Printf("Language: %s, Code: %s\n", language, code)
-
-	if !isLanguageSupported(language, supportedLanguages) {
-		http.Error(w, `{"error": "Language not supported"}`, http.StatusBadRequest)
+	lang, ok := resolveLanguage(w, &req)
+	if !ok {
 		return
 	}
+	if !checkTrace(w, r, &req) {
+		return
+	}
+	record := newExecutionRecord("exec", &req)
+	records.add(record)
 
-	randomUUID := uuid.New().String()
+	ctx, release, ok := admitExecution(w, r, &queuedExecution{ID: record.ID, Kind: record.Kind, Language: record.Language, bytes: unlimitedMemoryEstimate})
+	if !ok {
+		return
+	}
+	defer release()
 
-	filePath := fmt.Sprintf("/mnt/persistent/%s%s", randomUUID, LANGUAGE_EXTENSIONS[language])
+	// The execution stops if the client disconnects or cancels it
+	ctx, done := executions.start(ctx, record)
+	defer done()
+	announceExecution(w, record.ID)
 
 	start := time.Now()
 
-	err = os.WriteFile(filePath, []byte(req.Code), 0644)
+	job, result, err := prepareAndRun(ctx, lang, &req)
+	if job == nil {
+		status, code := classifyExecutionError(err)
+		writeError(w, status, code, fmt.Sprintf("Unable to prepare workspace: %v", err), timeoutDetails(err))
+		return
+	}
+	defer removeWorkspace(job)
+
+	if writePreempted(w, ctx) {
+		return
+	}
+	if job.Usage != nil {
+		result.Timeline = job.Usage.Timeline
+	}
+	if req.Snapshot {
+		snapshot, err := snapshotWorkspace(job)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to snapshot workspace: %v", err), result)
+			return
+		}
+		result.SnapshotID = snapshot.ID
+	}
+	if req.Trace != "" {
+		result.Artifacts = append(result.Artifacts, traceArtifacts(job)...)
+	}
+	if req.Metrics {
+		result.Metrics = codeMetrics(req.Language, req.Code)
+	}
 	if err != nil {
-		errStr := fmt.Sprintf(`{"error": "Unable to write file: %v"}`, err)
-		http.Error(w, errStr, http.StatusInternalServerError)
+		status, code := classifyExecutionError(err)
+		writeError(w, status, code, executionErrorMessage(err), ExecFailure{result, newExecVerdict(err)})
 		return
 	}
 
-	var stdout, stderr string
+	elapsed := time.Since(start).Milliseconds()
 
-	switch language {
-	case "javascript":
-		stdout, stderr, err = handleJavaScriptExecution(filePath)
+	response := CodeExecResponse{ExecResult: result, ExecVerdict: newExecVerdict(nil), ExecTime: fmt.Sprintf("%d", elapsed)}
+	if req.detected {
+		response.DetectedLanguage = req.Language
+	}
+	jsonResponse, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
 
-	case "typescript":
-		stdout, stderr, err = handleTypeScriptExecution(filePath)
+// resolveLanguage looks up the language and runtime of a request, detecting
+// the language if it is omitted and filling in the default runtime. If either
+// isn't supported it writes the error response and returns false.
+func resolveLanguage(w http.ResponseWriter, req *CodeExecRequest) (*Language, bool) {
+	if req.Language == "" {
+		req.Language = detectLanguage(req.Filename, req.Code)
+		if req.Language == "" {
+			writeError(w, http.StatusBadRequest, CodeUnsupportedLanguage, "Unable to detect the language, set language",
+				map[string]any{"supportedLanguages": supportedLanguages()})
+			return nil, false
+		}
+		req.detected = true
+	}
 
-	default:
-		http.Error(w, `{"error": "Language not supported"}`, http.StatusBadRequest)
-		return
+	lang, ok := languages[req.Language]
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeUnsupportedLanguage, "Language not supported", map[string]any{"supportedLanguages": supportedLanguages()})
+		return nil, false
 	}
 
-	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Execution error: %s"}`, err), http.StatusInternalServerError)
-		return
+	runtime, ok := lang.resolveRuntime(req.Runtime)
+	if !ok {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Runtime not supported", map[string]any{"supportedRuntimes": lang.Runtimes})
+		return nil, false
 	}
+	req.Runtime = runtime
 
-	err = os.Remove(filePath)
-	if err != nil {
-		log.Printf("Warning: Unable to delete file %s: %v", filePath, err)
+	if !checkGitSource(w, req) || !checkSourceURLs(w, req) || !checkServices(w, req) || !checkLocale(w, req) ||
+		!checkStackSize(w, req) || !checkPTY(w, lang, req) {
+		return nil, false
 	}
 
-	elapsed := time.Since(start).Milliseconds()
+	if req.RestoreSnapshot != "" {
+		snapshot, ok := getSnapshot(req.RestoreSnapshot)
+		if !ok || snapshot.Language != lang.Name {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Snapshot not found", map[string]string{"restoreSnapshot": req.RestoreSnapshot})
+			return nil, false
+		}
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(fmt.Sprintf(`{"stdout": "%s", "stderr": "%s", "execTime": "%d"}`, stdout, stderr, elapsed)))
-}
+	if req.Template != "" {
+		req.Template = strings.TrimPrefix(req.Template, lang.Name+"/")
+		if _, ok := namedTemplate(lang, req.Template); !ok {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Template not found", map[string]any{"templates": templateNames(lang.Name)})
+			return nil, false
+		}
+	}
 
-func isLanguageSupported(language string, supportedLanguages []string) bool {
-	for _, lang := range supportedLanguages {
-		if lang == language {
-			return true
+	switch {
+	case req.Mode == ModeServer:
+		if !checkServerSpec(w, req) {
+			return nil, false
+		}
+	case req.Mode == ModeScan:
+		if !checkScanSpec(w, req) {
+			return nil, false
 		}
+	case req.Mode != ModeRun && !(req.Mode == ModeProfile && lang.Profiling):
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Mode not supported", map[string]any{"mode": req.Mode})
+		return nil, false
 	}
 
-	return false
+	return lang, true
 }
 
 func main() {
-	http.HandleFunc("/health", healthHandler)
-	http.HandleFunc("/cmdExec", cmdExecHandler)
-	http.HandleFunc("/code/exec", codeExecHandler)
-
-	log.Println("Server is starting on port 8080")
-	err := http.ListenAndServe(":8080", nil)
+	handle("/health", healthHandler)
+	handle("/version", versionHandler)
+	handle("/capabilities", capabilitiesHandler)
+	handle("/metrics", metricsHandler)
+	handle("/verdicts", verdictsHandler)
+	handle("/cmdExec", cmdExecHandler, compressed, bodyLimit(maxRequestBodyBytes))
+	handle("/code/exec", codeExecHandler, compressed, bodyLimit(maxRequestBodyBytes), replayable("exec"), validated(validateCodeExecRequest))
+	handle("/code/upload", uploadExecHandler, compressed, bodyLimit(maxUploadBytes), validated(validateUploadRequest))
+	handle("/code/validate", validateHandler, bodyLimit(maxJudgeRequestBodyBytes), validated(validateCodeExecRequest))
+	handle("/code/debug", createDebugSessionHandler, bodyLimit(maxRequestBodyBytes), validated(validateCodeExecRequest))
+	handle("/code/debug/{id}", debugSessionHandler, untimed)
+	handle("/code/intel", intelHandler, bodyLimit(maxRequestBodyBytes), validated(validateIntelRequest))
+	handle("/code/judge", judgeHandler, compressed, bodyLimit(maxJudgeRequestBodyBytes), replayable("judge"), validated(validateCodeExecRequest))
+	handle("/executions/export", exportRecordsHandler, compressed)
+	handle("/executions/{id}", executionStatusHandler)
+	handle("/executions/{id}/cancel", cancelExecutionHandler)
+	handle("/executions/{id}/progress", progressHandler)
+	handle("/executions/{id}/replay", replayExecutionHandler, compressed)
+	handle("/programs", registerProgramHandler, compressed, bodyLimit(maxRequestBodyBytes), validated(validateCodeExecRequest))
+	handle("/programs/{id}", programHandler)
+	handle("/snapshots/{id}", snapshotHandler)
+	handle("/admin/templates/{language}", templatesHandler, adminOnly)
+	handle("/admin/templates/{language}/{version}", templateVersionHandler, adminOnly)
+	handle("/admin/templates/{language}/{version}/activate", templateVersionHandler, adminOnly)
+	handle("/admin/bundles/{name}", bundlesHandler, adminOnly)
+	handle("/admin/bundles/{name}/{version}", bundleVersionHandler, adminOnly)
+	handle("/admin/selftest", selfTestHandler, compressed, adminOnly)
+	handle("/admin/outputs", outputsHandler, compressed, adminOnly)
+	handle("/admin/outputs/{id}", outputHandler, compressed, adminOnly)
+	handle("/admin/state/export", exportStateHandler, compressed, adminOnly)
+	handle("/admin/state/import", importStateHandler, adminOnly)
+	handle("/events", eventsHandler, adminOnly)
+	handle("/queue", queueHandler, adminOnly)
+	handle("/queue/{id}/{action}", queueEntryHandler, adminOnly)
+
+	recoverJournal()
+	startEventObservers()
+	loadFaults(config.Faults)
+	loadPlugins(config.PluginDir)
+	loadRunnersConfig(config.RunnersConfig)
+	loadHooksConfig(config.HooksConfig)
+	loadServicesConfig(config.ServicesConfig)
+	loadDebugAdaptersConfig(config.DebugAdaptersConfig)
+	loadLanguageConcurrency(config.LanguageConcurrency)
+	loadTenantWeights(config.TenantWeights)
+	startWarmPools()
+	disk.check()
+	startWatchdog()
+	startPreemption()
+
+	listeners, err := activatedListeners()
+	if err == nil && listeners == nil {
+		listeners, err = openListeners()
+	}
 	if err != nil {
 		log.Fatalf("Server failed: %s", err)
 	}
+
+	log.Println("Server is starting")
+	server := newHTTPServer(chain(http.DefaultServeMux,
+		withHTTPMetrics, withRequestLog, withRecovery, withRoute, withRequestDeadline, withCORS, withRateLimit, withCapabilities, withAffinity,
+		withRouteCompression, withRouteAuth, withRouteBodyLimit, withRouteReplay, withRouteValidation))
+	go shutdownOnSignal(server)
+	go prewarmLanguages(func() { sdNotify("READY=1") })
+	startSystemdWatchdog()
+
+	err = serve(server, listeners)
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Server failed: %s", err)
+	}
+	<-shutdownDone
 }
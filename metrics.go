@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// metricsHandler exposes the agent's metrics in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	usage, pressure, gcRuns, gcRemoved := disk.snapshot()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "octree_workspace_disk_total_bytes", "gauge", "Size of the disk holding the workspaces", usage.TotalBytes)
+	writeMetric(w, "octree_workspace_disk_free_bytes", "gauge", "Space available on the disk holding the workspaces", usage.FreeBytes)
+	writeMetric(w, "octree_workspace_disk_total_inodes", "gauge", "Inodes of the disk holding the workspaces", usage.TotalInodes)
+	writeMetric(w, "octree_workspace_disk_free_inodes", "gauge", "Inodes available on the disk holding the workspaces", usage.FreeInodes)
+	writeMetric(w, "octree_workspace_disk_pressure", "gauge", "Whether the disk holding the workspaces is used past its threshold", boolMetric(pressure))
+	writeMetric(w, "octree_workspace_disk_gc_runs_total", "counter", "Garbage collections of the disk holding the workspaces", gcRuns)
+	writeMetric(w, "octree_workspace_disk_gc_removed_total", "counter", "Stale workspaces and compile cache entries removed", gcRemoved)
+	writeMetric(w, "octree_executions_accepted_total", "counter", "Executions accepted into the queue", eventMetrics.accepted.Load())
+	writeMetric(w, "octree_executions_started_total", "counter", "Executions started", eventMetrics.started.Load())
+	writeMetric(w, "octree_executions_finished_total", "counter", "Executions finished", eventMetrics.finished.Load())
+	writeMetric(w, "octree_executions_cancelled_total", "counter", "Executions finished after being cancelled or timing out", eventMetrics.cancelled.Load())
+	writeMetric(w, "octree_http_requests_total", "counter", "HTTP requests received", httpMetrics.requests.Load())
+	writeMetric(w, "octree_http_requests_in_flight", "gauge", "HTTP requests being served", httpMetrics.inFlight.Load())
+	writeMetric(w, "octree_http_client_errors_total", "counter", "HTTP requests answered with a 4xx status", httpMetrics.clientErrors.Load())
+	writeMetric(w, "octree_http_server_errors_total", "counter", "HTTP requests answered with a 5xx status", httpMetrics.serverErrors.Load())
+	writeMetric(w, "octree_http_panics_total", "counter", "HTTP handlers that panicked", httpMetrics.panics.Load())
+	writeMetric(w, "octree_http_rate_limited_total", "counter", "HTTP requests turned away by the rate limit", httpMetrics.rateLimited.Load())
+	writeMetric(w, "octree_execution_output_bytes_total", "counter", "Bytes written by the commands of executions", eventMetrics.outputBytes.Load())
+}
+
+// writeMetric writes a metric without labels, with its help and type
+func writeMetric(w io.Writer, name string, kind string, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+// boolMetric returns 1 for true and 0 for false
+func boolMetric(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package main
+
+import "strings"
+
+// CodeMetrics are static metrics of a submission, for graders to show
+// alongside its results. They are computed from the same tokens as the
+// fingerprints, so they work alike for every language.
+type CodeMetrics struct {
+	Lines        int `json:"lines"`
+	CodeLines    int `json:"codeLines"`
+	CommentLines int `json:"commentLines"`
+	BlankLines   int `json:"blankLines"`
+	Tokens       int `json:"tokens"`
+
+	// Complexity estimates the cyclomatic complexity of the whole program:
+	// one plus its number of decision points
+	Complexity int `json:"complexity"`
+}
+
+// decisionKeywords are the keywords that branch or loop
+var decisionKeywords = map[string]bool{
+	"if": true, "elif": true, "elsif": true, "unless": true, "case": true, "when": true,
+	"for": true, "foreach": true, "while": true, "until": true, "catch": true, "rescue": true,
+	"and": true, "or": true,
+}
+
+// codeMetrics computes the metrics of code written in language
+func codeMetrics(language string, code string) *CodeMetrics {
+	metrics := &CodeMetrics{Complexity: 1}
+
+	// The line break ending the last line doesn't start another
+	nonBlank := 0
+	for _, line := range strings.Split(strings.TrimSuffix(code, "\n"), "\n") {
+		metrics.Lines++
+		if strings.TrimSpace(line) == "" {
+			metrics.BlankLines++
+		} else {
+			nonBlank++
+		}
+	}
+
+	// Comments are stripped without their line breaks, so lines left with
+	// code are counted on the stripped code
+	stripped := stripComments(code, languageComments[language])
+	for _, line := range strings.Split(stripped, "\n") {
+		if strings.TrimSpace(line) != "" {
+			metrics.CodeLines++
+		}
+	}
+	metrics.CodeLines = min(metrics.CodeLines, nonBlank)
+	metrics.CommentLines = nonBlank - metrics.CodeLines
+
+	tokens := fingerprintTokens(stripped)
+	metrics.Tokens = len(tokens)
+	for i, token := range tokens {
+		switch {
+		case decisionKeywords[token], token == "?":
+			metrics.Complexity++
+		case (token == "&" || token == "|") && i > 0 && tokens[i-1] == token:
+			// && and ||, counted once
+			if i < 2 || tokens[i-2] != token {
+				metrics.Complexity++
+			}
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxRequestBodyBytes bounds how much of a request body is ever read into memory
+	maxRequestBodyBytes = 1 << 20
+
+	// maxCodeBytes bounds the size of the submitted source code
+	maxCodeBytes = 256 << 10
+)
+
+// limitRequestBody reads at most maxBytes of the request body before handing
+// the request to next, rejecting larger bodies with a 413.
+func limitRequestBody(maxBytes int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		r.Body.Close()
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeError(w, http.StatusRequestEntityTooLarge, CodeInvalidRequest,
+					fmt.Sprintf("Request body exceeds the limit of %d bytes", maxBytes),
+					map[string]int64{"limitBytes": maxBytes})
+				return
+			}
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read request body", nil)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// readValidBody reads the request body, which must be valid UTF-8. If it
+// isn't it writes the error response and returns false.
+func readValidBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read request body", nil)
+		return nil, false
+	}
+
+	if !utf8.Valid(body) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Request body must be valid UTF-8", nil)
+		return nil, false
+	}
+	return body, true
+}
+
+// checkCodeSize rejects code larger than maxCodeBytes
+func checkCodeSize(w http.ResponseWriter, code string) bool {
+	if len(code) > maxCodeBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, CodeInvalidRequest,
+			fmt.Sprintf("Code exceeds the limit of %d bytes", maxCodeBytes),
+			map[string]int{"limitBytes": maxCodeBytes, "codeBytes": len(code)})
+		return false
+	}
+	return true
+}
+
+// validateCodeExecRequest checks that a code execution request is valid UTF-8
+// JSON with all required fields present before handing it to next.
+func validateCodeExecRequest(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Let the handler reject the method itself
+		if r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		body, ok := readValidBody(w, r)
+		if !ok {
+			return
+		}
+
+		var req CodeExecRequest
+		err := json.Unmarshal(body, &req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON format", err.Error())
+			return
+		}
+
+		// A missing language is detected from the code
+		var missing []string
+		if req.Code == "" && req.Git == nil && req.CodeURL == "" {
+			missing = append(missing, "code")
+		}
+		if len(missing) > 0 {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Missing required fields",
+				map[string][]string{"fields": missing})
+			return
+		}
+
+		if !checkCodeSize(w, req.Code) {
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// validateIntelRequest checks that a code intel request is valid UTF-8 JSON
+// asking about a position in the code before handing it to next.
+func validateIntelRequest(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Let the handler reject the method itself
+		if r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		body, ok := readValidBody(w, r)
+		if !ok {
+			return
+		}
+
+		var req IntelRequest
+		err := json.Unmarshal(body, &req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON format", err.Error())
+			return
+		}
+
+		if !checkCodeSize(w, req.Code) {
+			return
+		}
+		if req.Line < 1 || req.Column < 1 {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Line and column must be at least 1", nil)
+			return
+		}
+		for _, kind := range req.Kinds {
+			if kind != intelHover && kind != intelDiagnostics && kind != intelCompletions {
+				writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Kinds must be hover, diagnostics or completions", map[string]string{"kind": kind})
+				return
+			}
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// validateUploadRequest checks that a project upload is multipart/form-data
+// with a valid manifest and an archive before handing it to next, which
+// finds the form parsed on the request.
+func validateUploadRequest(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Let the handler reject the method itself
+		if r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		err := r.ParseMultipartForm(maxUploadBytes)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid multipart/form-data body", err.Error())
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		manifest := []byte(r.FormValue("manifest"))
+		if !utf8.Valid(manifest) {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Manifest must be valid UTF-8", nil)
+			return
+		}
+
+		var req UploadManifest
+		err = json.Unmarshal(manifest, &req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid manifest", err.Error())
+			return
+		}
+
+		if req.Code != "" || req.CodeURL != "" {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "The code of an upload comes from its archive", nil)
+			return
+		}
+		if len(r.MultipartForm.File["archive"]) == 0 {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Missing required fields", map[string][]string{"fields": {"archive"}})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// compressResponseWriter sends everything written to it through a compressor
+type compressResponseWriter struct {
+	http.ResponseWriter
+	writer io.Writer
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	cw.Header().Del("Content-Length")
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	cw.Header().Del("Content-Length")
+	return cw.writer.Write(b)
+}
+
+// withCompression transparently decompresses gzip or deflate request bodies
+// and compresses the response according to the client's Accept-Encoding.
+func withCompression(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Step 1: Decompress the request body, if needed
+		switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			gzipReader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid gzip request body", err.Error())
+				return
+			}
+			defer gzipReader.Close()
+			r.Body = gzipReader
+		case "deflate":
+			zlibReader, err := zlib.NewReader(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid deflate request body", err.Error())
+				return
+			}
+			defer zlibReader.Close()
+			r.Body = zlibReader
+		default:
+			writeError(w, http.StatusUnsupportedMediaType, CodeInvalidRequest, "Unsupported Content-Encoding",
+				map[string][]string{"supportedEncodings": {"gzip", "deflate"}})
+			return
+		}
+		r.Header.Del("Content-Encoding")
+		r.ContentLength = -1
+
+		// Step 2: Compress the response, if the client accepts it
+		w.Header().Add("Vary", "Accept-Encoding")
+		acceptEncoding := r.Header.Get("Accept-Encoding")
+
+		switch {
+		case acceptsEncoding(acceptEncoding, "gzip"):
+			gzipWriter := gzip.NewWriter(w)
+			defer gzipWriter.Close()
+			w.Header().Set("Content-Encoding", "gzip")
+			w = &compressResponseWriter{ResponseWriter: w, writer: gzipWriter}
+		case acceptsEncoding(acceptEncoding, "deflate"):
+			zlibWriter := zlib.NewWriter(w)
+			defer zlibWriter.Close()
+			w.Header().Set("Content-Encoding", "deflate")
+			w = &compressResponseWriter{ResponseWriter: w, writer: zlibWriter}
+		}
+
+		next(w, r)
+	}
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows encoding
+func acceptsEncoding(header string, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+
+		q := strings.ReplaceAll(strings.TrimSpace(params), " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+
+	return false
+}
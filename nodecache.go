@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Node (22.1 and later) keeps a V8 code cache of the modules it loads in the
+// directory named by NODE_COMPILE_CACHE, which makes loading a template's
+// dependencies (e.g. the TypeScript compiler under ts-node) much faster from
+// the second run on. Each template gets its own cache directory, keyed by the
+// node version and the files declaring its dependencies, and the directories
+// of previous versions of the template are removed when the key changes.
+
+// nodeTemplateFiles are the template files whose changes invalidate its code cache
+var nodeTemplateFiles = []string{"package.json", "package-lock.json", "tsconfig.json"}
+
+// nodeCompileCacheEnv returns the environment enabling the code cache of
+// template, the current version of the template identified by key
+func nodeCompileCacheEnv(ctx context.Context, key string, template string) []string {
+	if config.NodeCompileCacheDir == "" || template == "" {
+		return nil
+	}
+
+	dir, err := nodeCompileCacheDir(ctx, key, template)
+	if err != nil {
+		log.Printf("Warning: not using the node compile cache: %s", err)
+		return nil
+	}
+
+	return []string{"NODE_COMPILE_CACHE=" + dir}
+}
+
+// nodeCompileCacheDir returns the code cache directory of template, the
+// current version of the template identified by key, creating it and removing
+// the ones of previous versions
+func nodeCompileCacheDir(ctx context.Context, key string, template string) (string, error) {
+	version, err := toolchainVersion(ctx, "node")
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00", version)
+	for _, name := range nodeTemplateFiles {
+		data, err := os.ReadFile(filepath.Join(template, name))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", name, len(data))
+		hash.Write(data)
+	}
+
+	parent := filepath.Join(config.NodeCompileCacheDir, key)
+	dir := filepath.Join(parent, hex.EncodeToString(hash.Sum(nil))[:16])
+
+	_, err = os.Stat(dir)
+	if err == nil {
+		return dir, nil
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	// The template changed, so the caches of its previous versions are dead weight
+	entries, _ := os.ReadDir(parent)
+	for _, entry := range entries {
+		path := filepath.Join(parent, entry.Name())
+		if path != dir {
+			err := os.RemoveAll(path)
+			if err != nil {
+				log.Printf("Warning: failed to remove stale node compile cache %s: %s", path, err)
+			}
+		}
+	}
+
+	return dir, nil
+}
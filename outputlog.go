@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The full output of every command run for the most recent executions is kept
+// in memory, for operators to debug reports like "it worked in the editor but
+// the judge cut off the interesting part" without enabling verbose logging
+// everywhere. At most config.OutputLogExecutions executions are kept, and
+// the oldest are dropped once their output takes more than
+// config.OutputLogBytes.
+
+// CapturedCommand is the output of a command run for an execution
+type CapturedCommand struct {
+	Command    string    `json:"command"`
+	Args       []string  `json:"args"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+	ExitCode   int       `json:"exitCode"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+}
+
+// CapturedExecution is the output of every command run for an execution
+type CapturedExecution struct {
+	ID       string            `json:"id"`
+	Bytes    int64             `json:"bytes"`
+	Commands []CapturedCommand `json:"commands"`
+}
+
+// outputLog keeps the output of the most recent executions
+type outputLog struct {
+	mu         sync.Mutex
+	executions map[string]*CapturedExecution
+	order      []string
+	bytes      int64
+}
+
+// outputs holds the output of the most recent executions of this agent
+var outputs = &outputLog{executions: map[string]*CapturedExecution{}}
+
+// add appends the output of a command run for the execution id, dropping the
+// oldest executions past the limits. The latest execution is always kept.
+func (l *outputLog) add(id string, captured CapturedCommand) {
+	if id == "" || config.OutputLogExecutions <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	execution, ok := l.executions[id]
+	if !ok {
+		execution = &CapturedExecution{ID: id}
+		l.executions[id] = execution
+		l.order = append(l.order, id)
+	}
+	size := int64(len(captured.Stdout) + len(captured.Stderr))
+	execution.Commands = append(execution.Commands, captured)
+	execution.Bytes += size
+	l.bytes += size
+
+	for len(l.order) > 1 && (len(l.order) > config.OutputLogExecutions || l.bytes > config.OutputLogBytes) {
+		l.bytes -= l.executions[l.order[0]].Bytes
+		delete(l.executions, l.order[0])
+		l.order = l.order[1:]
+	}
+}
+
+// get returns the output of the execution id, if it is still kept
+func (l *outputLog) get(id string) (CapturedExecution, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	execution, ok := l.executions[id]
+	if !ok {
+		return CapturedExecution{}, false
+	}
+	captured := *execution
+	captured.Commands = append([]CapturedCommand(nil), execution.Commands...)
+	return captured, true
+}
+
+// OutputLogEntry summarises the output kept for an execution
+type OutputLogEntry struct {
+	ID       string `json:"id"`
+	Bytes    int64  `json:"bytes"`
+	Commands int    `json:"commands"`
+}
+
+// list summarises the executions whose output is kept, newest first
+func (l *outputLog) list() []OutputLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []OutputLogEntry{}
+	for i := len(l.order) - 1; i >= 0; i-- {
+		execution := l.executions[l.order[i]]
+		entries = append(entries, OutputLogEntry{ID: execution.ID, Bytes: execution.Bytes, Commands: len(execution.Commands)})
+	}
+	return entries
+}
+
+// outputsHandler lists the executions whose output is kept
+func outputsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	jsonResponse, _ := json.Marshal(map[string]any{"executions": outputs.list()})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
+
+// outputHandler returns the full output of the execution /admin/outputs/{id}
+func outputHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	captured, ok := outputs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Execution output not found", nil)
+		return
+	}
+
+	jsonResponse, _ := json.Marshal(captured)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
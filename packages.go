@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Problem packages can be uploaded as bundles as they are, with their test
+// data, limits and way of checking outputs mapped from the package:
+//
+//   - Polygon packages (as exported for Codeforces), described by problem.xml
+//   - ICPC problem packages, described by problem.yaml, with their test data
+//     in data/sample and data/secret
+//
+// The standard testlib checkers and the ICPC default output validator map to
+// comparisons. Custom checkers, output validators and interactors speak
+// protocols of their own (and are mostly C++), so a package with one needs a
+// bundle.json naming a checker, interactor or comparison to use instead.
+
+// testlibComparisons maps the standard testlib checkers to the comparison
+// closest to them; nil compares the outputs line by line
+var testlibComparisons = map[string]*ComparisonSpec{
+	"std::fcmp.cpp":     nil,
+	"std::lcmp.cpp":     {},
+	"std::wcmp.cpp":     {},
+	"std::ncmp.cpp":     {},
+	"std::hcmp.cpp":     {},
+	"std::yesno.cpp":    {},
+	"std::nyesno.cpp":   {},
+	"std::caseicmp.cpp": {},
+	"std::casencmp.cpp": {},
+	"std::casewcmp.cpp": {},
+	"std::acmp.cpp":     {AbsoluteError: 1.5e-6},
+	"std::rcmp.cpp":     {AbsoluteError: 1.5e-6},
+	"std::dcmp.cpp":     {AbsoluteError: 1e-6, RelativeError: 1e-6},
+	"std::rcmp4.cpp":    {AbsoluteError: 1e-4, RelativeError: 1e-4},
+	"std::rcmp6.cpp":    {AbsoluteError: 1e-6, RelativeError: 1e-6},
+	"std::rcmp9.cpp":    {AbsoluteError: 1e-9, RelativeError: 1e-9},
+}
+
+// polygonProblem is the part of a Polygon problem.xml the import reads
+type polygonProblem struct {
+	Testsets   []polygonTestset `xml:"judging>testset"`
+	Checker    *polygonAsset    `xml:"assets>checker"`
+	Interactor *polygonAsset    `xml:"assets>interactor"`
+}
+
+// polygonTestset is a testset of a Polygon package. The paths of its tests
+// are patterns formatted with the test number, as in "tests/%02d".
+type polygonTestset struct {
+	Name          string `xml:"name,attr"`
+	TimeLimitMs   int64  `xml:"time-limit"`
+	MemoryLimit   int64  `xml:"memory-limit"`
+	TestCount     int    `xml:"test-count"`
+	InputPattern  string `xml:"input-path-pattern"`
+	AnswerPattern string `xml:"answer-path-pattern"`
+}
+
+// polygonAsset is a checker or interactor of a Polygon package
+type polygonAsset struct {
+	Name   string `xml:"name,attr"`
+	Source struct {
+		Path string `xml:"path,attr"`
+	} `xml:"source"`
+}
+
+// packageRoot returns the directory of a package unpacked in dir, which
+// archives often wrap in a directory of its own
+func packageRoot(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err == nil && len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name())
+	}
+	return dir
+}
+
+// importPackage moves the test cases of the package unpacked in pkg into the
+// directory tests. It returns the settings mapped from the package, what it
+// has that couldn't be mapped ("" if nothing) and the number of test cases.
+// Archives that aren't problem packages are read as plain .in and .out files.
+func importPackage(pkg string, tests string) (BundleSpec, string, int, error) {
+	if _, err := os.Stat(filepath.Join(pkg, "problem.xml")); err == nil {
+		return importPolygonPackage(pkg, tests)
+	}
+	if _, err := os.Stat(filepath.Join(pkg, "problem.yaml")); err == nil {
+		return importICPCPackage(pkg, tests)
+	}
+
+	cases, err := collectBundleTests(pkg, pkg, tests)
+	return BundleSpec{}, "", cases, err
+}
+
+// importPolygonPackage imports the Polygon package unpacked in pkg
+func importPolygonPackage(pkg string, tests string) (BundleSpec, string, int, error) {
+	data, err := os.ReadFile(filepath.Join(pkg, "problem.xml"))
+	if err != nil {
+		return BundleSpec{}, "", 0, err
+	}
+	var problem polygonProblem
+	err = xml.Unmarshal(data, &problem)
+	if err != nil {
+		return BundleSpec{}, "", 0, fmt.Errorf("invalid problem.xml: %w", err)
+	}
+
+	// Step 1: Find the tests of the testset submissions are judged on
+	var testset *polygonTestset
+	for i := range problem.Testsets {
+		if problem.Testsets[i].Name == "tests" {
+			testset = &problem.Testsets[i]
+		}
+	}
+	if testset == nil {
+		return BundleSpec{}, "", 0, fmt.Errorf(`problem.xml has no testset named "tests"`)
+	}
+
+	var files []testFiles
+	for i := 1; i <= testset.TestCount; i++ {
+		input, ok := extractedPath(pkg, fmt.Sprintf(testset.InputPattern, i))
+		if !ok {
+			return BundleSpec{}, "", 0, fmt.Errorf("invalid input path pattern %q", testset.InputPattern)
+		}
+		answer, ok := extractedPath(pkg, fmt.Sprintf(testset.AnswerPattern, i))
+		if !ok {
+			return BundleSpec{}, "", 0, fmt.Errorf("invalid answer path pattern %q", testset.AnswerPattern)
+		}
+
+		if _, err := os.Stat(input); err != nil {
+			return BundleSpec{}, "", 0, fmt.Errorf("the input of test %d is missing", i)
+		}
+		// Packages other than full ones leave the answers to be generated
+		if _, err := os.Stat(answer); err != nil {
+			return BundleSpec{}, "", 0, fmt.Errorf("the answer of test %d is missing; upload a full package, which includes the answers", i)
+		}
+		files = append(files, testFiles{input: input, answer: answer})
+	}
+
+	cases, err := moveTestFiles(tests, files)
+	if err != nil {
+		return BundleSpec{}, "", 0, err
+	}
+
+	// Step 2: Map its limits and checker
+	spec := BundleSpec{
+		TimeLimitMs:   testset.TimeLimitMs,
+		MemoryLimitMB: (testset.MemoryLimit + 1<<20 - 1) >> 20,
+	}
+
+	unmapped := ""
+	switch {
+	case problem.Interactor != nil:
+		unmapped = fmt.Sprintf("interactor %q", problem.Interactor.Source.Path)
+	case problem.Checker != nil:
+		comparison, ok := testlibComparisons[problem.Checker.Name]
+		if !ok {
+			unmapped = fmt.Sprintf("checker %q", problem.Checker.Source.Path)
+		}
+		spec.Comparison = comparison
+	}
+
+	return spec, unmapped, cases, nil
+}
+
+// importICPCPackage imports the ICPC problem package unpacked in pkg
+func importICPCPackage(pkg string, tests string) (BundleSpec, string, int, error) {
+	data, err := os.ReadFile(filepath.Join(pkg, "problem.yaml"))
+	if err != nil {
+		return BundleSpec{}, "", 0, err
+	}
+	problem := readYAMLScalars(string(data))
+
+	// Step 1: Take the sample tests, then the secret ones
+	var files []testFiles
+	for _, dir := range []string{"sample", "secret"} {
+		found, err := findTestFiles(pkg, filepath.Join(pkg, "data", dir))
+		if err != nil {
+			return BundleSpec{}, "", 0, err
+		}
+		files = append(files, found...)
+	}
+
+	cases, err := moveTestFiles(tests, files)
+	if err != nil {
+		return BundleSpec{}, "", 0, err
+	}
+
+	// Step 2: Map its limits, given in seconds and MB; older packages keep
+	// the time limit in .timelimit
+	var spec BundleSpec
+
+	seconds := problem["limits.time_limit"]
+	if data, err := os.ReadFile(filepath.Join(pkg, ".timelimit")); err == nil && seconds == "" {
+		seconds = strings.TrimSpace(string(data))
+	}
+	if seconds != "" {
+		t, err := strconv.ParseFloat(seconds, 64)
+		if err != nil {
+			return BundleSpec{}, "", 0, fmt.Errorf("invalid time limit %q", seconds)
+		}
+		spec.TimeLimitMs = int64(math.Ceil(t * 1000))
+	}
+
+	if memory := problem["limits.memory"]; memory != "" {
+		spec.MemoryLimitMB, err = strconv.ParseInt(memory, 10, 64)
+		if err != nil {
+			return BundleSpec{}, "", 0, fmt.Errorf("invalid memory limit %q", memory)
+		}
+	}
+
+	// Step 3: Map its output validator. The default one compares tokens,
+	// with the tolerance for numbers given in its flags, which newer
+	// packages keep in data/testdata.yaml.
+	validation := problem["validation"] + " " + problem["type"]
+	switch {
+	case strings.Contains(validation, "interactive"):
+		return spec, "interactor", cases, nil
+	case strings.Contains(validation, "custom") || isDir(filepath.Join(pkg, "output_validators")) || isDir(filepath.Join(pkg, "output_validator")):
+		return spec, "output validator", cases, nil
+	}
+
+	flags := problem["validator_flags"]
+	if data, err := os.ReadFile(filepath.Join(pkg, "data", "testdata.yaml")); err == nil && flags == "" {
+		flags = readYAMLScalars(string(data))["output_validator_flags"]
+	}
+
+	spec.Comparison = &ComparisonSpec{}
+	fields := strings.Fields(flags)
+	for i := 0; i+1 < len(fields); i++ {
+		tolerance, err := strconv.ParseFloat(fields[i+1], 64)
+		if err != nil {
+			continue
+		}
+		switch fields[i] {
+		case "float_tolerance":
+			spec.Comparison.AbsoluteError, spec.Comparison.RelativeError = tolerance, tolerance
+		case "float_absolute_tolerance":
+			spec.Comparison.AbsoluteError = tolerance
+		case "float_relative_tolerance":
+			spec.Comparison.RelativeError = tolerance
+		}
+	}
+
+	return spec, "", cases, nil
+}
+
+// readYAMLScalars reads the scalars of a YAML mapping, with the keys of nested
+// mappings joined by dots, as in "limits.memory". Lists and multi-line values
+// are skipped: the settings problem packages are read for don't need them.
+func readYAMLScalars(data string) map[string]string {
+	values := map[string]string{}
+
+	// parents are the keys of the mappings the current line is in, at indents
+	var parents []string
+	var indents []int
+	for _, line := range strings.Split(data, "\n") {
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		for len(indents) > 0 && indents[len(indents)-1] >= indent {
+			parents, indents = parents[:len(parents)-1], indents[:len(indents)-1]
+		}
+
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if value == "" {
+			parents, indents = append(parents, key), append(indents, indent)
+			continue
+		}
+		values[strings.Join(append(parents[:len(parents):len(parents)], key), ".")] = strings.Trim(value, `"'`)
+	}
+	return values
+}
+
+// isDir reports whether path is a directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
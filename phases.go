@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Each phase of an execution has a timeout of its own (see Config), so that a
+// slow one doesn't eat the time of the next: a setup hook running `npm ci`
+// doesn't shorten the time the program has to run. A phase running past its
+// timeout fails with a phaseTimeoutError, which tells the client which one.
+
+// Phases of an execution
+const (
+	PhaseSetup   = "setup"
+	PhaseCompile = "compile"
+	PhaseRun     = "run"
+	PhaseCleanup = "cleanup"
+)
+
+// phaseTimeout returns the configured timeout of phase, 0 if it is left to
+// the timeouts of its commands
+func phaseTimeout(phase string) time.Duration {
+	ms := map[string]int{
+		PhaseSetup:   config.SetupTimeoutMs,
+		PhaseCompile: config.CompileTimeoutMs,
+		PhaseRun:     config.RunTimeoutMs,
+		PhaseCleanup: config.CleanupTimeoutMs,
+	}[phase]
+	return time.Duration(ms) * time.Millisecond
+}
+
+// PhaseTimeout tells which phase of an execution ran past its timeout
+type PhaseTimeout struct {
+	Phase     string `json:"phase"`
+	TimeoutMs int64  `json:"timeoutMs"`
+}
+
+// phaseTimeoutError is returned (wrapping the timeout) when a phase of an
+// execution runs past its timeout
+type phaseTimeoutError struct {
+	phase   string
+	timeout time.Duration
+	err     error
+}
+
+func (e *phaseTimeoutError) Error() string {
+	return fmt.Sprintf("%s phase: %s", e.phase, e.err)
+}
+
+func (e *phaseTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// inPhase tags err, if it is a wall-clock timeout, as the timeout of phase,
+// which had timeout to run
+func inPhase(phase string, timeout time.Duration, err error) error {
+	var phaseErr *phaseTimeoutError
+	if !errors.Is(err, errExecutionTimeout) || errors.Is(err, errCPUTimeLimit) || errors.As(err, &phaseErr) {
+		return err
+	}
+	return &phaseTimeoutError{phase: phase, timeout: timeout, err: err}
+}
+
+// timedOutPhase returns the phase err timed out in, or nil if it isn't a
+// phase timeout
+func timedOutPhase(err error) *PhaseTimeout {
+	var phaseErr *phaseTimeoutError
+	if !errors.As(err, &phaseErr) {
+		return nil
+	}
+	return &PhaseTimeout{Phase: phaseErr.phase, TimeoutMs: phaseErr.timeout.Milliseconds()}
+}
+
+// timeoutDetails returns the details of the error response for a workspace
+// that couldn't be prepared: the phase that timed out, if it did
+func timeoutDetails(err error) any {
+	if timeout := timedOutPhase(err); timeout != nil {
+		return timeout
+	}
+	return nil
+}
+
+// withPhaseTimeout bounds ctx by the timeout of phase, if it is set
+func withPhaseTimeout(ctx context.Context, phase string) (context.Context, context.CancelFunc) {
+	timeout := phaseTimeout(phase)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// runCompiler runs a compile step of a job within the compile timeout
+func runCompiler(ctx context.Context, c command) (*commandResult, error) {
+	progress.advance(ctx, ProgressCompiling)
+	if timeout := phaseTimeout(PhaseCompile); timeout > 0 {
+		c.Timeout = timeout
+	}
+
+	res, err := runCommand(ctx, c)
+	return res, inPhase(PhaseCompile, c.timeout(), err)
+}
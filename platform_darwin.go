@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// On macOS the agent runs on developers' machines: workspaces are created in
+// the user's temp dir, and programs run under sandbox-exec, which keeps them
+// from writing outside their workspace and from using the network.
+
+// sandboxProfile is the default sandbox-exec profile. Programs may read any
+// file, since toolchains are installed all over a developer's machine, but
+// only write to their workspace and the temp dir, and only listen on
+// loopback for server mode.
+const sandboxProfile = `(version 1)
+(deny default)
+(allow process-exec)
+(allow process-fork)
+(allow signal (target same-sandbox))
+(allow sysctl-read)
+(allow mach-lookup)
+(allow ipc-posix-shm)
+(allow file-ioctl)
+(allow file-read*)
+(allow file-write*
+	(subpath (param "WORKSPACE"))
+	(subpath (param "TMPDIR"))
+	(literal "/dev/null")
+	(literal "/dev/tty"))
+(allow network-bind (local ip "localhost:*"))
+(allow network-inbound (local ip "localhost:*"))
+(allow network-outbound (remote ip "localhost:*"))
+`
+
+// defaultWorkspaceRoot is where workspaces are created unless configured otherwise
+func defaultWorkspaceRoot() string {
+	return filepath.Join(os.TempDir(), "octree")
+}
+
+// sandboxedCommand returns the command line running name with args under
+// sandbox-exec, with config.SandboxProfile or the default profile
+func sandboxedCommand(job *ExecJob, name string, args []string) (string, []string) {
+	profile := []string{"-p", sandboxProfile}
+	if config.SandboxProfile != "" {
+		profile = []string{"-f", config.SandboxProfile}
+	}
+
+	// The sandbox matches resolved paths, and the temp dir is behind the /var symlink
+	sandboxArgs := append(profile, "-D", "WORKSPACE="+realPath(job.Dir), "-D", "TMPDIR="+realPath(os.TempDir()), name)
+	return "sandbox-exec", append(sandboxArgs, args...)
+}
+
+// realPath returns path with its symlinks resolved, or as is if that fails
+func realPath(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return resolved
+}
@@ -0,0 +1,120 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// defaultConfigDir holds the agent's config files
+func defaultConfigDir() string {
+	return "/etc/octree"
+}
+
+// defaultInstallDir holds the plugins and toolchains installed for the agent
+func defaultInstallDir() string {
+	return "/opt/octree"
+}
+
+// shellCommand returns the command running script with the system shell
+func shellCommand(script string) (string, []string) {
+	return "sh", []string{"-c", script}
+}
+
+// diskUsage returns the space and inodes of the disk holding path, the free
+// ones being those available to the agent
+func diskUsage(path string) (DiskUsage, error) {
+	var fs syscall.Statfs_t
+	err := syscall.Statfs(path, &fs)
+	if err != nil {
+		return DiskUsage{}, err
+	}
+	return DiskUsage{
+		TotalBytes:  int64(fs.Blocks) * int64(fs.Bsize),
+		FreeBytes:   int64(fs.Bavail) * int64(fs.Bsize),
+		TotalInodes: int64(fs.Files),
+		FreeInodes:  int64(fs.Ffree),
+	}, nil
+}
+
+// hardLinks returns the number of hard links of the file described by info
+func hardLinks(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
+
+// reexecAgent replaces the agent with a fresh instance of itself
+func reexecAgent() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(executable, os.Args, os.Environ())
+}
+
+// commandTree is the tree of processes started by a command. On Unix the
+// command is started in a process group of its own, which is killed, with
+// whatever the command forked into it, once its context is done or it has
+// finished.
+type commandTree struct {
+	pgid int
+}
+
+// newCommandTree prepares cmd so its whole process tree can be killed
+func newCommandTree(cmd *exec.Cmd) *commandTree {
+	t := &commandTree{}
+
+	// A command started in a session of its own (under a pseudo-terminal)
+	// already leads its process group, and can't be moved to another one
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if !cmd.SysProcAttr.Setsid {
+		cmd.SysProcAttr.Setpgid = true
+	}
+
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd.Process)
+	}
+	return t
+}
+
+// attach tracks the started process, which leads the tree's process group
+func (t *commandTree) attach(process *os.Process) {
+	t.pgid = process.Pid
+}
+
+// limit caps the memory and CPU time of the tree, which Unix leaves to the
+// rlimits and the watch
+func (t *commandTree) limit(memory int64, cpu time.Duration) {}
+
+// usage returns the peak memory (in kilobytes) and CPU time of the tree, and
+// whether it was refused memory past its cap, which Unix leaves to the
+// rusage of the command and the watch
+func (t *commandTree) usage() (int64, time.Duration, bool) {
+	return 0, 0, false
+}
+
+// close kills what is left of the tree once the command has finished
+func (t *commandTree) close() {
+	t.kill()
+}
+
+// kill kills every process of the tree's process group
+func (t *commandTree) kill() {
+	if t.pgid > 0 {
+		syscall.Kill(-t.pgid, syscall.SIGKILL)
+	}
+}
+
+// killProcessGroup kills process along with the process group it leads, if
+// it does
+func killProcessGroup(process *os.Process) error {
+	syscall.Kill(-process.Pid, syscall.SIGKILL)
+	return process.Kill()
+}
@@ -0,0 +1,253 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32                      = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObjectW          = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject   = kernel32.NewProc("SetInformationJobObject")
+	procQueryInformationJobObject = kernel32.NewProc("QueryInformationJobObject")
+	procAssignProcessToJobObject  = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject        = kernel32.NewProc("TerminateJobObject")
+	procGetDiskFreeSpaceExW       = kernel32.NewProc("GetDiskFreeSpaceExW")
+)
+
+const (
+	// jobObjectBasicAccountingInformation is the JOBOBJECTINFOCLASS of jobObjectAccounting
+	jobObjectBasicAccountingInformation = 1
+
+	// jobObjectExtendedLimitInformation is the JOBOBJECTINFOCLASS of jobObjectExtendedLimits
+	jobObjectExtendedLimitInformation = 9
+
+	// jobObjectLimitKillOnJobClose kills the job's processes when its last handle is closed
+	jobObjectLimitKillOnJobClose = 0x2000
+
+	// jobObjectLimitJobTime caps the user time of the job's processes, which
+	// are terminated once they have used it up
+	jobObjectLimitJobTime = 0x4
+
+	// jobObjectLimitJobMemory caps the memory committed by the job's
+	// processes, whose allocations past it fail
+	jobObjectLimitJobMemory = 0x200
+
+	// jobMemoryCapMargin is how close to its memory cap the peak of a job
+	// must get for it to count as refused memory: the allocation that failed
+	// isn't counted in the peak
+	jobMemoryCapMargin = 1 << 20
+
+	// processSetQuota is the access right needed to assign a process to a job
+	processSetQuota = 0x0100
+)
+
+// jobObjectExtendedLimits is JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+type jobObjectExtendedLimits struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+	IoInfo                  [6]uint64
+	ProcessMemoryLimit      uintptr
+	JobMemoryLimit          uintptr
+	PeakProcessMemoryUsed   uintptr
+	PeakJobMemoryUsed       uintptr
+}
+
+// jobObjectAccounting is JOBOBJECT_BASIC_ACCOUNTING_INFORMATION
+type jobObjectAccounting struct {
+	TotalUserTime             int64
+	TotalKernelTime           int64
+	ThisPeriodTotalUserTime   int64
+	ThisPeriodTotalKernelTime int64
+	TotalPageFaultCount       uint32
+	TotalProcesses            uint32
+	ActiveProcesses           uint32
+	TotalTerminatedProcesses  uint32
+}
+
+// defaultWorkspaceRoot is where workspaces are created unless configured otherwise
+func defaultWorkspaceRoot() string {
+	return filepath.Join(os.TempDir(), "octree")
+}
+
+// defaultConfigDir holds the agent's config files
+func defaultConfigDir() string {
+	return filepath.Join(programData(), "octree")
+}
+
+// defaultInstallDir holds the plugins and toolchains installed for the agent
+func defaultInstallDir() string {
+	return filepath.Join(programData(), "octree")
+}
+
+// programData returns the directory for machine-wide application data
+func programData() string {
+	if dir := os.Getenv("ProgramData"); dir != "" {
+		return dir
+	}
+	return `C:\ProgramData`
+}
+
+// shellCommand returns the command running script with the system shell
+func shellCommand(script string) (string, []string) {
+	return "cmd", []string{"/C", script}
+}
+
+// diskUsage returns the space of the disk holding path, the free space being
+// that available to the agent. NTFS has no inode limit, so none are reported.
+func diskUsage(path string) (DiskUsage, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return DiskUsage{}, err
+	}
+
+	var free, total uint64
+	ok, _, err := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&free)), uintptr(unsafe.Pointer(&total)), 0)
+	if ok == 0 {
+		return DiskUsage{}, err
+	}
+	return DiskUsage{TotalBytes: int64(total), FreeBytes: int64(free)}, nil
+}
+
+// hardLinks returns the number of hard links of the file described by info,
+// which os.FileInfo doesn't tell on Windows
+func hardLinks(info os.FileInfo) uint64 {
+	return 1
+}
+
+// reexecAgent replaces the agent with a fresh instance of itself, which
+// Windows can't do in place
+func reexecAgent() error {
+	return errors.New("restarting in place is not supported on Windows")
+}
+
+// commandTree is the tree of processes started by a command, which Windows
+// doesn't kill along with the command: the processes are put in a job object
+// that is terminated instead.
+type commandTree struct {
+	job    syscall.Handle
+	limits jobObjectExtendedLimits
+}
+
+// newCommandTree prepares cmd so its whole process tree is killed once its
+// context is done
+func newCommandTree(cmd *exec.Cmd) *commandTree {
+	t := &commandTree{}
+
+	job, _, err := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		log.Printf("Warning: failed to create job object: %s", err)
+		return t
+	}
+
+	// Closing the job, e.g. when the agent exits, kills what is left of the tree
+	t.job = syscall.Handle(job)
+	t.limits.LimitFlags = jobObjectLimitKillOnJobClose
+	t.configure()
+
+	cmd.Cancel = func() error {
+		procTerminateJobObject.Call(uintptr(t.job), 1)
+		return cmd.Process.Kill()
+	}
+	return t
+}
+
+// configure applies the limits of the tree to its job
+func (t *commandTree) configure() {
+	ok, _, err := procSetInformationJobObject.Call(uintptr(t.job), jobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&t.limits)), unsafe.Sizeof(t.limits))
+	if ok == 0 {
+		log.Printf("Warning: failed to configure job object: %s", err)
+	}
+}
+
+// limit caps the memory committed and the user time used by the tree, which
+// the watch can't enforce on Windows. A limit of 0 leaves that resource
+// uncapped.
+func (t *commandTree) limit(memory int64, cpu time.Duration) {
+	if t.job == 0 || (memory <= 0 && cpu <= 0) {
+		return
+	}
+
+	if memory > 0 {
+		t.limits.LimitFlags |= jobObjectLimitJobMemory
+		t.limits.JobMemoryLimit = uintptr(memory)
+	}
+	if cpu > 0 {
+		// Job times are counted in 100ns units
+		t.limits.LimitFlags |= jobObjectLimitJobTime
+		t.limits.PerJobUserTimeLimit = int64(cpu / 100)
+	}
+	t.configure()
+}
+
+// usage returns the peak memory committed (in kilobytes) and the CPU time
+// used by the tree, and whether it was refused memory past its cap
+func (t *commandTree) usage() (int64, time.Duration, bool) {
+	if t.job == 0 {
+		return 0, 0, false
+	}
+
+	var accounting jobObjectAccounting
+	ok, _, err := procQueryInformationJobObject.Call(uintptr(t.job), jobObjectBasicAccountingInformation, uintptr(unsafe.Pointer(&accounting)), unsafe.Sizeof(accounting), 0)
+	if ok == 0 {
+		log.Printf("Warning: failed to query job object accounting: %s", err)
+	}
+
+	var limits jobObjectExtendedLimits
+	ok, _, err = procQueryInformationJobObject.Call(uintptr(t.job), jobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&limits)), unsafe.Sizeof(limits), 0)
+	if ok == 0 {
+		log.Printf("Warning: failed to query job object limits: %s", err)
+	}
+
+	cpu := time.Duration(accounting.TotalUserTime+accounting.TotalKernelTime) * 100
+	capped := t.limits.JobMemoryLimit > 0 && limits.PeakJobMemoryUsed+jobMemoryCapMargin > t.limits.JobMemoryLimit
+	return int64(limits.PeakJobMemoryUsed >> 10), cpu, capped
+}
+
+// attach puts the started process in the job. Children it started before
+// being attached aren't part of the tree.
+func (t *commandTree) attach(process *os.Process) {
+	if t.job == 0 {
+		return
+	}
+
+	handle, err := syscall.OpenProcess(processSetQuota|syscall.PROCESS_TERMINATE, false, uint32(process.Pid))
+	if err != nil {
+		log.Printf("Warning: failed to open process %d: %s", process.Pid, err)
+		return
+	}
+	defer syscall.CloseHandle(handle)
+
+	ok, _, err := procAssignProcessToJobObject.Call(uintptr(t.job), uintptr(handle))
+	if ok == 0 {
+		log.Printf("Warning: failed to assign process %d to its job object: %s", process.Pid, err)
+	}
+}
+
+// close kills what is left of the tree once the command has finished
+func (t *commandTree) close() {
+	if t.job != 0 {
+		syscall.CloseHandle(t.job)
+	}
+}
+
+// killProcessGroup kills process; the rest of its tree is killed with its
+// job object
+func killProcessGroup(process *os.Process) error {
+	return process.Kill()
+}
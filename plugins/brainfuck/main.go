@@ -0,0 +1,196 @@
+// Command brainfuck is the reference language plugin for the agent. Install
+// the built binary into the agent's plugin directory to add the "brainfuck"
+// language.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// protocolVersion is the runner protocol version this plugin speaks
+	protocolVersion = 2
+
+	// tapeSize is the number of cells available to a program
+	tapeSize = 30000
+)
+
+type description struct {
+	Name       string `json:"name"`
+	SourceFile string `json:"sourceFile"`
+}
+
+type request struct {
+	Version    int    `json:"version"`
+	Language   string `json:"language"`
+	SourceFile string `json:"sourceFile"`
+	Code       string `json:"code"`
+	Stdin      string `json:"stdin,omitempty"`
+	Limits     limits `json:"limits"`
+}
+
+type limits struct {
+	TimeoutMs   int64 `json:"timeoutMs"`
+	MemoryBytes int64 `json:"memoryBytes,omitempty"`
+}
+
+type diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type event struct {
+	Type       string      `json:"type"`
+	Data       string      `json:"data,omitempty"`
+	Diagnostic *diagnostic `json:"diagnostic,omitempty"`
+	ExitCode   int         `json:"exitCode,omitempty"`
+	Error      *apiError   `json:"error,omitempty"`
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: brainfuck describe|run")
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "describe":
+		writeJSON(description{Name: "brainfuck", SourceFile: "main.bf"})
+	case "run":
+		var req request
+		err := json.NewDecoder(os.Stdin).Decode(&req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid request: %s\n", err)
+			os.Exit(1)
+		}
+		if req.Version != protocolVersion {
+			fmt.Fprintf(os.Stderr, "unsupported protocol version %d\n", req.Version)
+			os.Exit(1)
+		}
+		run(req)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		os.Exit(2)
+	}
+}
+
+func writeJSON(v any) {
+	err := json.NewEncoder(os.Stdout).Encode(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write response: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// run interprets the program, streaming its output line by line
+func run(req request) {
+	// Step 1: Match the brackets, reporting unbalanced ones as compile errors
+	jumps, diagnostics := matchBrackets(req.SourceFile, req.Code)
+	if len(diagnostics) > 0 {
+		for i := range diagnostics {
+			writeJSON(event{Type: "diagnostic", Diagnostic: &diagnostics[i]})
+		}
+		writeJSON(event{Type: "result", ExitCode: 1, Error: &apiError{Code: "COMPILE_ERROR", Message: "unbalanced brackets"}})
+		return
+	}
+
+	// Step 2: Interpret
+	var line []byte
+	input := []byte(req.Stdin)
+	tape := make([]byte, tapeSize)
+	ptr := 0
+
+	for pc := 0; pc < len(req.Code); pc++ {
+		switch req.Code[pc] {
+		case '>':
+			ptr++
+		case '<':
+			ptr--
+		case '+':
+			tape[ptr]++
+		case '-':
+			tape[ptr]--
+		case '.':
+			line = append(line, tape[ptr])
+			if tape[ptr] == '\n' {
+				writeJSON(event{Type: "stdout", Data: string(line)})
+				line = line[:0]
+			}
+		case ',':
+			// Reading past the end of the input leaves 0 in the cell
+			tape[ptr] = 0
+			if len(input) > 0 {
+				tape[ptr] = input[0]
+				input = input[1:]
+			}
+		case '[':
+			if tape[ptr] == 0 {
+				pc = jumps[pc]
+			}
+		case ']':
+			if tape[ptr] != 0 {
+				pc = jumps[pc]
+			}
+		}
+
+		if ptr < 0 || ptr >= tapeSize {
+			writeJSON(event{Type: "stdout", Data: string(line)})
+			writeJSON(event{Type: "stderr", Data: fmt.Sprintf("pointer moved off the tape at offset %d\n", pc)})
+			writeJSON(event{Type: "result", ExitCode: 1})
+			return
+		}
+	}
+
+	if len(line) > 0 {
+		writeJSON(event{Type: "stdout", Data: string(line)})
+	}
+	writeJSON(event{Type: "result"})
+}
+
+// matchBrackets returns the jump table for the program's loops
+func matchBrackets(file string, code string) (map[int]int, []diagnostic) {
+	jumps := make(map[int]int)
+	var stack []int
+	var diagnostics []diagnostic
+
+	position := func(offset int) (int, int) {
+		line := strings.Count(code[:offset], "\n") + 1
+		column := offset - strings.LastIndex(code[:offset], "\n")
+		return line, column
+	}
+
+	for i := 0; i < len(code); i++ {
+		switch code[i] {
+		case '[':
+			stack = append(stack, i)
+		case ']':
+			if len(stack) == 0 {
+				line, column := position(i)
+				diagnostics = append(diagnostics, diagnostic{File: file, Line: line, Column: column, Severity: "error", Message: "unmatched ']'"})
+				continue
+			}
+			open := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			jumps[open] = i
+			jumps[i] = open
+		}
+	}
+
+	for _, open := range stack {
+		line, column := position(open)
+		diagnostics = append(diagnostics, diagnostic{File: file, Line: line, Column: column, Severity: "error", Message: "unmatched '['"})
+	}
+
+	return jumps, diagnostics
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Servers get their port from a managed range rather than picking their own,
+// so they can't collide with each other or with the agent. Once a server is
+// listening its sockets are checked: it may only listen on loopback, on the
+// port it was given, which keeps it off the agent's port and off the host's
+// network.
+
+// defaultServerPorts is the server port range used if the configured one is invalid
+const defaultServerPorts = "20000-20999"
+
+// portAllocator hands out the ports of the server port range
+type portAllocator struct {
+	mu    sync.Mutex
+	inUse map[int]bool
+	next  int
+}
+
+// serverPorts allocates the ports of servers
+var serverPorts = &portAllocator{inUse: map[int]bool{}}
+
+// parsePortRange parses a range such as "20000-20999"
+func parsePortRange(s string) (int, int, error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	first, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	last, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if !ok || err1 != nil || err2 != nil || first < 1024 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return first, last, nil
+}
+
+// serverPortRange returns the configured server port range
+func serverPortRange() (int, int) {
+	first, last, err := parsePortRange(config.ServerPorts)
+	if err != nil {
+		first, last, _ = parsePortRange(defaultServerPorts)
+	}
+	return first, last
+}
+
+// allocate reserves port, or any free port of the range if port is 0. Ports
+// something is already listening on are skipped.
+func (a *portAllocator) allocate(port int) (int, error) {
+	first, last := serverPortRange()
+	if port != 0 && (port < first || port > last) {
+		return 0, fmt.Errorf("port %d is outside the server port range %d-%d", port, first, last)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Ports are handed out round-robin, so a port isn't reused right away
+	var candidates []int
+	if port != 0 {
+		candidates = append(candidates, port)
+	} else {
+		size := last - first + 1
+		for i := 0; i < size; i++ {
+			candidates = append(candidates, first+(a.next+i)%size)
+		}
+	}
+
+	for _, p := range candidates {
+		if a.inUse[p] || portListening(net.JoinHostPort("127.0.0.1", strconv.Itoa(p))) {
+			continue
+		}
+		a.inUse[p] = true
+		a.next = p - first + 1
+		return p, nil
+	}
+
+	if port != 0 {
+		return 0, fmt.Errorf("port %d is already in use", port)
+	}
+	return 0, fmt.Errorf("no free port in the server port range %d-%d", first, last)
+}
+
+// release returns port to the range
+func (a *portAllocator) release(port int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.inUse, port)
+}
+
+// listenSocket is a TCP socket in the LISTEN state
+type listenSocket struct {
+	IP   net.IP
+	Port int
+}
+
+// checkServerSockets returns an error if the process pid or one of its
+// descendants listens anywhere but on loopback port
+func checkServerSockets(pid int, port int) error {
+	sockets, err := listeningSockets(processTree(pid))
+	if err != nil {
+		// Without /proc there is nothing to check
+		return nil
+	}
+
+	for _, s := range sockets {
+		if !s.IP.IsLoopback() || s.Port != port {
+			return fmt.Errorf("server may only listen on 127.0.0.1:%d, but listens on %s", port, net.JoinHostPort(s.IP.String(), strconv.Itoa(s.Port)))
+		}
+	}
+	return nil
+}
+
+// processTree returns pid and the pids of its descendants
+func processTree(pid int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return []int{pid}
+	}
+
+	children := map[int][]int{}
+	for _, entry := range entries {
+		child, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// The command name in parentheses may contain spaces
+		i := strings.LastIndexByte(string(stat), ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		parent, _ := strconv.Atoi(fields[1])
+		children[parent] = append(children[parent], child)
+	}
+
+	tree := []int{pid}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+	return tree
+}
+
+// listeningSockets returns the TCP sockets the processes pids listen on
+func listeningSockets(pids []int) ([]listenSocket, error) {
+	// Step 1: Find the inodes of the processes' sockets
+	inodes := map[string]bool{}
+	for _, pid := range pids {
+		fdDir := filepath.Join("/proc", strconv.Itoa(pid), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err == nil && strings.HasPrefix(link, "socket:[") {
+				inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] = true
+			}
+		}
+	}
+
+	// Step 2: Look them up among the listening sockets
+	var sockets []listenSocket
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(table)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			// The fields are sl, local_address, rem_address, st, ..., inode
+			if len(fields) < 10 || fields[3] != "0A" || !inodes[fields[9]] {
+				continue
+			}
+			if s, ok := parseProcNetAddress(fields[1]); ok {
+				sockets = append(sockets, s)
+			}
+		}
+		f.Close()
+	}
+
+	return sockets, nil
+}
+
+// parseProcNetAddress parses an address of /proc/net/tcp{,6}, such as
+// "0100007F:1F90": the IP is hex encoded in 32-bit host-order words
+func parseProcNetAddress(s string) (listenSocket, bool) {
+	ipHex, portHex, ok := strings.Cut(s, ":")
+	raw, err := hex.DecodeString(ipHex)
+	port, perr := strconv.ParseUint(portHex, 16, 16)
+	if !ok || err != nil || perr != nil || len(raw)%4 != 0 {
+		return listenSocket{}, false
+	}
+
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	return listenSocket{IP: ip, Port: int(port)}, true
+}
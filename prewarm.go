@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// On boot the agent runs the self-test program of each language once, in its
+// default runtime, to warm the runtimes' caches (JIT, module caches, the JVM's
+// class data sharing archives, the compile cache) so the first user of the
+// day doesn't pay a multi-second cold start. The agent reports itself ready,
+// to systemd and on /health, only once that is done.
+
+// prewarming is set while the languages are prewarmed
+var prewarming atomic.Bool
+
+// prewarmLanguages runs the self-test program of config.PrewarmLanguages
+// (every language if unset) and then calls ready. Languages that fail to run,
+// e.g. because their runtime isn't installed, are logged and skipped.
+func prewarmLanguages(ready func()) {
+	names := config.PrewarmLanguages
+	if names == nil {
+		names = supportedLanguages()
+	}
+	if len(names) == 0 {
+		ready()
+		return
+	}
+
+	prewarming.Store(true)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	var warmed atomic.Int32
+	slots := make(chan struct{}, selfTestConcurrency)
+	for _, name := range names {
+		lang, ok := languages[name]
+		if !ok {
+			log.Printf("Warning: not prewarming unknown language %q", name)
+			continue
+		}
+		runtime, _ := lang.resolveRuntime("")
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			result := selfTest(context.Background(), lang, runtime)
+			switch {
+			case result.Passed:
+				warmed.Add(1)
+			case !result.Skipped:
+				log.Printf("Warning: failed to prewarm %s: %s", name, result.Error)
+			}
+		}()
+	}
+	wg.Wait()
+
+	log.Printf("Prewarmed %d languages in %s", warmed.Load(), time.Since(start).Round(time.Millisecond))
+	prewarming.Store(false)
+	ready()
+}
+
+// writePrewarming writes a 503 while the languages are prewarmed, reporting
+// whether they are
+func writePrewarming(w http.ResponseWriter) bool {
+	if !prewarming.Load() {
+		return false
+	}
+	writeError(w, http.StatusServiceUnavailable, CodeOverloaded, "The agent is warming up", map[string]string{"reason": "prewarming languages"})
+	return true
+}
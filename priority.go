@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Executions run at the priority named by priorityHeader. Queued executions
+// are admitted by priority, and with config.PreemptAfterMs a high-priority
+// execution that has waited that long preempts the oldest running batch
+// execution, so live contest traffic never waits behind overnight regrades.
+// Preempted executions are stopped and turned away with a 503, for the client
+// to reschedule.
+
+// priorityHeader names the priority of an execution: batch, normal or high
+const priorityHeader = "X-Octree-Priority"
+
+// preemptionInterval is how often waiting executions are checked for preemption
+const preemptionInterval = time.Second
+
+type executionPriority int
+
+const (
+	priorityBatch executionPriority = iota
+	priorityNormal
+	priorityHigh
+)
+
+var priorityNames = map[executionPriority]string{
+	priorityBatch:  "batch",
+	priorityNormal: "normal",
+	priorityHigh:   "high",
+}
+
+func (p executionPriority) String() string {
+	return priorityNames[p]
+}
+
+// errPreempted is the cause of the cancellation of a preempted execution
+var errPreempted = errors.New("preempted by a higher-priority execution")
+
+// parsePriority parses the value of priorityHeader, which defaults to normal
+func parsePriority(value string) (executionPriority, bool) {
+	if value == "" {
+		return priorityNormal, true
+	}
+	for priority, name := range priorityNames {
+		if name == value {
+			return priority, true
+		}
+	}
+	return 0, false
+}
+
+// startPreemption checks for executions to preempt, if preemption is enabled
+func startPreemption() {
+	if config.PreemptAfterMs <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(preemptionInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			queue.preempt(time.Duration(config.PreemptAfterMs) * time.Millisecond)
+		}
+	}()
+}
+
+// preempt stops the oldest running batch execution if a high-priority one has
+// waited longer than after. Only one execution is preempted at a time, so the
+// memory it frees is accounted for before preempting another.
+func (q *executionQueue) preempt(after time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var victim *queuedExecution
+	for _, e := range q.running {
+		if e.preempted {
+			return
+		}
+		if e.priority == priorityBatch && (victim == nil || e.startedAt.Before(victim.startedAt)) {
+			victim = e
+		}
+	}
+	if victim == nil {
+		return
+	}
+
+	for _, e := range q.waiting {
+		if e.priority == priorityHigh && time.Since(e.enqueuedAt) >= after {
+			log.Printf("Preempting batch execution %s, high-priority execution %s has waited %s",
+				victim.ID, e.ID, time.Since(e.enqueuedAt).Round(time.Millisecond))
+			victim.preempted = true
+			victim.cancel(errPreempted)
+			return
+		}
+	}
+}
+
+// writePreempted writes a 503 with Retry-After if the execution running under
+// ctx was preempted, reporting whether it was
+func writePreempted(w http.ResponseWriter, ctx context.Context) bool {
+	if !errors.Is(context.Cause(ctx), errPreempted) {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(admissionRetryAfterSeconds))
+	writeError(w, http.StatusServiceUnavailable, CodePreempted,
+		"The execution was preempted by a higher-priority execution, retry later", nil)
+	return true
+}
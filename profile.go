@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Modes of execution a request can ask for
+const (
+	ModeRun     = ""
+	ModeProfile = "profile"
+)
+
+// maxHotFunctions bounds the number of functions listed in a profile summary
+const maxHotFunctions = 20
+
+// ProfileSummary lists the functions a profiled program spent the most time in
+type ProfileSummary struct {
+	TotalMs   float64       `json:"totalMs"`
+	Functions []HotFunction `json:"functions"`
+}
+
+// HotFunction is the time spent in a function itself, excluding its callees
+type HotFunction struct {
+	Name        string  `json:"name"`
+	File        string  `json:"file,omitempty"`
+	Line        int     `json:"line,omitempty"`
+	SelfMs      float64 `json:"selfMs"`
+	SelfPercent float64 `json:"selfPercent"`
+}
+
+// cpuProfile is the subset of a V8 .cpuprofile that is summarized
+type cpuProfile struct {
+	Nodes []struct {
+		ID        int `json:"id"`
+		CallFrame struct {
+			FunctionName string `json:"functionName"`
+			URL          string `json:"url"`
+			LineNumber   int    `json:"lineNumber"`
+		} `json:"callFrame"`
+	} `json:"nodes"`
+	Samples    []int   `json:"samples"`
+	TimeDeltas []int64 `json:"timeDeltas"`
+}
+
+// summarizeCPUProfile adds up the samples of a V8 .cpuprofile by function.
+// Files inside dir are reported relative to it.
+func summarizeCPUProfile(data []byte, dir string) (*ProfileSummary, error) {
+	var profile cpuProfile
+	err := json.Unmarshal(data, &profile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cpu profile: %w", err)
+	}
+
+	// Step 1: Add up the time of each sample to its function
+	nodes := make(map[int]HotFunction)
+	for _, node := range profile.Nodes {
+		frame := node.CallFrame
+		name := frame.FunctionName
+		if name == "" {
+			name = "(anonymous)"
+		}
+		nodes[node.ID] = HotFunction{
+			Name: name,
+			File: strings.TrimPrefix(strings.TrimPrefix(frame.URL, "file://"), dir+"/"),
+			Line: frame.LineNumber + 1,
+		}
+	}
+
+	selfUs := make(map[HotFunction]int64)
+	var totalUs int64
+	for i, id := range profile.Samples {
+		fn, ok := nodes[id]
+		if !ok || i >= len(profile.TimeDeltas) || fn.Name == "(root)" || fn.Name == "(idle)" {
+			continue
+		}
+		selfUs[fn] += profile.TimeDeltas[i]
+		totalUs += profile.TimeDeltas[i]
+	}
+
+	// Step 2: List the functions by self time
+	summary := &ProfileSummary{TotalMs: float64(totalUs) / 1000, Functions: []HotFunction{}}
+	for fn, us := range selfUs {
+		fn.SelfMs = float64(us) / 1000
+		if totalUs > 0 {
+			fn.SelfPercent = float64(us) * 100 / float64(totalUs)
+		}
+		summary.Functions = append(summary.Functions, fn)
+	}
+
+	sort.Slice(summary.Functions, func(i, j int) bool {
+		return summary.Functions[i].SelfMs > summary.Functions[j].SelfMs
+	})
+	if len(summary.Functions) > maxHotFunctions {
+		summary.Functions = summary.Functions[:maxHotFunctions]
+	}
+
+	return summary, nil
+}
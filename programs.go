@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reference programs (checkers, interactors, generators, reference solutions)
+// are registered once and then referenced by ID from judge requests instead of
+// being resubmitted with every one. Registration compiles the program, which
+// rejects programs that don't compile and leaves the compiled binary in the
+// compile cache for every later run.
+
+// programWarmupTimeLimit bounds the run that compiles a program on registration
+const programWarmupTimeLimit = time.Second
+
+// Program is a registered reference program
+type Program struct {
+	ID        string    `json:"id"`
+	Language  string    `json:"language"`
+	Runtime   string    `json:"runtime,omitempty"`
+	Template  string    `json:"template,omitempty"`
+	Code      string    `json:"code"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// programRegistry stores the registered programs as JSON files in a directory
+type programRegistry struct {
+	mu       sync.RWMutex
+	dir      string
+	programs map[string]*Program
+}
+
+// programs holds the reference programs registered with this agent
+var programs = &programRegistry{dir: config.ProgramsDir, programs: map[string]*Program{}}
+
+// get returns the program with the given ID, loading it from disk if it was
+// registered before the agent started
+func (r *programRegistry) get(id string) (*Program, bool) {
+	r.mu.RLock()
+	program, ok := r.programs[id]
+	r.mu.RUnlock()
+	if ok {
+		return program, true
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(r.dir, id+".json"))
+	if err != nil {
+		return nil, false
+	}
+
+	program = &Program{}
+	err = json.Unmarshal(data, program)
+	if err != nil {
+		log.Printf("Warning: invalid program file for %s: %s", id, err)
+		return nil, false
+	}
+
+	r.mu.Lock()
+	r.programs[id] = program
+	r.mu.Unlock()
+
+	return program, true
+}
+
+// add stores program
+func (r *programRegistry) add(program *Program) error {
+	data, _ := json.Marshal(program)
+
+	err := os.MkdirAll(r.dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(r.dir, program.ID+".json"), data, 0644)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.programs[program.ID] = program
+	r.mu.Unlock()
+
+	return nil
+}
+
+// remove deletes the program with the given ID, reporting whether it existed
+func (r *programRegistry) remove(id string) bool {
+	if _, ok := r.get(id); !ok {
+		return false
+	}
+
+	r.mu.Lock()
+	delete(r.programs, id)
+	r.mu.Unlock()
+
+	err := os.Remove(filepath.Join(r.dir, id+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to delete program file for %s: %s", id, err)
+	}
+	return true
+}
+
+// registerProgramHandler registers the program in the request body
+func registerProgramHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read request body", nil)
+		return
+	}
+	defer r.Body.Close()
+
+	var req CodeExecRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON format", err.Error())
+		return
+	}
+
+	if req.Git != nil || req.FilesURL != "" || req.RestoreSnapshot != "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Programs are registered with their code only", nil)
+		return
+	}
+	if req.Mode == ModeServer || req.Mode == ModeScan {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Programs can't be registered in server or scan mode", nil)
+		return
+	}
+
+	lang, ok := resolveLanguage(w, &req)
+	if !ok {
+		return
+	}
+
+	// Step 1: Compile the program by running it once; only compile errors matter
+	job, err := prepareWorkspace(r.Context(), lang, &req)
+	if err != nil {
+		status, code := classifyExecutionError(err)
+		writeError(w, status, code, fmt.Sprintf("Unable to prepare workspace: %v", err), timeoutDetails(err))
+		return
+	}
+	defer removeWorkspace(job)
+
+	job.TimeLimit = programWarmupTimeLimit
+	result, err := lang.Run(r.Context(), job)
+	if errors.Is(err, errCompilation) {
+		status, code := classifyExecutionError(err)
+		writeError(w, status, code, executionErrorMessage(err), ExecFailure{result, newExecVerdict(err)})
+		return
+	}
+
+	// Step 2: Store it
+	program := &Program{
+		ID:        uuid.New().String(),
+		Language:  req.Language,
+		Runtime:   req.Runtime,
+		Template:  req.Template,
+		Code:      req.Code,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err = programs.add(program)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to store program: %v", err), nil)
+		return
+	}
+
+	jsonResponse, _ := json.Marshal(program)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonResponse)
+}
+
+// programHandler returns (GET) or deletes (DELETE) the program /programs/{id}
+func programHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		program, ok := programs.get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, CodeInvalidRequest, "Program not found", nil)
+			return
+		}
+
+		jsonResponse, _ := json.Marshal(program)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	case http.MethodDelete:
+		if !programs.remove(id) {
+			writeError(w, http.StatusNotFound, CodeInvalidRequest, "Program not found", nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+	}
+}
+
+// resolveJudgeProgram validates the checker or interactor of a judge request
+// (named field), filling it in from the registry if it references a
+// registered program. If it is invalid it writes the error response and
+// returns false.
+func resolveJudgeProgram(w http.ResponseWriter, field string, req *CodeExecRequest) (*Language, bool) {
+	if req.ProgramID != "" {
+		program, ok := programs.get(req.ProgramID)
+		if !ok {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Program not found", map[string]string{"programId": req.ProgramID})
+			return nil, false
+		}
+		req.Language, req.Runtime, req.Template, req.Code = program.Language, program.Runtime, program.Template, program.Code
+	}
+
+	if req.Code == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Missing required fields", map[string][]string{"fields": {field + ".code"}})
+		return nil, false
+	}
+
+	return resolveLanguage(w, req)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Clients can follow the progress of an execution, from the 103 Early Hints
+// carrying its ID on, as server-sent events at GET /executions/{id}/progress:
+// its state as it moves from queued to preparing (its workspace), compiling,
+// running and finished, and while it is queued its position in the queue and
+// estimated start. An execution turned away from the queue ends rejected.
+//
+// Runners report the compiling and running phases as they reach them, in
+// phase events on the event bus; states only ever move forward, so the
+// compilations and runs of every test case of a judge request don't make
+// them flip back and forth.
+
+// States of an execution in progress
+const (
+	ProgressQueued    = "queued"
+	ProgressPreparing = "preparing"
+	ProgressCompiling = "compiling"
+	ProgressRunning   = "running"
+	ProgressFinished  = "finished"
+	ProgressRejected  = "rejected"
+)
+
+// progressPollInterval is how often the position of a queued execution is
+// checked for changes
+const progressPollInterval = time.Second
+
+// progressOrder orders the states the running executions go through
+var progressOrder = map[string]int{ProgressPreparing: 1, ProgressCompiling: 2, ProgressRunning: 3}
+
+// ExecutionProgress is sent as a server-sent event whenever the state of an
+// execution, or its position in the queue, changes
+type ExecutionProgress struct {
+	ExecutionID string `json:"executionId"`
+	State       string `json:"state"`
+
+	// QueuePosition and EstimatedStartAt are set while it is queued
+	QueuePosition    *int       `json:"queuePosition,omitempty"`
+	EstimatedStartAt *time.Time `json:"estimatedStartAt,omitempty"`
+
+	// DurationMs and Cancelled are set once it has finished
+	DurationMs int64 `json:"durationMs,omitempty"`
+	Cancelled  bool  `json:"cancelled,omitempty"`
+}
+
+// progressTracker holds the state of the running executions
+type progressTracker struct {
+	mu     sync.Mutex
+	states map[string]string
+}
+
+// progress tracks the executions of this agent
+var progress = &progressTracker{states: map[string]string{}}
+
+// observe is the observer tracking the state of the executions
+func (p *progressTracker) observe(event ExecutionEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch event.Type {
+	case EventStarted:
+		p.states[event.ExecutionID] = ProgressPreparing
+	case EventFinished:
+		delete(p.states, event.ExecutionID)
+	}
+}
+
+// state returns the state of the running execution id
+func (p *progressTracker) state(id string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, ok := p.states[id]
+	return state, ok
+}
+
+// advance moves the execution running under ctx on to state, publishing a
+// phase event, unless it is past it already
+func (p *progressTracker) advance(ctx context.Context, state string) {
+	id := executionID(ctx)
+
+	p.mu.Lock()
+	current, ok := p.states[id]
+	if !ok || progressOrder[state] <= progressOrder[current] {
+		p.mu.Unlock()
+		return
+	}
+	p.states[id] = state
+	p.mu.Unlock()
+
+	events.publish(ExecutionEvent{Type: EventPhase, ExecutionID: id, Phase: state})
+}
+
+// progressHandler streams the progress of the queued or running execution
+// /executions/{id}/progress as server-sent events, until it has finished
+func progressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+	id := r.PathValue("id")
+
+	// Step 1: Watch the execution's events before looking it up, so that none is missed
+	updates := make(chan ExecutionEvent, eventQueueSize)
+	unsubscribe := events.subscribe(func(event ExecutionEvent) {
+		if event.ExecutionID != id || event.Type == EventOutput {
+			return
+		}
+		select {
+		case updates <- event:
+		default:
+			// A client too slow to keep up misses states
+		}
+	})
+	defer unsubscribe()
+
+	current := ExecutionProgress{ExecutionID: id}
+	if entry, ok := queue.lookup(id); ok {
+		current.State = ProgressQueued
+		current.QueuePosition, current.EstimatedStartAt = entry.Position, entry.EstimatedStartAt
+	} else if state, ok := progress.state(id); ok {
+		current.State = state
+	} else {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Execution not found or already finished", nil)
+		return
+	}
+
+	// Step 2: Stream its state as it changes
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	controller := http.NewResponseController(w)
+	send := func(p ExecutionProgress) bool {
+		data, _ := json.Marshal(p)
+		_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+		return err == nil && controller.Flush() == nil
+	}
+	if !send(current) {
+		return
+	}
+
+	ticker := time.NewTicker(progressPollInterval)
+	defer ticker.Stop()
+
+	for {
+		next := ExecutionProgress{ExecutionID: id}
+		select {
+		case event := <-updates:
+			switch event.Type {
+			case EventStarted:
+				next.State = ProgressPreparing
+			case EventPhase:
+				next.State = event.Phase
+			case EventFinished:
+				next.State = ProgressFinished
+				next.DurationMs, next.Cancelled = event.DurationMs, event.Cancelled
+				send(next)
+				return
+			default:
+				continue
+			}
+
+		case <-ticker.C:
+			if current.State != ProgressQueued {
+				continue
+			}
+			entry, ok := queue.lookup(id)
+			switch {
+			case ok && *entry.Position == *current.QueuePosition:
+				continue
+			case ok:
+				next.State = ProgressQueued
+				next.QueuePosition, next.EstimatedStartAt = entry.Position, entry.EstimatedStartAt
+			default:
+				// It left the queue without starting if it isn't running
+				// and its events aren't just coming in
+				if _, running := progress.state(id); running || len(updates) > 0 {
+					continue
+				}
+				next.State = ProgressRejected
+				send(next)
+				return
+			}
+
+		case <-r.Context().Done():
+			return
+		}
+
+		if !send(next) {
+			return
+		}
+		current = next
+	}
+}
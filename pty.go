@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A request can ask for its program to run under a pseudo-terminal, so that
+// tools that check for a TTY color their output, draw progress bars and
+// prompt like they do locally. Stdout and stderr then both go to the terminal
+// and are returned together as stdout, ANSI escape codes included. Programs
+// of warm-pool languages, started before their request is known, are handed
+// their stdin through a pipe, so they can't run under one.
+
+// ptyColumns and ptyRows are the size of the terminal
+const (
+	ptyColumns = 80
+	ptyRows    = 24
+)
+
+// checkPTY validates the pseudo-terminal of a request. If its language can't
+// run under one it writes the error response and returns false.
+func checkPTY(w http.ResponseWriter, lang *Language, req *CodeExecRequest) bool {
+	if req.PTY && lang.Warm {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Pseudo-terminal not supported for this language",
+			map[string]string{"language": lang.Name})
+		return false
+	}
+	return true
+}
+
+// ptySession connects a command to a pseudo-terminal
+type ptySession struct {
+	master  *os.File
+	tty     *os.File
+	started bool
+	done    chan struct{}
+}
+
+// start copies the terminal's output to stdout and feeds it stdin, followed
+// by end-of-file, once the command has started
+func (s *ptySession) start(stdin io.Reader, stdout io.Writer) {
+	s.started = true
+	s.tty.Close()
+
+	go func() {
+		io.Copy(stdout, s.master)
+		close(s.done)
+	}()
+
+	go func() {
+		if stdin != nil {
+			io.Copy(s.master, stdin)
+		}
+		// The first ^D ends a partial line, the second signals end-of-file
+		s.master.Write([]byte{4, 4})
+	}()
+}
+
+// close waits for the rest of the output, unless children the command left
+// behind keep the terminal open, and closes the terminal
+func (s *ptySession) close() {
+	if !s.started {
+		s.master.Close()
+		s.tty.Close()
+		return
+	}
+
+	select {
+	case <-s.done:
+	case <-time.After(waitDelay):
+		s.master.Close()
+		<-s.done
+	}
+	s.master.Close()
+}
+
+// ansiEscape matches ANSI escape sequences: CSI sequences such as colors and
+// cursor movement, OSC sequences such as window titles, and two-byte escapes
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// stripANSI removes the ANSI escape sequences from s
+func stripANSI(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	return ansiEscape.ReplaceAllString(s, "")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY opens a new pseudo-terminal, returning its master and terminal ends
+func openPTY() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unlock int32
+	err = ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to unlock terminal: %w", err)
+	}
+
+	var n uint32
+	err = ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to get terminal number: %w", err)
+	}
+
+	tty, err := os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	return master, tty, nil
+}
+
+// ioctl runs the ioctl request on f
+func ioctl(f *os.File, request uintptr, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// attachPTY connects cmd to a new pseudo-terminal. The terminal doesn't echo
+// the input and leaves newlines alone, so the output reads like it would
+// from a pipe apart from the escape codes.
+func attachPTY(cmd *exec.Cmd) (*ptySession, error) {
+	master, tty, err := openPTY()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a pseudo-terminal: %w", err)
+	}
+
+	var termios syscall.Termios
+	err = ioctl(tty, syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	if err == nil {
+		termios.Lflag &^= syscall.ECHO
+		termios.Oflag &^= syscall.ONLCR
+		err = ioctl(tty, syscall.TCSETS, uintptr(unsafe.Pointer(&termios)))
+	}
+	if err == nil {
+		size := [4]uint16{ptyRows, ptyColumns, 0, 0}
+		err = ioctl(tty, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&size)))
+	}
+	if err != nil {
+		master.Close()
+		tty.Close()
+		return nil, fmt.Errorf("failed to configure the pseudo-terminal: %w", err)
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+
+	return &ptySession{master: master, tty: tty, done: make(chan struct{})}, nil
+}
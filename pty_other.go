@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// attachPTY fails, since pseudo-terminals are only supported on Linux
+func attachPTY(cmd *exec.Cmd) (*ptySession, error) {
+	return nil, errors.New("pseudo-terminals are not supported on this platform")
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Executions that don't fit in the memory left to commit wait in a queue,
+// up to config.MaxQueuedExecutions of them (none by default, in which case
+// they are turned away right away) for at most config.QueueTimeoutMs.
+// Operators can list the queued and running executions, and bump or evict
+// queued ones.
+//
+// OCTREE_LANGUAGE_CONCURRENCY caps the running executions of some languages,
+// e.g. "java=2,javascript=8", so heavyweight JVM or compiler runs can't take
+// up the agent. Executions of a language at its cap wait without holding up
+// the executions of other languages queued behind them.
+//
+// Executions of the same priority are queued round-robin across clients (a
+// tenant's user, the tenant, or else the address the request came from), so
+// a user sending many submissions at once only delays their own. Tenants get
+// a share of the turns by their weight in OCTREE_TENANT_WEIGHTS: each queued
+// execution takes its client 1/weight further along a virtual clock, and the
+// queue is kept in the order of the times executions were queued at on it.
+
+const (
+	// tenantHeader names the tenant an execution is run for, e.g. a course
+	tenantHeader = "X-Octree-Tenant"
+
+	// userHeader names the user of the tenant an execution is run for
+	userHeader = "X-Octree-User"
+)
+
+// queueDurationWeight is the weight of the latest execution in the average
+// execution durations used to estimate start times
+const queueDurationWeight = 0.2
+
+const (
+	// queuePositionHeader and estimatedStartHeader tell a client whose
+	// execution was queued where it is in the queue and when it should start
+	// (RFC 3339), in a 103 Early Hints response along with its ID, and
+	// estimatedWaitHeader how long that is from now in milliseconds
+	queuePositionHeader  = "X-Queue-Position"
+	estimatedStartHeader = "X-Estimated-Start"
+	estimatedWaitHeader  = "X-Estimated-Wait-Ms"
+)
+
+// queuedExecution is an execution waiting for admission or running
+type queuedExecution struct {
+	ID       string
+	Kind     string
+	Language string
+	Tenant   string
+	User     string
+	priority executionPriority
+
+	// client is who the execution is queued fairly for, and pass is its
+	// time on the virtual clock of the queue
+	client string
+	pass   float64
+
+	// bytes is the memory committed to the execution
+	bytes int64
+
+	enqueuedAt time.Time
+	startedAt  time.Time
+
+	// admitted is closed once the execution is admitted, and evicted if an
+	// operator evicts it from the queue instead
+	admitted chan struct{}
+	evicted  chan struct{}
+
+	// cancel stops the running execution, and preempted is set once it was
+	// preempted
+	cancel    context.CancelCauseFunc
+	preempted bool
+}
+
+// executionQueue holds the executions waiting for admission, in order, and
+// the running ones
+type executionQueue struct {
+	mu      sync.Mutex
+	waiting []*queuedExecution
+	running map[string]*queuedExecution
+
+	// clock is the virtual time of the latest execution admitted, and
+	// passes the time of the latest execution queued by each client, while
+	// it is ahead of the clock
+	clock  float64
+	passes map[string]float64
+
+	// averageDuration is the moving average of the executions' durations,
+	// and languageDurations that of the executions of each language
+	averageDuration   time.Duration
+	languageDurations map[string]time.Duration
+}
+
+// queue holds the executions of this agent
+var queue = &executionQueue{
+	running:           map[string]*queuedExecution{},
+	passes:            map[string]float64{},
+	languageDurations: map[string]time.Duration{},
+}
+
+// languageConcurrency is the maximum number of running executions of each
+// capped language
+var languageConcurrency = map[string]int{}
+
+// loadLanguageConcurrency parses the per-language caps, ignoring the invalid ones
+func loadLanguageConcurrency(specs []string) {
+	for _, spec := range specs {
+		name, value, _ := strings.Cut(spec, "=")
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			log.Printf("Warning: ignoring invalid language concurrency %q", spec)
+			continue
+		}
+		if _, ok := languages[name]; !ok {
+			log.Printf("Warning: ignoring concurrency of unknown language %q", name)
+			continue
+		}
+		languageConcurrency[name] = limit
+	}
+}
+
+// tenantWeights are the shares of the queue of the tenants that don't have
+// the default weight of 1
+var tenantWeights = map[string]float64{}
+
+// loadTenantWeights parses the tenants' weights, ignoring the invalid ones
+func loadTenantWeights(specs []string) {
+	for _, spec := range specs {
+		name, value, _ := strings.Cut(spec, "=")
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(weight > 0) || math.IsInf(weight, 0) {
+			log.Printf("Warning: ignoring invalid tenant weight %q", spec)
+			continue
+		}
+		tenantWeights[name] = weight
+	}
+}
+
+// queueClient returns who the request's execution is queued fairly for
+func queueClient(r *http.Request) string {
+	client := rateLimitClient(r)
+	if user := r.Header.Get(userHeader); user != "" {
+		client += "/user:" + user
+	}
+	return client
+}
+
+// add queues e by priority and admits it right away if it can be. It reports
+// false if e would have to wait but the queue is full.
+func (q *executionQueue) add(e *queuedExecution) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e.enqueuedAt = time.Now()
+	e.admitted = make(chan struct{})
+	e.evicted = make(chan struct{})
+
+	// A client that had nothing queued starts at the current time
+	weight, ok := tenantWeights[e.Tenant]
+	if !ok {
+		weight = 1
+	}
+	e.pass = max(q.passes[e.client], q.clock) + 1/weight
+
+	// e waits behind the executions of a higher priority, and of the same
+	// priority that are earlier on the virtual clock
+	i := len(q.waiting)
+	for i > 0 && (q.waiting[i-1].priority < e.priority || q.waiting[i-1].priority == e.priority && q.waiting[i-1].pass > e.pass) {
+		i--
+	}
+	q.waiting = slices.Insert(q.waiting, i, e)
+	q.dispatch()
+
+	if len(q.waiting) > config.MaxQueuedExecutions {
+		if i := q.position(e.ID); i >= 0 {
+			q.waiting = slices.Delete(q.waiting, i, i+1)
+			return false
+		}
+	}
+
+	// Only a queued execution moves its client on; one started right away
+	// has already moved the clock past it
+	if e.pass > q.clock {
+		q.passes[e.client] = e.pass
+	}
+	return true
+}
+
+// start marks e as running
+func (q *executionQueue) start(e *queuedExecution) {
+	e.startedAt = time.Now()
+	q.running[e.ID] = e
+	close(e.admitted)
+
+	// Clients with nothing queued past the current time start afresh
+	q.clock = max(q.clock, e.pass)
+	for client, pass := range q.passes {
+		if pass <= q.clock {
+			delete(q.passes, client)
+		}
+	}
+}
+
+// dispatch admits the waiting executions, in order, while their memory fits.
+// Executions of languages at their cap are passed over.
+func (q *executionQueue) dispatch() {
+	for i := 0; i < len(q.waiting); {
+		e := q.waiting[i]
+		if q.atCap(e.Language) {
+			i++
+			continue
+		}
+		if !inFlight.admit(e.bytes) {
+			return
+		}
+		q.waiting = slices.Delete(q.waiting, i, i+1)
+		q.start(e)
+	}
+}
+
+// atCap reports whether language has as many running executions as it may
+func (q *executionQueue) atCap(language string) bool {
+	limit, ok := languageConcurrency[language]
+	if !ok {
+		return false
+	}
+
+	running := 0
+	for _, e := range q.running {
+		if e.Language == language {
+			running++
+		}
+	}
+	return running >= limit
+}
+
+// finish releases the memory of the finished execution e and admits the
+// executions waiting for it
+func (q *executionQueue) finish(e *queuedExecution) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	inFlight.release(e.bytes)
+	delete(q.running, e.ID)
+
+	duration := time.Since(e.startedAt)
+	q.averageDuration = movingAverage(q.averageDuration, duration)
+	q.languageDurations[e.Language] = movingAverage(q.languageDurations[e.Language], duration)
+
+	q.dispatch()
+}
+
+// movingAverage adds duration to the moving average, which is zero before the first one
+func movingAverage(average time.Duration, duration time.Duration) time.Duration {
+	if average == 0 {
+		return duration
+	}
+	return average + time.Duration(queueDurationWeight*float64(duration-average))
+}
+
+// remove takes e out of the queue, reporting false if it was admitted meanwhile
+func (q *executionQueue) remove(e *queuedExecution) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.position(e.ID)
+	if i < 0 {
+		return false
+	}
+	q.waiting = slices.Delete(q.waiting, i, i+1)
+	return true
+}
+
+// position returns the index of the queued execution id, or -1 if it isn't queued
+func (q *executionQueue) position(id string) int {
+	for i, e := range q.waiting {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// bump moves the queued execution id to the front of the queue, reporting
+// whether it was queued
+func (q *executionQueue) bump(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.position(id)
+	if i < 0 {
+		return false
+	}
+	e := q.waiting[i]
+	copy(q.waiting[1:i+1], q.waiting[:i])
+	q.waiting[0] = e
+
+	q.dispatch()
+	return true
+}
+
+// evict turns the queued execution id away, reporting whether it was queued
+func (q *executionQueue) evict(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.position(id)
+	if i < 0 {
+		return false
+	}
+	e := q.waiting[i]
+	q.waiting = slices.Delete(q.waiting, i, i+1)
+	close(e.evicted)
+
+	// The executions behind it may fit now
+	q.dispatch()
+	return true
+}
+
+// estimatedStarts estimates when each queued execution starts: once the
+// rest of the running executions and the queued ones ahead of it have run,
+// each for the average duration of its language, as many at a time as are
+// running now. They are zero until an execution has finished.
+func (q *executionQueue) estimatedStarts() []time.Time {
+	starts := make([]time.Time, len(q.waiting))
+	if q.averageDuration == 0 {
+		return starts
+	}
+
+	now := time.Now()
+	var ahead time.Duration
+	for _, e := range q.running {
+		ahead += max(q.expectedDuration(e.Language)-now.Sub(e.startedAt), 0)
+	}
+	slots := time.Duration(max(len(q.running), 1))
+	for i, e := range q.waiting {
+		starts[i] = now.Add(ahead / slots)
+		ahead += q.expectedDuration(e.Language)
+	}
+	return starts
+}
+
+// expectedDuration is how long an execution of language is expected to run,
+// the average of all executions until one of the language has finished
+func (q *executionQueue) expectedDuration(language string) time.Duration {
+	if d, ok := q.languageDurations[language]; ok {
+		return d
+	}
+	return q.averageDuration
+}
+
+// lookup describes the queued execution id, reporting whether it is queued
+func (q *executionQueue) lookup(id string) (QueueEntry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.position(id)
+	if i < 0 {
+		return QueueEntry{}, false
+	}
+	return q.queuedEntry(i, q.estimatedStarts()[i], time.Now()), true
+}
+
+// queuedEntry describes the execution at position of the queue at now
+func (q *executionQueue) queuedEntry(position int, start time.Time, now time.Time) QueueEntry {
+	entry := q.waiting[position].entry(now)
+	entry.Position = &position
+	if !start.IsZero() {
+		entry.EstimatedStartAt = &start
+	}
+	return entry
+}
+
+// announceQueued tells the client of the queued execution e its ID, where it
+// is in the queue (0 being next) and when it should start, in a 103 Early
+// Hints response
+func announceQueued(w http.ResponseWriter, e *queuedExecution) {
+	entry, ok := queue.lookup(e.ID)
+	if !ok {
+		return
+	}
+
+	w.Header().Set(executionIDHeader, e.ID)
+	w.Header().Set(queuePositionHeader, strconv.Itoa(*entry.Position))
+	if entry.EstimatedStartAt != nil {
+		w.Header().Set(estimatedStartHeader, entry.EstimatedStartAt.UTC().Format(time.RFC3339))
+		w.Header().Set(estimatedWaitHeader, strconv.FormatInt(max(time.Until(*entry.EstimatedStartAt).Milliseconds(), 0), 10))
+	}
+	w.WriteHeader(http.StatusEarlyHints)
+
+	// They would be stale in the final response
+	for _, header := range []string{queuePositionHeader, estimatedStartHeader, estimatedWaitHeader} {
+		w.Header().Del(header)
+	}
+}
+
+// QueueEntry describes a queued or running execution
+type QueueEntry struct {
+	ID       string `json:"id"`
+	Kind     string `json:"kind"`
+	Language string `json:"language"`
+	Tenant   string `json:"tenant,omitempty"`
+	User     string `json:"user,omitempty"`
+	Priority string `json:"priority"`
+	AgeMs    int64  `json:"ageMs"`
+
+	// Position and EstimatedStartAt are set for queued executions
+	Position         *int       `json:"position,omitempty"`
+	EstimatedStartAt *time.Time `json:"estimatedStartAt,omitempty"`
+
+	// RunningMs and Preempted are set for running executions
+	RunningMs *int64 `json:"runningMs,omitempty"`
+	Preempted bool   `json:"preempted,omitempty"`
+}
+
+// QueueResponse is returned by the queue endpoint
+type QueueResponse struct {
+	Queued  []QueueEntry `json:"queued"`
+	Running []QueueEntry `json:"running"`
+}
+
+// snapshot describes the queued executions, in order, and the running ones,
+// oldest first
+func (q *executionQueue) snapshot() QueueResponse {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	response := QueueResponse{Queued: []QueueEntry{}, Running: []QueueEntry{}}
+	starts := q.estimatedStarts()
+	for i := range q.waiting {
+		response.Queued = append(response.Queued, q.queuedEntry(i, starts[i], now))
+	}
+
+	for _, e := range q.running {
+		entry := e.entry(now)
+		running := now.Sub(e.startedAt).Milliseconds()
+		entry.RunningMs = &running
+		entry.Preempted = e.preempted
+		response.Running = append(response.Running, entry)
+	}
+	slices.SortFunc(response.Running, func(a, b QueueEntry) int { return cmp.Compare(b.AgeMs, a.AgeMs) })
+	return response
+}
+
+// entry describes e at now
+func (e *queuedExecution) entry(now time.Time) QueueEntry {
+	return QueueEntry{
+		ID:       e.ID,
+		Kind:     e.Kind,
+		Language: e.Language,
+		Tenant:   e.Tenant,
+		User:     e.User,
+		Priority: e.priority.String(),
+		AgeMs:    now.Sub(e.enqueuedAt).Milliseconds(),
+	}
+}
+
+// queueHandler lists the queued and running executions
+func queueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	jsonResponse, _ := json.Marshal(queue.snapshot())
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
+
+// queueEntryHandler bumps the queued execution /queue/{id}/bump to the front
+// of the queue, or evicts /queue/{id}/evict from it
+func queueEntryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	id := r.PathValue("id")
+	action := r.PathValue("action")
+
+	var ok bool
+	switch action {
+	case "bump":
+		ok = queue.bump(id)
+	case "evict":
+		ok = queue.evict(id)
+	default:
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Unknown queue action", map[string]string{"action": action})
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Execution not queued", nil)
+		return
+	}
+
+	jsonResponse, _ := json.Marshal(map[string]any{"id": id, action + "ed": true})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Clients are rate limited with a token bucket each, keyed by tenant if the
+// request names one and by address otherwise: a client may send
+// config.RateLimitBurst requests at once and config.RateLimitPerSecond on
+// average (OCTREE_RATE_LIMIT_PER_SECOND, 0 to disable). Health checks and
+// metrics scrapes aren't limited.
+
+// rateLimitIdle is how long a full bucket is kept before it is forgotten
+const rateLimitIdle = 10 * time.Minute
+
+// rateLimitExempt are the paths that aren't rate limited
+var rateLimitExempt = []string{"/health", "/metrics"}
+
+// tokenBucket holds the requests a client may still send
+type tokenBucket struct {
+	tokens float64
+	filled time.Time
+}
+
+// rateLimiter holds the bucket of every client
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// limiter rate limits the clients of this agent
+var limiter = &rateLimiter{buckets: map[string]*tokenBucket{}}
+
+// allow takes a token from the bucket of client, returning how long to wait
+// for one if it is empty
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rate := float64(config.RateLimitPerSecond)
+	burst := float64(max(config.RateLimitBurst, 1))
+
+	if now.Sub(l.swept) > rateLimitIdle {
+		l.sweep(now, rate, burst)
+	}
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, filled: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.filled).Seconds()*rate)
+	bucket.filled = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep forgets the clients whose buckets have been full for a while
+func (l *rateLimiter) sweep(now time.Time, rate float64, burst float64) {
+	for client, bucket := range l.buckets {
+		full := bucket.filled.Add(time.Duration((burst - bucket.tokens) / rate * float64(time.Second)))
+		if now.Sub(full) > rateLimitIdle {
+			delete(l.buckets, client)
+		}
+	}
+	l.swept = now
+}
+
+// rateLimitClient returns the key the request is rate limited by
+func rateLimitClient(r *http.Request) string {
+	if tenant := r.Header.Get(tenantHeader); tenant != "" {
+		return "tenant:" + tenant
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "addr:" + r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
+// withRateLimit turns away the requests of clients over their rate with a 429
+func withRateLimit(next http.Handler) http.Handler {
+	if config.RateLimitPerSecond <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range rateLimitExempt {
+			if r.URL.Path == path {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		ok, wait := limiter.allow(rateLimitClient(r), time.Now())
+		if !ok {
+			httpMetrics.rateLimited.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, CodeRateLimited, "Too many requests, retry later",
+				map[string]int{"limitPerSecond": config.RateLimitPerSecond, "burst": config.RateLimitBurst})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
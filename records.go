@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ExecutionRecord is kept for every submission the agent runs, so that
+// downstream tooling (e.g. plagiarism detection) can consume submissions
+// without having to resubmit them. Its ID is the execution ID.
+type ExecutionRecord struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Language  string    `json:"language"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// CodeHash is the SHA-256 of the code, to find exact duplicates
+	CodeHash string `json:"codeHash"`
+
+	// Fingerprints are the winnowing fingerprints of the code, to find near duplicates
+	Fingerprints []string `json:"fingerprints"`
+}
+
+// recordStore keeps the most recent execution records in memory
+type recordStore struct {
+	mu      sync.Mutex
+	records []*ExecutionRecord
+	max     int
+}
+
+// records holds the execution records of this agent
+var records = &recordStore{max: config.MaxRecords}
+
+// newExecutionRecord creates the record of a submission of the given kind ("exec" or "judge")
+func newExecutionRecord(kind string, req *CodeExecRequest) *ExecutionRecord {
+	hash := sha256.Sum256([]byte(req.Code))
+	return &ExecutionRecord{
+		ID:           uuid.New().String(),
+		Kind:         kind,
+		Language:     req.Language,
+		CreatedAt:    time.Now().UTC(),
+		CodeHash:     hex.EncodeToString(hash[:]),
+		Fingerprints: fingerprint(req.Language, req.Code),
+	}
+}
+
+// add stores record, dropping the oldest records past the limit
+func (s *recordStore) add(record *ExecutionRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = append(s.records, record)
+	if len(s.records) > s.max {
+		s.records = s.records[len(s.records)-max(s.max, 0):]
+	}
+}
+
+// since returns the records created after t, oldest first
+func (s *recordStore) since(t time.Time) []*ExecutionRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []*ExecutionRecord
+	for _, record := range s.records {
+		if record.CreatedAt.After(t) {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// exportRecordsHandler streams the execution records as newline-delimited
+// JSON. Passing the createdAt of the last record seen as ?since= (RFC 3339)
+// returns only newer records.
+func exportRecordsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	var since time.Time
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid since timestamp", err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	for _, record := range records.since(since) {
+		encoder.Encode(record)
+	}
+}
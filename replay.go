@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// The most recent exec and judge requests are kept along with their
+// responses, so that an execution can be rerun exactly as it was submitted
+// (same code, stdin and limits) and both results compared side by side. This
+// helps to debug nondeterministic programs and drift between agent versions.
+
+// replayableExecution is a request as it was submitted and the response it got
+type replayableExecution struct {
+	kind     string
+	request  []byte
+	status   int
+	response []byte
+}
+
+// replayStore keeps the most recent replayable executions in memory
+type replayStore struct {
+	mu         sync.Mutex
+	executions map[string]*replayableExecution
+	order      []string
+	max        int
+}
+
+// replays holds the replayable executions of this agent
+var replays = &replayStore{executions: map[string]*replayableExecution{}, max: config.MaxReplays}
+
+// add stores the execution id, dropping the oldest executions past the limit
+func (s *replayStore) add(id string, execution *replayableExecution) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.executions[id] = execution
+	s.order = append(s.order, id)
+	for len(s.order) > max(s.max, 0) {
+		delete(s.executions, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// get returns the execution id, if it is still kept
+func (s *replayStore) get(id string) (*replayableExecution, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	execution, ok := s.executions[id]
+	return execution, ok
+}
+
+// captureResponseWriter keeps a copy of the final response written through it
+type captureResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (cw *captureResponseWriter) WriteHeader(status int) {
+	// Informational responses such as the execution announcement aren't final
+	if cw.status == 0 && status >= 200 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureResponseWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+// responseBuffer is a ResponseWriter that only keeps the final response
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 && status >= 200 {
+		b.status = status
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// recordForReplay keeps the requests that started an execution, along with
+// their responses, so the execution can be replayed. kind is "exec" or "judge".
+func recordForReplay(kind string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read request body", nil)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		cw := &captureResponseWriter{ResponseWriter: w}
+		next(cw, r)
+
+		id := w.Header().Get(executionIDHeader)
+		if id != "" {
+			replays.add(id, &replayableExecution{kind: kind, request: body, status: cw.status, response: cw.body.Bytes()})
+		}
+	}
+}
+
+// replayHandlers are the handlers an execution is replayed through, by kind
+var replayHandlers = map[string]http.HandlerFunc{
+	"exec":  validateCodeExecRequest(codeExecHandler),
+	"judge": validateCodeExecRequest(judgeHandler),
+}
+
+// ReplayedResponse is a response of an execution and its replay
+type ReplayedResponse struct {
+	ID     string          `json:"id"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// ReplayResponse is returned by the replay endpoint
+type ReplayResponse struct {
+	Original ReplayedResponse `json:"original"`
+	Replay   ReplayedResponse `json:"replay"`
+}
+
+// replayExecutionHandler reruns the execution /executions/{id}/replay with
+// its original request and returns both responses
+func replayExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	id := r.PathValue("id")
+	original, ok := replays.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Execution not found or no longer replayable", nil)
+		return
+	}
+
+	// The replay runs like a new submission of the original request, and
+	// stops if the client disconnects
+	replayRequest, err := http.NewRequestWithContext(r.Context(), http.MethodPost, "/code/"+original.kind, bytes.NewReader(original.request))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Unable to replay execution", err.Error())
+		return
+	}
+	replayed := &responseBuffer{header: http.Header{}}
+	replayHandlers[original.kind](replayed, replayRequest)
+
+	response := ReplayResponse{
+		Original: ReplayedResponse{ID: id, Status: original.status, Body: rawJSON(original.response)},
+		Replay:   ReplayedResponse{ID: replayed.header.Get(executionIDHeader), Status: replayed.status, Body: rawJSON(replayed.body.Bytes())},
+	}
+	jsonResponse, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
+
+// rawJSON returns body to embed as is, or null if it isn't JSON
+func rawJSON(body []byte) json.RawMessage {
+	if !json.Valid(body) {
+		return json.RawMessage("null")
+	}
+	return body
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	// maxTimeLimitReruns bounds how many times a borderline case is rerun
+	maxTimeLimitReruns = 5
+
+	// defaultRerunMarginPercent is how far past its time limit a case may run
+	// and still be borderline, by default
+	defaultRerunMarginPercent = 10
+)
+
+// RerunSpec reruns the test cases that ran only a little past their time
+// limit, so a noisy neighbour on a shared agent doesn't turn a solution that
+// is fast enough into a TIME_LIMIT. Programs are given MarginPercent more time
+// than the limits before being stopped, and a case that finishes within that
+// margin is run Runs more times. The best or the median run is then judged,
+// as a TIME_LIMIT if it is still over the limit.
+type RerunSpec struct {
+	Runs          int   `json:"runs"`
+	MarginPercent int64 `json:"marginPercent,omitempty"`
+
+	// Pick is the run judged: "best" (the default) or "median"
+	Pick string `json:"pick,omitempty"`
+}
+
+// checkRerunSpec validates the rerun settings of req and fills in their
+// defaults. If they are invalid it writes the error response and returns false.
+func checkRerunSpec(w http.ResponseWriter, req *JudgeRequest) bool {
+	spec := req.TimeLimitReruns
+	if spec == nil {
+		return true
+	}
+	if req.Interactor != nil || req.Stress != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Time limit reruns can't be used with an interactor or stress mode", nil)
+		return false
+	}
+	if spec.Runs < 1 || spec.Runs > maxTimeLimitReruns {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("Between 1 and %d reruns are required", maxTimeLimitReruns), nil)
+		return false
+	}
+	if spec.MarginPercent == 0 {
+		spec.MarginPercent = defaultRerunMarginPercent
+	}
+	if spec.MarginPercent < 0 || spec.MarginPercent > 100 {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Rerun margin must be between 1 and 100 percent", nil)
+		return false
+	}
+	if spec.Pick == "" {
+		spec.Pick = "best"
+	}
+	if spec.Pick != "best" && spec.Pick != "median" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Rerun pick must be best or median", map[string]string{"pick": spec.Pick})
+		return false
+	}
+	return true
+}
+
+// extend returns the time a program with the time limit d is given before
+// being stopped, which leaves room for the margin
+func (spec *RerunSpec) extend(d time.Duration) time.Duration {
+	if spec == nil {
+		return d
+	}
+	return d * time.Duration(100+spec.MarginPercent) / 100
+}
+
+// timeLimitUsage is the share of its time limits a test case used, above 1
+// if it exceeded either
+func timeLimitUsage(req *JudgeRequest, caseResult TestCaseResult) float64 {
+	usage := float64(caseResult.TimeMs) / float64(req.TimeLimitMs)
+	if req.CPUTimeLimitMs > 0 {
+		usage = max(usage, float64(caseResult.CPUTimeMs)/float64(req.CPUTimeLimitMs))
+	}
+	return usage
+}
+
+// judgedRun is one run of a test case
+type judgedRun struct {
+	caseResult TestCaseResult
+	result     *ExecResult
+}
+
+// rerunBorderline reruns the test case judged caseResult if it ran past its
+// time limit without being stopped, and returns the run picked. That run is
+// judged a TIME_LIMIT if it is still over the limit.
+func rerunBorderline(ctx context.Context, lang *Language, job *ExecJob, req *JudgeRequest, index int, caseResult TestCaseResult, result *ExecResult) (TestCaseResult, *ExecResult) {
+	if caseResult.Verdict == VerdictTimeLimit || caseResult.Verdict == VerdictCompileError || timeLimitUsage(req, caseResult) <= 1 {
+		return caseResult, result
+	}
+
+	runs := []judgedRun{{caseResult, result}}
+	for range req.TimeLimitReruns.Runs {
+		if ctx.Err() != nil {
+			break
+		}
+		job.Usage = nil
+		result, err := lang.Run(ctx, job)
+		runs = append(runs, judgedRun{judgeTestCase(index, job, result, err), result})
+	}
+
+	var times []int64
+	over := 0
+	for _, run := range runs {
+		times = append(times, run.caseResult.TimeMs)
+		if timeLimitUsage(req, run.caseResult) > 1 {
+			over++
+		}
+	}
+
+	slices.SortStableFunc(runs, func(a, b judgedRun) int {
+		return cmp.Compare(timeLimitUsage(req, a.caseResult), timeLimitUsage(req, b.caseResult))
+	})
+	picked := runs[0]
+	if req.TimeLimitReruns.Pick == "median" {
+		picked = runs[len(runs)/2]
+	}
+	picked.caseResult.RunTimesMs = times
+
+	if timeLimitUsage(req, picked.caseResult) > 1 {
+		picked.caseResult.Verdict = VerdictTimeLimit
+		picked.caseResult.TimeLimit = TimeLimitWall
+		if req.CPUTimeLimitMs > 0 && picked.caseResult.CPUTimeMs > req.CPUTimeLimitMs {
+			picked.caseResult.TimeLimit = TimeLimitCPU
+		}
+		picked.caseResult.Message = fmt.Sprintf("Exceeded the time limit in %d of %d runs", over, len(runs))
+	}
+	return picked.caseResult, picked.result
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// External runners are executables that implement a language outside of the
+// agent. They are either dropped into the plugin directory, in which case they
+// describe themselves, or declared in the runners config file.
+//
+// The agent talks to a runner with JSON over stdio:
+//
+//   - `<plugin> describe` (plugin directory only) prints a runnerDescription.
+//   - For each execution the runner is started in the job's workspace, reads
+//     one runnerRequest from stdin and writes newline-delimited runnerEvents
+//     to stdout as the program produces output, ending with a "result" event.
+//
+// Runners are expected to enforce the limits they are given; the agent also
+// kills a runner that outlives its timeout. See plugins/brainfuck for a
+// reference implementation.
+
+// runnerProtocolVersion is sent with every request so runners can reject versions they don't speak
+const runnerProtocolVersion = 2
+
+// pluginDescribeTimeout bounds how long a plugin may take to describe itself
+const pluginDescribeTimeout = 10 * time.Second
+
+// maxRunnerEventBytes bounds a line of a runner's event stream; longer lines
+// are dropped rather than buffered
+const maxRunnerEventBytes = 8 << 20
+
+// runnerDescription is printed by `<plugin> describe`
+type runnerDescription struct {
+	Name       string `json:"name"`
+	SourceFile string `json:"sourceFile"`
+}
+
+// runnerRequest is written to the runner's stdin
+type runnerRequest struct {
+	Version    int          `json:"version"`
+	Language   string       `json:"language"`
+	SourceFile string       `json:"sourceFile"`
+	Code       string       `json:"code"`
+	Runtime    string       `json:"runtime,omitempty"`
+	Stdin      string       `json:"stdin,omitempty"`
+	Files      []runnerFile `json:"files"`
+	Limits     runnerLimits `json:"limits"`
+}
+
+// runnerFile is a file of the submission, already written to the workspace
+type runnerFile struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// runnerLimits are the resource limits the runner must apply to the program
+type runnerLimits struct {
+	TimeoutMs   int64 `json:"timeoutMs"`
+	MemoryBytes int64 `json:"memoryBytes,omitempty"`
+}
+
+// runnerEvent is one line of the runner's output. Type is one of "stdout",
+// "stderr" (with Data), "diagnostic" (with Diagnostic) or "result" (with
+// ExitCode and, on failure, Error).
+type runnerEvent struct {
+	Type       string      `json:"type"`
+	Data       string      `json:"data,omitempty"`
+	Diagnostic *Diagnostic `json:"diagnostic,omitempty"`
+	ExitCode   int         `json:"exitCode,omitempty"`
+	Error      *APIError   `json:"error,omitempty"`
+}
+
+// runnersConfigFile is the format of the runners config file
+type runnersConfigFile struct {
+	Runners []runnerConfig `json:"runners"`
+}
+
+// runnerConfig declares an external runner
+type runnerConfig struct {
+	Language    string            `json:"language"`
+	SourceFile  string            `json:"sourceFile"`
+	Command     string            `json:"command"`
+	Args        []string          `json:"args,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	TimeoutMs   int64             `json:"timeoutMs,omitempty"`
+	MemoryBytes int64             `json:"memoryBytes,omitempty"`
+}
+
+// externalRunner runs jobs through a runner executable
+type externalRunner struct {
+	path        string
+	args        []string
+	env         []string
+	timeout     time.Duration
+	memoryBytes int64
+}
+
+// loadPlugins registers a language for every plugin executable in dir
+func loadPlugins(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read plugin directory %s: %s", dir, err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+
+		desc, err := describePlugin(path)
+		if err != nil {
+			log.Printf("Warning: skipping plugin %s: %s", path, err)
+			continue
+		}
+
+		runner := &externalRunner{path: path, args: []string{"run"}, timeout: defaultTimeout}
+		if registerExternalRunner(desc.Name, desc.SourceFile, runner) {
+			log.Printf("Loaded plugin %s for language %s", path, desc.Name)
+		}
+	}
+}
+
+// describePlugin asks the plugin at path which language it implements
+func describePlugin(path string) (*runnerDescription, error) {
+	res, err := runCommand(context.Background(), command{
+		Name:    path,
+		Args:    []string{"describe"},
+		Timeout: pluginDescribeTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var desc runnerDescription
+	err = json.Unmarshal([]byte(res.Stdout), &desc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid description: %w", err)
+	}
+
+	return &desc, nil
+}
+
+// loadRunnersConfig registers a language for every runner declared in the config file at path
+func loadRunnersConfig(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read runners config %s: %s", path, err)
+		}
+		return
+	}
+
+	var file runnersConfigFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		log.Printf("Warning: invalid runners config %s: %s", path, err)
+		return
+	}
+
+	for _, rc := range file.Runners {
+		runner := &externalRunner{
+			path:        rc.Command,
+			args:        rc.Args,
+			timeout:     time.Duration(rc.TimeoutMs) * time.Millisecond,
+			memoryBytes: rc.MemoryBytes,
+		}
+		if runner.timeout == 0 {
+			runner.timeout = defaultTimeout
+		}
+		for key, value := range rc.Env {
+			runner.env = append(runner.env, key+"="+value)
+		}
+
+		if registerExternalRunner(rc.Language, rc.SourceFile, runner) {
+			log.Printf("Loaded runner %s for language %s", rc.Command, rc.Language)
+		}
+	}
+}
+
+// registerExternalRunner validates the declaration and registers the language,
+// reporting whether it was registered
+func registerExternalRunner(name string, sourceFile string, runner *externalRunner) bool {
+	switch {
+	case name == "" || sourceFile == "" || runner.path == "":
+		log.Printf("Warning: skipping runner %q: name, sourceFile and command are required", runner.path)
+		return false
+	case !filepath.IsLocal(sourceFile):
+		log.Printf("Warning: skipping runner %s: sourceFile %q must be a relative path inside the workspace", runner.path, sourceFile)
+		return false
+	}
+
+	if _, exists := languages[name]; exists {
+		log.Printf("Warning: skipping runner %s: language %s is already registered", runner.path, name)
+		return false
+	}
+
+	registerLanguage(&Language{
+		Name:       name,
+		SourceFile: sourceFile,
+		Run:        runner.run,
+		Backend:    "external",
+	})
+	return true
+}
+
+// run delegates the job to the runner, collecting its events as they stream in
+func (r *externalRunner) run(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	// The protocol passes stdin up front, so there's no way to interact with the program
+	if job.Input != nil {
+		return &ExecResult{}, fmt.Errorf("runner %s does not support interactive runs", filepath.Base(r.path))
+	}
+
+	sourceFile, err := filepath.Rel(job.Dir, job.SourcePath)
+	if err != nil {
+		return &ExecResult{}, err
+	}
+
+	limits := r.limitsFor(job)
+
+	request, err := json.Marshal(runnerRequest{
+		Version:    runnerProtocolVersion,
+		Language:   job.Request.Language,
+		SourceFile: sourceFile,
+		Code:       job.Request.Code,
+		Runtime:    job.Request.Runtime,
+		Stdin:      job.Stdin,
+		Files:      []runnerFile{{Name: sourceFile, Content: job.Request.Code}},
+		Limits:     limits,
+	})
+	if err != nil {
+		return &ExecResult{}, err
+	}
+
+	events := newRunnerEventWriter(ctx, job.CaptureLimit)
+
+	// The event stream is only decoded, not captured as well
+	res, err := runCommand(ctx, command{
+		Name:          r.path,
+		Args:          r.args,
+		Dir:           job.Dir,
+		Env:           append(r.env[:len(r.env):len(r.env)], job.Env...),
+		Stdin:         bytes.NewReader(request),
+		Stdout:        events,
+		DiscardStdout: true,
+		CaptureLimit:  job.CaptureLimit,
+		Timeout:       time.Duration(limits.TimeoutMs) * time.Millisecond,
+	})
+	job.Usage = &ProgramUsage{TimeMs: res.Duration.Milliseconds(), MemoryKB: res.MaxRSSKB}
+	events.flush()
+
+	result := events.result()
+	if err != nil {
+		result.Stderr += res.Stderr
+		return result, fmt.Errorf("runner %s failed: %w", filepath.Base(r.path), err)
+	}
+
+	if events.final == nil {
+		return result, fmt.Errorf("runner %s exited without a result", filepath.Base(r.path))
+	}
+	if events.final.Error != nil {
+		return result, runnerError(events.final.Error)
+	}
+	if events.final.ExitCode != 0 {
+		return result, &programExitError{name: "program", code: events.final.ExitCode}
+	}
+
+	return result, nil
+}
+
+// limitsFor returns the runner's limits, tightened by the job's own limits
+func (r *externalRunner) limitsFor(job *ExecJob) runnerLimits {
+	limits := runnerLimits{TimeoutMs: r.timeout.Milliseconds(), MemoryBytes: r.memoryBytes}
+	if job.TimeLimit > 0 {
+		limits.TimeoutMs = job.TimeLimit.Milliseconds()
+	}
+	if job.MemoryLimit > 0 && (limits.MemoryBytes == 0 || job.MemoryLimit < limits.MemoryBytes) {
+		limits.MemoryBytes = job.MemoryLimit
+	}
+	return limits
+}
+
+// runnerEventWriter decodes the runner's event stream as it is written,
+// publishing the program's output as output events of the execution running
+// under ctx, if any
+type runnerEventWriter struct {
+	ctx context.Context
+	mu  sync.Mutex
+
+	// partial is the start of the next event line, unless that line is
+	// oversized and dropped
+	partial   []byte
+	oversized bool
+
+	stdout      cappedBuffer
+	stderr      cappedBuffer
+	diagnostics []Diagnostic
+	final       *runnerEvent
+}
+
+// newRunnerEventWriter returns a writer decoding the event stream of a
+// runner, keeping up to captureLimit bytes, if set, of the program's stdout
+// and stderr
+func newRunnerEventWriter(ctx context.Context, captureLimit int) *runnerEventWriter {
+	return &runnerEventWriter{
+		ctx:    ctx,
+		stdout: cappedBuffer{limit: captureLimit},
+		stderr: cappedBuffer{limit: captureLimit},
+	}
+}
+
+func (w *runnerEventWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.buffer(p)
+			break
+		}
+		w.buffer(p[:i])
+		if !w.oversized {
+			w.handle(w.partial)
+		}
+		w.partial, w.oversized = w.partial[:0], false
+		p = p[i+1:]
+	}
+
+	return n, nil
+}
+
+// buffer adds p to the partial event line, dropping the line once it is
+// longer than maxRunnerEventBytes
+func (w *runnerEventWriter) buffer(p []byte) {
+	if w.oversized || len(p) == 0 {
+		return
+	}
+	if len(w.partial)+len(p) > maxRunnerEventBytes {
+		log.Printf("Warning: ignoring runner event longer than %d bytes", maxRunnerEventBytes)
+		w.partial, w.oversized = w.partial[:0], true
+		return
+	}
+	w.partial = append(w.partial, p...)
+}
+
+// flush handles a final event that wasn't newline-terminated
+func (w *runnerEventWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.oversized {
+		w.handle(w.partial)
+	}
+	w.partial, w.oversized = nil, false
+}
+
+// handle decodes and applies a single event line
+func (w *runnerEventWriter) handle(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	var event runnerEvent
+	err := json.Unmarshal(line, &event)
+	if err != nil {
+		log.Printf("Warning: ignoring invalid runner event %q: %s", line, err)
+		return
+	}
+
+	switch event.Type {
+	case "stdout":
+		w.stdout.Write([]byte(event.Data))
+		w.publish("stdout", event.Data)
+	case "stderr":
+		w.stderr.Write([]byte(event.Data))
+		w.publish("stderr", event.Data)
+	case "diagnostic":
+		if event.Diagnostic != nil {
+			w.diagnostics = append(w.diagnostics, *event.Diagnostic)
+		}
+	case "result":
+		w.final = &event
+	default:
+		log.Printf("Warning: ignoring runner event of unknown type %q", event.Type)
+	}
+}
+
+// publish publishes data, written by the program to stream, as an output event
+func (w *runnerEventWriter) publish(stream string, data string) {
+	if executionID(w.ctx) != "" && data != "" {
+		eventWriter{w.ctx, stream}.Write([]byte(data))
+	}
+}
+
+// result returns what the runner has reported so far
+func (w *runnerEventWriter) result() *ExecResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return &ExecResult{
+		Stdout:      w.stdout.String(),
+		Stderr:      w.stderr.String(),
+		Diagnostics: w.diagnostics,
+	}
+}
+
+// runnerError converts an error reported by a runner into the matching runner error
+func runnerError(apiErr *APIError) error {
+	switch apiErr.Code {
+	case CodeCompileError:
+		return fmt.Errorf("%w: %s", errCompilation, apiErr.Message)
+	case CodeTimeout:
+		return fmt.Errorf("%w: %s", errExecutionTimeout, apiErr.Message)
+	case CodeMemoryLimit:
+		return fmt.Errorf("%w: %s", errMemoryLimit, apiErr.Message)
+	default:
+		return fmt.Errorf("%s", apiErr.Message)
+	}
+}
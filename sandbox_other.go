@@ -0,0 +1,9 @@
+//go:build !darwin
+
+package main
+
+// sandboxedCommand returns name and args unchanged, since programs are
+// isolated by the MicroVM rather than by a sandbox on other platforms
+func sandboxedCommand(job *ExecJob, name string, args []string) (string, []string) {
+	return name, args
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// In scan mode the submission isn't run: semgrep scans it with one of the
+// rulesets in config.ScanRulesDir, and its findings are returned with the
+// result, so secure coding exercises get automated feedback through the same
+// agent. A ruleset is a semgrep config file <name>.yml or directory <name>.
+
+// ModeScan scans the code for security issues instead of running it
+const ModeScan = "scan"
+
+const (
+	// defaultScanRuleset is the ruleset used when a request names none
+	defaultScanRuleset = "default"
+
+	// scanTimeout bounds a scan
+	scanTimeout = time.Minute
+)
+
+// ScanSpec configures the scan of a request in scan mode
+type ScanSpec struct {
+	// Ruleset names the ruleset to scan with, "default" if empty
+	Ruleset string `json:"ruleset,omitempty"`
+}
+
+// ScanFinding is an issue the scan found in the code
+type ScanFinding struct {
+	RuleID    string `json:"ruleId"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"endLine"`
+	EndColumn int    `json:"endColumn"`
+}
+
+// semgrepOutput is the subset of semgrep's JSON output that is returned
+type semgrepOutput struct {
+	Results []struct {
+		CheckID string `json:"check_id"`
+		Path    string `json:"path"`
+		Start   struct {
+			Line int `json:"line"`
+			Col  int `json:"col"`
+		} `json:"start"`
+		End struct {
+			Line int `json:"line"`
+			Col  int `json:"col"`
+		} `json:"end"`
+		Extra struct {
+			Message  string `json:"message"`
+			Severity string `json:"severity"`
+		} `json:"extra"`
+	} `json:"results"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// scanRulesetPath returns the path of the ruleset name, or false if there is none
+func scanRulesetPath(name string) (string, bool) {
+	if name == "" {
+		name = defaultScanRuleset
+	}
+	if !templateName.MatchString(name) {
+		return "", false
+	}
+
+	for _, path := range []string{filepath.Join(config.ScanRulesDir, name+".yml"), filepath.Join(config.ScanRulesDir, name)} {
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// checkScanSpec validates the scan of a request in scan mode. If it is
+// invalid it writes the error response and returns false.
+func checkScanSpec(w http.ResponseWriter, req *CodeExecRequest) bool {
+	var ruleset string
+	if req.Scan != nil {
+		ruleset = req.Scan.Ruleset
+	}
+	if _, ok := scanRulesetPath(ruleset); !ok {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Scan ruleset not found", map[string]string{"ruleset": ruleset})
+		return false
+	}
+	return true
+}
+
+// runScan scans the code of job with semgrep. The rest of the workspace,
+// such as the template's dependencies, isn't scanned.
+func runScan(ctx context.Context, job *ExecJob) (*ExecResult, error) {
+	var ruleset string
+	if job.Request.Scan != nil {
+		ruleset = job.Request.Scan.Ruleset
+	}
+	rules, _ := scanRulesetPath(ruleset)
+
+	res, err := runCommand(ctx, command{
+		Name:    "semgrep",
+		Args:    []string{"scan", "--config", rules, "--json", "--metrics=off", "--disable-version-check", "--quiet", job.SourcePath},
+		Dir:     job.Dir,
+		Timeout: scanTimeout,
+	})
+	if errors.Is(err, exec.ErrNotFound) {
+		return &ExecResult{}, fmt.Errorf("semgrep is not installed: %w", errServiceUnavailable)
+	}
+
+	result := &ExecResult{Stderr: res.Stderr, Findings: []ScanFinding{}}
+	var output semgrepOutput
+	if jsonErr := json.Unmarshal([]byte(res.Stdout), &output); jsonErr != nil {
+		if err != nil {
+			return result, err
+		}
+		return result, fmt.Errorf("invalid semgrep output: %w", jsonErr)
+	}
+
+	for _, r := range output.Results {
+		if rel, err := filepath.Rel(job.Dir, r.Path); err == nil {
+			r.Path = rel
+		}
+		result.Findings = append(result.Findings, ScanFinding{
+			RuleID:    r.CheckID,
+			Severity:  r.Extra.Severity,
+			Message:   r.Extra.Message,
+			File:      r.Path,
+			Line:      r.Start.Line,
+			Column:    r.Start.Col,
+			EndLine:   r.End.Line,
+			EndColumn: r.End.Col,
+		})
+	}
+	if len(output.Errors) > 0 && len(output.Results) == 0 {
+		return result, fmt.Errorf("semgrep failed: %s", output.Errors[0].Message)
+	}
+	return result, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The self-test runs a known-good program in every runtime of every
+// language, to verify an agent after a deploy or a runtime upgrade without
+// crafting requests by hand. Each program prints selfTestOutput.
+
+// selfTestOutput is what the self-test programs print
+const selfTestOutput = "octree selftest"
+
+const (
+	// selfTestTimeout bounds the self-test of a single runtime, which may
+	// include starting a compile server
+	selfTestTimeout = 2 * time.Minute
+
+	// selfTestConcurrency is how many runtimes are tested at once
+	selfTestConcurrency = 4
+)
+
+// selfTestPrograms are the known-good programs, by language. Languages
+// without one, such as those of plugins, are skipped. The html program only
+// has to pass the template's tests.
+var selfTestPrograms = map[string]string{
+	"asm": `section .data
+msg: db "octree selftest", 10
+section .text
+global _start
+_start:
+	mov rax, 1
+	mov rdi, 1
+	mov rsi, msg
+	mov rdx, 16
+	syscall
+	mov rax, 60
+	xor rdi, rdi
+	syscall
+`,
+	"clojure":    `(println "octree selftest")`,
+	"dart":       `void main() { print("octree selftest"); }`,
+	"elixir":     `IO.puts("octree selftest")`,
+	"erlang":     "main(_) -> io:format(\"octree selftest~n\").\n",
+	"fsharp":     `printfn "octree selftest"`,
+	"haskell":    `main = putStrLn "octree selftest"`,
+	"html":       `<!DOCTYPE html><html><head><title>octree selftest</title></head><body></body></html>`,
+	"javascript": `console.log("octree selftest");`,
+	"julia":      `println("octree selftest")`,
+	"lua":        `print("octree selftest")`,
+	"ocaml":      `let () = print_endline "octree selftest"`,
+	"perl":       `print "octree selftest\n";`,
+	"r":          `cat("octree selftest\n")`,
+	"scala":      `@main def main(): Unit = println("octree selftest")`,
+	"typescript": `const message: string = "octree selftest"; console.log(message);`,
+	"wasm": `(module
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 16) "octree selftest\n")
+  (func (export "_start")
+    (i32.store (i32.const 0) (i32.const 16))
+    (i32.store (i32.const 4) (i32.const 16))
+    (drop (call $fd_write (i32.const 1) (i32.const 0) (i32.const 1) (i32.const 8)))))
+`,
+	"zig": `const std = @import("std");
+pub fn main() !void {
+    try std.io.getStdOut().writer().print("octree selftest\n", .{});
+}
+`,
+}
+
+// SelfTestResult is the outcome of the self-test of a runtime
+type SelfTestResult struct {
+	Language   string `json:"language"`
+	Runtime    string `json:"runtime,omitempty"`
+	Passed     bool   `json:"passed"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	Stdout     string `json:"stdout,omitempty"`
+	Stderr     string `json:"stderr,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// SelfTestResponse is returned by the self-test endpoint
+type SelfTestResponse struct {
+	Passed  bool             `json:"passed"`
+	Results []SelfTestResult `json:"results"`
+}
+
+// selfTest runs the self-test program of lang in runtime
+func selfTest(ctx context.Context, lang *Language, runtime string) (result SelfTestResult) {
+	result = SelfTestResult{Language: lang.Name, Runtime: runtime}
+
+	code, ok := selfTestPrograms[lang.Name]
+	if !ok {
+		result.Skipped = true
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	start := time.Now()
+	defer func() { result.DurationMs = time.Since(start).Milliseconds() }()
+
+	req := &CodeExecRequest{Language: lang.Name, Runtime: runtime, Code: code}
+	job, err := prepareWorkspace(ctx, lang, req)
+	if err != nil {
+		result.Error = fmt.Sprintf("Unable to prepare workspace: %s", err)
+		return result
+	}
+	defer removeWorkspace(job)
+
+	res, err := runJob(ctx, lang, job)
+	result.Stdout, result.Stderr = res.Stdout, res.Stderr
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case lang.Name != "html" && strings.TrimSpace(res.Stdout) != selfTestOutput:
+		result.Error = fmt.Sprintf("expected output %q", selfTestOutput)
+	default:
+		result.Passed = true
+	}
+	return result
+}
+
+// selfTestHandler runs the self-test of every runtime of every language, or
+// of the comma-separated ?languages= only, and reports the outcome of each.
+// Skipped runtimes don't fail the self-test.
+func selfTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	names := supportedLanguages()
+	if value := r.URL.Query().Get("languages"); value != "" {
+		names = strings.Split(value, ",")
+		for _, name := range names {
+			if _, ok := languages[name]; !ok {
+				writeError(w, http.StatusBadRequest, CodeUnsupportedLanguage, "Language not supported", map[string]any{"supportedLanguages": supportedLanguages()})
+				return
+			}
+		}
+	}
+
+	ctx, release, ok := admitExecution(w, r, &queuedExecution{ID: uuid.New().String(), Kind: "selftest", bytes: selfTestConcurrency * unlimitedMemoryEstimate})
+	if !ok {
+		return
+	}
+	defer release()
+
+	type target struct {
+		lang    *Language
+		runtime string
+	}
+	var targets []target
+	for _, name := range names {
+		lang := languages[name]
+		if len(lang.Runtimes) == 0 {
+			targets = append(targets, target{lang, ""})
+		}
+		for _, runtime := range lang.Runtimes {
+			targets = append(targets, target{lang, runtime})
+		}
+	}
+
+	response := SelfTestResponse{Passed: true, Results: make([]SelfTestResult, len(targets))}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, selfTestConcurrency)
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			response.Results[i] = selfTest(ctx, t.lang, t.runtime)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range response.Results {
+		if !result.Passed && !result.Skipped {
+			response.Passed = false
+		}
+	}
+
+	jsonResponse, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
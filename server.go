@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// In server mode the program is started as a server instead of being run to
+// completion: once it listens on its port (see ports.go) the request's HTTP probes
+// are sent to it one after the other, and the server is stopped after the
+// last one. The responses are returned with the result for grading.
+
+// ModeServer runs the program as a server and probes it over HTTP
+const ModeServer = "server"
+
+const (
+	// maxProbes bounds the number of probes of a request
+	maxProbes = 50
+
+	// probeTimeout bounds a single probe
+	probeTimeout = 5 * time.Second
+
+	// maxProbeBodyBytes bounds the response body returned for a probe
+	maxProbeBodyBytes = 1 << 20
+
+	// defaultServerReadyTimeout bounds how long the server may take to listen on its port
+	defaultServerReadyTimeout = 10 * time.Second
+
+	// serverReadyPollInterval is how often the port is checked while waiting for the server
+	serverReadyPollInterval = 50 * time.Millisecond
+)
+
+// ServerSpec describes the server a request in server mode runs, and the
+// probes sent to it
+type ServerSpec struct {
+	// Port is the port the program listens on, passed to it as $PORT. It is
+	// allocated from the server port range if empty.
+	Port int `json:"port,omitempty"`
+
+	// ReadyTimeoutMs bounds how long the program may take to listen on its port
+	ReadyTimeoutMs int64 `json:"readyTimeoutMs,omitempty"`
+
+	Probes []HTTPProbe `json:"probes"`
+}
+
+// HTTPProbe is an HTTP request sent to the server
+type HTTPProbe struct {
+	// Method defaults to GET
+	Method  string            `json:"method,omitempty"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// ProbeResult is the server's response to a probe. Error is set instead if
+// no response was received.
+type ProbeResult struct {
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	TimeMs  int64             `json:"timeMs"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// checkServerSpec validates the server of a request in server mode. If it is
+// invalid it writes the error response and returns false.
+func checkServerSpec(w http.ResponseWriter, req *CodeExecRequest) bool {
+	spec := req.Server
+	first, last := serverPortRange()
+	switch {
+	case spec == nil:
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Server mode needs a server", nil)
+		return false
+	case spec.Port != 0 && (spec.Port < first || spec.Port > last):
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Server port out of range",
+			map[string]int{"firstPort": first, "lastPort": last})
+		return false
+	case len(spec.Probes) > maxProbes:
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("At most %d probes can be sent", maxProbes), nil)
+		return false
+	case spec.ReadyTimeoutMs < 0 || spec.ReadyTimeoutMs > defaultTimeout.Milliseconds():
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Server ready timeout out of range",
+			map[string]int64{"maxReadyTimeoutMs": defaultTimeout.Milliseconds()})
+		return false
+	}
+
+	for i, probe := range spec.Probes {
+		if !strings.HasPrefix(probe.Path, "/") {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Probe paths must start with /", map[string]int{"probe": i})
+			return false
+		}
+	}
+
+	return true
+}
+
+// runServer starts the program, waits for it to listen on its port, sends it
+// the probes and stops it
+func runServer(ctx context.Context, lang *Language, job *ExecJob) (*ExecResult, error) {
+	spec := job.Request.Server
+
+	// Step 1: Allocate the port and start the program
+	port, err := serverPorts.allocate(spec.Port)
+	if err != nil {
+		return &ExecResult{}, err
+	}
+	defer serverPorts.release(port)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	serverCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	job.Env = append(job.Env, "PORT="+strconv.Itoa(port))
+
+	started := make(chan int, 1)
+	job.Started = func(p *os.Process) { started <- p.Pid }
+
+	type outcome struct {
+		result *ExecResult
+		err    error
+	}
+	exited := make(chan outcome, 1)
+	go func() {
+		result, err := lang.Run(serverCtx, job)
+		exited <- outcome{result, err}
+	}()
+
+	// Step 2: Wait for it to listen on its port
+	readyTimeout := time.Duration(spec.ReadyTimeoutMs) * time.Millisecond
+	if readyTimeout == 0 {
+		readyTimeout = defaultServerReadyTimeout
+	}
+	deadline := time.After(readyTimeout)
+	ticker := time.NewTicker(serverReadyPollInterval)
+	defer ticker.Stop()
+
+	for !portListening(addr) {
+		select {
+		case out := <-exited:
+			if out.err == nil {
+				out.err = fmt.Errorf("program exited without listening on port %d", port)
+			}
+			return out.result, out.err
+		case <-deadline:
+			stop()
+			out := <-exited
+			return out.result, fmt.Errorf("%w: program didn't listen on port %d within %s", errExecutionTimeout, port, readyTimeout)
+		case <-ticker.C:
+		}
+	}
+
+	// Step 3: Make sure it only listens where it should, probe it, check
+	// again in case the probes made it listen elsewhere, then stop it
+	var pid int
+	select {
+	case pid = <-started:
+	default:
+		// Runners that don't report their process can't be checked
+	}
+	if pid != 0 {
+		err = checkServerSockets(pid, port)
+	}
+
+	var probes []ProbeResult
+	if err == nil {
+		for _, probe := range spec.Probes {
+			probes = append(probes, sendProbe(serverCtx, addr, probe))
+		}
+		if pid != 0 {
+			err = checkServerSockets(pid, port)
+		}
+	}
+
+	stop()
+	out := <-exited
+	out.result.Probes = probes
+	if err != nil {
+		return out.result, err
+	}
+
+	// Being stopped is how a server is expected to end, unless the whole
+	// execution was cancelled
+	if errors.Is(out.err, errCancelled) && ctx.Err() == nil {
+		out.err = nil
+	}
+	return out.result, out.err
+}
+
+// portListening reports whether something accepts connections on addr
+func portListening(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, serverReadyPollInterval)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// sendProbe sends probe to the server at addr
+func sendProbe(ctx context.Context, addr string, probe HTTPProbe) ProbeResult {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	method := probe.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+addr+probe.Path, strings.NewReader(probe.Body))
+	if err != nil {
+		return ProbeResult{Error: err.Error()}
+	}
+	for key, value := range probe.Headers {
+		req.Header.Set(key, value)
+	}
+
+	// The server's redirects are part of its response
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return ProbeResult{TimeMs: time.Since(start).Milliseconds(), Error: err.Error()}
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxProbeBodyBytes))
+	result := ProbeResult{
+		Status:  res.StatusCode,
+		Headers: map[string]string{},
+		Body:    string(body),
+		TimeMs:  time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	for key := range res.Header {
+		result.Headers[key] = res.Header.Get(key)
+	}
+
+	return result
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Services are ephemeral backing services, such as a Postgres database, that a
+// request can ask for by name. Each one is declared in the services config
+// file with a command that acquires an instance (creating a database in a
+// shared server, taking one from a pool or starting a container) and prints
+// its connection string, and a command that releases it again, which finds the
+// connection string in $OCTREE_SERVICE_URL. For example:
+//
+//	{"services": [{
+//	    "name": "postgres",
+//	    "env": "DATABASE_URL",
+//	    "acquire": "/opt/octree/services/postgres acquire",
+//	    "release": "/opt/octree/services/postgres release"
+//	}]}
+//
+// The connection string is passed to the program (and the hooks) in the
+// service's environment variable, and the instance is released once the
+// workspace is removed.
+
+// maxServicesPerRequest bounds the number of services a request can ask for
+const maxServicesPerRequest = 4
+
+// servicesConfigFile is the format of the services config file
+type servicesConfigFile struct {
+	Services []*serviceConfig `json:"services"`
+}
+
+// serviceConfig declares a service
+type serviceConfig struct {
+	Name string `json:"name"`
+
+	// Env is the environment variable the connection string is passed in
+	Env string `json:"env"`
+
+	Acquire   string `json:"acquire"`
+	Release   string `json:"release"`
+	TimeoutMs int64  `json:"timeoutMs,omitempty"`
+}
+
+// serviceLease is an acquired instance of a service
+type serviceLease struct {
+	service *serviceConfig
+	url     string
+}
+
+// services maps a service name to its declaration
+var services = map[string]*serviceConfig{}
+
+// loadServicesConfig loads the services declared in the config file at path
+func loadServicesConfig(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read services config %s: %s", path, err)
+		}
+		return
+	}
+
+	var file servicesConfigFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		log.Printf("Warning: invalid services config %s: %s", path, err)
+		return
+	}
+
+	for _, sc := range file.Services {
+		if sc.Name == "" || sc.Env == "" || sc.Acquire == "" || sc.Release == "" {
+			log.Printf("Warning: skipping service %q: name, env, acquire and release are required", sc.Name)
+			continue
+		}
+		services[sc.Name] = sc
+		log.Printf("Loaded service %s", sc.Name)
+	}
+}
+
+// serviceNames returns the names of the configured services
+func serviceNames() []string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// checkServices validates the services a request asks for. If they are
+// invalid it writes the error response and returns false.
+func checkServices(w http.ResponseWriter, req *CodeExecRequest) bool {
+	if len(req.Services) > maxServicesPerRequest {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("At most %d services can be requested", maxServicesPerRequest), nil)
+		return false
+	}
+
+	for i, name := range req.Services {
+		if _, ok := services[name]; !ok || slices.Contains(req.Services[:i], name) {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Service not available",
+				map[string]any{"service": name, "services": serviceNames()})
+			return false
+		}
+	}
+
+	return true
+}
+
+// runServiceCommand runs one of the commands of a service
+func runServiceCommand(sc *serviceConfig, script string, env []string) (*commandResult, error) {
+	name, args := shellCommand(script)
+	return runCommand(context.Background(), command{
+		Name:    name,
+		Args:    args,
+		Env:     append([]string{"OCTREE_SERVICE=" + sc.Name}, env...),
+		Timeout: time.Duration(sc.TimeoutMs) * time.Millisecond,
+	})
+}
+
+// acquireServices acquires the services the job's request asks for, adding
+// their connection strings to the job's environment. If one can't be
+// acquired, those already acquired are released.
+func acquireServices(job *ExecJob) error {
+	for _, name := range job.Request.Services {
+		sc := services[name]
+
+		res, err := runServiceCommand(sc, sc.Acquire, nil)
+		url := strings.TrimSpace(res.Stdout)
+		if err == nil && url == "" {
+			err = fmt.Errorf("no connection string was printed")
+		}
+		if err != nil {
+			releaseServices(job)
+			return fmt.Errorf("%w: acquiring %s failed: %s %s", errServiceUnavailable, name, err, res.Stderr)
+		}
+
+		job.Services = append(job.Services, &serviceLease{service: sc, url: url})
+		job.Env = append(job.Env, sc.Env+"="+url)
+	}
+
+	return nil
+}
+
+// releaseServices releases the services acquired for the job, logging the ones that fail
+func releaseServices(job *ExecJob) {
+	for _, lease := range job.Services {
+		res, err := runServiceCommand(lease.service, lease.service.Release, []string{"OCTREE_SERVICE_URL=" + lease.url})
+		if err != nil {
+			log.Printf("Warning: releasing %s failed: %s %s", lease.service.Name, err, res.Stderr)
+		}
+	}
+	job.Services = nil
+}
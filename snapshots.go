@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A run can ask for its workspace to be snapshotted once it finishes, and a
+// later run can start from that snapshot instead of from the template, which
+// lets multi-session project exercises pick up where they were left. Snapshots
+// are stored as gzipped tarballs in SnapshotsDir, next to a JSON file
+// describing them.
+
+// maxSnapshotBytes bounds the size of the files in a snapshotted workspace
+const maxSnapshotBytes = 64 << 20
+
+// Snapshot describes a stored workspace snapshot
+type Snapshot struct {
+	ID        string    `json:"id"`
+	Language  string    `json:"language"`
+	SizeBytes int64     `json:"sizeBytes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// snapshotPath returns the path of the tarball (ext ".tar.gz") or description
+// (ext ".json") of the snapshot id
+func snapshotPath(id string, ext string) string {
+	return filepath.Join(config.SnapshotsDir, id+ext)
+}
+
+// getSnapshot returns the description of the snapshot id
+func getSnapshot(id string) (*Snapshot, bool) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(snapshotPath(id, ".json"))
+	if err != nil {
+		return nil, false
+	}
+
+	snapshot := &Snapshot{}
+	err = json.Unmarshal(data, snapshot)
+	if err != nil {
+		log.Printf("Warning: invalid snapshot file for %s: %s", id, err)
+		return nil, false
+	}
+	return snapshot, true
+}
+
+// snapshotWorkspace stores the workspace of job as a new snapshot
+func snapshotWorkspace(job *ExecJob) (*Snapshot, error) {
+	err := os.MkdirAll(config.SnapshotsDir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{ID: uuid.New().String(), Language: job.Request.Language, CreatedAt: time.Now().UTC()}
+
+	// Step 1: Write the tarball, only renaming it into place once complete
+	path := snapshotPath(snapshot.ID, ".tar.gz")
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path + ".tmp")
+
+	snapshot.SizeBytes, err = writeTarball(f, job.Dir)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 2: Describe it
+	data, _ := json.Marshal(snapshot)
+	err = os.WriteFile(snapshotPath(snapshot.ID, ".json"), data, 0644)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// restoreSnapshot unpacks the snapshot id into dir
+func restoreSnapshot(id string, dir string) error {
+	f, err := os.Open(snapshotPath(id, ".tar.gz"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return extractTarball(f, dir, 0)
+}
+
+// writeTarball writes the regular files and directories under dir to w as a
+// gzipped tarball, returning the size of the files. Anything else (e.g.
+// symlinks or hard links left by the program) is skipped.
+func writeTarball(w io.Writer, dir string) (int64, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	var total int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir || !(info.IsDir() || info.Mode().IsRegular()) {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		// The program may have swapped a file for a link since it was listed
+		var f *os.File
+		if !info.IsDir() {
+			f, err = openWorkspaceFile(dir, filepath.ToSlash(name))
+			if errors.Is(err, errPathEscapes) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+		}
+
+		total += info.Size()
+		if total > maxSnapshotBytes {
+			return fmt.Errorf("workspace exceeds the snapshot limit of %d bytes", maxSnapshotBytes)
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+
+		err = tw.WriteHeader(header)
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return 0, err
+	}
+	return total, gz.Close()
+}
+
+// snapshotHandler downloads (GET) or deletes (DELETE) the snapshot /snapshots/{id}
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	snapshot, ok := getSnapshot(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Snapshot not found", nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", snapshot.ID+".tar.gz"))
+		http.ServeFile(w, r, snapshotPath(snapshot.ID, ".tar.gz"))
+	case http.MethodDelete:
+		for _, ext := range []string{".tar.gz", ".json"} {
+			err := os.Remove(snapshotPath(snapshot.ID, ext))
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("Warning: failed to delete snapshot file for %s: %s", snapshot.ID, err)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Large submissions can be fetched from object storage (e.g. through a
+// presigned URL) rather than embedded in the request: CodeURL in place of the
+// code, and FilesURL for a zip or gzipped tar archive unpacked into the
+// workspace. Only https URLs on the hosts in SourceURLHosts are fetched.
+
+const (
+	// sourceFetchTimeout bounds downloading a source
+	sourceFetchTimeout = 30 * time.Second
+
+	// maxRemoteCodeBytes bounds the size of the code fetched from CodeURL
+	maxRemoteCodeBytes = 8 << 20
+
+	// maxFilesBytes bounds both the size of the archive fetched from FilesURL
+	// and the total size of the files in it
+	maxFilesBytes = 64 << 20
+)
+
+// codeContentTypes are the content types accepted for CodeURL
+var codeContentTypes = []string{"text/", "application/octet-stream"}
+
+// filesContentTypes are the content types accepted for FilesURL
+var filesContentTypes = []string{"application/zip", "application/x-zip-compressed", "application/gzip", "application/x-gzip", "application/x-tar", "application/octet-stream"}
+
+// sourceURLAllowed reports whether raw is an https URL on one of the allowed
+// hosts. A host starting with "." allows all of its subdomains.
+func sourceURLAllowed(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return false
+	}
+
+	host := u.Hostname()
+	for _, allowed := range config.SourceURLHosts {
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSourceURLs validates the URLs of a request and fetches its code from
+// CodeURL. If either fails it writes the error response and returns false.
+func checkSourceURLs(w http.ResponseWriter, req *CodeExecRequest) bool {
+	for _, raw := range []string{req.CodeURL, req.FilesURL} {
+		if raw != "" && !sourceURLAllowed(raw) {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Source URL not allowed",
+				map[string]any{"allowedHosts": config.SourceURLHosts})
+			return false
+		}
+	}
+
+	if req.CodeURL == "" {
+		return true
+	}
+	if req.Code != "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Code and codeUrl can't be used together", nil)
+		return false
+	}
+
+	code, err := fetchSource(req.CodeURL, codeContentTypes, maxRemoteCodeBytes)
+	if err == nil && !utf8.Valid(code) {
+		err = fmt.Errorf("%w: code is not valid UTF-8", errSourceUnavailable)
+	}
+	if err != nil {
+		status, code := classifyExecutionError(err)
+		writeError(w, status, code, err.Error(), nil)
+		return false
+	}
+
+	req.Code, req.CodeURL = string(code), ""
+	return true
+}
+
+// fetchSource downloads raw, checking its content type against contentTypes
+// (prefixes ending in "/" match any subtype) and its size against maxBytes
+func fetchSource(raw string, contentTypes []string, maxBytes int64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sourceFetchTimeout)
+	defer cancel()
+
+	res, err := getSourceURL(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if !contentTypeAllowed(mediaType, contentTypes) {
+		return nil, fmt.Errorf("%w: unexpected content type %q", errSourceUnavailable, mediaType)
+	}
+
+	if res.ContentLength > maxBytes {
+		return nil, fmt.Errorf("%w: source exceeds the limit of %d bytes", errSourceUnavailable, maxBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errSourceUnavailable, err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: source exceeds the limit of %d bytes", errSourceUnavailable, maxBytes)
+	}
+
+	return data, nil
+}
+
+// getSourceURL starts downloading raw, returning the response once its
+// status is OK
+func getSourceURL(ctx context.Context, raw string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errSourceUnavailable, err)
+	}
+
+	// Redirects could lead off the allowed hosts
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !sourceURLAllowed(req.URL.String()) {
+				return fmt.Errorf("redirect to a host that isn't allowed")
+			}
+			return nil
+		},
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errSourceUnavailable, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		// The query of a presigned URL holds its signature
+		return nil, fmt.Errorf("%w: fetching %s%s returned %s", errSourceUnavailable, req.URL.Host, req.URL.Path, res.Status)
+	}
+
+	return res, nil
+}
+
+// contentTypeAllowed reports whether mediaType is one of contentTypes
+func contentTypeAllowed(mediaType string, contentTypes []string) bool {
+	for _, allowed := range contentTypes {
+		if mediaType == allowed || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchFiles downloads the archive at raw and unpacks it into dir
+func fetchFiles(raw string, dir string) error {
+	data, err := fetchSource(raw, filesContentTypes, maxFilesBytes)
+	if err != nil {
+		return err
+	}
+
+	err = extractArchive(data, dir, maxFilesBytes)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errSourceUnavailable, err)
+	}
+
+	return nil
+}
+
+// extractArchive unpacks the zip or gzipped tar archive data into dir. The
+// content type archives come with is often generic, so the format is told
+// from the archive itself.
+func extractArchive(data []byte, dir string, maxBytes int64) error {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return extractZip(data, dir, maxBytes)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return extractTarball(bytes.NewReader(data), dir, maxBytes)
+	default:
+		return fmt.Errorf("not a zip or gzipped tar archive")
+	}
+}
+
+// extractZip unpacks the zip archive data into dir, with the same protections
+// as extractTarball
+func extractZip(data []byte, dir string, maxBytes int64) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+
+	var total int64
+	for _, f := range zr.File {
+		path, ok := extractedPath(dir, f.Name)
+		if !ok {
+			return fmt.Errorf("invalid path %q in zip archive", f.Name)
+		}
+
+		total += int64(f.UncompressedSize64)
+		if maxBytes > 0 && total > maxBytes {
+			return fmt.Errorf("archive exceeds the limit of %d bytes", maxBytes)
+		}
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = os.MkdirAll(path, os.ModePerm)
+		case mode.IsRegular():
+			err = extractZipFile(f, path)
+		default:
+			return fmt.Errorf("%q is neither a regular file nor a directory", f.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractZipFile writes the zip entry f to path
+func extractZipFile(f *zip.File, path string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return writeExtractedFile(path, rc, f.Mode().Perm()|0644)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+)
+
+// Programs run with the platform's default stack size, usually 8MB, unless
+// the request sets StackSizeMB, e.g. for deep recursion exercises written
+// against a judge with a larger stack. The limit is set by a shell the
+// program is exec'd from, so it applies from the program's start, and node,
+// which bounds its stack itself, is also given a matching --stack-size.
+// Programs of warm-pool languages setting a stack size are started cold.
+
+// maxStackSizeMB bounds the stack size a request may set
+const maxStackSizeMB = 1024
+
+// checkStackSize validates the stack size of a request. If it isn't allowed
+// it writes the error response and returns false.
+func checkStackSize(w http.ResponseWriter, req *CodeExecRequest) bool {
+	if req.StackSizeMB < 0 || req.StackSizeMB > maxStackSizeMB {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Stack size not supported",
+			map[string]int64{"maxStackSizeMb": maxStackSizeMB, "stackSizeMb": req.StackSizeMB})
+		return false
+	}
+	return true
+}
+
+// stackCommand wraps a command so that it runs with the stack size of the
+// job, if it sets one
+func stackCommand(job *ExecJob, name string, args []string) (string, []string) {
+	if job.Request == nil || job.Request.StackSizeMB == 0 || runtime.GOOS == "windows" {
+		return name, args
+	}
+
+	kb := strconv.FormatInt(job.Request.StackSizeMB<<10, 10)
+	return "sh", append([]string{"-c", `ulimit -s "$0" && exec "$@"`, kb, name}, args...)
+}
+
+// nodeStackSizeArgs returns the node flags bounding its stack to the stack
+// size of the job, if it sets one. V8 is given less than the whole stack,
+// since it overflows the real one if native frames don't fit in the rest.
+func nodeStackSizeArgs(job *ExecJob) []string {
+	if job.Request == nil || job.Request.StackSizeMB == 0 {
+		return nil
+	}
+	return []string{"--stack-size=" + strconv.FormatInt(job.Request.StackSizeMB<<10*9/10, 10)}
+}
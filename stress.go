@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// In stress mode a judge request has no test cases. Instead, a generator
+// program prints an input for every seed in a range (given as its stdin), a
+// reference solution produces the expected output for it and the submission
+// is judged against that, until the first seed it fails on.
+
+const (
+	// maxStressSeeds bounds the number of seeds in a stress test
+	maxStressSeeds = 1000
+
+	// stressHelperTimeLimit bounds each run of the generator and the reference solution
+	stressHelperTimeLimit = 10 * time.Second
+)
+
+// StressSpec configures stress mode. The generator and the reference can
+// also be given as the programId of a registered program.
+type StressSpec struct {
+	Generator *CodeExecRequest `json:"generator"`
+	Reference *CodeExecRequest `json:"reference"`
+
+	// SeedFrom and SeedTo are the first and last seeds tried
+	SeedFrom int64 `json:"seedFrom"`
+	SeedTo   int64 `json:"seedTo"`
+}
+
+// StressResult reports how a stress test went
+type StressResult struct {
+	SeedsRun int `json:"seedsRun"`
+
+	// FailingSeed is the first seed the submission failed on, along with the
+	// input generated for it and the reference output
+	FailingSeed    *int64 `json:"failingSeed,omitempty"`
+	Input          string `json:"input,omitempty"`
+	ExpectedOutput string `json:"expectedOutput,omitempty"`
+
+	// Message explains why the generator or the reference failed, if they did
+	Message string `json:"message,omitempty"`
+}
+
+// stressProgram is the generator or the reference solution of a stress test
+type stressProgram struct {
+	name string
+	lang *Language
+	job  *ExecJob
+}
+
+// run runs the program on stdin and returns its output
+func (p *stressProgram) run(ctx context.Context, stdin string) (string, error) {
+	p.job.Stdin = stdin
+	p.job.TimeLimit = stressHelperTimeLimit
+
+	result, err := p.lang.Run(ctx, p.job)
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w", p.name, err)
+	}
+	return result.Stdout, nil
+}
+
+// stressTest judges the job against the inputs generated for every seed of
+// req's range, stopping at the first one it fails
+func stressTest(ctx context.Context, lang *Language, job *ExecJob, req *JudgeRequest, chk *checker, gen *stressProgram, ref *stressProgram) *JudgeResponse {
+	response := &JudgeResponse{Verdict: VerdictAccepted, Cases: []TestCaseResult{}, Stress: &StressResult{}}
+
+	job.TimeLimit = time.Duration(req.TimeLimitMs) * time.Millisecond
+	job.CPUTimeLimit = time.Duration(req.CPUTimeLimitMs) * time.Millisecond
+	job.MemoryLimit = req.MemoryLimitMB << 20
+
+	for seed := req.Stress.SeedFrom; seed <= req.Stress.SeedTo; seed++ {
+		// Step 1: Generate the input
+		input, err := gen.run(ctx, strconv.FormatInt(seed, 10))
+		if err != nil {
+			response.Verdict = VerdictInternalError
+			response.Stress.Message = fmt.Sprintf("seed %d: %s", seed, err)
+			return response
+		}
+
+		// Step 2: Produce the expected output
+		expected, err := ref.run(ctx, input)
+		if err != nil {
+			response.Verdict = VerdictInternalError
+			response.Stress.Message = fmt.Sprintf("seed %d: %s", seed, err)
+			return response
+		}
+
+		// Step 3: Judge the submission
+		tc := TestCase{Input: input, ExpectedOutput: expected}
+		caseResult, result := runTestCase(ctx, lang, job, req, int(seed-req.Stress.SeedFrom), tc, chk, nil)
+		response.Stress.SeedsRun++
+
+		if caseResult.Verdict != VerdictAccepted {
+			failingSeed := seed
+			response.Verdict = caseResult.Verdict
+			caseResult.encodeOutputs()
+			response.Cases = append(response.Cases, caseResult)
+			response.Stress.FailingSeed = &failingSeed
+			response.Stress.Input = input
+			response.Stress.ExpectedOutput = expected
+			if caseResult.Verdict == VerdictCompileError && result != nil {
+				response.Diagnostics = result.Diagnostics
+			}
+			return response
+		}
+	}
+
+	return response
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Under systemd the agent can be socket activated, so the socket stays open
+// across restarts and no connection is refused while the agent is down, and
+// it reports its state to systemd (Type=notify): it is ready once it listens,
+// pings the systemd watchdog (WatchdogSec=) so a hung agent gets restarted,
+// and tells systemd when it stops.
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+// activatedListeners returns the listeners passed by systemd socket
+// activation, or nil if the agent wasn't socket activated. The passed file
+// descriptors are kept open, so they survive the agent re-executing itself.
+func activatedListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var listeners []net.Listener
+	for i := range count {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		listener, err := net.FileListener(os.NewFile(uintptr(listenFDsStart+i), name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to use socket %s passed by systemd: %w", name, err)
+		}
+		if config.MaxConnections > 0 {
+			listener = &limitListener{Listener: listener, slots: make(chan struct{}, config.MaxConnections)}
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// sdNotify sends state to systemd, if it is supervising the agent, e.g. "READY=1"
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+	// Abstract sockets are passed with a leading @
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		log.Printf("Warning: failed to notify systemd: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		log.Printf("Warning: failed to notify systemd: %s", err)
+	}
+}
+
+// startSystemdWatchdog pings the systemd watchdog at half its interval. An
+// agent that is merely unhealthy is left to the agent's own watchdog, which
+// lets the running executions finish before restarting.
+func startSystemdWatchdog() {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(usec) * time.Microsecond / 2)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Template projects (the directory a language's workspaces are copied from)
+// can be uploaded by admins as gzipped tarballs. Every upload becomes a new
+// numbered version under TemplatesDir/<language>/versions, and the "current"
+// symlink next to it names the active version. Activating a version swaps the
+// symlink atomically, so executions switch over without downtime: workspaces
+// being copied keep reading the version they started with. Until a template
+// is uploaded the language's built-in one is used.
+//
+// Besides its default template a language can have named ones (e.g. with
+// different dependencies preinstalled), which requests select by name. They
+// are managed the same way with ?name= and live under
+// TemplatesDir/<language>/named/<name>.
+
+// maxTemplateBytes bounds the size of an uploaded template tarball
+const maxTemplateBytes = 1 << 30
+
+// templatesMu serializes changes to the uploaded templates
+var templatesMu sync.Mutex
+
+// TemplateVersion describes an uploaded version of a template
+type TemplateVersion struct {
+	Version   string    `json:"version"`
+	Active    bool      `json:"active"`
+	SizeBytes int64     `json:"sizeBytes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// templateName matches the names of named templates
+var templateName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// templateBase returns the directory holding the versions of the template
+// name of language, the default one if name is empty
+func templateBase(language string, name string) string {
+	if name == "" {
+		return filepath.Join(config.TemplatesDir, language)
+	}
+	return filepath.Join(config.TemplatesDir, language, "named", name)
+}
+
+// languageTemplate returns the directory the workspaces of lang are copied
+// from: the active version of its uploaded template, or else its built-in one
+func languageTemplate(lang *Language) string {
+	dir, err := filepath.EvalSymlinks(filepath.Join(templateBase(lang.Name, ""), "current"))
+	if err == nil {
+		return dir
+	}
+	return lang.Template
+}
+
+// namedTemplate returns the active version of the template name of lang,
+// or the default template if name is empty. The name may be qualified with
+// the language, as in "typescript/react-testing".
+func namedTemplate(lang *Language, name string) (string, bool) {
+	name = strings.TrimPrefix(name, lang.Name+"/")
+	if name == "" {
+		return languageTemplate(lang), true
+	}
+	if !templateName.MatchString(name) {
+		return "", false
+	}
+
+	dir, err := filepath.EvalSymlinks(filepath.Join(templateBase(lang.Name, name), "current"))
+	if err != nil {
+		return "", false
+	}
+	return dir, true
+}
+
+// templateNames lists the named templates of language that have an active version
+func templateNames(language string) []string {
+	entries, _ := os.ReadDir(filepath.Join(config.TemplatesDir, language, "named"))
+
+	names := []string{}
+	for _, entry := range entries {
+		_, err := os.Stat(filepath.Join(templateBase(language, entry.Name()), "current"))
+		if err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+// templateVersions lists the uploaded versions of the template at base, oldest first
+func templateVersions(base string) ([]TemplateVersion, error) {
+
+	entries, err := os.ReadDir(filepath.Join(base, "versions"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	active, _ := os.Readlink(filepath.Join(base, "current"))
+
+	var versions []TemplateVersion
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		size := directorySize(filepath.Join(base, "versions", entry.Name()))
+		versions = append(versions, TemplateVersion{
+			Version:   entry.Name(),
+			Active:    filepath.Base(active) == entry.Name(),
+			SizeBytes: size,
+			CreatedAt: info.ModTime(),
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		a, _ := strconv.Atoi(versions[i].Version)
+		b, _ := strconv.Atoi(versions[j].Version)
+		return a < b
+	})
+
+	return versions, nil
+}
+
+// nextTemplateVersion returns the number of the next version of the template at base
+func nextTemplateVersion(base string) (string, error) {
+	versions, err := templateVersions(base)
+	if err != nil {
+		return "", err
+	}
+
+	next := 1
+	for _, v := range versions {
+		n, _ := strconv.Atoi(v.Version)
+		if n >= next {
+			next = n + 1
+		}
+	}
+	return strconv.Itoa(next), nil
+}
+
+// activateTemplate points the template at base to version
+func activateTemplate(base string, version string) error {
+	_, err := os.Stat(filepath.Join(base, "versions", version))
+	if err != nil {
+		return err
+	}
+
+	// Renaming over the old symlink switches versions atomically
+	tmp := filepath.Join(base, "current.tmp")
+	os.Remove(tmp)
+	err = os.Symlink(filepath.Join("versions", version), tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create symlink: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(base, "current"))
+}
+
+// extractTarball unpacks the gzipped tarball r into dir. Only regular files
+// and directories are accepted, so that nothing escapes dir. If maxBytes is
+// positive it bounds the total size of the files.
+func extractTarball(r io.Reader, dir string, maxBytes int64) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("not a gzipped tarball: %w", err)
+	}
+	defer gz.Close()
+
+	var total int64
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tarball: %w", err)
+		}
+
+		path, ok := extractedPath(dir, header.Name)
+		if !ok {
+			return fmt.Errorf("invalid path %q in tarball", header.Name)
+		}
+
+		total += header.Size
+		if maxBytes > 0 && total > maxBytes {
+			return fmt.Errorf("archive exceeds the limit of %d bytes", maxBytes)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, os.ModePerm)
+		case tar.TypeReg:
+			err = writeExtractedFile(path, tr, header.FileInfo().Mode().Perm()|0644)
+		default:
+			return fmt.Errorf("%q is neither a regular file nor a directory; create the tarball with symlinks dereferenced (tar -h)", header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractedPath returns where the archive entry name goes within dir, or false
+// if it would land outside of it
+func extractedPath(dir string, name string) (string, bool) {
+	path, err := confinedPath(dir, name)
+	return path, err == nil
+}
+
+// writeExtractedFile writes the contents of r to path, creating its directory
+func writeExtractedFile(path string, r io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// templateRequest reads the language and the ?name= of the template an admin
+// request is about, returning the directory holding its versions. If either is
+// invalid it writes the error response and returns false.
+func templateRequest(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	language, name := r.PathValue("language"), r.URL.Query().Get("name")
+	if _, ok := languages[language]; !ok {
+		writeError(w, http.StatusBadRequest, CodeUnsupportedLanguage, "Language not supported", map[string]any{"supportedLanguages": supportedLanguages()})
+		return "", "", false
+	}
+	if name != "" && !templateName.MatchString(name) {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid template name", map[string]string{"pattern": templateName.String()})
+		return "", "", false
+	}
+	return language, templateBase(language, name), true
+}
+
+// templatesHandler lists the versions of a language's template (GET) or
+// uploads a new one from a gzipped tarball (POST), activating it unless
+// ?activate=false is given
+func templatesHandler(w http.ResponseWriter, r *http.Request) {
+	language, base, ok := templateRequest(w, r)
+	if !ok {
+		return
+	}
+	name := r.URL.Query().Get("name")
+
+	switch r.Method {
+	case http.MethodGet:
+		versions, err := templateVersions(base)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to list templates: %v", err), nil)
+			return
+		}
+
+		response := map[string]any{"language": language, "versions": versions}
+		if name == "" {
+			response["named"] = templateNames(language)
+		} else {
+			response["name"] = name
+		}
+
+		jsonResponse, _ := json.Marshal(response)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+
+	case http.MethodPost:
+		templatesMu.Lock()
+		defer templatesMu.Unlock()
+
+		version, err := nextTemplateVersion(base)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to list templates: %v", err), nil)
+			return
+		}
+
+		// Step 1: Unpack the upload next to the versions, then move it into place
+		tmp := filepath.Join(base, ".upload-"+version)
+		os.RemoveAll(tmp)
+		defer os.RemoveAll(tmp)
+
+		err = os.MkdirAll(tmp, os.ModePerm)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to store template: %v", err), nil)
+			return
+		}
+
+		err = extractTarball(http.MaxBytesReader(w, r.Body, maxTemplateBytes), tmp, 0)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeError(w, http.StatusRequestEntityTooLarge, CodeInvalidRequest,
+					fmt.Sprintf("Template exceeds the limit of %d bytes", maxTemplateBytes),
+					map[string]int64{"limitBytes": maxTemplateBytes})
+				return
+			}
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error(), nil)
+			return
+		}
+
+		err = os.MkdirAll(filepath.Join(base, "versions"), os.ModePerm)
+		if err == nil {
+			err = os.Rename(tmp, filepath.Join(base, "versions", version))
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to store template: %v", err), nil)
+			return
+		}
+
+		// Step 2: Switch executions over to it
+		active := r.URL.Query().Get("activate") != "false"
+		if active {
+			err = activateTemplate(base, version)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to activate template: %v", err), nil)
+				return
+			}
+		}
+		log.Printf("Stored template version %s for %s %q (active: %t)", version, language, name, active)
+
+		jsonResponse, _ := json.Marshal(map[string]any{"language": language, "name": name, "version": version, "active": active})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+	}
+}
+
+// templateVersionHandler activates (POST .../activate) or deletes (DELETE) a
+// version of a language's template. The active version can't be deleted.
+func templateVersionHandler(w http.ResponseWriter, r *http.Request) {
+	language, base, ok := templateRequest(w, r)
+	if !ok {
+		return
+	}
+	version := r.PathValue("version")
+	if _, err := strconv.Atoi(version); err != nil {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Template version not found", nil)
+		return
+	}
+
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+
+	dir := filepath.Join(base, "versions", version)
+	if _, err := os.Stat(dir); err != nil {
+		writeError(w, http.StatusNotFound, CodeInvalidRequest, "Template version not found", nil)
+		return
+	}
+
+	activate := strings.HasSuffix(r.URL.Path, "/activate")
+	switch {
+	case activate && r.Method == http.MethodPost:
+		err := activateTemplate(base, version)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to activate template: %v", err), nil)
+			return
+		}
+		log.Printf("Activated template version %s for %s %q", version, language, r.URL.Query().Get("name"))
+
+	case !activate && r.Method == http.MethodDelete:
+		active, _ := os.Readlink(filepath.Join(base, "current"))
+		if filepath.Base(active) == version {
+			writeError(w, http.StatusConflict, CodeInvalidRequest, "The active template version can't be deleted", nil)
+			return
+		}
+		err := os.RemoveAll(dir)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to delete template: %v", err), nil)
+			return
+		}
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Test cases too large to embed in a judge request can reference their data
+// by URL instead (presigned object storage URLs, on the hosts allowed for
+// source URLs): the input is streamed to the program's stdin as it is
+// downloaded, and the program's output is compared with the expected output
+// as both stream in, so neither is held in memory. Only the start of the
+// program's output is returned with the result. The test data of bundles is
+// streamed the same way from the bundle's files.
+
+const (
+	// maxTestDataBytes bounds the input or expected output of a test case fetched from a URL
+	maxTestDataBytes = 1 << 30
+
+	// streamedOutputCapture is how much of the output of a program compared
+	// with a streamed expected output is returned
+	streamedOutputCapture = 64 << 10
+
+	// streamExcerptBytes bounds the excerpt of each output shown for a
+	// mismatch found while streaming
+	streamExcerptBytes = 200
+)
+
+// errTestDataTooLarge is returned reading test data past maxTestDataBytes
+var errTestDataTooLarge = fmt.Errorf("test data exceeds the limit of %d bytes", maxTestDataBytes)
+
+// checkTestCaseURLs validates the test cases of req whose data is fetched
+// from URLs. If they are invalid it writes the error response and returns false.
+func checkTestCaseURLs(w http.ResponseWriter, req *JudgeRequest) bool {
+	for i, tc := range req.TestCases {
+		if tc.InputURL == "" && tc.ExpectedOutputURL == "" {
+			continue
+		}
+
+		if (tc.InputURL != "" && tc.Input != "") || (tc.ExpectedOutputURL != "" && tc.ExpectedOutput != "") {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "A test case's data can't be given both inline and by URL", map[string]int{"index": i})
+			return false
+		}
+		for _, raw := range []string{tc.InputURL, tc.ExpectedOutputURL} {
+			if raw != "" && !sourceURLAllowed(raw) {
+				writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Test data URL not allowed",
+					map[string]any{"index": i, "allowedHosts": config.SourceURLHosts})
+				return false
+			}
+		}
+
+		// These need the whole data at once, or more than once
+		if req.Checker != nil || req.Interactor != nil || req.Function != nil || req.TimeLimitReruns != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+				"Test data URLs can't be used with a checker, an interactor, function mode or time limit reruns", nil)
+			return false
+		}
+		if tc.ExpectedOutputURL != "" && req.Comparison != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Expected output URLs can't be used with a comparison", nil)
+			return false
+		}
+	}
+	return true
+}
+
+// testStreams connect a job to the test data of a case fetched from URLs or
+// read from files
+type testStreams struct {
+	job       *ExecJob
+	input     *inputStream
+	inputFile *os.File
+	expected  *outputComparer
+	body      io.ReadCloser
+}
+
+// openTestStreams starts fetching the test data of tc given by URL or by
+// file, and connects job to it until the streams are closed. It returns nil
+// if tc has no such data.
+func openTestStreams(ctx context.Context, job *ExecJob, tc TestCase) (*testStreams, error) {
+	if tc.InputURL == "" && tc.ExpectedOutputURL == "" && tc.inputFile == "" && tc.expectedOutputFile == "" {
+		return nil, nil
+	}
+	streams := &testStreams{job: job}
+
+	switch {
+	case tc.InputURL != "":
+		input, err := streamInput(ctx, tc.InputURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch the input: %w", err)
+		}
+		streams.input = input
+		job.Input = input.reader
+	case tc.inputFile != "":
+		f, err := os.Open(tc.inputFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the input: %w", err)
+		}
+		streams.inputFile = f
+		job.Input = f
+	}
+
+	switch {
+	case tc.ExpectedOutputURL != "":
+		res, err := getSourceURL(ctx, tc.ExpectedOutputURL)
+		if err != nil {
+			streams.close()
+			return nil, fmt.Errorf("failed to fetch the expected output: %w", err)
+		}
+		streams.body = res.Body
+	case tc.expectedOutputFile != "":
+		f, err := os.Open(tc.expectedOutputFile)
+		if err != nil {
+			streams.close()
+			return nil, fmt.Errorf("failed to read the expected output: %w", err)
+		}
+		streams.body = f
+	}
+	if streams.body != nil {
+		streams.expected = newOutputComparer(&limitedData{r: streams.body, left: maxTestDataBytes})
+		job.Output = streams.expected
+		job.CaptureLimit = streamedOutputCapture
+	}
+
+	return streams, nil
+}
+
+// disconnect stops the input and disconnects the job from the streams once
+// the program has run, returning the error the input failed with while the
+// program was reading it, if any. The expected output stays open for the
+// verdict.
+func (s *testStreams) disconnect() error {
+	if s == nil {
+		return nil
+	}
+
+	s.job.Input, s.job.Output, s.job.CaptureLimit = nil, nil, 0
+	if s.inputFile != nil {
+		s.inputFile.Close()
+	}
+	if s.input != nil {
+		return s.input.stop()
+	}
+	return nil
+}
+
+// close disconnects the streams and closes the expected output
+func (s *testStreams) close() error {
+	if s == nil {
+		return nil
+	}
+
+	err := s.disconnect()
+	if s.body != nil {
+		s.body.Close()
+	}
+	return err
+}
+
+// inputStream copies test data into the pipe a program reads its input from
+type inputStream struct {
+	reader  *os.File
+	writer  *os.File
+	body    io.ReadCloser
+	done    chan struct{}
+	stopped atomic.Bool
+
+	// err is the error fetching the data failed with before the stream was stopped
+	err error
+}
+
+// streamInput starts downloading raw into the pipe returned
+func streamInput(ctx context.Context, raw string) (*inputStream, error) {
+	res, err := getSourceURL(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// The program reads the pipe itself rather than through a goroutine
+	// copying into it, which would outlive a program that stops reading
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		res.Body.Close()
+		return nil, fmt.Errorf("failed to create input pipe: %w", err)
+	}
+
+	s := &inputStream{reader: reader, writer: writer, body: res.Body, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		defer writer.Close()
+
+		// Failing to write is the program exiting without reading all of
+		// its input, which is up to the program
+		source := &limitedData{r: res.Body, left: maxTestDataBytes}
+		io.Copy(writer, source)
+		if source.err != nil && !s.stopped.Load() {
+			s.err = source.err
+		}
+	}()
+
+	return s, nil
+}
+
+// stop ends the download once the program is done with its input
+func (s *inputStream) stop() error {
+	s.stopped.Store(true)
+	s.body.Close()
+	s.reader.Close()
+	<-s.done
+	return s.err
+}
+
+// limitedData reads test data, failing past limit bytes
+type limitedData struct {
+	r    io.Reader
+	left int64
+
+	// err is the error reading failed with, other than io.EOF
+	err error
+}
+
+func (l *limitedData) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		l.err = errTestDataTooLarge
+		return 0, l.err
+	}
+
+	n, err := l.r.Read(p[:min(int64(len(p)), l.left+1)])
+	if int64(n) > l.left {
+		n, err = int(l.left), errTestDataTooLarge
+	}
+	l.left -= int64(n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		l.err = err
+	}
+	return n, err
+}
+
+// outputNormalizer brings an output, a byte at a time, into the form
+// normalizeOutput gives it: whitespace ending a line and blank lines ending
+// the output are held back until a later byte shows they don't
+type outputNormalizer struct {
+	spaces   []byte
+	newlines int
+}
+
+// push feeds b to the normalizer, appending the bytes it lets out to out
+func (n *outputNormalizer) push(b byte, out []byte) []byte {
+	switch b {
+	case '\n':
+		n.spaces = n.spaces[:0]
+		n.newlines++
+		return out
+	case ' ', '\t', '\r':
+		n.spaces = append(n.spaces, b)
+		return out
+	}
+
+	for ; n.newlines > 0; n.newlines-- {
+		out = append(out, '\n')
+	}
+	out = append(out, n.spaces...)
+	n.spaces = n.spaces[:0]
+	return append(out, b)
+}
+
+// outputComparer compares the output written to it with an expected output
+// read as it goes, both normalized, stopping at the first difference
+type outputComparer struct {
+	expected *bufio.Reader
+	want     outputNormalizer
+	got      outputNormalizer
+
+	// queued are the normalized expected bytes not compared yet
+	queued []byte
+
+	// line and column (in characters) are where the next byte goes, and
+	// current is the end of the line so far
+	line    int
+	column  int
+	current []byte
+
+	diff *OutputDiff
+	err  error
+}
+
+// newOutputComparer compares an output with expected
+func newOutputComparer(expected io.Reader) *outputComparer {
+	return &outputComparer{expected: bufio.NewReader(expected)}
+}
+
+func (c *outputComparer) Write(p []byte) (int, error) {
+	if c.diff != nil || c.err != nil {
+		return len(p), nil
+	}
+
+	var got []byte
+	for _, b := range p {
+		got = c.got.push(b, got)
+	}
+
+	for i, b := range got {
+		want, ok := c.nextExpected()
+		if c.err != nil {
+			break
+		}
+		if !ok || want != b {
+			c.mismatch(want, ok, got[i:], true)
+			break
+		}
+		c.advance(b)
+	}
+	return len(p), nil
+}
+
+// finish compares the end of the output, once it was all written, returning
+// where the outputs first differ or nil if they match
+func (c *outputComparer) finish() (*OutputDiff, error) {
+	if c.diff == nil && c.err == nil {
+		if want, ok := c.nextExpected(); ok {
+			c.mismatch(want, true, nil, false)
+		}
+	}
+	return c.diff, c.err
+}
+
+// verdict judges the output once it was all written
+func (c *outputComparer) verdict() (Verdict, string, *OutputDiff) {
+	diff, err := c.finish()
+	switch {
+	case err != nil:
+		return VerdictInternalError, fmt.Sprintf("failed to read the expected output: %s", err), nil
+	case diff != nil:
+		return VerdictWrongAnswer, diff.summary(), diff
+	}
+	return VerdictAccepted, "", nil
+}
+
+// nextExpected returns the next normalized byte of the expected output, or
+// false once it has ended
+func (c *outputComparer) nextExpected() (byte, bool) {
+	for len(c.queued) == 0 {
+		b, err := c.expected.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return 0, false
+		}
+		if err != nil {
+			c.err = err
+			return 0, false
+		}
+		c.queued = c.want.push(b, c.queued)
+	}
+
+	b := c.queued[0]
+	c.queued = c.queued[1:]
+	return b, true
+}
+
+// advance moves past b, which both outputs have
+func (c *outputComparer) advance(b byte) {
+	if b == '\n' {
+		c.line++
+		c.column = 0
+		c.current = c.current[:0]
+		return
+	}
+
+	// Continuation bytes don't start a character
+	if b&0xc0 != 0x80 {
+		c.column++
+	}
+	c.current = append(c.current, b)
+	if len(c.current) > 2*streamExcerptBytes {
+		c.current = append(c.current[:0], c.current[len(c.current)-streamExcerptBytes:]...)
+	}
+}
+
+// mismatch records the difference between the next expected byte want and
+// the rest got of the output, where either may have ended
+func (c *outputComparer) mismatch(want byte, wantOK bool, got []byte, gotOK bool) {
+	gotOK = gotOK && len(got) > 0
+
+	var expected []byte
+	if wantOK {
+		expected = append(expected, want)
+		for len(expected) < streamExcerptBytes {
+			b, ok := c.nextExpected()
+			if !ok {
+				break
+			}
+			expected = append(expected, b)
+		}
+	}
+
+	// An output ending where the other has more lines differs on the next line
+	line, column, current := c.line, c.column, c.current
+	switch {
+	case !gotOK && len(expected) > 0 && expected[0] == '\n':
+		expected = expected[1:]
+		line, column, current = line+1, 0, nil
+	case !wantOK && gotOK && got[0] == '\n':
+		got = got[1:]
+		line, column, current = line+1, 0, nil
+	}
+
+	c.diff = &OutputDiff{
+		Line:     line + 1,
+		Column:   column + 1,
+		FromLine: line + 1,
+		Expected: []string{streamExcerpt(current, expected, wantOK)},
+		Actual:   []string{streamExcerpt(current, got, gotOK)},
+	}
+}
+
+// streamExcerpt returns the line made of prefix and rest, up to its end, with
+// whitespace made visible, or marks the end of the output if it has ended
+// there
+func streamExcerpt(prefix []byte, rest []byte, more bool) string {
+	if i := strings.IndexByte(string(rest), '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	rest = rest[:min(len(rest), streamExcerptBytes)]
+	if !more && len(prefix) == 0 && len(rest) == 0 {
+		return endOfOutput
+	}
+
+	line := strings.ToValidUTF8(string(prefix)+string(rest), "")
+	return visualizeWhitespace(line)
+}
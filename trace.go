@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Trace mode runs the program step of an execution under strace or ltrace and
+// returns the trace as an artifact. It is meant for diagnosing failures that
+// only happen on the agent, so it is restricted to admins.
+
+// tracers are the tools an execution can be traced with
+var tracers = map[string]bool{"strace": true, "ltrace": true}
+
+// traceDir is where the trace is written, relative to the workspace
+const traceDir = ".trace"
+
+// checkTrace validates the trace option of a request. If it can't be used it
+// writes the error response and returns false.
+func checkTrace(w http.ResponseWriter, r *http.Request, req *CodeExecRequest) bool {
+	if req.Trace == "" {
+		return true
+	}
+	if !tracers[req.Trace] {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Unknown tracer %q", req.Trace), map[string]any{"tracers": []string{"strace", "ltrace"}})
+		return false
+	}
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "Tracing requires the admin token", nil)
+		return false
+	}
+	return true
+}
+
+// tracedCommand returns the command line running name with args under the
+// job's tracer, or name and args unchanged if the job isn't traced
+func tracedCommand(job *ExecJob, name string, args []string) (string, []string) {
+	if job.Request == nil || job.Request.Trace == "" {
+		return name, args
+	}
+
+	dir := filepath.Join(job.Dir, traceDir)
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		log.Printf("Warning: failed to create trace directory %s: %s", dir, err)
+	}
+
+	output := filepath.Join(dir, job.Request.Trace+".txt")
+	return job.Request.Trace, append([]string{"-f", "-o", output, name}, args...)
+}
+
+// traceArtifacts returns the trace written for the job, if any
+func traceArtifacts(job *ExecJob) []Artifact {
+	artifacts, err := collectArtifacts(job, traceDir, map[string]string{".txt": "text/plain"})
+	if err != nil {
+		log.Printf("Warning: failed to collect trace: %s", err)
+	}
+	return artifacts
+}
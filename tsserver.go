@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// typeCheckTimeout bounds a single type-check by the warm server
+const typeCheckTimeout = 30 * time.Second
+
+// errTypeCheckUnavailable is returned when the warm server can't be used, in
+// which case the program is type-checked the slow way
+var errTypeCheckUnavailable = errors.New("type-check server unavailable")
+
+// errTSServerRejected is returned when the server answers a request with a failure
+var errTSServerRejected = errors.New("request rejected")
+
+// tsServer is a persistent tsserver for a TypeScript template project. Every
+// submission is checked as the template's own source file, so the program the
+// server built for the template (lib files, dependencies' types) is reused
+// and only the submission itself is checked again. Checks are serialized, and
+// the server is restarted when the template changes.
+type tsServer struct {
+	sourceFile string
+	template   string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	seq    int
+
+	// missing is set once tsserver turned out not to be installed
+	missing bool
+}
+
+// tsServerMessage is a message read from tsserver
+type tsServerMessage struct {
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// tsServerDiagnostic is a diagnostic as reported by tsserver
+type tsServerDiagnostic struct {
+	Start struct {
+		Line   int `json:"line"`
+		Offset int `json:"offset"`
+	} `json:"start"`
+	Text     string `json:"text"`
+	Code     int    `json:"code"`
+	Category string `json:"category"`
+}
+
+// newTSServer returns a server checking sourceFile, started on first use
+func newTSServer(sourceFile string) *tsServer {
+	return &tsServer{sourceFile: sourceFile}
+}
+
+// prewarm starts the server and has it build the program of template
+func (s *tsServer) prewarm(template string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.missing || template == "" {
+		return
+	}
+	s.stop()
+	s.template = template
+	err := s.start()
+	if err != nil {
+		if !s.missing {
+			log.Printf("Warning: failed to prewarm tsserver for %s: %s", s.template, err)
+		}
+		return
+	}
+
+	_, err = s.diagnostics(context.Background(), "")
+	if err != nil {
+		log.Printf("Warning: failed to prewarm tsserver for %s: %s", s.template, err)
+	}
+}
+
+// check type-checks code within template, returning its errors
+func (s *tsServer) check(ctx context.Context, template string, code string) ([]Diagnostic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.use(template)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, typeCheckTimeout)
+	defer cancel()
+
+	diagnostics, err := s.diagnostics(ctx, code)
+	if err != nil {
+		// The server may be wedged or gone, so start afresh next time
+		log.Printf("Warning: tsserver for %s failed: %s", s.template, err)
+		s.stop()
+		return nil, fmt.Errorf("%w: %s", errTypeCheckUnavailable, err)
+	}
+
+	return diagnostics, nil
+}
+
+// use makes sure the server runs for template. The caller holds s.mu.
+func (s *tsServer) use(template string) error {
+	if s.missing || template == "" {
+		return errTypeCheckUnavailable
+	}
+	if s.template != template {
+		s.stop()
+		s.template = template
+	}
+	if s.cmd == nil {
+		err := s.start()
+		if err != nil {
+			return fmt.Errorf("%w: %s", errTypeCheckUnavailable, err)
+		}
+	}
+	return nil
+}
+
+// intel answers query about code within template: its errors, the type at
+// the position and the completions there
+func (s *tsServer) intel(ctx context.Context, template string, code string, query IntelQuery) (*IntelResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.use(template)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, typeCheckTimeout)
+	defer cancel()
+
+	response, err := s.query(ctx, code, query)
+	if err != nil && !errors.Is(err, errTSServerRejected) {
+		log.Printf("Warning: tsserver for %s failed: %s", s.template, err)
+		s.stop()
+		return nil, fmt.Errorf("%w: %s", errTypeCheckUnavailable, err)
+	}
+	return response, err
+}
+
+// query opens code as the template's source file and runs the requested
+// commands on it
+func (s *tsServer) query(ctx context.Context, code string, query IntelQuery) (*IntelResponse, error) {
+	file := filepath.Join(s.template, s.sourceFile)
+	location := map[string]any{"file": file, "line": query.Line, "offset": query.Column}
+
+	// Diagnostics opens the file, so it runs even when they weren't requested
+	diagnostics, err := s.diagnostics(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &IntelResponse{}
+	if query.wants(intelDiagnostics) {
+		response.Diagnostics = diagnostics
+		if response.Diagnostics == nil {
+			response.Diagnostics = []Diagnostic{}
+		}
+	}
+
+	if query.wants(intelHover) {
+		body, err := s.request(ctx, "quickinfo", location)
+		if err != nil && !errors.Is(err, errTSServerRejected) {
+			return nil, err
+		}
+
+		// The server rejects positions without anything to describe
+		var info struct {
+			Kind          string `json:"kind"`
+			DisplayString string `json:"displayString"`
+			Documentation string `json:"documentation"`
+		}
+		if err == nil && json.Unmarshal(body, &info) == nil && info.DisplayString != "" {
+			response.Hover = &IntelHover{Kind: info.Kind, Signature: info.DisplayString, Documentation: info.Documentation}
+		}
+	}
+
+	if query.wants(intelCompletions) {
+		body, err := s.request(ctx, "completionInfo", location)
+		if err != nil && !errors.Is(err, errTSServerRejected) {
+			return nil, err
+		}
+
+		var info struct {
+			Entries []struct {
+				Name     string `json:"name"`
+				Kind     string `json:"kind"`
+				SortText string `json:"sortText"`
+			} `json:"entries"`
+		}
+		if err == nil {
+			json.Unmarshal(body, &info)
+		}
+		sort.SliceStable(info.Entries, func(i, j int) bool { return info.Entries[i].SortText < info.Entries[j].SortText })
+
+		response.Completions = []IntelCompletion{}
+		for _, entry := range info.Entries[:min(len(info.Entries), maxintelCompletions)] {
+			response.Completions = append(response.Completions, IntelCompletion{Name: entry.Name, Kind: entry.Kind})
+		}
+	}
+
+	return response, nil
+}
+
+// start launches tsserver
+func (s *tsServer) start() error {
+	cmd := exec.Command("tsserver", "--disableAutomaticTypingAcquisition")
+	cmd.Dir = s.template
+	cmd.WaitDelay = waitDelay
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error while obtaining stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error while obtaining stdout pipe: %w", err)
+	}
+
+	err = cmd.Start()
+	if errors.Is(err, exec.ErrNotFound) {
+		log.Printf("Warning: tsserver is not installed, TypeScript is type-checked by ts-node")
+		s.missing = true
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start tsserver: %w", err)
+	}
+
+	s.cmd, s.stdin, s.stdout, s.seq = cmd, stdin, bufio.NewReader(stdout), 0
+	return nil
+}
+
+// stop kills the server
+func (s *tsServer) stop() {
+	if s.cmd == nil {
+		return
+	}
+	s.stdin.Close()
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+	s.cmd = nil
+}
+
+// diagnostics opens code as the template's source file and returns its
+// syntactic and semantic errors
+func (s *tsServer) diagnostics(ctx context.Context, code string) ([]Diagnostic, error) {
+	file := filepath.Join(s.template, s.sourceFile)
+
+	// Opening an open file again replaces its content. The server doesn't
+	// respond to it.
+	_, err := s.send("open", map[string]any{"file": file, "fileContent": code, "projectRootPath": s.template})
+	if err != nil {
+		return nil, err
+	}
+
+	var diagnostics []Diagnostic
+	for _, command := range []string{"syntacticDiagnosticsSync", "semanticDiagnosticsSync"} {
+		body, err := s.request(ctx, command, map[string]any{"file": file})
+		if err != nil {
+			return nil, err
+		}
+
+		var reported []tsServerDiagnostic
+		err = json.Unmarshal(body, &reported)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s response: %w", command, err)
+		}
+
+		for _, d := range reported {
+			if d.Category != "error" {
+				continue
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     s.sourceFile,
+				Line:     d.Start.Line,
+				Column:   d.Start.Offset,
+				Severity: d.Category,
+				Message:  fmt.Sprintf("TS%d: %s", d.Code, d.Text),
+			})
+		}
+	}
+
+	return diagnostics, nil
+}
+
+// send writes a command to the server, returning its sequence number
+func (s *tsServer) send(command string, arguments any) (int, error) {
+	s.seq++
+
+	line, _ := json.Marshal(map[string]any{"seq": s.seq, "type": "request", "command": command, "arguments": arguments})
+	_, err := s.stdin.Write(append(line, '\n'))
+	if err != nil {
+		return 0, fmt.Errorf("failed to send %s: %w", command, err)
+	}
+
+	return s.seq, nil
+}
+
+// request sends a command and waits for its response, skipping any events
+// the server emits in the meantime
+func (s *tsServer) request(ctx context.Context, command string, arguments any) (json.RawMessage, error) {
+	seq, err := s.send(command, arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	type response struct {
+		message *tsServerMessage
+		err     error
+	}
+	responses := make(chan response, 1)
+
+	go func() {
+		for {
+			message, err := s.read()
+			if err != nil || (message.Type == "response" && message.RequestSeq == seq) {
+				responses <- response{message, err}
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// Killing the server unblocks the read
+		s.stop()
+		<-responses
+		return nil, fmt.Errorf("%s: %w", command, ctx.Err())
+	case res := <-responses:
+		if res.err != nil {
+			return nil, fmt.Errorf("%s: %w", command, res.err)
+		}
+		if !res.message.Success {
+			return nil, fmt.Errorf("%s failed: %w: %s", command, errTSServerRejected, res.message.Message)
+		}
+		return res.message.Body, nil
+	}
+}
+
+// read reads one message, framed as "Content-Length: n\r\n\r\n" and n bytes of JSON
+func (s *tsServer) read() (*tsServerMessage, error) {
+	body, err := readFramed(s.stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	var message tsServerMessage
+	err = json.Unmarshal(body, &message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+
+	return &message, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxUploadBytes bounds the size of a project upload
+const maxUploadBytes = maxFilesBytes + 1<<20
+
+// UploadManifest describes how to run an uploaded project. Besides the
+// fields of a code execution request (other than the code, which comes from
+// the archive) it names the file to run and, optionally, shell commands to
+// run in its place.
+type UploadManifest struct {
+	CodeExecRequest
+
+	// Entrypoint is the file run as the program, the language's source file if empty
+	Entrypoint string `json:"entrypoint,omitempty"`
+
+	// Commands are run in order in the workspace instead of the language
+	// runner, stopping at the first one that fails
+	Commands []string `json:"commands,omitempty"`
+}
+
+// uploadExecHandler runs a project uploaded as multipart/form-data, with the
+// project archive (zip or gzipped tar) in the "archive" part and the JSON
+// manifest in the "manifest" part
+func uploadExecHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	// Step 1: Read the upload, parsed and checked by validateUploadRequest
+	var manifest UploadManifest
+	json.Unmarshal([]byte(r.FormValue("manifest")), &manifest)
+	req := &manifest.CodeExecRequest
+
+	archive, _, err := r.FormFile("archive")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read archive", nil)
+		return
+	}
+	defer archive.Close()
+
+	data, err := io.ReadAll(archive)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read archive", nil)
+		return
+	}
+
+	lang, ok := resolveLanguage(w, req)
+	if !ok {
+		return
+	}
+	if !checkTrace(w, r, req) {
+		return
+	}
+
+	// The execution is queued before its record exists, under the record's ID
+	id := uuid.New().String()
+	ctx, release, ok := admitExecution(w, r, &queuedExecution{ID: id, Kind: "upload", Language: req.Language, bytes: unlimitedMemoryEstimate})
+	if !ok {
+		return
+	}
+	defer release()
+
+	start := time.Now()
+
+	// Step 2: Unpack the project into the workspace, over the template
+	job, err := prepareWorkspace(ctx, lang, req)
+	if err != nil {
+		status, code := classifyExecutionError(err)
+		writeError(w, status, code, fmt.Sprintf("Unable to prepare workspace: %v", err), timeoutDetails(err))
+		return
+	}
+	defer removeWorkspace(job)
+
+	// The code prepareWorkspace wrote is empty
+	os.Remove(job.SourcePath)
+
+	err = extractArchive(data, job.Dir, maxFilesBytes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Unable to extract archive: %v", err), nil)
+		return
+	}
+
+	if manifest.Entrypoint != "" {
+		entrypoint, ok := extractedPath(job.Dir, manifest.Entrypoint)
+		if ok {
+			err = copyFile(entrypoint, job.SourcePath)
+		}
+		if !ok || err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Entrypoint not found in archive", map[string]string{"entrypoint": manifest.Entrypoint})
+			return
+		}
+	}
+
+	code, err := os.ReadFile(job.SourcePath)
+	if err != nil && len(manifest.Commands) == 0 {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("Archive has no %s; name the file to run as the manifest's entrypoint", lang.SourceFile), nil)
+		return
+	}
+	req.Code = string(code)
+
+	record := newExecutionRecord("upload", req)
+	record.ID = id
+	records.add(record)
+
+	// The execution stops if the client disconnects or cancels it
+	ctx, done := executions.start(ctx, record)
+	defer done()
+	announceExecution(w, record.ID)
+
+	// Step 3: Run it
+	var result *ExecResult
+	if len(manifest.Commands) > 0 {
+		result = &ExecResult{}
+		for i, cmd := range manifest.Commands {
+			name, args := shellCommand(cmd)
+			res, runErr := runProgram(ctx, job, command{Name: name, Args: args, Dir: job.Dir})
+			result.Phases = append(result.Phases, newPhaseResult(fmt.Sprintf("command %d", i+1), res))
+			result.Stdout, result.Stderr, err = res.Stdout, res.Stderr, runErr
+			if err != nil {
+				break
+			}
+		}
+	} else {
+		result, err = runJob(ctx, lang, job)
+	}
+	if writePreempted(w, ctx) {
+		return
+	}
+
+	if job.Usage != nil {
+		result.Timeline = job.Usage.Timeline
+	}
+	if req.Snapshot {
+		snapshot, err := snapshotWorkspace(job)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Unable to snapshot workspace: %v", err), result)
+			return
+		}
+		result.SnapshotID = snapshot.ID
+	}
+	if req.Trace != "" {
+		result.Artifacts = append(result.Artifacts, traceArtifacts(job)...)
+	}
+	if err != nil {
+		status, code := classifyExecutionError(err)
+		writeError(w, status, code, executionErrorMessage(err), ExecFailure{result, newExecVerdict(err)})
+		return
+	}
+
+	elapsed := time.Since(start).Milliseconds()
+
+	jsonResponse, _ := json.Marshal(CodeExecResponse{ExecResult: result, ExecVerdict: newExecVerdict(nil), ExecTime: fmt.Sprintf("%d", elapsed)})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
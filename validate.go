@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ValidateResponse is returned by the validate endpoint for a valid request
+type ValidateResponse struct {
+	Valid    bool            `json:"valid"`
+	Language string          `json:"language"`
+	Detected bool            `json:"detected,omitempty"`
+	Runtime  string          `json:"runtime,omitempty"`
+	Limits   ValidatedLimits `json:"limits"`
+}
+
+// ValidatedLimits are the limits a validated request would run with
+type ValidatedLimits struct {
+	TimeLimitMs    int64 `json:"timeLimitMs"`
+	CPUTimeLimitMs int64 `json:"cpuTimeLimitMs,omitempty"`
+	MemoryLimitMB  int64 `json:"memoryLimitMb,omitempty"`
+	MaxCodeBytes   int64 `json:"maxCodeBytes"`
+}
+
+// validateHandler checks a request like /code/exec would, or like
+// /code/judge with ?kind=judge, without running it, and returns the limits it
+// would run with. Invalid requests get the error the endpoint would return.
+func validateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Unable to read request body", nil)
+		return
+	}
+	defer r.Body.Close()
+
+	var req JudgeRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON format", err.Error())
+		return
+	}
+
+	limits := ValidatedLimits{TimeLimitMs: defaultTimeout.Milliseconds(), MaxCodeBytes: maxCodeBytes}
+	switch kind := r.URL.Query().Get("kind"); kind {
+	case "", "exec":
+		if len(body) > maxRequestBodyBytes {
+			writeError(w, http.StatusRequestEntityTooLarge, CodeInvalidRequest,
+				fmt.Sprintf("Request body exceeds the limit of %d bytes", maxRequestBodyBytes),
+				map[string]int64{"limitBytes": maxRequestBodyBytes})
+			return
+		}
+		if !checkTrace(w, r, &req.CodeExecRequest) {
+			return
+		}
+	case "judge":
+		if !resolveBundle(w, &req) || !checkJudgeRequest(w, &req) {
+			return
+		}
+		limits.TimeLimitMs = req.TimeLimitMs
+		limits.CPUTimeLimitMs = req.CPUTimeLimitMs
+		limits.MemoryLimitMB = req.MemoryLimitMB
+	default:
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "Kind must be exec or judge", map[string]string{"kind": kind})
+		return
+	}
+
+	_, ok := resolveLanguage(w, &req.CodeExecRequest)
+	if !ok {
+		return
+	}
+
+	response := ValidateResponse{Valid: true, Language: req.Language, Detected: req.detected, Runtime: req.Runtime, Limits: limits}
+	jsonResponse, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
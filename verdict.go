@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Verdict is the outcome of an execution, a test case or a whole submission.
+// Exec and judge responses carry both its name and its numeric code, which
+// is stable across agent versions; /verdicts lists them.
+type Verdict string
+
+const (
+	VerdictAccepted      Verdict = "ACCEPTED"
+	VerdictWrongAnswer   Verdict = "WRONG_ANSWER"
+	VerdictCompileError  Verdict = "COMPILE_ERROR"
+	VerdictRuntimeError  Verdict = "RUNTIME_ERROR"
+	VerdictTimeLimit     Verdict = "TIME_LIMIT"
+	VerdictMemoryLimit   Verdict = "MEMORY_LIMIT"
+	VerdictOutputLimit   Verdict = "OUTPUT_LIMIT"
+	VerdictInternalError Verdict = "INTERNAL_ERROR"
+	VerdictIdlenessLimit Verdict = "IDLENESS_LIMIT"
+	VerdictSkipped       Verdict = "SKIPPED"
+)
+
+// verdicts are every verdict, in the order of their numeric codes
+var verdicts = []Verdict{
+	VerdictAccepted,
+	VerdictWrongAnswer,
+	VerdictCompileError,
+	VerdictRuntimeError,
+	VerdictTimeLimit,
+	VerdictMemoryLimit,
+	VerdictOutputLimit,
+	VerdictInternalError,
+	VerdictIdlenessLimit,
+	VerdictSkipped,
+}
+
+// Code returns the numeric code of v, that of INTERNAL_ERROR if v is unknown
+func (v Verdict) Code() int {
+	for code, verdict := range verdicts {
+		if verdict == v {
+			return code
+		}
+	}
+	return VerdictInternalError.Code()
+}
+
+// verdictOf returns the verdict of a program that returned err. A program that
+// ran successfully is ACCEPTED; its output is checked separately.
+func verdictOf(err error) Verdict {
+	var exitErr *programExitError
+	switch {
+	case err == nil:
+		return VerdictAccepted
+	case errors.Is(err, errCompilation):
+		return VerdictCompileError
+	case errors.Is(err, errExecutionTimeout):
+		return VerdictTimeLimit
+	case errors.Is(err, errMemoryLimit):
+		return VerdictMemoryLimit
+	case errors.As(err, &exitErr):
+		return VerdictRuntimeError
+	default:
+		return VerdictInternalError
+	}
+}
+
+// ExecVerdict is the verdict of a program run outside judge mode
+type ExecVerdict struct {
+	Verdict     Verdict `json:"verdict"`
+	VerdictCode int     `json:"verdictCode"`
+
+	// Timeout tells which phase ran past its timeout, for a TIME_LIMIT verdict
+	Timeout *PhaseTimeout `json:"timeout,omitempty"`
+
+	// Infrastructure is set if the execution failed because of the agent
+	// rather than the code
+	Infrastructure bool `json:"infrastructure,omitempty"`
+}
+
+// newExecVerdict returns the verdict of a program that returned err
+func newExecVerdict(err error) ExecVerdict {
+	verdict := verdictOf(err)
+	return ExecVerdict{Verdict: verdict, VerdictCode: verdict.Code(), Timeout: timedOutPhase(err), Infrastructure: isInfraFailure(err)}
+}
+
+// ExecFailure is the details of the error returned for a failed execution
+type ExecFailure struct {
+	*ExecResult
+	ExecVerdict
+}
+
+// setVerdictCodes fills in the numeric codes of the verdicts of r and its cases
+func (r *JudgeResponse) setVerdictCodes() {
+	r.VerdictCode = r.Verdict.Code()
+	for i := range r.Cases {
+		r.Cases[i].VerdictCode = r.Cases[i].Verdict.Code()
+	}
+}
+
+// VerdictInfo names a verdict and gives its numeric code
+type VerdictInfo struct {
+	Name Verdict `json:"name"`
+	Code int     `json:"code"`
+}
+
+// verdictsHandler lists every verdict with its numeric code
+func verdictsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	infos := []VerdictInfo{}
+	for code, verdict := range verdicts {
+		infos = append(infos, VerdictInfo{Name: verdict, Code: code})
+	}
+
+	jsonResponse, _ := json.Marshal(map[string]any{"verdicts": infos})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+// The build info is set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.gitSHA=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// The git SHA and build date fall back to the VCS info Go stamps into the binary.
+var (
+	version   = "dev"
+	gitSHA    = ""
+	buildDate = ""
+)
+
+// VersionResponse is returned by the version endpoint
+type VersionResponse struct {
+	Version   string `json:"version"`
+	GitSHA    string `json:"gitSha"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+
+	// Backends lists the languages by the backend running them: "builtin" or "external"
+	Backends map[string][]string `json:"backends"`
+
+	// Features lists the optional features this agent supports as configured
+	Features []string `json:"features"`
+}
+
+// buildInfo returns the git SHA and build date of the agent
+func buildInfo() (string, string) {
+	sha, date := gitSHA, buildDate
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return sha, date
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && sha == "":
+			sha = setting.Value
+		case setting.Key == "vcs.time" && date == "":
+			date = setting.Value
+		}
+	}
+	return sha, date
+}
+
+// agentFeatures returns the optional features this agent supports, sorted.
+// Features that depend on the configuration are only listed when enabled.
+func agentFeatures() []string {
+	features := []string{
+		"affinity", "artifacts", "bundles", "cancel", "compression", "display", "function", "interactive", "judge",
+		"programs", "progress", "pty", "replay", "server", "snapshots", "stress", "templates", "upload",
+	}
+	for _, lang := range languages {
+		if lang.Profiling {
+			features = append(features, "profile")
+			break
+		}
+	}
+	if len(config.GitHosts) > 0 {
+		features = append(features, "git")
+	}
+	if len(config.SourceURLHosts) > 0 {
+		features = append(features, "sourceUrls")
+	}
+	if len(services) > 0 {
+		features = append(features, "services")
+	}
+	if len(tracers) > 0 {
+		features = append(features, "trace")
+	}
+	if len(debugAdapters) > 0 {
+		features = append(features, "debug")
+	}
+	if resourceLimitsEnforced {
+		features = append(features, "resourceLimits")
+	}
+	if config.AdminToken != "" {
+		features = append(features, "admin")
+	}
+	if _, err := exec.LookPath("tsserver"); err == nil {
+		features = append(features, "intel")
+	}
+	if _, err := exec.LookPath("semgrep"); err == nil {
+		features = append(features, "scan")
+	}
+	sort.Strings(features)
+	return features
+}
+
+// versionHandler returns the agent's version, build info and capabilities
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, CodeInvalidRequest, "Invalid request method", nil)
+		return
+	}
+
+	backends := map[string][]string{}
+	for _, name := range supportedLanguages() {
+		backend := languages[name].Backend
+		backends[backend] = append(backends[backend], name)
+	}
+
+	sha, date := buildInfo()
+	response := VersionResponse{
+		Version:   version,
+		GitSHA:    sha,
+		BuildDate: date,
+		GoVersion: runtime.Version(),
+		Backends:  backends,
+		Features:  features(),
+	}
+
+	jsonResponse, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResponse)
+}
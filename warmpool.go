@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// warmPoolSize is the number of idle processes each pool keeps ready
+const warmPoolSize = 2
+
+// prewarmProcesses reports whether pools keep idle processes: on macOS a
+// process is sandboxed to the workspace of its job, so it can only be started
+// once the job is known
+const prewarmProcesses = runtime.GOOS != "darwin"
+
+// warmPool keeps interpreter processes started ahead of time so that slow
+// runtimes (e.g. the BEAM) don't pay their startup cost on the request path.
+// Each process blocks reading a workspace directory from stdin and then runs
+// the code found there.
+type warmPool struct {
+	name string
+	args []string
+
+	idle chan *warmProcess
+}
+
+// warmProcess is a started interpreter waiting for its workspace directory.
+// It outlives the request it may have been started for, so it has a context
+// of its own, which kill cancels to kill its whole tree.
+type warmProcess struct {
+	cmd    *exec.Cmd
+	tree   *commandTree
+	kill   context.CancelFunc
+	stdin  io.WriteCloser
+	stdout *warmOutput
+	stderr *warmOutput
+}
+
+// stop kills the process along with its tree and waits for it to exit
+func (w *warmProcess) stop() {
+	w.kill()
+	w.cmd.Wait()
+	w.tree.close()
+}
+
+// warmOutput captures a warm process's stdout or stderr. Since the process is
+// started before its job is known, the job's writers and capture limit are
+// attached later.
+type warmOutput struct {
+	mu  sync.Mutex
+	buf cappedBuffer
+	tee io.Writer
+}
+
+func (o *warmOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.buf.Write(p)
+	if o.tee != nil {
+		o.tee.Write(p)
+	}
+	return len(p), nil
+}
+
+// attach starts copying the output to w, if set, and bounds what is
+// captured to limit bytes, if set
+func (o *warmOutput) attach(w io.Writer, limit int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.tee = w
+	o.buf.limit = limit
+}
+
+func (o *warmOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.buf.String()
+}
+
+// warmPools holds every pool created by newWarmPool so they can be filled on boot
+var warmPools []*warmPool
+
+// newWarmPool creates a pool of `name args...` processes
+func newWarmPool(name string, args ...string) *warmPool {
+	pool := &warmPool{
+		name: name,
+		args: args,
+		idle: make(chan *warmProcess, warmPoolSize),
+	}
+	warmPools = append(warmPools, pool)
+	return pool
+}
+
+// startWarmPools fills every pool in the background
+func startWarmPools() {
+	if prewarmProcesses {
+		for _, pool := range warmPools {
+			go pool.refill()
+		}
+	}
+	go typeScriptChecker("").prewarm(languageTemplate(languages["typescript"]))
+}
+
+// refill starts processes until the pool is full
+func (p *warmPool) refill() {
+	for len(p.idle) < cap(p.idle) {
+		proc, err := p.spawn()
+		if err != nil {
+			log.Printf("Warning: failed to prewarm %s: %s", p.name, err)
+			return
+		}
+
+		select {
+		case p.idle <- proc:
+		default:
+			// Another refill got there first
+			proc.stop()
+			return
+		}
+	}
+}
+
+// spawn starts a new idle process for the pool
+func (p *warmPool) spawn() (*warmProcess, error) {
+	return p.spawnCommand(nil, p.name, p.args)
+}
+
+// spawnCommand starts name with args as a process for the pool. A process
+// started for job, rather than ahead of it, runs in its sandbox and with its
+// extra environment.
+func (p *warmPool) spawnCommand(job *ExecJob, name string, args []string) (*warmProcess, error) {
+	var env []string
+	if job != nil {
+		name, args = sandboxedCommand(job, name, args)
+		env = job.Env
+	}
+
+	ctx, kill := context.WithCancel(context.Background())
+	limitedName, limitedArgs := limitedCommand(command{Name: name, Args: args, Dir: workspaceRoot})
+	cmd := exec.CommandContext(ctx, limitedName, limitedArgs...)
+	cmd.Dir = workspaceRoot
+	cmd.WaitDelay = waitDelay
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		kill()
+		return nil, fmt.Errorf("error while obtaining stdin pipe: %w", err)
+	}
+
+	stdout := &warmOutput{}
+	stderr := &warmOutput{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	tree := newCommandTree(cmd)
+	err = cmd.Start()
+	if err != nil {
+		kill()
+		tree.close()
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	tree.attach(cmd.Process)
+
+	return &warmProcess{cmd: cmd, tree: tree, kill: kill, stdin: stdin, stdout: stdout, stderr: stderr}, nil
+}
+
+// acquire returns an idle process for job, starting a cold one if the pool is empty
+func (p *warmPool) acquire(job *ExecJob) (*warmProcess, error) {
+	// A traced process has to be started under the tracer, one with a stack
+	// size of its own with that stack, and one with its own environment with
+	// that environment
+	custom := len(job.Env) > 0 || job.Request != nil && (job.Request.Trace != "" || job.Request.StackSizeMB != 0)
+
+	if prewarmProcesses && !custom {
+		defer func() { go p.refill() }()
+
+		select {
+		case proc := <-p.idle:
+			return proc, nil
+		default:
+		}
+	}
+
+	name, args := stackCommand(job, p.name, p.args)
+	name, args = tracedCommand(job, name, args)
+	return p.spawnCommand(job, name, args)
+}
+
+// run hands the job's workspace to an idle process, followed by the job's
+// stdin, and waits for it to finish within the job's time limit, or the run
+// timeout
+func (p *warmPool) run(ctx context.Context, job *ExecJob) (*commandResult, error) {
+	timeout := job.TimeLimit
+	if timeout == 0 {
+		timeout = phaseTimeout(PhaseRun)
+	}
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	proc, err := p.acquire(job)
+	if err != nil {
+		return &commandResult{}, err
+	}
+	// Whatever the program left running is killed once it exits
+	defer proc.tree.close()
+	defer proc.kill()
+
+	// Where the watch can't, the job object caps the tree's memory; its CPU
+	// time is only checked once it exits, counting from the job on
+	proc.tree.limit(job.MemoryLimit, 0)
+	_, baseCPU, _ := proc.tree.usage()
+
+	start := time.Now()
+
+	// Step 1: Tell the process where its code is
+	_, err = fmt.Fprintln(proc.stdin, job.Dir)
+	if err != nil {
+		proc.stdin.Close()
+		proc.kill()
+		proc.cmd.Wait()
+		return &commandResult{Stderr: proc.stderr.String()}, fmt.Errorf("failed to hand workspace to %s: %w", p.name, err)
+	}
+
+	// Step 2: The rest of stdin belongs to the program
+	stdout := job.Output
+	var stderr io.Writer
+	if job.Combined != nil {
+		stdout = job.Combined.writer("stdout")
+		if job.Output != nil {
+			stdout = io.MultiWriter(job.Output, stdout)
+		}
+		stderr = job.Combined.writer("stderr")
+	}
+	proc.stdout.attach(publishedWriter(ctx, "stdout", stdout), job.CaptureLimit)
+	proc.stderr.attach(publishedWriter(ctx, "stderr", stderr), job.CaptureLimit)
+	if job.Started != nil {
+		job.Started(proc.cmd.Process)
+	}
+
+	go func() {
+		if job.Input != nil {
+			io.Copy(proc.stdin, job.Input)
+		} else {
+			io.WriteString(proc.stdin, job.Stdin)
+		}
+		proc.stdin.Close()
+	}()
+
+	sample := job.Request != nil && job.Request.SampleUsage
+
+	var watch *processWatch
+	if job.MemoryLimit > 0 || job.CPUTimeLimit > 0 || sample {
+		watch = watchProcess(proc.cmd.Process, job.MemoryLimit, job.CPUTimeLimit, sample)
+	}
+
+	// Step 3: Wait for the process to finish or timeout
+	done := make(chan error, 1)
+	go func() { done <- proc.cmd.Wait() }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var timedOut, cancelled bool
+	select {
+	case err = <-done:
+	case <-timer.C:
+		timedOut = true
+		proc.kill()
+		err = <-done
+	case <-ctx.Done():
+		cancelled = true
+		proc.kill()
+		err = <-done
+	}
+
+	if watch != nil {
+		watch.stop()
+	}
+
+	treeKB, treeCPU, memoryCapped := proc.tree.usage()
+	result := &commandResult{
+		Stdout:   proc.stdout.String(),
+		Stderr:   proc.stderr.String(),
+		Duration: time.Since(start),
+		CPUTime:  treeCPU - baseCPU,
+		MaxRSSKB: max(maxRSSKB(proc.cmd.ProcessState), treeKB),
+	}
+	if watch != nil {
+		result.Timeline = watch.timeline()
+	}
+	outputs.add(executionID(ctx), CapturedCommand{
+		Command:    p.name,
+		Args:       p.args,
+		StartedAt:  start,
+		DurationMs: result.Duration.Milliseconds(),
+		ExitCode:   proc.cmd.ProcessState.ExitCode(),
+		Stdout:     result.Stdout,
+		Stderr:     result.Stderr,
+	})
+	job.Usage = &ProgramUsage{TimeMs: result.Duration.Milliseconds(), MemoryKB: result.MaxRSSKB, Timeline: result.Timeline}
+
+	if timedOut {
+		return result, inPhase(PhaseRun, timeout, fmt.Errorf("%w after %s", errExecutionTimeout, timeout))
+	}
+	if cancelled {
+		return result, fmt.Errorf("%s: %w", p.name, errCancelled)
+	}
+	// The process was warmed up before the job, so only the watch and the
+	// tree know the job's CPU time
+	if job.CPUTimeLimit > 0 && (watch.cpuExceeded() || result.CPUTime > job.CPUTimeLimit) {
+		return result, fmt.Errorf("%w: %s used more than %s", errCPUTimeLimit, p.name, job.CPUTimeLimit)
+	}
+	if job.MemoryLimit > 0 && (watch.exceeded() || memoryCapped) {
+		return result, fmt.Errorf("%w: %s used more than %d bytes", errMemoryLimit, p.name, job.MemoryLimit)
+	}
+
+	if err != nil {
+		result.ExitCode = proc.cmd.ProcessState.ExitCode()
+		return result, &programExitError{name: p.name, code: result.ExitCode}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// The watchdog keeps an eye on the agent's own health: its heap, the free
+// space of the workspace disk and its number of goroutines. While any of them
+// is past its threshold new executions are turned away, so the agent
+// recovers instead of dying under pressure along with its in-flight jobs. If
+// it stays unhealthy and restarts are enabled, it lets the running
+// executions finish and re-executes itself.
+
+const (
+	// watchdogInterval is how often the agent's health is checked
+	watchdogInterval = 5 * time.Second
+
+	// watchdogRestartChecks is how many consecutive unhealthy checks lead to a restart
+	watchdogRestartChecks = 12
+
+	// watchdogDrainTimeout bounds how long a restart waits for running executions
+	watchdogDrainTimeout = 2 * time.Minute
+)
+
+// agentWatchdog holds the outcome of the latest health check
+type agentWatchdog struct {
+	mu        sync.Mutex
+	problem   string
+	unhealthy int
+}
+
+// watchdog checks the agent's health in the background
+var watchdog = &agentWatchdog{}
+
+// startWatchdog starts checking the agent's health
+func startWatchdog() {
+	go func() {
+		ticker := time.NewTicker(watchdogInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			watchdog.check()
+			disk.check()
+		}
+	}()
+}
+
+// check checks the agent's health, restarting it if it has been unhealthy
+// for too long and restarts are enabled
+func (d *agentWatchdog) check() {
+	problem := healthProblem()
+
+	d.mu.Lock()
+	if problem != d.problem {
+		if problem != "" {
+			log.Printf("Warning: shedding load, %s", problem)
+		} else {
+			log.Printf("Agent is healthy again, accepting executions")
+		}
+	}
+	d.problem = problem
+	if problem != "" {
+		d.unhealthy++
+	} else {
+		d.unhealthy = 0
+	}
+	restart := config.WatchdogRestart && d.unhealthy >= watchdogRestartChecks
+	d.mu.Unlock()
+
+	if restart {
+		restartAgent(problem)
+	}
+}
+
+// shedding returns why new executions are turned away, or "" if the agent is healthy
+func (d *agentWatchdog) shedding() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.problem
+}
+
+// healthProblem returns the first threshold the agent is past, or "" if none
+func healthProblem() string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	if config.WatchdogMaxHeapBytes > 0 && int64(mem.HeapAlloc) > config.WatchdogMaxHeapBytes {
+		return fmt.Sprintf("heap of %d bytes exceeds %d", mem.HeapAlloc, config.WatchdogMaxHeapBytes)
+	}
+
+	if goroutines := runtime.NumGoroutine(); config.WatchdogMaxGoroutines > 0 && goroutines > config.WatchdogMaxGoroutines {
+		return fmt.Sprintf("%d goroutines exceed %d", goroutines, config.WatchdogMaxGoroutines)
+	}
+
+	if config.WatchdogMinFreeDiskBytes > 0 {
+		usage, err := diskUsage(workspaceRoot)
+		if err == nil && usage.FreeBytes < config.WatchdogMinFreeDiskBytes {
+			return fmt.Sprintf("%d bytes free on %s, below %d", usage.FreeBytes, workspaceRoot, config.WatchdogMinFreeDiskBytes)
+		}
+	}
+
+	return ""
+}
+
+// restartAgent waits for the running executions to finish, which the
+// watchdog keeps new ones from joining, and re-executes the agent in place
+func restartAgent(problem string) {
+	log.Printf("Restarting agent: %s", problem)
+
+	deadline := time.Now().Add(watchdogDrainTimeout)
+	for !inFlight.idle() && time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+	}
+
+	// The listening sockets are close-on-exec, so the new agent can bind
+	// them again; those passed by systemd are inherited instead
+	err := reexecAgent()
+	log.Printf("Warning: failed to restart agent: %s", err)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal WebSocket server (RFC 6455): enough for the agent's own
+// endpoints, which exchange text messages with the editor. Extensions and
+// subprotocols aren't supported.
+
+// webSocketGUID is appended to the client's key to accept the handshake
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebSocketMessageBytes bounds the size of a message from the client
+const maxWebSocketMessageBytes = 16 << 20
+
+// The WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// errWebSocketClosed is returned once either side closed the connection
+var errWebSocketClosed = errors.New("websocket closed")
+
+// webSocket is a server-side WebSocket connection
+type webSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// mu serializes writes, since pongs are sent while reading
+	mu     sync.Mutex
+	closed bool
+}
+
+// upgradeWebSocket completes the WebSocket handshake of r and takes over its
+// connection. If the request isn't a valid handshake an error is written and
+// nil is returned.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) *webSocket {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "WebSocket handshake expected", nil)
+		return nil
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, http.StatusUpgradeRequired, CodeInvalidRequest, "Unsupported WebSocket version", nil)
+		return nil
+	}
+
+	// Browsers don't apply CORS to WebSockets, so other origins are turned
+	// away here
+	origin := r.Header.Get("Origin")
+	if origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Host != r.Host && !corsOriginAllowed(origin)) {
+			writeError(w, http.StatusForbidden, CodeForbidden, "Origin not allowed", map[string]string{"origin": origin})
+			return nil
+		}
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Unable to take over the connection", err.Error())
+		return nil
+	}
+
+	// The server's deadlines are meant for plain requests
+	conn.SetDeadline(time.Time{})
+
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(hash[:]))
+	err = rw.Flush()
+	if err != nil {
+		conn.Close()
+		return nil
+	}
+
+	return &webSocket{conn: conn, reader: rw.Reader}
+}
+
+// headerContains reports whether the comma-separated header name lists token
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// read returns the next text or binary message, answering pings on the way.
+// It returns errWebSocketClosed once the client closes the connection.
+func (ws *webSocket) read() (int, []byte, error) {
+	var opcode int
+	var message []byte
+	for {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case wsPing:
+			err = ws.write(wsPong, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			ws.close()
+			return 0, nil, errWebSocketClosed
+		case wsText, wsBinary:
+			if message != nil {
+				return 0, nil, errors.New("websocket: message interrupted by another one")
+			}
+			opcode = op
+		case wsContinuation:
+			if message == nil {
+				return 0, nil, errors.New("websocket: continuation without a message")
+			}
+		default:
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %d", op)
+		}
+
+		if len(message)+len(payload) > maxWebSocketMessageBytes {
+			return 0, nil, fmt.Errorf("websocket: message exceeds %d bytes", maxWebSocketMessageBytes)
+		}
+		message = append(message, payload...)
+		if message == nil {
+			message = []byte{}
+		}
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// readFrame reads one frame, unmasking its payload
+func (ws *webSocket) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	_, err := io.ReadFull(ws.reader, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0F)
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	if !masked {
+		return false, 0, nil, errors.New("websocket: client frames must be masked")
+	}
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		_, err = io.ReadFull(ws.reader, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		_, err = io.ReadFull(ws.reader, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if length > maxWebSocketMessageBytes {
+		return false, 0, nil, fmt.Errorf("websocket: frame exceeds %d bytes", maxWebSocketMessageBytes)
+	}
+
+	var mask [4]byte
+	_, err = io.ReadFull(ws.reader, mask[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(ws.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// write sends payload as a single unmasked frame
+func (ws *webSocket) write(opcode int, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.closed {
+		return errWebSocketClosed
+	}
+
+	frame := []byte{0x80 | byte(opcode)}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	_, err := ws.conn.Write(append(frame, payload...))
+	return err
+}
+
+// close sends a normal closure, unless the connection is closed already,
+// and closes the connection
+func (ws *webSocket) close() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.closed {
+		return
+	}
+	ws.closed = true
+	ws.conn.Write([]byte{0x80 | wsClose, 2, 0x03, 0xE8})
+	ws.conn.Close()
+}
@@ -0,0 +1,8 @@
+//go:build unix && !darwin
+
+package main
+
+// defaultWorkspaceRoot is where workspaces are created unless configured otherwise
+func defaultWorkspaceRoot() string {
+	return "/mnt/persistent"
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// openWorkspaceFile opens the file name (slash-separated) of the workspace
+// dir for reading. Without openat2, the path is confined to dir and the file
+// opened checked to be the one it names.
+func openWorkspaceFile(dir string, name string) (*os.File, error) {
+	f, err := openConfinedFile(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkWorkspaceFile(f, name)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}